@@ -0,0 +1,480 @@
+package main
+
+import (
+    "os"
+    "fmt"
+    "errors"
+    "strings"
+    "path"
+    "path/filepath"
+    "encoding/json"
+    "encoding/hex"
+    "crypto/rand"
+    "net/http"
+    "sync"
+    "time"
+    "context"
+    "syscall"
+)
+
+// Projects and assets are publicly readable unless 'readers' is specified in their permissions.
+// A wildcard in 'readers' explicitly marks the project or asset as public.
+const readersWildcard = "*"
+
+func isReadRestricted(perms *permissionsMetadata) bool {
+    if perms == nil || perms.Readers == nil {
+        return false
+    }
+    for _, r := range perms.Readers {
+        if r == readersWildcard {
+            return false
+        }
+    }
+    return true
+}
+
+// Asset-level 'readers' take precedence over the project-level 'readers' when determining whether an asset is restricted.
+// If it is restricted, owners and readers of the project or asset are allowed to read it, along with the administrators.
+func isAuthorizedToRead(username *string, administrators []string, project_perms *permissionsMetadata, asset_perms *permissionsMetadata) bool {
+    restricted := isReadRestricted(project_perms)
+    if asset_perms != nil && asset_perms.Readers != nil {
+        restricted = isReadRestricted(asset_perms)
+    }
+    if !restricted {
+        return true
+    }
+    if username == nil {
+        return false
+    }
+
+    for _, perms := range []*permissionsMetadata{ project_perms, asset_perms } {
+        if perms == nil {
+            continue
+        }
+        if isAuthorizedToMaintain(*username, administrators, perms.Owners) {
+            return true
+        }
+        for _, r := range perms.Readers {
+            if matchesUserOrGroup(*username, r) {
+                return true
+            }
+        }
+    }
+
+    return isAuthorizedToAdmin(*username, administrators)
+}
+
+func sanitizeReaders(readers []string) error {
+    for _, r := range readers {
+        if r == "" {
+            return errors.New("entries of 'readers' should be non-empty strings")
+        }
+    }
+    return checkGroupEntries(readers)
+}
+
+// Returns nil if the directory or its permissions do not exist, in which case the caller should fail with a 404 later.
+func readPermissionsIfExists(dir string) (*permissionsMetadata, error) {
+    info, err := os.Stat(dir)
+    if err != nil || !info.IsDir() {
+        return nil, nil
+    }
+
+    _, err = os.Stat(filepath.Join(dir, permissionsFileName))
+    if err != nil {
+        if errors.Is(err, os.ErrNotExist) {
+            return nil, nil
+        }
+        return nil, fmt.Errorf("failed to stat permissions in %q; %w", dir, err)
+    }
+
+    return readPermissions(dir)
+}
+
+// Checks whether any project or asset in the registry has restricted read access.
+func hasReadRestrictions(registry string) (bool, error) {
+    projects, err := os.ReadDir(registry)
+    if err != nil {
+        return false, fmt.Errorf("failed to list projects in %q; %w", registry, err)
+    }
+
+    for _, pentry := range projects {
+        if !pentry.IsDir() || isBadName(pentry.Name()) != nil {
+            continue
+        }
+        project_dir := filepath.Join(registry, pentry.Name())
+        project_perms, err := readPermissionsIfExists(project_dir)
+        if err != nil {
+            return false, err
+        }
+        if isReadRestricted(project_perms) {
+            return true, nil
+        }
+
+        assets, err := os.ReadDir(project_dir)
+        if err != nil {
+            return false, fmt.Errorf("failed to list assets in %q; %w", project_dir, err)
+        }
+        for _, aentry := range assets {
+            if !aentry.IsDir() || isBadName(aentry.Name()) != nil {
+                continue
+            }
+            asset_perms, err := readPermissionsIfExists(filepath.Join(project_dir, aentry.Name()))
+            if err != nil {
+                return false, err
+            }
+            if isReadRestricted(asset_perms) {
+                return true, nil
+            }
+        }
+    }
+
+    return false, nil
+}
+
+// Checks whether 'reader' is allowed to read 'rel_path', a slash-separated path relative to the registry.
+// 'reader' may be nil if the user has not provided any credentials.
+func checkReadAccess(registry string, rel_path string, reader *string, administrators []string) error {
+    cleaned := strings.TrimPrefix(path.Clean("/" + rel_path), "/")
+    if cleaned == "" {
+        return nil
+    }
+
+    components := strings.Split(cleaned, "/")
+    project := components[0]
+    if isBadName(project) != nil {
+        // Internal files in the registry root (e.g., logs, deduplication index) may refer to restricted projects,
+        // so they are only available to administrators if any project or asset is restricted.
+        restricted, err := hasReadRestrictions(registry)
+        if err != nil {
+            return err
+        }
+        if !restricted {
+            return nil
+        }
+        if reader == nil {
+            return newHttpError(http.StatusUnauthorized, fmt.Errorf("a token is required to read %q", cleaned))
+        }
+        if !isAuthorizedToAdmin(*reader, administrators) {
+            return newHttpError(http.StatusForbidden, fmt.Errorf("user %q is not authorized to read %q", *reader, cleaned))
+        }
+        return nil
+    }
+
+    project_dir := filepath.Join(registry, project)
+    project_perms, err := readPermissionsIfExists(project_dir)
+    if err != nil {
+        return err
+    }
+
+    var asset_perms *permissionsMetadata
+    if len(components) > 1 && isBadName(components[1]) == nil {
+        asset_perms, err = readPermissionsIfExists(filepath.Join(project_dir, components[1]))
+        if err != nil {
+            return err
+        }
+    }
+
+    if !isAuthorizedToRead(reader, administrators, project_perms, asset_perms) {
+        if reader == nil {
+            return newHttpError(http.StatusUnauthorized, fmt.Errorf("a token is required to read %q", cleaned))
+        }
+        return newHttpError(http.StatusForbidden, fmt.Errorf("user %q is not authorized to read %q", *reader, cleaned))
+    }
+
+    return nil
+}
+
+// Symbolic links in the registry may point to files in other projects, so the access checks need to be repeated for the link target.
+// This returns nil if 'rel_path' does not exist, in which case the caller should fail with a 404 later.
+func checkSymlinkReadAccess(registry string, rel_path string, reader *string, administrators []string) error {
+    resolved, err := filepath.EvalSymlinks(filepath.Join(registry, filepath.FromSlash(rel_path)))
+    if err != nil {
+        if errors.Is(err, os.ErrNotExist) {
+            return nil
+        }
+        return fmt.Errorf("failed to resolve %q; %w", rel_path, err)
+    }
+
+    resolved_registry, err := filepath.EvalSymlinks(registry)
+    if err != nil {
+        return fmt.Errorf("failed to resolve the registry; %w", err)
+    }
+
+    target, err := filepath.Rel(resolved_registry, resolved)
+    if err == nil && target == "." {
+        return nil
+    }
+    if err != nil || !filepath.IsLocal(target) {
+        return newHttpError(http.StatusForbidden, fmt.Errorf("%q points outside of the registry", rel_path))
+    }
+
+    return checkReadAccess(registry, filepath.ToSlash(target), reader, administrators)
+}
+
+// Tokens are held in memory and are forgotten when the Gobbler restarts, after which users need to request new tokens.
+type readToken struct {
+    User string
+    Expiry time.Time
+}
+
+type readTokenRegistry struct {
+    Lock sync.Mutex
+    Tokens map[string]*readToken
+    DefaultLifetime time.Duration
+    MaxLifetime time.Duration
+}
+
+func newReadTokenRegistry(default_lifetime, max_lifetime time.Duration) *readTokenRegistry {
+    return &readTokenRegistry{
+        Tokens: map[string]*readToken{},
+        DefaultLifetime: default_lifetime,
+        MaxLifetime: max_lifetime,
+    }
+}
+
+func (r *readTokenRegistry) Issue(username string, lifetime time.Duration) (string, time.Time, error) {
+    buffer := make([]byte, 32)
+    _, err := rand.Read(buffer)
+    if err != nil {
+        return "", time.Time{}, fmt.Errorf("failed to generate a token; %w", err)
+    }
+    token := hex.EncodeToString(buffer)
+    expiry := time.Now().Add(lifetime)
+
+    r.Lock.Lock()
+    defer r.Lock.Unlock()
+
+    // Taking the opportunity to purge expired tokens.
+    now := time.Now()
+    for k, v := range r.Tokens {
+        if now.After(v.Expiry) {
+            delete(r.Tokens, k)
+        }
+    }
+
+    r.Tokens[token] = &readToken{ User: username, Expiry: expiry }
+    return token, expiry, nil
+}
+
+func (r *readTokenRegistry) Identify(token string) (string, bool) {
+    r.Lock.Lock()
+    defer r.Lock.Unlock()
+
+    found, ok := r.Tokens[token]
+    if !ok {
+        return "", false
+    }
+    if time.Now().After(found.Expiry) {
+        delete(r.Tokens, token)
+        return "", false
+    }
+    return found.User, true
+}
+
+// Returns nil if no token was supplied in the request.
+func identifyReader(r *http.Request, tokens *readTokenRegistry) (*string, error) {
+    auth := r.Header.Get("Authorization")
+    if auth == "" {
+        return nil, nil
+    }
+
+    token, ok := strings.CutPrefix(auth, "Bearer ")
+    if !ok {
+        return nil, newHttpError(http.StatusUnauthorized, errors.New("expected a bearer token in the 'Authorization' header"))
+    }
+
+    username, ok := tokens.Identify(strings.TrimSpace(token))
+    if !ok {
+        return nil, newHttpError(http.StatusUnauthorized, errors.New("token is invalid or has expired"))
+    }
+    return &username, nil
+}
+
+// The token is written to a file that was created by the requester beside the request file,
+// so that it cannot be obtained by anyone else who submits the request to the Gobbler's API.
+func tokenFilePath(reqpath string) string {
+    return filepath.Join(filepath.Dir(reqpath), "token-" + strings.TrimPrefix(filepath.Base(reqpath), "request-"))
+}
+
+// The Gobbler cannot create a file owned by the requester, so the requester must create the token file themselves.
+// This should be writable by the Gobbler but should not be readable by anyone other than the requester.
+func openTokenFile(reqpath string) (*os.File, error) {
+    token_path := tokenFilePath(reqpath)
+    handle, err := os.OpenFile(token_path, os.O_WRONLY | syscall.O_NOFOLLOW, 0)
+    if err != nil {
+        return nil, newHttpError(http.StatusBadRequest, fmt.Errorf("failed to open the token file at %q; %w", token_path, err))
+    }
+
+    has_failed := true
+    defer func() {
+        if has_failed {
+            handle.Close()
+        }
+    }()
+
+    // Inspecting the opened file rather than the path, so that it cannot be swapped out after the checks.
+    token_info, err := handle.Stat()
+    if err != nil {
+        return nil, fmt.Errorf("failed to inspect the token file at %q; %w", token_path, err)
+    }
+    if !token_info.Mode().IsRegular() {
+        return nil, newHttpError(http.StatusBadRequest, fmt.Errorf("token file at %q should be a regular file", token_path))
+    }
+    if token_info.Mode().Perm() & 0044 != 0 {
+        return nil, newHttpError(http.StatusBadRequest, fmt.Errorf("token file at %q should not be readable by group or others", token_path))
+    }
+
+    req_info, err := os.Stat(reqpath)
+    if err != nil {
+        return nil, fmt.Errorf("failed to inspect %q; %w", reqpath, err)
+    }
+    req_stat, ok := req_info.Sys().(*syscall.Stat_t)
+    if !ok {
+        return nil, fmt.Errorf("failed to determine the owner of %q", reqpath)
+    }
+    token_stat, ok := token_info.Sys().(*syscall.Stat_t)
+    if !ok {
+        return nil, fmt.Errorf("failed to determine the owner of %q", token_path)
+    }
+    if req_stat.Uid != token_stat.Uid {
+        return nil, newHttpError(http.StatusBadRequest, fmt.Errorf("token file at %q should have the same owner as the request file", token_path))
+    }
+
+    has_failed = false
+    return handle, nil
+}
+
+type createTokenResult struct {
+    Token string
+    Expires string
+}
+
+func createTokenHandler(reqpath string, globals *globalConfiguration, ctx context.Context) (*createTokenResult, error) {
+    incoming := struct {
+        Lifetime *int64 `json:"lifetime"`
+        Spoof *string `json:"spoof"`
+    }{}
+    {
+        handle, err := os.ReadFile(reqpath)
+        if err != nil {
+            return nil, fmt.Errorf("failed to read %q; %w", reqpath, err)
+        }
+
+        err = json.Unmarshal(handle, &incoming)
+        if err != nil {
+            return nil, newHttpError(http.StatusBadRequest, fmt.Errorf("failed to parse JSON from %q; %w", reqpath, err))
+        }
+    }
+
+    lifetime := globals.ReadTokens.DefaultLifetime
+    if incoming.Lifetime != nil {
+        lifetime = time.Duration(*(incoming.Lifetime)) * time.Second
+        if lifetime <= 0 || lifetime > globals.ReadTokens.MaxLifetime {
+            return nil, newHttpError(http.StatusBadRequest, fmt.Errorf("'lifetime' in %q should be positive and no greater than %d seconds", reqpath, int64(globals.ReadTokens.MaxLifetime / time.Second)))
+        }
+    }
+
+    // Identity is proven by the ownership of the request file in the staging directory.
+    username, err := identifySpoofedUser(reqpath, incoming.Spoof, globals.SpoofPermissions)
+    if err != nil {
+        return nil, fmt.Errorf("failed to identify user; %w", err)
+    }
+
+    handle, err := openTokenFile(reqpath)
+    if err != nil {
+        return nil, err
+    }
+    defer handle.Close()
+
+    token, expiry, err := globals.ReadTokens.Issue(username, lifetime)
+    if err != nil {
+        return nil, err
+    }
+
+    err = handle.Truncate(0)
+    if err == nil {
+        _, err = handle.WriteString(token)
+    }
+    if err == nil {
+        err = handle.Close()
+    }
+    if err != nil {
+        return nil, fmt.Errorf("failed to write the token file for %q; %w", reqpath, err)
+    }
+
+    return &createTokenResult{ Token: token, Expires: expiry.Format(time.RFC3339) }, nil
+}
+
+// Removes all projects and assets that cannot be read by 'reader' from the catalogue.
+// Projects are retained if the reader can access the project itself or any of its assets.
+func filterCatalogueForReader(catalogue map[string]catalogueProject, reader *string, administrators []string) map[string]catalogueProject {
+    output := map[string]catalogueProject{}
+    for project, pinfo := range catalogue {
+        project_ok := isAuthorizedToRead(reader, administrators, pinfo.Permissions, nil)
+
+        assets := map[string]catalogueAsset{}
+        for asset, ainfo := range pinfo.Assets {
+            if isAuthorizedToRead(reader, administrators, pinfo.Permissions, ainfo.Permissions) {
+                assets[asset] = ainfo
+            }
+        }
+
+        if !project_ok && len(assets) == 0 {
+            continue
+        }
+        pinfo.Assets = assets
+        pinfo.AssetCount = len(assets)
+        output[project] = pinfo
+    }
+    return output
+}
+
+// Serves files from the registry after checking that the reader is authorized to read them.
+func newFetchHandler(globals *globalConfiguration, fetch_endpt, catalogue_endpt string) http.HandlerFunc {
+    fs := http.FileServer(http.Dir(globals.Registry))
+    fs_stripped := http.StripPrefix(fetch_endpt, fs)
+    return func(w http.ResponseWriter, r *http.Request) {
+        rel_path := strings.TrimPrefix(r.URL.Path, fetch_endpt)
+        reader, err := identifyReader(r, globals.ReadTokens)
+        if err != nil {
+            dumpHttpErrorResponse(w, err, "fetch request")
+            return
+        }
+
+        // The raw catalogue may contain restricted projects, so we redirect to the endpoint that filters them out.
+        if rel_path == catalogueFileName {
+            w.Header().Set("Access-Control-Allow-Origin", "*")
+            http.Redirect(w, r, catalogue_endpt, http.StatusFound)
+            return
+        }
+
+        err = checkReadAccess(globals.Registry, rel_path, reader, globals.Administrators)
+        if err != nil {
+            dumpHttpErrorResponse(w, err, "fetch request")
+            return
+        }
+
+        // Redirecting aliases to the actual version, so that clients can cache the version-specific files.
+        resolved, err := resolveAliasPath(globals.Registry, rel_path)
+        if err != nil {
+            dumpHttpErrorResponse(w, err, "fetch request")
+            return
+        }
+        if resolved != rel_path {
+            w.Header().Set("Access-Control-Allow-Origin", "*")
+            http.Redirect(w, r, fetch_endpt + resolved, http.StatusFound)
+            return
+        }
+
+        // The file server follows symbolic links, which may point to a project or asset with different read permissions.
+        err = checkSymlinkReadAccess(globals.Registry, rel_path, reader, globals.Administrators)
+        if err != nil {
+            dumpHttpErrorResponse(w, err, "fetch request")
+            return
+        }
+
+        w.Header().Set("Access-Control-Allow-Origin", "*")
+        fs_stripped.ServeHTTP(w, r)
+    }
+}
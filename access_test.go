@@ -0,0 +1,611 @@
+package main
+
+import (
+    "testing"
+    "os"
+    "path/filepath"
+    "strings"
+    "net/http"
+    "errors"
+    "sort"
+    "time"
+    "net/http/httptest"
+)
+
+func TestIsAuthorizedToRead(t *testing.T) {
+    may := "may"
+    erika := "erika"
+    misty := "misty"
+
+    public := &permissionsMetadata{ Owners: []string{ "erika" } }
+    if !isAuthorizedToRead(nil, nil, public, nil) || !isAuthorizedToRead(&may, nil, public, nil) {
+        t.Fatal("expected public projects to be readable by anyone")
+    }
+
+    restricted := &permissionsMetadata{ Owners: []string{ "erika" }, Readers: []string{ "misty" } }
+    if isAuthorizedToRead(nil, nil, restricted, nil) || isAuthorizedToRead(&may, nil, restricted, nil) {
+        t.Fatal("unexpected authorization for a restricted project")
+    }
+    if !isAuthorizedToRead(&misty, nil, restricted, nil) || !isAuthorizedToRead(&erika, nil, restricted, nil) || !isAuthorizedToRead(&may, []string{ "may" }, restricted, nil) {
+        t.Fatal("expected readers, owners and administrators to be authorized")
+    }
+
+    wildcard := &permissionsMetadata{ Owners: []string{}, Readers: []string{ "*" } }
+    if !isAuthorizedToRead(nil, nil, wildcard, nil) {
+        t.Fatal("expected a wildcard to make the project public")
+    }
+
+    // Asset-level readers take precedence.
+    if !isAuthorizedToRead(nil, nil, restricted, wildcard) {
+        t.Fatal("expected a wildcard to make the asset public")
+    }
+    asset_restricted := &permissionsMetadata{ Owners: []string{}, Readers: []string{ "may" } }
+    if isAuthorizedToRead(nil, nil, public, asset_restricted) || !isAuthorizedToRead(&may, nil, public, asset_restricted) {
+        t.Fatal("expected asset-level readers to restrict the asset")
+    }
+    if !isAuthorizedToRead(&erika, nil, public, asset_restricted) {
+        t.Fatal("expected project owners to read restricted assets")
+    }
+    if !isAuthorizedToRead(&misty, nil, restricted, asset_restricted) {
+        t.Fatal("expected project readers to read restricted assets")
+    }
+}
+
+func setupRegistryForAccessTest() (string, error) {
+    reg, err := os.MkdirTemp("", "")
+    if err != nil {
+        return "", err
+    }
+
+    for _, dir := range []string{ "public/foo/v1", "secret/foo/v1", "secret/bar/v1" } {
+        err := os.MkdirAll(filepath.Join(reg, dir), 0755)
+        if err != nil {
+            return "", err
+        }
+        err = os.WriteFile(filepath.Join(reg, dir, "data"), []byte("hello"), 0644)
+        if err != nil {
+            return "", err
+        }
+    }
+
+    err = dumpJson(filepath.Join(reg, "public", permissionsFileName), &permissionsMetadata{ Owners: []string{ "erika" }, Uploaders: []uploaderEntry{} })
+    if err != nil {
+        return "", err
+    }
+    err = dumpJson(filepath.Join(reg, "secret", permissionsFileName), &permissionsMetadata{ Owners: []string{ "erika" }, Uploaders: []uploaderEntry{}, Readers: []string{ "misty" } })
+    if err != nil {
+        return "", err
+    }
+    err = dumpJson(filepath.Join(reg, "secret", "bar", permissionsFileName), &permissionsMetadata{ Owners: []string{}, Uploaders: []uploaderEntry{}, Readers: []string{ "may" } })
+    if err != nil {
+        return "", err
+    }
+
+    return reg, nil
+}
+
+func TestCheckReadAccess(t *testing.T) {
+    reg, err := setupRegistryForAccessTest()
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    may := "may"
+    misty := "misty"
+
+    for _, path := range []string{ "", "public", "public/foo/v1/data", "missing/foo" } {
+        err := checkReadAccess(reg, path, nil, nil)
+        if err != nil {
+            t.Fatalf("unexpected failure to access %q; %v", path, err)
+        }
+    }
+
+    err = checkReadAccess(reg, "secret/foo/v1/data", nil, nil)
+    var http_err *httpError
+    if err == nil || !errors.As(err, &http_err) || http_err.Status != http.StatusUnauthorized {
+        t.Fatal("expected a 401 error without a reader")
+    }
+    err = checkReadAccess(reg, "secret/foo/v1/data", &may, nil)
+    if err == nil || !errors.As(err, &http_err) || http_err.Status != http.StatusForbidden {
+        t.Fatal("expected a 403 error for an unauthorized reader")
+    }
+    err = checkReadAccess(reg, "secret/foo/../../secret/foo", &may, nil)
+    if err == nil {
+        t.Fatal("expected failure for a non-clean path")
+    }
+
+    err = checkReadAccess(reg, "secret/foo/v1/data", &misty, nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = checkReadAccess(reg, "secret/bar/v1/data", &may, nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = checkReadAccess(reg, "secret/..permissions", &may, nil)
+    if err == nil {
+        t.Fatal("expected failure to access internal files of a restricted project")
+    }
+
+    // Internal files in the registry root are only accessible to administrators if any project is restricted.
+    err = checkReadAccess(reg, "..logs/foo", nil, nil)
+    if err == nil || !errors.As(err, &http_err) || http_err.Status != http.StatusUnauthorized {
+        t.Fatal("expected a 401 error for registry internals without a reader")
+    }
+    err = checkReadAccess(reg, "..logs/foo", &misty, nil)
+    if err == nil || !errors.As(err, &http_err) || http_err.Status != http.StatusForbidden {
+        t.Fatal("expected a 403 error for registry internals with a non-administrator")
+    }
+    err = checkReadAccess(reg, "..logs/foo", &misty, []string{ misty })
+    if err != nil {
+        t.Fatal(err)
+    }
+}
+
+func TestHasReadRestrictions(t *testing.T) {
+    reg, err := os.MkdirTemp("", "")
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = os.MkdirAll(filepath.Join(reg, "public", "foo"), 0755)
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = dumpJson(filepath.Join(reg, "public", permissionsFileName), &permissionsMetadata{ Owners: []string{ "erika" }, Uploaders: []uploaderEntry{} })
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    restricted, err := hasReadRestrictions(reg)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if restricted {
+        t.Fatal("expected no restrictions in a public registry")
+    }
+
+    err = checkReadAccess(reg, "..logs/foo", nil, nil)
+    if err != nil {
+        t.Fatalf("expected registry internals to be readable without restrictions; %v", err)
+    }
+
+    err = dumpJson(filepath.Join(reg, "public", "foo", permissionsFileName), &permissionsMetadata{ Owners: []string{}, Uploaders: []uploaderEntry{}, Readers: []string{ "*" } })
+    if err != nil {
+        t.Fatal(err)
+    }
+    restricted, err = hasReadRestrictions(reg)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if restricted {
+        t.Fatal("expected no restrictions for a wildcard reader")
+    }
+
+    err = dumpJson(filepath.Join(reg, "public", "foo", permissionsFileName), &permissionsMetadata{ Owners: []string{}, Uploaders: []uploaderEntry{}, Readers: []string{ "may" } })
+    if err != nil {
+        t.Fatal(err)
+    }
+    restricted, err = hasReadRestrictions(reg)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !restricted {
+        t.Fatal("expected restrictions for an asset with readers")
+    }
+
+    err = checkReadAccess(reg, "..logs/foo", nil, nil)
+    if err == nil {
+        t.Fatal("expected registry internals to be protected once an asset is restricted")
+    }
+}
+
+func TestFetchHandler(t *testing.T) {
+    reg, err := os.MkdirTemp("", "")
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = os.MkdirAll(filepath.Join(reg, "public", "foo", "v1"), 0755)
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = os.WriteFile(filepath.Join(reg, "public", "foo", "v1", "data"), []byte("hello"), 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = dumpJson(filepath.Join(reg, "public", permissionsFileName), &permissionsMetadata{ Owners: []string{ "erika" }, Uploaders: []uploaderEntry{} })
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = os.Mkdir(filepath.Join(reg, logDirName), 0755)
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = os.WriteFile(filepath.Join(reg, logDirName, "foo"), []byte("whee"), 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    globals := newGlobalConfiguration(reg, 2)
+    handler := newFetchHandler(&globals, "/fetch/", "/catalogue")
+    fetch := func(path string, token string) *httptest.ResponseRecorder {
+        r := httptest.NewRequest("GET", "/fetch/" + path, nil)
+        if token != "" {
+            r.Header.Set("Authorization", "Bearer " + token)
+        }
+        w := httptest.NewRecorder()
+        handler(w, r)
+        return w
+    }
+
+    // Anonymous users can read the registry internals if nothing is restricted.
+    res := fetch("public/foo/v1/data", "")
+    if res.Code != http.StatusOK || res.Body.String() != "hello" {
+        t.Fatalf("unexpected response for a public file; %v", res)
+    }
+    res = fetch(logDirName + "/foo", "")
+    if res.Code != http.StatusOK || res.Body.String() != "whee" {
+        t.Fatalf("unexpected response for the logs in an unrestricted registry; %v", res)
+    }
+
+    res = fetch(catalogueFileName, "")
+    if res.Code != http.StatusFound {
+        t.Fatalf("expected the catalogue to be redirected; %v", res)
+    }
+
+    // Once a project is restricted, only administrators can read the registry internals.
+    err = dumpJson(filepath.Join(reg, "public", permissionsFileName), &permissionsMetadata{ Owners: []string{ "erika" }, Uploaders: []uploaderEntry{}, Readers: []string{ "misty" } })
+    if err != nil {
+        t.Fatal(err)
+    }
+    res = fetch(logDirName + "/foo", "")
+    if res.Code != http.StatusUnauthorized {
+        t.Fatalf("expected a 401 error for the logs in a restricted registry; %v", res)
+    }
+    res = fetch("public/foo/v1/data", "")
+    if res.Code != http.StatusUnauthorized {
+        t.Fatalf("expected a 401 error for a restricted file; %v", res)
+    }
+
+    token, _, err := globals.ReadTokens.Issue("misty", time.Hour)
+    if err != nil {
+        t.Fatal(err)
+    }
+    res = fetch("public/foo/v1/data", token)
+    if res.Code != http.StatusOK {
+        t.Fatalf("expected a reader to access the restricted file; %v", res)
+    }
+    res = fetch(logDirName + "/foo", token)
+    if res.Code != http.StatusForbidden {
+        t.Fatalf("expected a 403 error for the logs with a non-administrator; %v", res)
+    }
+
+    globals.Administrators = append(globals.Administrators, "misty")
+    res = fetch(logDirName + "/foo", token)
+    if res.Code != http.StatusOK || res.Body.String() != "whee" {
+        t.Fatalf("expected an administrator to access the logs; %v", res)
+    }
+}
+
+func TestCheckSymlinkReadAccess(t *testing.T) {
+    reg, err := setupRegistryForAccessTest()
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    err = os.Symlink("../../../secret/foo/v1/data", filepath.Join(reg, "public", "foo", "v1", "secret_link"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = os.Symlink("../../../public/foo/v1/data", filepath.Join(reg, "public", "foo", "v1", "public_link"))
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    outside, err := os.CreateTemp("", "")
+    if err != nil {
+        t.Fatal(err)
+    }
+    outside.Close()
+    err = os.Symlink(outside.Name(), filepath.Join(reg, "public", "foo", "v1", "outside_link"))
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    for _, path := range []string{ "", "public", "public/foo/v1/data", "public/foo/v1/public_link", "public/foo/v1/missing" } {
+        err := checkSymlinkReadAccess(reg, path, nil, nil)
+        if err != nil {
+            t.Fatalf("unexpected failure to access %q; %v", path, err)
+        }
+    }
+
+    var http_err *httpError
+    err = checkSymlinkReadAccess(reg, "public/foo/v1/secret_link", nil, nil)
+    if err == nil || !errors.As(err, &http_err) || http_err.Status != http.StatusUnauthorized {
+        t.Fatal("expected a 401 error for a link to restricted content without a reader")
+    }
+
+    may := "may"
+    err = checkSymlinkReadAccess(reg, "public/foo/v1/secret_link", &may, nil)
+    if err == nil || !errors.As(err, &http_err) || http_err.Status != http.StatusForbidden {
+        t.Fatal("expected a 403 error for a link to restricted content with an unauthorized reader")
+    }
+
+    misty := "misty"
+    err = checkSymlinkReadAccess(reg, "public/foo/v1/secret_link", &misty, nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    err = checkSymlinkReadAccess(reg, "public/foo/v1/outside_link", &misty, nil)
+    if err == nil || !strings.Contains(err.Error(), "outside") {
+        t.Fatal("expected failure for a link outside of the registry")
+    }
+}
+
+func TestListFilesHandlerRestricted(t *testing.T) {
+    reg, err := setupRegistryForAccessTest()
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    list := func(query string, reader *string) ([]string, error) {
+        r, err := http.NewRequest("GET", "/list?" + query, nil)
+        if err != nil {
+            t.Fatal(err)
+        }
+        all, err := listFilesHandler(r, reg, reader, nil)
+        sort.Strings(all)
+        return all, err
+    }
+
+    all, err := list("recursive=true", nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(all) != 2 || all[0] != "public/..permissions" || all[1] != "public/foo/v1/data" {
+        t.Fatalf("unexpected listing for an anonymous user; %v", all)
+    }
+
+    may := "may"
+    all, err = list("recursive=true&path=secret", &may)
+    if err == nil {
+        t.Fatal("expected failure to list a restricted project")
+    }
+    all, err = list("recursive=true", &may)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(all) != 2 {
+        t.Fatalf("expected restricted projects to be skipped for an asset-level reader; %v", all)
+    }
+    all, err = list("recursive=true&path=secret%2Fbar", &may)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(all) != 2 || all[0] != "..permissions" || all[1] != "v1/data" {
+        t.Fatalf("unexpected listing for an asset-level reader; %v", all)
+    }
+
+    misty := "misty"
+    all, err = list("recursive=true&path=secret", &misty)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(all) != 4 {
+        t.Fatalf("unexpected listing for a project-level reader; %v", all)
+    }
+
+    // Non-recursive listings of the root still report the project names.
+    all, err = list("", nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(all) != 2 || all[0] != "public/" || all[1] != "secret/" {
+        t.Fatalf("unexpected listing of the registry root; %v", all)
+    }
+}
+
+func TestReadTokenRegistry(t *testing.T) {
+    tokens := newReadTokenRegistry(time.Hour, time.Hour * 2)
+
+    token, expiry, err := tokens.Issue("may", time.Hour)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(token) != 64 || expiry.Before(time.Now()) {
+        t.Fatalf("unexpected token; %v", token)
+    }
+
+    user, ok := tokens.Identify(token)
+    if !ok || user != "may" {
+        t.Fatal("failed to identify the token's user")
+    }
+    _, ok = tokens.Identify("foo")
+    if ok {
+        t.Fatal("unexpected identification of an unknown token")
+    }
+
+    expired, _, err := tokens.Issue("misty", -time.Minute)
+    if err != nil {
+        t.Fatal(err)
+    }
+    _, ok = tokens.Identify(expired)
+    if ok {
+        t.Fatal("unexpected identification of an expired token")
+    }
+
+    r, err := http.NewRequest("GET", "/fetch/foo", nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    reader, err := identifyReader(r, tokens)
+    if err != nil || reader != nil {
+        t.Fatal("expected no reader without an 'Authorization' header")
+    }
+
+    r.Header.Set("Authorization", "Bearer " + token)
+    reader, err = identifyReader(r, tokens)
+    if err != nil || reader == nil || *reader != "may" {
+        t.Fatal("failed to identify the reader from the token")
+    }
+
+    r.Header.Set("Authorization", "Bearer " + expired)
+    _, err = identifyReader(r, tokens)
+    if err == nil || !strings.Contains(err.Error(), "expired") {
+        t.Fatal("expected failure for an expired token")
+    }
+
+    r.Header.Set("Authorization", "Basic foo")
+    _, err = identifyReader(r, tokens)
+    if err == nil || !strings.Contains(err.Error(), "bearer") {
+        t.Fatal("expected failure for a non-bearer token")
+    }
+}
+
+// Creating the token file beside the request file, as a requester would.
+func dumpTokenRequest(request_string string) (string, error) {
+    reqpath, err := dumpRequest("create_token", request_string)
+    if err != nil {
+        return "", err
+    }
+    err = os.WriteFile(tokenFilePath(reqpath), []byte{}, 0600)
+    if err != nil {
+        return "", err
+    }
+    return reqpath, nil
+}
+
+func TestCreateTokenHandler(t *testing.T) {
+    reg, err := constructMockRegistry()
+    if err != nil {
+        t.Fatalf("failed to create the registry; %v", err)
+    }
+    globals := newGlobalConfiguration(reg, 2)
+
+    self, err := identifyUser(reg)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    reqpath, err := dumpTokenRequest("{}")
+    if err != nil {
+        t.Fatal(err)
+    }
+    res, err := createTokenHandler(reqpath, &globals, nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    user, ok := globals.ReadTokens.Identify(res.Token)
+    if !ok || user != self {
+        t.Fatal("failed to identify the user from the token")
+    }
+    err = verifyFileContents(tokenFilePath(reqpath), res.Token)
+    if err != nil {
+        t.Fatalf("token file does not contain the token; %v", err)
+    }
+
+    expiry, err := time.Parse(time.RFC3339, res.Expires)
+    if err != nil || expiry.Before(time.Now().Add(time.Hour * 23)) {
+        t.Fatalf("unexpected expiry time; %v", res.Expires)
+    }
+
+    reqpath, err = dumpTokenRequest(`{ "lifetime": 60 }`)
+    if err != nil {
+        t.Fatal(err)
+    }
+    res, err = createTokenHandler(reqpath, &globals, nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    expiry, err = time.Parse(time.RFC3339, res.Expires)
+    if err != nil || expiry.After(time.Now().Add(time.Minute * 2)) {
+        t.Fatalf("unexpected expiry time; %v", res.Expires)
+    }
+
+    reqpath, err = dumpTokenRequest(`{ "lifetime": 100000000 }`)
+    if err != nil {
+        t.Fatal(err)
+    }
+    _, err = createTokenHandler(reqpath, &globals, nil)
+    if err == nil || !strings.Contains(err.Error(), "lifetime") {
+        t.Fatal("expected failure for an excessive lifetime")
+    }
+
+    reqpath, err = dumpRequest("create_token", "{}")
+    if err != nil {
+        t.Fatal(err)
+    }
+    _, err = createTokenHandler(reqpath, &globals, nil)
+    if err == nil || !strings.Contains(err.Error(), "token file") {
+        t.Fatal("expected failure when the token file is missing")
+    }
+
+    reqpath, err = dumpTokenRequest("{}")
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = os.Chmod(tokenFilePath(reqpath), 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+    _, err = createTokenHandler(reqpath, &globals, nil)
+    if err == nil || !strings.Contains(err.Error(), "readable") {
+        t.Fatal("expected failure when the token file is readable by others")
+    }
+
+    reqpath, err = dumpTokenRequest("{}")
+    if err != nil {
+        t.Fatal(err)
+    }
+    token_path := tokenFilePath(reqpath)
+    err = os.Remove(token_path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = os.Symlink(reqpath, token_path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    _, err = createTokenHandler(reqpath, &globals, nil)
+    if err == nil || !strings.Contains(err.Error(), "token file") {
+        t.Fatal("expected failure when the token file is a symbolic link")
+    }
+}
+
+func TestFilterCatalogueForReader(t *testing.T) {
+    reg, err := setupRegistryForAccessTest()
+    if err != nil {
+        t.Fatal(err)
+    }
+    for _, project := range []string{ "public", "secret" } {
+        err := os.WriteFile(filepath.Join(reg, project, usageFileName), []byte(`{ "total": 0 }`), 0644)
+        if err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    catalogue, err := createCatalogue(reg)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    filtered := filterCatalogueForReader(catalogue, nil, nil)
+    if _, ok := filtered["secret"]; ok || len(filtered) != 1 {
+        t.Fatalf("unexpected catalogue for an anonymous user; %v", filtered)
+    }
+
+    may := "may"
+    filtered = filterCatalogueForReader(catalogue, &may, nil)
+    secret, ok := filtered["secret"]
+    if !ok || secret.AssetCount != 1 || len(secret.Assets) != 1 {
+        t.Fatalf("unexpected catalogue for an asset-level reader; %v", filtered)
+    }
+    if _, ok := secret.Assets["bar"]; !ok {
+        t.Fatalf("expected the readable asset in the catalogue; %v", secret)
+    }
+
+    filtered = filterCatalogueForReader(catalogue, &may, []string{ "may" })
+    if filtered["secret"].AssetCount != 2 {
+        t.Fatalf("unexpected catalogue for an administrator; %v", filtered)
+    }
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+    "os"
+    "fmt"
+    "bufio"
+    "strings"
+    "path/filepath"
+)
+
+// Scoped administrators are granted administrative rights for a subset of projects and/or actions,
+// in contrast to the global administrators that can perform any action on any project.
+const (
+    adminActionCreateProject = "create_project"
+    adminActionDelete = "delete"
+    adminActionRename = "rename"
+    adminActionSetQuota = "set_quota"
+    adminActionRefresh = "refresh"
+    adminActionReindex = "reindex"
+    adminActionValidate = "validate"
+    adminActionReroute = "reroute"
+)
+
+var allAdminActions = []string{
+    adminActionCreateProject,
+    adminActionDelete,
+    adminActionRename,
+    adminActionSetQuota,
+    adminActionRefresh,
+    adminActionReindex,
+    adminActionValidate,
+    adminActionReroute,
+}
+
+const adminScopeWildcard = "*"
+
+type scopedAdminGrant struct {
+    User string
+    Projects []string // glob patterns, or nil for all projects.
+    Actions map[string]bool // nil for all actions.
+}
+
+func (g *scopedAdminGrant) allowsProject(project *string) bool {
+    if g.Projects == nil {
+        return true
+    }
+
+    // Grants restricted to particular projects cannot be used for registry-wide actions.
+    if project == nil {
+        return false
+    }
+
+    for _, pattern := range g.Projects {
+        matched, err := filepath.Match(pattern, *project)
+        if err == nil && matched {
+            return true
+        }
+    }
+    return false
+}
+
+func (g *scopedAdminGrant) allowsAction(action string) bool {
+    return g.Actions == nil || g.Actions[action]
+}
+
+// This replaces isAuthorizedToAdmin for administrative actions, where 'project' is the target project or nil for registry-wide actions.
+func isAuthorizedToAdminAction(username string, administrators []string, grants []scopedAdminGrant, action string, project *string) bool {
+    if isAuthorizedToAdmin(username, administrators) {
+        return true
+    }
+    for _, g := range grants {
+        if matchesUserOrGroup(username, g.User) && g.allowsAction(action) && g.allowsProject(project) {
+            return true
+        }
+    }
+    return false
+}
+
+func loadScopedAdminGrants(path string) ([]scopedAdminGrant, error) {
+    handle, err := os.Open(path)
+    if err != nil {
+        return nil, fmt.Errorf("failed to open the scoped administrators file; %v", err)
+    }
+    defer handle.Close()
+
+    known_actions := map[string]bool{}
+    for _, a := range allAdminActions {
+        known_actions[a] = true
+    }
+
+    output := []scopedAdminGrant{}
+    scanner := bufio.NewScanner(handle)
+    for scanner.Scan() {
+        info := strings.TrimSpace(scanner.Text())
+        if info == "" {
+            continue
+        }
+
+        separated := strings.Split(info, ":")
+        if len(separated) != 3 || separated[0] == "" || separated[1] == "" || separated[2] == "" {
+            return nil, fmt.Errorf("unexpected format for line %q in the scoped administrators file", info)
+        }
+
+        grant := scopedAdminGrant{ User: separated[0] }
+        err := checkGroupEntry(grant.User)
+        if err != nil {
+            return nil, fmt.Errorf("invalid group for line %q in the scoped administrators file; %w", info, err)
+        }
+
+        for _, pattern := range strings.Split(separated[1], ",") {
+            if pattern == adminScopeWildcard {
+                grant.Projects = nil
+                break
+            }
+            _, err := filepath.Match(pattern, "")
+            if err != nil {
+                return nil, fmt.Errorf("invalid project pattern %q in the scoped administrators file; %w", pattern, err)
+            }
+            grant.Projects = append(grant.Projects, pattern)
+        }
+
+        for _, action := range strings.Split(separated[2], ",") {
+            if action == adminScopeWildcard {
+                grant.Actions = nil
+                break
+            }
+            if !known_actions[action] {
+                return nil, fmt.Errorf("unknown action %q in the scoped administrators file", action)
+            }
+            if grant.Actions == nil {
+                grant.Actions = map[string]bool{}
+            }
+            grant.Actions[action] = true
+        }
+
+        output = append(output, grant)
+    }
+
+    if err := scanner.Err(); err != nil {
+        return nil, fmt.Errorf("failed to parse the scoped administrators file; %v", err)
+    }
+    return output, nil
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+    "testing"
+    "os"
+    "strings"
+    "context"
+    "path/filepath"
+)
+
+func TestIsAuthorizedToAdminAction(t *testing.T) {
+    project := "lab-alpha"
+    other := "core-bravo"
+
+    grants := []scopedAdminGrant{
+        scopedAdminGrant{ User: "alice", Projects: []string{ "lab-*" }, Actions: map[string]bool{ adminActionReindex: true, adminActionValidate: true } },
+        scopedAdminGrant{ User: "bob", Projects: nil, Actions: map[string]bool{ adminActionDelete: true } },
+        scopedAdminGrant{ User: "carol", Projects: []string{ "core-bravo" }, Actions: nil },
+    }
+
+    // Global administrators can do anything.
+    if !isAuthorizedToAdminAction("root", []string{ "root" }, grants, adminActionDelete, &project) {
+        t.Error("expected administrator to be authorized")
+    }
+    if !isAuthorizedToAdminAction("root", []string{ "root" }, grants, adminActionRefresh, nil) {
+        t.Error("expected administrator to be authorized for registry-wide actions")
+    }
+
+    // Checking project patterns and action subsets.
+    if !isAuthorizedToAdminAction("alice", []string{}, grants, adminActionReindex, &project) {
+        t.Error("expected scoped administrator to be authorized to reindex a matching project")
+    }
+    if isAuthorizedToAdminAction("alice", []string{}, grants, adminActionDelete, &project) {
+        t.Error("unexpected authorization for an action outside of the grant")
+    }
+    if isAuthorizedToAdminAction("alice", []string{}, grants, adminActionValidate, &other) {
+        t.Error("unexpected authorization for a project outside of the grant")
+    }
+
+    if !isAuthorizedToAdminAction("bob", []string{}, grants, adminActionDelete, &project) || !isAuthorizedToAdminAction("bob", []string{}, grants, adminActionDelete, &other) {
+        t.Error("expected scoped administrator to be authorized to delete any project")
+    }
+    if isAuthorizedToAdminAction("bob", []string{}, grants, adminActionCreateProject, &project) {
+        t.Error("unexpected authorization for an action outside of the grant")
+    }
+
+    if !isAuthorizedToAdminAction("carol", []string{}, grants, adminActionSetQuota, &other) {
+        t.Error("expected scoped administrator to be authorized for any action on a matching project")
+    }
+    if isAuthorizedToAdminAction("carol", []string{}, grants, adminActionSetQuota, &project) {
+        t.Error("unexpected authorization for a project outside of the grant")
+    }
+
+    // Registry-wide actions are only allowed for grants that are not restricted to particular projects.
+    if isAuthorizedToAdminAction("carol", []string{}, grants, adminActionRefresh, nil) {
+        t.Error("unexpected authorization for a registry-wide action with a project-restricted grant")
+    }
+    unrestricted := []scopedAdminGrant{ scopedAdminGrant{ User: "dave", Projects: nil, Actions: map[string]bool{ adminActionRefresh: true } } }
+    if !isAuthorizedToAdminAction("dave", []string{}, unrestricted, adminActionRefresh, nil) {
+        t.Error("expected authorization for a registry-wide action with an unrestricted grant")
+    }
+
+    if isAuthorizedToAdminAction("eve", []string{}, grants, adminActionReindex, &project) {
+        t.Error("unexpected authorization for a user without any grant")
+    }
+}
+
+func TestLoadScopedAdminGrants(t *testing.T) {
+    other, err := os.CreateTemp("", "")
+    if err != nil {
+        t.Fatal(err)
+    }
+    other_name := other.Name()
+    if err := other.Close(); err != nil {
+        t.Fatal(err)
+    }
+
+    if err := os.WriteFile(other_name, []byte("alice:lab-*,test-?:reindex,validate\n\nbob:*:delete\ncarol:core:*\n"), 0644); err != nil {
+        t.Fatal(err)
+    }
+    loaded, err := loadScopedAdminGrants(other_name)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if len(loaded) != 3 {
+        t.Fatalf("unexpected number of grants in the loaded file")
+    }
+    if loaded[0].User != "alice" || len(loaded[0].Projects) != 2 || loaded[0].Projects[0] != "lab-*" || loaded[0].Projects[1] != "test-?" ||
+        len(loaded[0].Actions) != 2 || !loaded[0].Actions[adminActionReindex] || !loaded[0].Actions[adminActionValidate] {
+        t.Errorf("unexpected first grant; %v", loaded[0])
+    }
+    if loaded[1].User != "bob" || loaded[1].Projects != nil || len(loaded[1].Actions) != 1 || !loaded[1].Actions[adminActionDelete] {
+        t.Errorf("unexpected second grant; %v", loaded[1])
+    }
+    if loaded[2].User != "carol" || len(loaded[2].Projects) != 1 || loaded[2].Projects[0] != "core" || loaded[2].Actions != nil {
+        t.Errorf("unexpected third grant; %v", loaded[2])
+    }
+
+    // Checking for errors.
+    if err := os.WriteFile(other_name, []byte("alice:lab-*"), 0644); err != nil {
+        t.Fatal(err)
+    }
+    _, err = loadScopedAdminGrants(other_name)
+    if err == nil || !strings.Contains(err.Error(), "unexpected format") {
+        t.Error("expected an error for an incorrectly formatted line")
+    }
+
+    if err := os.WriteFile(other_name, []byte("alice:lab-*:upload"), 0644); err != nil {
+        t.Fatal(err)
+    }
+    _, err = loadScopedAdminGrants(other_name)
+    if err == nil || !strings.Contains(err.Error(), "unknown action") {
+        t.Error("expected an error for an unknown action")
+    }
+
+    if err := os.WriteFile(other_name, []byte("alice:lab-[:delete"), 0644); err != nil {
+        t.Fatal(err)
+    }
+    _, err = loadScopedAdminGrants(other_name)
+    if err == nil || !strings.Contains(err.Error(), "invalid project pattern") {
+        t.Error("expected an error for an invalid project pattern")
+    }
+
+    if err := os.WriteFile(other_name, []byte("@gobbler-nonexistent-group:*:delete"), 0644); err != nil {
+        t.Fatal(err)
+    }
+    _, err = loadScopedAdminGrants(other_name)
+    if err == nil || !strings.Contains(err.Error(), "invalid group") {
+        t.Error("expected an error for a non-existent group")
+    }
+}
+
+func TestScopedAdministratorHandlers(t *testing.T) {
+    reg, err := mockRegistryForDeletion("lab-foo", "stuff", []string{ "1" })
+    if err != nil {
+        t.Fatalf("failed to mock up registry; %v", err)
+    }
+    err = os.MkdirAll(filepath.Join(reg, "core-bar"), 0755)
+    if err != nil {
+        t.Fatalf("failed to create another project; %v", err)
+    }
+
+    self, err := identifyUser(reg)
+    if err != nil {
+        t.Fatalf("failed to identify self; %v", err)
+    }
+
+    ctx := context.Background()
+    globals := newGlobalConfiguration(reg, 2)
+    globals.ScopedAdministrators = []scopedAdminGrant{
+        scopedAdminGrant{ User: self, Projects: []string{ "lab-*" }, Actions: map[string]bool{ adminActionDelete: true, adminActionCreateProject: true } },
+    }
+
+    t.Run("create", func(t *testing.T) {
+        reqpath, err := dumpRequest("create_project", `{ "project": "lab-whee" }`)
+        if err != nil {
+            t.Fatalf("failed to dump a request type; %v", err)
+        }
+        err = createProjectHandler(reqpath, &globals, ctx)
+        if err != nil {
+            t.Fatalf("failed to create a project within the grant; %v", err)
+        }
+
+        reqpath, err = dumpRequest("create_project", `{ "project": "core-whee" }`)
+        if err != nil {
+            t.Fatalf("failed to dump a request type; %v", err)
+        }
+        err = createProjectHandler(reqpath, &globals, ctx)
+        if err == nil || !strings.Contains(err.Error(), "not authorized") {
+            t.Fatal("unexpected authorization to create a project outside of the grant")
+        }
+        if _, err := os.Stat(filepath.Join(reg, "core-whee")); err == nil {
+            t.Fatal("project outside of the grant should not have been created")
+        }
+    })
+
+    t.Run("delete", func(t *testing.T) {
+        reqpath, err := dumpRequest("delete_project", `{ "project": "core-bar" }`)
+        if err != nil {
+            t.Fatalf("failed to dump a request type; %v", err)
+        }
+        err = deleteProjectHandler(reqpath, &globals, ctx)
+        if err == nil || !strings.Contains(err.Error(), "not authorized") {
+            t.Fatal("unexpected authorization to delete a project outside of the grant")
+        }
+
+        reqpath, err = dumpRequest("delete_version", `{ "project": "lab-foo", "asset": "stuff", "version": "1" }`)
+        if err != nil {
+            t.Fatalf("failed to dump a request type; %v", err)
+        }
+        err = deleteVersionHandler(reqpath, &globals, ctx)
+        if err != nil {
+            t.Fatalf("failed to delete a version within the grant; %v", err)
+        }
+        if _, err := os.Stat(filepath.Join(reg, "lab-foo", "stuff", "1")); err == nil {
+            t.Fatal("version should have been deleted")
+        }
+    })
+
+    t.Run("other actions", func(t *testing.T) {
+        reqpath, err := dumpRequest("set_quota", `{ "project": "lab-foo", "growth_rate": 100 }`)
+        if err != nil {
+            t.Fatalf("failed to dump a request type; %v", err)
+        }
+        err = setQuotaHandler(reqpath, &globals, ctx)
+        if err == nil || !strings.Contains(err.Error(), "not authorized") {
+            t.Fatal("unexpected authorization for an action outside of the grant")
+        }
+
+        reqpath, err = dumpRequest("refresh_catalogue", "{}")
+        if err != nil {
+            t.Fatalf("failed to dump a request type; %v", err)
+        }
+        err = refreshCatalogueHandler(reqpath, &globals, ctx)
+        if err == nil || !strings.Contains(err.Error(), "not authorized") {
+            t.Fatalf("unexpected authorization for a registry-wide action; %v", err)
+        }
+    })
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+    "os"
+    "encoding/json"
+    "fmt"
+    "errors"
+    "strings"
+    "path/filepath"
+    "net/http"
+    "context"
+)
+
+// Each asset may have a '..aliases' file that maps alias names (e.g., 'stable') to version names.
+// Aliases can be used in place of the version name in /fetch and /list, by prefixing the alias with '@'.
+const aliasesFileName = "..aliases"
+
+const aliasPrefix = "@"
+
+func readAliases(asset_dir string) (map[string]string, error) {
+    aliases_path := filepath.Join(asset_dir, aliasesFileName)
+
+    aliases_raw, err := os.ReadFile(aliases_path)
+    if err != nil {
+        if errors.Is(err, os.ErrNotExist) {
+            return map[string]string{}, nil
+        }
+        return nil, fmt.Errorf("failed to read %q; %w", aliases_path, err)
+    }
+
+    output := map[string]string{}
+    err = json.Unmarshal(aliases_raw, &output)
+    if err != nil {
+        return nil, fmt.Errorf("failed to parse JSON in %q; %w", aliases_path, err)
+    }
+
+    return output, nil
+}
+
+func isBadAlias(alias string) error {
+    err := isBadName(alias)
+    if err != nil {
+        return err
+    }
+    if strings.HasPrefix(alias, aliasPrefix) {
+        return fmt.Errorf("alias cannot start with %q", aliasPrefix)
+    }
+    return nil
+}
+
+// New versions cannot start with the alias prefix, otherwise they would be indistinguishable from aliases in /fetch and /list.
+// Existing versions are still accessible via isBadName(), in case they were created before this check was introduced.
+func isBadNewVersionName(version string) error {
+    err := isBadName(version)
+    if err != nil {
+        return err
+    }
+    if strings.HasPrefix(version, aliasPrefix) {
+        return fmt.Errorf("version name cannot start with %q", aliasPrefix)
+    }
+    return nil
+}
+
+// This assumes that the caller holds an exclusive lock on the asset directory.
+func removeAliasesForVersion(asset_dir, version string) ([]string, error) {
+    aliases, err := readAliases(asset_dir)
+    if err != nil {
+        return nil, err
+    }
+
+    removed := []string{}
+    for alias, target := range aliases {
+        if target == version {
+            removed = append(removed, alias)
+            delete(aliases, alias)
+        }
+    }
+    if len(removed) == 0 {
+        return removed, nil
+    }
+
+    aliases_path := filepath.Join(asset_dir, aliasesFileName)
+    err = dumpJson(aliases_path, &aliases)
+    if err != nil {
+        return nil, fmt.Errorf("failed to update aliases at %q; %w", aliases_path, err)
+    }
+    return removed, nil
+}
+
+// This replaces an '@alias' in the version position of a registry-relative path with the actual version name.
+// Paths without an alias are returned unchanged.
+func resolveAliasPath(registry, path string) (string, error) {
+    components := strings.Split(path, "/")
+    if len(components) < 3 || !strings.HasPrefix(components[2], aliasPrefix) {
+        return path, nil
+    }
+
+    project := components[0]
+    asset := components[1]
+    if isBadName(project) != nil || isBadName(asset) != nil {
+        return path, nil
+    }
+
+    alias := strings.TrimPrefix(components[2], aliasPrefix)
+    aliases, err := readAliases(filepath.Join(registry, project, asset))
+    if err != nil {
+        return "", err
+    }
+
+    version, ok := aliases[alias]
+    if !ok {
+        return "", newHttpError(http.StatusNotFound, fmt.Errorf("alias %q does not exist for asset %s of project %s", alias, asset, project))
+    }
+
+    components[2] = version
+    return strings.Join(components, "/"), nil
+}
+
+func baseAliasHandler(reqpath string, remove bool, globals *globalConfiguration, ctx context.Context) error {
+    incoming := struct {
+        Project *string `json:"project"`
+        Asset *string `json:"asset"`
+        Alias *string `json:"alias"`
+        Version *string `json:"version"`
+        Spoof *string `json:"spoof"`
+    }{}
+    {
+        handle, err := os.ReadFile(reqpath)
+        if err != nil {
+            return fmt.Errorf("failed to read %q; %w", reqpath, err)
+        }
+
+        err = json.Unmarshal(handle, &incoming)
+        if err != nil {
+            return newHttpError(http.StatusBadRequest, fmt.Errorf("failed to parse JSON from %q; %w", reqpath, err))
+        }
+
+        err = isMissingOrBadName(incoming.Project)
+        if err != nil {
+            return newHttpError(http.StatusBadRequest, fmt.Errorf("invalid 'project' property in %q; %w", reqpath, err))
+        }
+
+        err = isMissingOrBadName(incoming.Asset)
+        if err != nil {
+            return newHttpError(http.StatusBadRequest, fmt.Errorf("invalid 'asset' property in %q; %w", reqpath, err))
+        }
+
+        if incoming.Alias == nil {
+            return newHttpError(http.StatusBadRequest, fmt.Errorf("expected an 'alias' property in %q", reqpath))
+        }
+        err = isBadAlias(*(incoming.Alias))
+        if err != nil {
+            return newHttpError(http.StatusBadRequest, fmt.Errorf("invalid 'alias' property in %q; %w", reqpath, err))
+        }
+
+        if !remove {
+            err = isMissingOrBadName(incoming.Version)
+            if err != nil {
+                return newHttpError(http.StatusBadRequest, fmt.Errorf("invalid 'version' property in %q; %w", reqpath, err))
+            }
+        }
+    }
+
+    username, err := identifySpoofedUser(reqpath, incoming.Spoof, globals.SpoofPermissions)
+    if err != nil {
+        return fmt.Errorf("failed to identify user; %w", err)
+    }
+
+    rlock, err := lockDirectoryShared(globals.Registry, globals, ctx)
+    if err != nil {
+        return fmt.Errorf("failed to lock the registry %q; %w", globals.Registry, err)
+    }
+    defer rlock.Unlock(globals)
+
+    rnnlock, err := lockDirectoryNewDirShared(globals.Registry, globals, ctx)
+    if err != nil {
+        return fmt.Errorf("failed to lock the registry %q; %w", globals.Registry, err)
+    }
+    defer rnnlock.Unlock(globals)
+
+    project := *(incoming.Project)
+    project_dir := filepath.Join(globals.Registry, project)
+    if err := checkProjectExists(project_dir, project); err != nil {
+        return err
+    }
+    rnnlock.Unlock(globals) // no need for this lock once we determine that the project directory exists.
+
+    plock, err := lockDirectoryShared(project_dir, globals, ctx)
+    if err != nil {
+        return fmt.Errorf("failed to lock project directory %q; %w", project_dir, err)
+    }
+    defer plock.Unlock(globals)
+
+    pnnlock, err := lockDirectoryNewDirShared(project_dir, globals, ctx)
+    if err != nil {
+        return fmt.Errorf("failed to lock project directory %q; %w", project_dir, err)
+    }
+    defer pnnlock.Unlock(globals)
+
+    asset := *(incoming.Asset)
+    asset_dir := filepath.Join(project_dir, asset)
+    if err := checkAssetExists(asset_dir, asset, project); err != nil {
+        return err
+    }
+    pnnlock.Unlock(globals) // no need for this lock once we determine that the asset directory exists.
+
+    alock, err := lockDirectoryExclusive(asset_dir, globals, ctx)
+    if err != nil {
+        return fmt.Errorf("failed to lock asset directory %q; %w", asset_dir, err)
+    }
+    defer alock.Unlock(globals)
+
+    aliases, err := readAliases(asset_dir)
+    if err != nil {
+        return err
+    }
+
+    alias := *(incoming.Alias)
+    var version string
+    if remove {
+        existing, ok := aliases[alias]
+        if !ok {
+            return newHttpError(http.StatusNotFound, fmt.Errorf("alias %q does not exist for asset %s of project %s", alias, asset, project))
+        }
+        version = existing
+    } else {
+        version = *(incoming.Version)
+    }
+
+    existing_perms, err := readPermissions(project_dir)
+    if err != nil {
+        return fmt.Errorf("failed to read permissions for %q; %w", project_dir, err)
+    }
+    asset_perms, err := addAssetPermissionsForUpload(existing_perms, asset_dir, asset)
+    if err != nil {
+        return fmt.Errorf("failed to read permissions for asset %q in %q; %w", asset, project, err)
+    }
+
+    // Only trusted uploaders can manage aliases, as untrusted uploaders shouldn't be able to redirect users to arbitrary versions.
+    ok, trusted := isAuthorizedToUpload(username, globals.Administrators, asset_perms, &asset, &version)
+    if !ok || !trusted {
+        return newHttpError(http.StatusForbidden, fmt.Errorf("user %q is not authorized to modify aliases in %q", username, asset_dir))
+    }
+
+    if remove {
+        delete(aliases, alias)
+    } else {
+        version_dir := filepath.Join(asset_dir, version)
+        if err := checkVersionExists(version_dir, version, asset, project); err != nil {
+            return err
+        }
+        summ, err := readSummary(version_dir)
+        if err != nil {
+            return fmt.Errorf("failed to read the version summary at %q; %w", version_dir, err)
+        }
+        if summ.IsProbational() {
+            return newHttpError(http.StatusBadRequest, fmt.Errorf("cannot create an alias to probational version %q", version_dir))
+        }
+        aliases[alias] = version
+    }
+
+    aliases_path := filepath.Join(asset_dir, aliasesFileName)
+    err = dumpJson(aliases_path, &aliases)
+    if err != nil {
+        return fmt.Errorf("failed to update aliases at %q; %w", aliases_path, err)
+    }
+
+    log_type := "set-alias"
+    if remove {
+        log_type = "remove-alias"
+    }
+    log_info := map[string]interface{} {
+        "type": log_type,
+        "project": project,
+        "asset": asset,
+        "alias": alias,
+        "version": version,
+    }
+    err = dumpLog(globals.Registry, &log_info)
+    if err != nil {
+        return fmt.Errorf("failed to save log file; %w", err)
+    }
+
+    return nil
+}
+
+func setAliasHandler(reqpath string, globals *globalConfiguration, ctx context.Context) error {
+    return baseAliasHandler(reqpath, false, globals, ctx)
+}
+
+func removeAliasHandler(reqpath string, globals *globalConfiguration, ctx context.Context) error {
+    return baseAliasHandler(reqpath, true, globals, ctx)
+}
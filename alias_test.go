@@ -0,0 +1,247 @@
+package main
+
+import (
+    "testing"
+    "os"
+    "path/filepath"
+    "fmt"
+    "strings"
+    "context"
+    "net/http"
+)
+
+func TestResolveAliasPath(t *testing.T) {
+    reg, err := constructMockRegistry()
+    if err != nil {
+        t.Fatalf("failed to create the registry; %v", err)
+    }
+
+    asset_dir := filepath.Join(reg, "foo", "bar")
+    err = os.MkdirAll(asset_dir, 0755)
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = dumpJson(filepath.Join(asset_dir, aliasesFileName), map[string]string{ "stable": "v1" })
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    resolved, err := resolveAliasPath(reg, "foo/bar/@stable/whee.txt")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if resolved != "foo/bar/v1/whee.txt" {
+        t.Fatalf("unexpected resolved path %q", resolved)
+    }
+
+    resolved, err = resolveAliasPath(reg, "foo/bar/@stable")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if resolved != "foo/bar/v1" {
+        t.Fatalf("unexpected resolved path %q", resolved)
+    }
+
+    for _, unchanged := range []string{ "foo/bar/v1/whee.txt", "foo/bar", "foo/@bar/v1" } {
+        resolved, err = resolveAliasPath(reg, unchanged)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if resolved != unchanged {
+            t.Fatalf("expected path %q to be unchanged", unchanged)
+        }
+    }
+
+    _, err = resolveAliasPath(reg, "foo/bar/@unstable/whee.txt")
+    if err == nil || !strings.Contains(err.Error(), "does not exist") {
+        t.Fatal("expected an error for a missing alias")
+    }
+
+    // Works without an aliases file.
+    _, err = resolveAliasPath(reg, "foo/stuff/@stable")
+    if err == nil || !strings.Contains(err.Error(), "does not exist") {
+        t.Fatal("expected an error for a missing alias")
+    }
+}
+
+func TestAliasHandlers(t *testing.T) {
+    reg, err := constructMockRegistry()
+    if err != nil {
+        t.Fatalf("failed to create the registry; %v", err)
+    }
+    globals := newGlobalConfiguration(reg, 2)
+    ctx := context.Background()
+
+    project := "dawn"
+    asset := "sinnoh"
+    version := "foo"
+    err = mockProbationVersion(reg, project, asset, version)
+    if err != nil {
+        t.Fatalf("failed to create a mock version; %v", err)
+    }
+    asset_dir := filepath.Join(reg, project, asset)
+
+    self, err := identifyUser(reg)
+    if err != nil {
+        t.Fatalf("failed to identify self; %v", err)
+    }
+
+    set_req, err := dumpRequest("set_alias", fmt.Sprintf(`{ "project": "%s", "asset": "%s", "alias": "stable", "version": "%s" }`, project, asset, version))
+    if err != nil {
+        t.Fatalf("failed to dump a request type; %v", err)
+    }
+
+    t.Run("unauthorized", func(t *testing.T) {
+        err := setAliasHandler(set_req, &globals, ctx)
+        if err == nil || !strings.Contains(err.Error(), "not authorized") {
+            t.Fatalf("expected failure for unauthorized user; %v", err)
+        }
+
+        // Untrusted uploaders are not allowed either.
+        err = os.WriteFile(filepath.Join(reg, project, permissionsFileName), []byte(fmt.Sprintf(`{ "owners": [], "uploaders": [ { "id": "%s" } ] }`, self)), 0644)
+        if err != nil {
+            t.Fatal(err)
+        }
+        err = setAliasHandler(set_req, &globals, ctx)
+        if err == nil || !strings.Contains(err.Error(), "not authorized") {
+            t.Fatalf("expected failure for untrusted uploader; %v", err)
+        }
+    })
+
+    err = os.WriteFile(filepath.Join(reg, project, permissionsFileName), []byte(fmt.Sprintf(`{ "owners": [], "uploaders": [ { "id": "%s", "trusted": true } ] }`, self)), 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    t.Run("probational", func(t *testing.T) {
+        err := setAliasHandler(set_req, &globals, ctx)
+        if err == nil || !strings.Contains(err.Error(), "probational") {
+            t.Fatalf("expected failure for probational version; %v", err)
+        }
+    })
+
+    err = dumpJson(filepath.Join(asset_dir, version, summaryFileName), &summaryMetadata{ UploadUserId: "cynthia", UploadStart: "2020-02-02T02:02:02Z", UploadFinish: "2020-02-02T02:02:20Z" })
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    t.Run("set", func(t *testing.T) {
+        err := setAliasHandler(set_req, &globals, ctx)
+        if err != nil {
+            t.Fatal(err)
+        }
+
+        aliases, err := readAliases(asset_dir)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if len(aliases) != 1 || aliases["stable"] != version {
+            t.Fatalf("unexpected aliases; %v", aliases)
+        }
+
+        logs, err := readAllLogs(reg)
+        if err != nil {
+            t.Fatalf("failed to read the logs; %v", err)
+        }
+        if len(logs) != 1 || logs[0].Type != "set-alias" || *(logs[0].Alias) != "stable" || *(logs[0].Version) != version || *(logs[0].Asset) != asset {
+            t.Fatalf("unexpected logs; %v", logs)
+        }
+
+        r, err := http.NewRequest("GET", "/list?path=" + project + "/" + asset + "/@stable", nil)
+        if err != nil {
+            t.Fatal(err)
+        }
+        all, err := listFilesHandler(r, reg, nil, nil)
+        if err != nil {
+            t.Fatal(err)
+        }
+        found := false
+        for _, f := range all {
+            if f == "random" {
+                found = true
+            }
+        }
+        if !found {
+            t.Fatalf("unexpected listing for an aliased version; %v", all)
+        }
+    })
+
+    t.Run("set failures", func(t *testing.T) {
+        reqpath, err := dumpRequest("set_alias", fmt.Sprintf(`{ "project": "%s", "asset": "%s", "alias": "stable", "version": "bar" }`, project, asset))
+        if err != nil {
+            t.Fatalf("failed to dump a request type; %v", err)
+        }
+        err = setAliasHandler(reqpath, &globals, ctx)
+        if err == nil || !strings.Contains(err.Error(), "does not exist") {
+            t.Fatalf("expected failure for a missing version; %v", err)
+        }
+
+        reqpath, err = dumpRequest("set_alias", fmt.Sprintf(`{ "project": "%s", "asset": "%s", "alias": "@stable", "version": "%s" }`, project, asset, version))
+        if err != nil {
+            t.Fatalf("failed to dump a request type; %v", err)
+        }
+        err = setAliasHandler(reqpath, &globals, ctx)
+        if err == nil || !strings.Contains(err.Error(), "invalid 'alias'") {
+            t.Fatalf("expected failure for an invalid alias; %v", err)
+        }
+
+        reqpath, err = dumpRequest("set_alias", fmt.Sprintf(`{ "project": "%s", "asset": "%s", "alias": "stable" }`, project, asset))
+        if err != nil {
+            t.Fatalf("failed to dump a request type; %v", err)
+        }
+        err = setAliasHandler(reqpath, &globals, ctx)
+        if err == nil || !strings.Contains(err.Error(), "invalid 'version'") {
+            t.Fatalf("expected failure for a missing version; %v", err)
+        }
+    })
+
+    t.Run("remove", func(t *testing.T) {
+        reqpath, err := dumpRequest("remove_alias", fmt.Sprintf(`{ "project": "%s", "asset": "%s", "alias": "stable" }`, project, asset))
+        if err != nil {
+            t.Fatalf("failed to dump a request type; %v", err)
+        }
+        err = removeAliasHandler(reqpath, &globals, ctx)
+        if err != nil {
+            t.Fatal(err)
+        }
+
+        aliases, err := readAliases(asset_dir)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if len(aliases) != 0 {
+            t.Fatalf("unexpected aliases; %v", aliases)
+        }
+
+        err = removeAliasHandler(reqpath, &globals, ctx)
+        if err == nil || !strings.Contains(err.Error(), "does not exist") {
+            t.Fatalf("expected failure for a missing alias; %v", err)
+        }
+    })
+
+    t.Run("delete version", func(t *testing.T) {
+        err := setAliasHandler(set_req, &globals, ctx)
+        if err != nil {
+            t.Fatal(err)
+        }
+
+        globals.Administrators = append(globals.Administrators, self)
+        defer func() { globals.Administrators = nil }()
+        reqpath, err := dumpRequest("delete_version", fmt.Sprintf(`{ "project": "%s", "asset": "%s", "version": "%s" }`, project, asset, version))
+        if err != nil {
+            t.Fatalf("failed to dump a request type; %v", err)
+        }
+        err = deleteVersionHandler(reqpath, &globals, ctx)
+        if err != nil {
+            t.Fatal(err)
+        }
+
+        aliases, err := readAliases(asset_dir)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if len(aliases) != 0 {
+            t.Fatalf("expected aliases to be removed after version deletion; %v", aliases)
+        }
+    })
+}
@@ -0,0 +1,560 @@
+package main
+
+import (
+    "fmt"
+    "path/filepath"
+    "os"
+    "encoding/json"
+    "net/http"
+    "context"
+    "strings"
+    "errors"
+    "log"
+)
+
+// Checks that no path in the manifest lies inside another path, which would occur if an amendment replaces a directory with a file or vice versa.
+func checkAmendedPathConflicts(manifest map[string]manifestEntry) error {
+    for k, _ := range manifest {
+        parent := filepath.Dir(k)
+        for parent != "." {
+            if _, ok := manifest[parent]; ok {
+                return fmt.Errorf("path %q conflicts with existing path %q", k, parent)
+            }
+            parent = filepath.Dir(parent)
+        }
+    }
+    return nil
+}
+
+func amendProbationHandler(reqpath string, globals *globalConfiguration, ctx context.Context) error {
+    incoming := struct {
+        Project *string `json:"project"`
+        Asset *string `json:"asset"`
+        Version *string `json:"version"`
+        Source *string `json:"source"`
+        Delete []string `json:"delete"`
+        Spoof *string `json:"spoof"`
+    }{}
+    {
+        handle, err := os.ReadFile(reqpath)
+        if err != nil {
+            return fmt.Errorf("failed to read %q; %w", reqpath, err)
+        }
+
+        err = json.Unmarshal(handle, &incoming)
+        if err != nil {
+            return newHttpError(http.StatusBadRequest, fmt.Errorf("failed to parse JSON from %q; %w", reqpath, err))
+        }
+
+        err = isMissingOrBadName(incoming.Project)
+        if err != nil {
+            return newHttpError(http.StatusBadRequest, fmt.Errorf("invalid 'project' property in %q; %w", reqpath, err))
+        }
+
+        err = isMissingOrBadName(incoming.Asset)
+        if err != nil {
+            return newHttpError(http.StatusBadRequest, fmt.Errorf("invalid 'asset' property in %q; %w", reqpath, err))
+        }
+
+        err = isMissingOrBadName(incoming.Version)
+        if err != nil {
+            return newHttpError(http.StatusBadRequest, fmt.Errorf("invalid 'version' property in %q; %w", reqpath, err))
+        }
+
+        if incoming.Source == nil && len(incoming.Delete) == 0 {
+            return newHttpError(http.StatusBadRequest, fmt.Errorf("expected at least one of 'source' or 'delete' in %q", reqpath))
+        }
+
+        for i, p := range incoming.Delete {
+            cleaned := filepath.Clean(p)
+            if !filepath.IsLocal(cleaned) {
+                return newHttpError(http.StatusBadRequest, fmt.Errorf("invalid path %q in 'delete' of %q; should be local to the version directory", p, reqpath))
+            }
+            for _, component := range strings.Split(cleaned, "/") {
+                if strings.HasPrefix(component, "..") {
+                    return newHttpError(http.StatusBadRequest, fmt.Errorf("invalid path %q in 'delete' of %q; cannot refer to internal '..' files", p, reqpath))
+                }
+            }
+            incoming.Delete[i] = cleaned
+        }
+    }
+
+    request_user, err := identifyUser(reqpath)
+    if err != nil {
+        return fmt.Errorf("failed to find owner of %q; %w", reqpath, err)
+    }
+
+    username, err := identifySpoofedUser(reqpath, incoming.Spoof, globals.SpoofPermissions)
+    if err != nil {
+        return fmt.Errorf("failed to identify user; %w", err)
+    }
+
+    // Same checks on the source directory as for uploads.
+    var source string
+    if incoming.Source != nil {
+        source_name := *(incoming.Source)
+        if source_name != filepath.Base(source_name) {
+            return newHttpError(http.StatusBadRequest, fmt.Errorf("expected 'source' to be a name, not a path, in %q", reqpath))
+        }
+
+        source = filepath.Join(filepath.Dir(reqpath), source_name)
+        source_info, err := os.Lstat(source)
+        if err != nil {
+            return newHttpError(http.StatusBadRequest, fmt.Errorf("failed to stat %q in the staging directory; %w", source_name, err))
+        }
+        if !source_info.IsDir() {
+            return newHttpError(http.StatusBadRequest, fmt.Errorf("expected %q to be a directory", source_name))
+        }
+
+        source_user, err := identifySpoofedUser(source, incoming.Spoof, globals.SpoofPermissions)
+        if err != nil {
+            return fmt.Errorf("failed to find owner of %q; %w", source, err)
+        }
+        if source_user != username {
+            return newHttpError(http.StatusForbidden, fmt.Errorf("requesting user must be the same as the owner of the 'source' directory (%s vs %s)", source_user, username))
+        }
+    }
+
+    rlock, err := lockDirectoryShared(globals.Registry, globals, ctx)
+    if err != nil {
+        return fmt.Errorf("failed to lock the registry %q; %w", globals.Registry, err)
+    }
+    defer rlock.Unlock(globals)
+
+    rnnlock, err := lockDirectoryNewDirShared(globals.Registry, globals, ctx)
+    if err != nil {
+        return fmt.Errorf("failed to lock the registry %q; %w", globals.Registry, err)
+    }
+    defer rnnlock.Unlock(globals)
+
+    project := *(incoming.Project)
+    project_dir := filepath.Join(globals.Registry, project)
+    if err := checkProjectExists(project_dir, project); err != nil {
+        return err
+    }
+    rnnlock.Unlock(globals) // no need for this lock once we determine that the project directory exists.
+
+    plock, err := lockDirectoryShared(project_dir, globals, ctx)
+    if err != nil {
+        return fmt.Errorf("failed to lock project directory %q; %w", project_dir, err)
+    }
+    defer plock.Unlock(globals)
+
+    pnnlock, err := lockDirectoryNewDirShared(project_dir, globals, ctx)
+    if err != nil {
+        return fmt.Errorf("failed to lock project directory %q; %w", project_dir, err)
+    }
+    defer pnnlock.Unlock(globals)
+
+    asset := *(incoming.Asset)
+    asset_dir := filepath.Join(project_dir, asset)
+    if err := checkAssetExists(asset_dir, asset, project); err != nil {
+        return err
+    }
+    pnnlock.Unlock(globals) // no need for this lock once we determine that the asset directory exists.
+
+    alock, err := lockDirectoryExclusive(asset_dir, globals, ctx)
+    if err != nil {
+        return fmt.Errorf("failed to lock asset directory %q; %w", asset_dir, err)
+    }
+    defer alock.Unlock(globals)
+
+    version := *(incoming.Version)
+    version_dir := filepath.Join(asset_dir, version)
+    if err := checkVersionExists(version_dir, version, asset, project); err != nil {
+        return err
+    }
+
+    summ, err := readSummary(version_dir)
+    if err != nil {
+        return fmt.Errorf("failed to read the version summary at %q; %w", version_dir, err)
+    }
+    if !summ.IsProbational() {
+        return newHttpError(http.StatusBadRequest, fmt.Errorf("version directory at %q is not on probation", version_dir))
+    }
+
+    // Only the original uploader or the owners can amend a probational version.
+    if summ.UploadUserId != username {
+        existing_perms, err := readPermissions(project_dir)
+        if err != nil {
+            return fmt.Errorf("failed to read permissions for %q; %w", project_dir, err)
+        }
+        asset_perms, err := addAssetPermissionsForUpload(existing_perms, asset_dir, asset)
+        if err != nil {
+            return fmt.Errorf("failed to read permissions for asset %q in %q; %w", asset, project, err)
+        }
+        if !isAuthorizedToMaintain(username, globals.Administrators, asset_perms.Owners) {
+            return newHttpError(http.StatusForbidden, fmt.Errorf("user %q is not authorized to amend %q", username, version_dir))
+        }
+    }
+
+    old_manifest, err := readManifest(version_dir)
+    if err != nil {
+        return fmt.Errorf("failed to read the manifest for %q; %w", version_dir, err)
+    }
+
+    deleted := []string{}
+    if len(incoming.Delete) > 0 {
+        deleted, err = selectClonedPaths(old_manifest, incoming.Delete)
+        if err != nil {
+            return newHttpError(http.StatusBadRequest, err)
+        }
+    }
+
+    // The amended version is assembled in a staging directory inside the asset, which is swapped with the existing version directory once complete.
+    // This directory is prefixed with '..' so that it is ignored by all other operations on the asset.
+    temp_version := "..amend-" + version
+
+    if incoming.Source != nil {
+        err = checkAmendQuota(source, project, asset, temp_version, old_manifest, deleted, globals, ctx)
+        if err != nil {
+            return err
+        }
+    }
+
+    temp_dir := filepath.Join(asset_dir, temp_version)
+    err = os.RemoveAll(temp_dir)
+    if err != nil {
+        return fmt.Errorf("failed to clear the temporary directory %q; %w", temp_dir, err)
+    }
+    err = os.Mkdir(temp_dir, 0755)
+    if err != nil {
+        return fmt.Errorf("failed to create the temporary directory %q; %w", temp_dir, err)
+    }
+    defer os.RemoveAll(temp_dir)
+
+    new_manifest := map[string]manifestEntry{}
+    if incoming.Source != nil {
+        err = transferDirectory(
+            source,
+            globals.Registry,
+            project,
+            asset,
+            temp_version,
+            ctx,
+            globals.ConcurrencyThrottle,
+            transferDirectoryOptions{
+                LinkMode: linkModeCopy,
+                LinkWhitelist: globals.LinkWhitelist,
+                Digests: globals.Digests,
+                Globals: globals,
+            },
+        )
+        if err != nil {
+            return fmt.Errorf("failed to transfer files from %q; %w", source, err)
+        }
+
+        new_manifest, err = readManifest(temp_dir)
+        if err != nil {
+            return fmt.Errorf("failed to read the manifest for %q; %w", temp_dir, err)
+        }
+
+        // Links between files in the source directory refer to the temporary directory, so we redirect them to the amended version.
+        // The symlinks themselves are relative and remain valid once the temporary directory replaces the version directory.
+        redirect := func(link *linkMetadata) {
+            if link != nil && link.Project == project && link.Asset == asset && link.Version == temp_version {
+                link.Version = version
+            }
+        }
+        for _, entry := range new_manifest {
+            if entry.Link != nil {
+                redirect(entry.Link)
+                redirect(entry.Link.Ancestor)
+            }
+        }
+    }
+
+    // Assembling the amended manifest and checking for conflicts before touching anything in the version directory.
+    final_manifest := map[string]manifestEntry{}
+    for k, v := range old_manifest {
+        final_manifest[k] = v
+    }
+    for _, k := range deleted {
+        delete(final_manifest, k)
+    }
+    for k, v := range new_manifest {
+        final_manifest[k] = v
+    }
+    for k, v := range final_manifest {
+        // Empty directories that now contain new files are no longer empty.
+        if v.Link == nil && v.Md5sum == "" {
+            for nk, _ := range new_manifest {
+                if strings.HasPrefix(nk, k + "/") {
+                    delete(final_manifest, k)
+                    break
+                }
+            }
+        }
+    }
+    err = checkAmendedPathConflicts(final_manifest)
+    if err != nil {
+        return newHttpError(http.StatusBadRequest, err)
+    }
+
+    lost_keys := map[string]bool{}
+    for _, k := range deleted {
+        lost_keys[k] = true
+    }
+    for k, _ := range new_manifest {
+        if _, ok := old_manifest[k]; ok {
+            lost_keys[k] = true
+        }
+    }
+
+    // Any remaining files that link to lost files in the same version are rerouted, in the same manner as for deletions.
+    relative_version_dir := filepath.Join(project, asset, version)
+    lost_files := map[string]bool{}
+    for k, _ := range lost_keys {
+        lost_files[filepath.Join(relative_version_dir, k)] = true
+    }
+    proposal, err := proposeLinkReroutes(globals.Registry, lost_files, relative_version_dir)
+    if err != nil {
+        return err
+    }
+    filtered := &rerouteProposal{ Actions: []rerouteAction{}, DeltaManifest: map[string]manifestEntry{} }
+    for _, action := range proposal.Actions {
+        if !lost_keys[action.Key] {
+            filtered.Actions = append(filtered.Actions, action)
+        }
+    }
+    for k, v := range proposal.DeltaManifest {
+        if !lost_keys[k] {
+            filtered.DeltaManifest[k] = v
+        }
+    }
+
+    var usage_delta int64
+    for k, _ := range lost_keys {
+        if entry := old_manifest[k]; entry.Link == nil {
+            usage_delta -= entry.Size
+        }
+    }
+    for _, entry := range new_manifest {
+        if entry.Link == nil {
+            usage_delta += entry.Size
+        }
+    }
+    for _, action := range filtered.Actions {
+        usage_delta += action.Usage
+    }
+
+    // Populating the staging directory with the retained files, leaving the existing version directory untouched.
+    // Regular files are hard-linked to avoid copying, while symlinks are recreated with the same relative targets as the staging directory is at the same depth.
+    rerouted := map[string]*rerouteAction{}
+    for i, action := range filtered.Actions {
+        rerouted[action.Key] = &(filtered.Actions[i])
+    }
+    for k, _ := range final_manifest {
+        if _, ok := new_manifest[k]; ok {
+            continue
+        }
+        err := stageAmendedPath(globals.Registry, version_dir, temp_dir, k, rerouted[k])
+        if err != nil {
+            return err
+        }
+    }
+    for k, v := range filtered.DeltaManifest {
+        final_manifest[k] = v
+    }
+
+    err = copyVersionInternalFiles(version_dir, temp_dir)
+    if err != nil {
+        return err
+    }
+
+    // Recomputing the provenance of the summary, as the file counts and sizes are no longer correct after the amendment.
+    summ.AddProvenance(reqpath, request_user, final_manifest, globals)
+    err = dumpJson(filepath.Join(temp_dir, summaryFileName), summ)
+    if err != nil {
+        return fmt.Errorf("failed to save summary for %q; %w", version_dir, err)
+    }
+
+    manifest_path := filepath.Join(temp_dir, manifestFileName)
+    err = dumpJson(manifest_path, &final_manifest)
+    if err != nil {
+        return fmt.Errorf("failed to save manifest for %q; %w", version_dir, err)
+    }
+
+    _, err = recreateLinkFiles(temp_dir, final_manifest)
+    if err != nil {
+        return fmt.Errorf("failed to create linkfiles; %w", err)
+    }
+
+    // Swapping the staging directory with the existing version directory, which is restored if any of the subsequent steps fail.
+    backup_dir := filepath.Join(asset_dir, "..unamended-" + version)
+    err = os.RemoveAll(backup_dir)
+    if err != nil {
+        return fmt.Errorf("failed to clear the backup directory %q; %w", backup_dir, err)
+    }
+    err = os.Rename(version_dir, backup_dir)
+    if err != nil {
+        return fmt.Errorf("failed to move %q to %q; %w", version_dir, backup_dir, err)
+    }
+    defer os.RemoveAll(backup_dir)
+
+    has_failed := true
+    defer func() {
+        if has_failed {
+            restoreAmendedVersion(version_dir, backup_dir)
+        }
+    }()
+
+    err = os.Rename(temp_dir, version_dir)
+    if err != nil {
+        return fmt.Errorf("failed to move %q to %q; %w", temp_dir, version_dir, err)
+    }
+
+    // The quota is checked here, after all other changes have succeeded, so that the usage is only modified if the amendment is successful.
+    err = editUsage(project_dir, usage_delta, globals, ctx)
+    if err != nil {
+        return err
+    }
+    has_failed = false
+
+    // Errors are not fatal as the amendment has already been committed, and the index is rebuilt by refreshing the latest version.
+    err = updateVersionIndex(asset_dir, version)
+    if err != nil {
+        log.Printf("failed to update the version index for %q; %v", version_dir, err)
+    }
+
+    return nil
+}
+
+// Rejects amendments that would exceed the quota before any files are transferred, in the same manner as checkUploadQuota().
+// Files in the existing version that are deleted or replaced by the amendment are assumed to be freed, ignoring any rerouting of links to those files.
+// The quota is checked again in editUsage() once the amended version is in place.
+func checkAmendQuota(source, project, asset, temp_version string, old_manifest map[string]manifestEntry, deleted []string, globals *globalConfiguration, ctx context.Context) error {
+    project_dir := filepath.Join(globals.Registry, project)
+    usage, err := readUsage(project_dir)
+    if err != nil {
+        return fmt.Errorf("failed to read existing usage for %q; %w", project_dir, err)
+    }
+
+    lost_keys := map[string]bool{}
+    for _, k := range deleted {
+        lost_keys[k] = true
+    }
+    var freed int64
+    for k, entry := range old_manifest {
+        if entry.Link != nil {
+            continue
+        }
+        if !lost_keys[k] {
+            if _, err := os.Lstat(filepath.Join(source, k)); err != nil {
+                continue
+            }
+        }
+        freed += entry.Size
+    }
+
+    bound, err := estimateTransferUsage(source, false, nil)
+    if err != nil {
+        return fmt.Errorf("failed to inspect files in %q; %w", source, err)
+    }
+
+    err = checkQuota(project_dir, usage, bound - freed)
+    var http_err *httpError
+    if err == nil || !errors.As(err, &http_err) {
+        return err
+    }
+
+    report := newWalkDirectoryReport()
+    err = transferDirectory(
+        source,
+        globals.Registry,
+        project,
+        asset,
+        temp_version,
+        ctx,
+        globals.ConcurrencyThrottle,
+        transferDirectoryOptions{
+            LinkWhitelist: globals.LinkWhitelist,
+            Digests: globals.Digests,
+            DryRun: true,
+            Report: report,
+        },
+    )
+    if err != nil {
+        return fmt.Errorf("failed to inspect files in %q; %w", source, err)
+    }
+    return checkQuota(project_dir, usage, report.Usage - freed)
+}
+
+// Adds a retained path from the existing version directory to the staging directory,
+// or applies the reroute action if the path was a link to a lost file in the same version.
+func stageAmendedPath(registry, version_dir, staging_dir, path string, action *rerouteAction) error {
+    src := filepath.Join(version_dir, path)
+    dest := filepath.Join(staging_dir, path)
+    err := os.MkdirAll(filepath.Dir(dest), 0755)
+    if err != nil {
+        return fmt.Errorf("failed to create parent directories for %q; %w", dest, err)
+    }
+
+    if action != nil {
+        if action.Copy {
+            return copyFile(filepath.Join(registry, action.Source), dest)
+        }
+        return createSymlink(dest, registry, action.Link, /* wipe_existing = */ false)
+    }
+
+    info, err := os.Lstat(src)
+    if err != nil {
+        return fmt.Errorf("failed to stat %q; %w", src, err)
+    }
+
+    if info.Mode() & os.ModeSymlink != 0 {
+        target, err := os.Readlink(src)
+        if err != nil {
+            return fmt.Errorf("failed to read the symlink at %q; %w", src, err)
+        }
+        err = os.Symlink(target, dest)
+        if err != nil {
+            return fmt.Errorf("failed to create a symlink at %q; %w", dest, err)
+        }
+    } else if info.IsDir() {
+        err = os.MkdirAll(dest, 0755)
+        if err != nil {
+            return fmt.Errorf("failed to create directory at %q; %w", dest, err)
+        }
+    } else {
+        err = os.Link(src, dest)
+        if err != nil {
+            return fmt.Errorf("failed to link %q to %q; %w", src, dest, err)
+        }
+    }
+    return nil
+}
+
+// Copies the internal files (e.g., summary, metadata) at the top level of the version directory, except for those that are recreated from the amended manifest.
+func copyVersionInternalFiles(version_dir, staging_dir string) error {
+    entries, err := os.ReadDir(version_dir)
+    if err != nil {
+        return fmt.Errorf("failed to list the contents of %q; %w", version_dir, err)
+    }
+    for _, entry := range entries {
+        name := entry.Name()
+        if !strings.HasPrefix(name, "..") || name == manifestFileName || name == linksFileName || !entry.Type().IsRegular() {
+            continue
+        }
+        err := copyFile(filepath.Join(version_dir, name), filepath.Join(staging_dir, name))
+        if err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// Swaps the original version directory back into place after a failed amendment.
+func restoreAmendedVersion(version_dir, backup_dir string) {
+    if _, err := os.Lstat(version_dir); err == nil {
+        err := os.RemoveAll(version_dir)
+        if err != nil {
+            log.Printf("failed to remove the amended version at %q; %v", version_dir, err)
+            return
+        }
+    }
+    err := os.Rename(backup_dir, version_dir)
+    if err != nil {
+        log.Printf("failed to restore the original version from %q to %q; %v", backup_dir, version_dir, err)
+    }
+}
@@ -0,0 +1,403 @@
+package main
+
+import (
+    "testing"
+    "os"
+    "path/filepath"
+    "fmt"
+    "strings"
+    "context"
+)
+
+func TestAmendProbationHandler(t *testing.T) {
+    reg, err := constructMockRegistry()
+    if err != nil {
+        t.Fatalf("failed to create the registry; %v", err)
+    }
+    globals := newGlobalConfiguration(reg, 2)
+    ctx := context.Background()
+
+    project := "pokemon"
+    asset := "pikachu"
+    version := "red"
+    err = setupProjectForUploadTest(project, &globals)
+    if err != nil {
+        t.Fatalf("failed to set up project directory; %v", err)
+    }
+
+    src, err := setupSourceForWalkDirectoryTest()
+    if err != nil {
+        t.Fatalf("failed to set up test directories; %v", err)
+    }
+    err = os.Symlink("thunderbolt", filepath.Join(src, "moves", "electric", "favorite"))
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    reqname, err := dumpRequest("upload", fmt.Sprintf(`{ "source": "%s", "project": "%s", "asset": "%s", "version": "%s", "on_probation": true }`, filepath.Base(src), project, asset, version))
+    if err != nil {
+        t.Fatalf("failed to create upload request; %v", err)
+    }
+    err = uploadHandler(reqname, &globals, ctx)
+    if err != nil {
+        t.Fatalf("failed to perform the upload; %v", err)
+    }
+
+    version_dir := filepath.Join(reg, project, asset, version)
+    old_manifest, err := readManifest(version_dir)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if old_manifest["moves/electric/favorite"].Link == nil {
+        t.Fatal("expected a local link in the probational version")
+    }
+
+    original_summ, err := readSummary(version_dir)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    // The provenance in the summary should be recomputed from the amended manifest.
+    checkSummary := func(t *testing.T) *summaryMetadata {
+        summ, err := readSummary(version_dir)
+        if err != nil {
+            t.Fatal(err)
+        }
+        manifest, err := readManifest(version_dir)
+        if err != nil {
+            t.Fatal(err)
+        }
+
+        var count, total, deduplicated int64
+        for _, entry := range manifest {
+            if entry.Link == nil && entry.Md5sum == "" {
+                continue
+            }
+            count++
+            total += entry.Size
+            if entry.Link != nil {
+                deduplicated += entry.Size
+            }
+        }
+        if summ.FileCount == nil || *(summ.FileCount) != count {
+            t.Fatalf("unexpected file count in the summary; %v", summ.FileCount)
+        }
+        if summ.TotalBytes == nil || *(summ.TotalBytes) != total {
+            t.Fatalf("unexpected total bytes in the summary; %v", summ.TotalBytes)
+        }
+        if summ.DeduplicatedBytes == nil || *(summ.DeduplicatedBytes) != deduplicated {
+            t.Fatalf("unexpected deduplicated bytes in the summary; %v", summ.DeduplicatedBytes)
+        }
+        return summ
+    }
+
+    amend := func(body string) error {
+        reqname, err := dumpRequest("amend_probation", body)
+        if err != nil {
+            t.Fatalf("failed to create amend request; %v", err)
+        }
+        return amendProbationHandler(reqname, &globals, ctx)
+    }
+
+    t.Run("simple", func(t *testing.T) {
+        amended, err := os.MkdirTemp("", "")
+        if err != nil {
+            t.Fatal(err)
+        }
+        err = os.MkdirAll(filepath.Join(amended, "moves", "electric"), 0755)
+        if err != nil {
+            t.Fatal(err)
+        }
+        err = os.WriteFile(filepath.Join(amended, "moves", "electric", "thunderbolt"), []byte("95"), 0644)
+        if err != nil {
+            t.Fatal(err)
+        }
+        err = os.MkdirAll(filepath.Join(amended, "stats"), 0755)
+        if err != nil {
+            t.Fatal(err)
+        }
+        err = os.WriteFile(filepath.Join(amended, "stats", "speed"), []byte("90"), 0644)
+        if err != nil {
+            t.Fatal(err)
+        }
+        err = os.Symlink("speed", filepath.Join(amended, "stats", "fastest"))
+        if err != nil {
+            t.Fatal(err)
+        }
+
+        err = amend(fmt.Sprintf(`{ "project": "%s", "asset": "%s", "version": "%s", "source": "%s", "delete": [ "evolution" ] }`, project, asset, version, filepath.Base(amended)))
+        if err != nil {
+            t.Fatalf("failed to amend the version; %v", err)
+        }
+
+        manifest, err := readManifest(version_dir)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if _, ok := manifest["evolution/up"]; ok {
+            t.Fatal("expected deleted files to be removed from the manifest")
+        }
+        if _, err := os.Stat(filepath.Join(version_dir, "evolution")); err == nil || !os.IsNotExist(err) {
+            t.Fatal("expected emptied directories to be removed")
+        }
+
+        err = verifyFileContents(filepath.Join(version_dir, "moves", "electric", "thunderbolt"), "95")
+        if err != nil {
+            t.Fatal(err)
+        }
+        if manifest["moves/electric/thunderbolt"].Size != 2 || manifest["moves/electric/thunderbolt"].Md5sum == old_manifest["moves/electric/thunderbolt"].Md5sum {
+            t.Fatal("expected the manifest entry to be replaced")
+        }
+
+        // Links to the replaced file should now be real files with the old contents.
+        err = verifyFileContents(filepath.Join(version_dir, "moves", "electric", "favorite"), "90")
+        if err != nil {
+            t.Fatal(err)
+        }
+        if manifest["moves/electric/favorite"].Link != nil {
+            t.Fatal("expected the link to the replaced file to be converted into a copy")
+        }
+        if _, err := os.Stat(filepath.Join(version_dir, "moves", "electric", linksFileName)); err == nil || !os.IsNotExist(err) {
+            t.Fatal("expected the unnecessary linkfile to be removed")
+        }
+
+        // Links within the amendment should refer to the amended version.
+        err = verifyFileContents(filepath.Join(version_dir, "stats", "fastest"), "90")
+        if err != nil {
+            t.Fatal(err)
+        }
+        link := manifest["stats/fastest"].Link
+        if link == nil || link.Project != project || link.Asset != asset || link.Version != version || link.Path != "stats/speed" {
+            t.Fatalf("unexpected link for the amended file; %v", link)
+        }
+        links, err := readLinkfile(filepath.Join(version_dir, "stats", linksFileName))
+        if err != nil {
+            t.Fatal(err)
+        }
+        if links["fastest"] == nil || links["fastest"].Version != version {
+            t.Fatalf("unexpected linkfile contents; %v", links)
+        }
+
+        // Temporary directory should be cleaned up.
+        listing, err := os.ReadDir(filepath.Join(reg, project, asset))
+        if err != nil {
+            t.Fatal(err)
+        }
+        for _, entry := range listing {
+            if strings.HasPrefix(entry.Name(), "..amend") || strings.HasPrefix(entry.Name(), "..unamended") {
+                t.Fatalf("unexpected leftover temporary directory %q", entry.Name())
+            }
+        }
+
+        // Internal files are carried over from the original version.
+        summ := checkSummary(t)
+        if !summ.IsProbational() {
+            t.Fatal("expected the amended version to still be probational")
+        }
+        if summ.UploadStart != original_summ.UploadStart || *(summ.TotalBytes) != *(original_summ.TotalBytes) - 7 { // deleted 'raichu' and 'pichu', added '90' and its link.
+            t.Fatalf("unexpected summary after amendment; %v", summ)
+        }
+
+        usage, err := readUsage(filepath.Join(reg, project))
+        if err != nil {
+            t.Fatal(err)
+        }
+        expected_usage, err := computeProjectUsage(filepath.Join(reg, project))
+        if err != nil {
+            t.Fatal(err)
+        }
+        if usage.Total != expected_usage {
+            t.Fatalf("recorded usage %v is not consistent with the actual usage %v", usage.Total, expected_usage)
+        }
+    })
+
+    t.Run("delete only", func(t *testing.T) {
+        err := amend(fmt.Sprintf(`{ "project": "%s", "asset": "%s", "version": "%s", "delete": [ "moves/normal/double_team" ] }`, project, asset, version))
+        if err != nil {
+            t.Fatalf("failed to amend the version; %v", err)
+        }
+
+        manifest, err := readManifest(version_dir)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if _, ok := manifest["moves/normal/double_team"]; ok {
+            t.Fatal("expected deleted file to be removed from the manifest")
+        }
+        if _, ok := manifest["moves/normal/quick_attack"]; !ok {
+            t.Fatal("expected other files to be retained")
+        }
+
+        summ := checkSummary(t)
+        if *(summ.FileCount) != *(original_summ.FileCount) - 1 {
+            t.Fatalf("expected the file count to decrease after deletion; %v", *(summ.FileCount))
+        }
+    })
+
+    t.Run("failures", func(t *testing.T) {
+        err := amend(fmt.Sprintf(`{ "project": "%s", "asset": "%s", "version": "%s" }`, project, asset, version))
+        if err == nil || !strings.Contains(err.Error(), "at least one") {
+            t.Fatalf("expected failure for an empty amendment; %v", err)
+        }
+
+        err = amend(fmt.Sprintf(`{ "project": "%s", "asset": "%s", "version": "%s", "delete": [ "missing" ] }`, project, asset, version))
+        if err == nil || !strings.Contains(err.Error(), "does not exist") {
+            t.Fatalf("expected failure for a missing path; %v", err)
+        }
+
+        err = amend(fmt.Sprintf(`{ "project": "%s", "asset": "%s", "version": "%s", "delete": [ "../foo" ] }`, project, asset, version))
+        if err == nil || !strings.Contains(err.Error(), "local") {
+            t.Fatalf("expected failure for a non-local path; %v", err)
+        }
+
+        conflicting, err := os.MkdirTemp("", "")
+        if err != nil {
+            t.Fatal(err)
+        }
+        err = os.MkdirAll(filepath.Join(conflicting, "type"), 0755)
+        if err != nil {
+            t.Fatal(err)
+        }
+        err = os.WriteFile(filepath.Join(conflicting, "type", "primary"), []byte("electric"), 0644)
+        if err != nil {
+            t.Fatal(err)
+        }
+        err = amend(fmt.Sprintf(`{ "project": "%s", "asset": "%s", "version": "%s", "source": "%s" }`, project, asset, version, filepath.Base(conflicting)))
+        if err == nil || !strings.Contains(err.Error(), "conflicts") {
+            t.Fatalf("expected failure for a conflicting path; %v", err)
+        }
+        err = verifyFileContents(filepath.Join(version_dir, "type"), "electric")
+        if err != nil {
+            t.Fatalf("expected the version to be unchanged after a failed amendment; %v", err)
+        }
+
+        // Replacing the file with a directory is fine if the file is deleted.
+        err = amend(fmt.Sprintf(`{ "project": "%s", "asset": "%s", "version": "%s", "source": "%s", "delete": [ "type" ] }`, project, asset, version, filepath.Base(conflicting)))
+        if err != nil {
+            t.Fatalf("failed to amend the version; %v", err)
+        }
+        err = verifyFileContents(filepath.Join(version_dir, "type", "primary"), "electric")
+        if err != nil {
+            t.Fatal(err)
+        }
+    })
+
+    t.Run("quota", func(t *testing.T) {
+        project_dir := filepath.Join(reg, project)
+        before_usage, err := readUsage(project_dir)
+        if err != nil {
+            t.Fatal(err)
+        }
+        before_manifest, err := readManifest(version_dir)
+        if err != nil {
+            t.Fatal(err)
+        }
+
+        err = dumpJson(filepath.Join(project_dir, quotaFileName), &quotaMetadata{ Baseline: before_usage.Total, GrowthRate: 0, Year: 2000 })
+        if err != nil {
+            t.Fatal(err)
+        }
+        defer os.Remove(filepath.Join(project_dir, quotaFileName))
+
+        bigger, err := os.MkdirTemp("", "")
+        if err != nil {
+            t.Fatal(err)
+        }
+        err = os.WriteFile(filepath.Join(bigger, "extra"), []byte("thunder"), 0644)
+        if err != nil {
+            t.Fatal(err)
+        }
+        err = amend(fmt.Sprintf(`{ "project": "%s", "asset": "%s", "version": "%s", "source": "%s", "delete": [ "moves/normal/quick_attack" ] }`, project, asset, version, filepath.Base(bigger)))
+        if err == nil || !strings.Contains(err.Error(), "quota") {
+            t.Fatalf("expected failure when exceeding the quota; %v", err)
+        }
+        if _, err := os.Stat(filepath.Join(reg, project, asset, "..amend-" + version)); err == nil {
+            t.Fatal("expected the quota to be checked before any files are transferred")
+        }
+
+        // Deleting a file that is the target of a link requires a copy, which is only detected after the transfer.
+        smaller, err := os.MkdirTemp("", "")
+        if err != nil {
+            t.Fatal(err)
+        }
+        err = os.WriteFile(filepath.Join(smaller, "extra"), []byte("1"), 0644)
+        if err != nil {
+            t.Fatal(err)
+        }
+        err = amend(fmt.Sprintf(`{ "project": "%s", "asset": "%s", "version": "%s", "source": "%s", "delete": [ "stats/speed" ] }`, project, asset, version, filepath.Base(smaller)))
+        if err == nil || !strings.Contains(err.Error(), "quota") {
+            t.Fatalf("expected failure when exceeding the quota; %v", err)
+        }
+
+        // The original version should be fully restored.
+        manifest, err := readManifest(version_dir)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if len(manifest) != len(before_manifest) {
+            t.Fatalf("expected the manifest to be unchanged after a failed amendment; %v", manifest)
+        }
+        if _, err := os.Stat(filepath.Join(version_dir, "extra")); err == nil {
+            t.Fatal("expected no new files after a failed amendment")
+        }
+        if _, err := os.Stat(filepath.Join(version_dir, "moves", "normal", "quick_attack")); err != nil {
+            t.Fatalf("expected deleted files to be restored after a failed amendment; %v", err)
+        }
+
+        after_usage, err := readUsage(project_dir)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if after_usage.Total != before_usage.Total {
+            t.Fatalf("expected the usage to be unchanged after a failed amendment; %v", after_usage.Total)
+        }
+
+        listing, err := os.ReadDir(filepath.Join(reg, project, asset))
+        if err != nil {
+            t.Fatal(err)
+        }
+        for _, entry := range listing {
+            if strings.HasPrefix(entry.Name(), "..amend") || strings.HasPrefix(entry.Name(), "..unamended") {
+                t.Fatalf("unexpected leftover temporary directory %q", entry.Name())
+            }
+        }
+    })
+
+    t.Run("unauthorized", func(t *testing.T) {
+        summ, err := readSummary(version_dir)
+        if err != nil {
+            t.Fatal(err)
+        }
+        summ.UploadUserId = "ash"
+        err = dumpJson(filepath.Join(version_dir, summaryFileName), summ)
+        if err != nil {
+            t.Fatal(err)
+        }
+
+        err = os.WriteFile(filepath.Join(reg, project, permissionsFileName), []byte(`{ "owners": [], "uploaders": [] }`), 0644)
+        if err != nil {
+            t.Fatal(err)
+        }
+        err = amend(fmt.Sprintf(`{ "project": "%s", "asset": "%s", "version": "%s", "delete": [ "moves/normal/quick_attack" ] }`, project, asset, version))
+        if err == nil || !strings.Contains(err.Error(), "not authorized") {
+            t.Fatalf("expected failure for an unauthorized user; %v", err)
+        }
+    })
+
+    t.Run("not probational", func(t *testing.T) {
+        summ, err := readSummary(version_dir)
+        if err != nil {
+            t.Fatal(err)
+        }
+        summ.OnProbation = nil
+        err = dumpJson(filepath.Join(version_dir, summaryFileName), summ)
+        if err != nil {
+            t.Fatal(err)
+        }
+
+        err = amend(fmt.Sprintf(`{ "project": "%s", "asset": "%s", "version": "%s", "delete": [ "moves/normal/quick_attack" ] }`, project, asset, version))
+        if err == nil || !strings.Contains(err.Error(), "not on probation") {
+            t.Fatalf("expected failure for a non-probational version; %v", err)
+        }
+    })
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+    "os"
+    "encoding/json"
+    "fmt"
+    "errors"
+    "path/filepath"
+    "net/http"
+    "context"
+    "strings"
+)
+
+// The catalogue aggregates the permissions, usage, quota and latest versions for every project and asset in the registry,
+// so that clients can enumerate the registry contents without fetching each of the individual files.
+const catalogueFileName = "..catalogue"
+
+type catalogueAsset struct {
+    Latest *string `json:"latest,omitempty"`
+    Permissions *permissionsMetadata `json:"permissions,omitempty"`
+}
+
+type catalogueProject struct {
+    Permissions *permissionsMetadata `json:"permissions"`
+    Usage int64 `json:"usage"`
+    Quota *quotaMetadata `json:"quota,omitempty"`
+    AssetCount int `json:"asset_count"`
+    Assets map[string]catalogueAsset `json:"assets"`
+}
+
+// Returns nil if the catalogue does not exist.
+func readCatalogue(registry string) (map[string]catalogueProject, error) {
+    catalogue_path := filepath.Join(registry, catalogueFileName)
+
+    catalogue_raw, err := os.ReadFile(catalogue_path)
+    if err != nil {
+        if errors.Is(err, os.ErrNotExist) {
+            return nil, nil
+        }
+        return nil, fmt.Errorf("failed to read %q; %w", catalogue_path, err)
+    }
+
+    var output map[string]catalogueProject
+    err = json.Unmarshal(catalogue_raw, &output)
+    if err != nil {
+        return nil, fmt.Errorf("failed to parse JSON in %q; %w", catalogue_path, err)
+    }
+
+    return output, nil
+}
+
+func createCatalogueProject(project_dir string) (*catalogueProject, error) {
+    perms, err := readPermissions(project_dir)
+    if err != nil {
+        return nil, fmt.Errorf("failed to read permissions for %q; %w", project_dir, err)
+    }
+
+    usage, err := readUsage(project_dir)
+    if err != nil {
+        return nil, fmt.Errorf("failed to read usage for %q; %w", project_dir, err)
+    }
+
+    output := &catalogueProject{
+        Permissions: perms,
+        Usage: usage.Total,
+        Assets: map[string]catalogueAsset{},
+    }
+
+    quota, err := readQuota(project_dir)
+    if err == nil {
+        output.Quota = quota
+    } else if !errors.Is(err, os.ErrNotExist) {
+        return nil, fmt.Errorf("failed to read quota for %q; %w", project_dir, err)
+    }
+
+    assets, err := listUserDirectories(project_dir)
+    if err != nil {
+        return nil, fmt.Errorf("failed to list assets in %q; %w", project_dir, err)
+    }
+    output.AssetCount = len(assets)
+
+    for _, asset := range assets {
+        asset_dir := filepath.Join(project_dir, asset)
+        var entry catalogueAsset
+
+        latest, err := readLatest(asset_dir)
+        if err == nil {
+            entry.Latest = &(latest.Version)
+        } else if !errors.Is(err, os.ErrNotExist) {
+            return nil, fmt.Errorf("failed to read the latest version for %q; %w", asset_dir, err)
+        }
+
+        if _, err := os.Stat(filepath.Join(asset_dir, permissionsFileName)); err == nil {
+            asset_perms, err := readPermissions(asset_dir)
+            if err != nil {
+                return nil, fmt.Errorf("failed to read permissions for %q; %w", asset_dir, err)
+            }
+            entry.Permissions = asset_perms
+        } else if !errors.Is(err, os.ErrNotExist) {
+            return nil, fmt.Errorf("failed to stat permissions for %q; %w", asset_dir, err)
+        }
+
+        output.Assets[asset] = entry
+    }
+
+    return output, nil
+}
+
+// This does not acquire any locks, as it only reads files that are always replaced atomically.
+// At worst, the catalogue will be slightly out of date if a project is being modified, in which case it will be refreshed again by the modifying request.
+func createCatalogue(registry string) (map[string]catalogueProject, error) {
+    projects, err := listUserDirectories(registry)
+    if err != nil {
+        return nil, fmt.Errorf("failed to list projects in the registry; %w", err)
+    }
+
+    catalogue := map[string]catalogueProject{}
+    for _, project := range projects {
+        entry, err := createCatalogueProject(filepath.Join(registry, project))
+        if err != nil {
+            return nil, err
+        }
+        catalogue[project] = *entry
+    }
+
+    return catalogue, nil
+}
+
+func rebuildCatalogue(globals *globalConfiguration, ctx context.Context) error {
+    clock, err := lockRegistryWriteCatalogue(globals.Registry, globals, ctx)
+    if err != nil {
+        return fmt.Errorf("failed to lock the catalogue in %q; %w", globals.Registry, err)
+    }
+    defer clock.Unlock(globals)
+
+    catalogue, err := createCatalogue(globals.Registry)
+    if err != nil {
+        return err
+    }
+
+    catalogue_path := filepath.Join(globals.Registry, catalogueFileName)
+    err = dumpJson(catalogue_path, &catalogue)
+    if err != nil {
+        return fmt.Errorf("failed to save the catalogue at %q; %w", catalogue_path, err)
+    }
+    return nil
+}
+
+// Updates the catalogue entries for the specified projects, removing the entries for projects that no longer exist.
+// If the catalogue does not exist yet, it is rebuilt from scratch.
+func refreshCatalogueProjects(projects []string, globals *globalConfiguration, ctx context.Context) error {
+    clock, err := lockRegistryWriteCatalogue(globals.Registry, globals, ctx)
+    if err != nil {
+        return fmt.Errorf("failed to lock the catalogue in %q; %w", globals.Registry, err)
+    }
+    defer clock.Unlock(globals)
+
+    catalogue, err := readCatalogue(globals.Registry)
+    if err != nil {
+        return err
+    }
+    if catalogue == nil {
+        catalogue, err = createCatalogue(globals.Registry)
+        if err != nil {
+            return err
+        }
+    }
+
+    for _, project := range projects {
+        project_dir := filepath.Join(globals.Registry, project)
+        if _, err := os.Stat(project_dir); err != nil {
+            if errors.Is(err, os.ErrNotExist) {
+                delete(catalogue, project)
+                continue
+            }
+            return fmt.Errorf("failed to stat %q; %w", project_dir, err)
+        }
+
+        entry, err := createCatalogueProject(project_dir)
+        if err != nil {
+            return err
+        }
+        catalogue[project] = *entry
+    }
+
+    catalogue_path := filepath.Join(globals.Registry, catalogueFileName)
+    err = dumpJson(catalogue_path, &catalogue)
+    if err != nil {
+        return fmt.Errorf("failed to save the catalogue at %q; %w", catalogue_path, err)
+    }
+    return nil
+}
+
+// Refreshes the catalogue after a successful request that modified the registry.
+// Rerouting may change the usage of any project, so the entire catalogue is rebuilt in that case.
+func refreshCatalogueForRequest(reqtype string, reqpath string, globals *globalConfiguration, ctx context.Context) error {
+    if strings.HasPrefix(reqtype, "reroute_links-") {
+        return rebuildCatalogue(globals, ctx)
+    }
+
+    incoming := struct {
+        Project *string `json:"project"`
+        NewProject *string `json:"new_project"`
+    }{}
+    handle, err := os.ReadFile(reqpath)
+    if err != nil {
+        return fmt.Errorf("failed to read %q; %w", reqpath, err)
+    }
+    err = json.Unmarshal(handle, &incoming)
+    if err != nil {
+        return fmt.Errorf("failed to parse JSON from %q; %w", reqpath, err)
+    }
+
+    projects := []string{}
+    for _, project := range []*string{ incoming.Project, incoming.NewProject } {
+        if isMissingOrBadName(project) == nil {
+            projects = append(projects, *project)
+        }
+    }
+    if len(projects) == 0 {
+        return nil
+    }
+
+    return refreshCatalogueProjects(projects, globals, ctx)
+}
+
+func refreshCatalogueHandler(reqpath string, globals *globalConfiguration, ctx context.Context) error {
+    source_user, err := identifyUser(reqpath)
+    if err != nil {
+        return fmt.Errorf("failed to find owner of %q; %w", reqpath, err)
+    }
+
+    if !isAuthorizedToAdminAction(source_user, globals.Administrators, globals.ScopedAdministrators, adminActionRefresh, nil) {
+        return newHttpError(http.StatusForbidden, fmt.Errorf("user %q is not authorized to refresh the catalogue (%q)", source_user, reqpath))
+    }
+
+    return rebuildCatalogue(globals, ctx)
+}
+
+// Projects and assets that cannot be read by 'reader' are omitted from the returned catalogue.
+func getCatalogueHandler(registry string, reader *string, administrators []string) (map[string]catalogueProject, error) {
+    catalogue, err := readCatalogue(registry)
+    if err != nil {
+        return nil, err
+    }
+
+    // Falling back to a fresh (but unsaved) catalogue if it was not created yet.
+    if catalogue == nil {
+        catalogue, err = createCatalogue(registry)
+        if err != nil {
+            return nil, err
+        }
+    }
+
+    return filterCatalogueForReader(catalogue, reader, administrators), nil
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+    "testing"
+    "os"
+    "path/filepath"
+    "fmt"
+    "strings"
+    "context"
+)
+
+func TestCreateCatalogue(t *testing.T) {
+    reg, err := constructMockRegistry()
+    if err != nil {
+        t.Fatalf("failed to create the registry; %v", err)
+    }
+    globals := newGlobalConfiguration(reg, 2)
+    ctx := context.Background()
+
+    err = setupProjectForUploadTest("pokemon", &globals)
+    if err != nil {
+        t.Fatalf("failed to set up project directory; %v", err)
+    }
+    err = setupProjectForUploadTest("digimon", &globals)
+    if err != nil {
+        t.Fatalf("failed to set up project directory; %v", err)
+    }
+    err = os.WriteFile(filepath.Join(reg, "digimon", quotaFileName), []byte(`{ "baseline": 1000, "growth_rate": 100, "year": 2020 }`), 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    src, err := setupSourceForUploadTest()
+    if err != nil {
+        t.Fatalf("failed to set up test directories; %v", err)
+    }
+    reqname, err := dumpRequest("upload", fmt.Sprintf(`{ "source": "%s", "project": "pokemon", "asset": "pikachu", "version": "red" }`, filepath.Base(src)))
+    if err != nil {
+        t.Fatalf("failed to create upload request; %v", err)
+    }
+    err = uploadHandler(reqname, &globals, ctx)
+    if err != nil {
+        t.Fatalf("failed to perform the upload; %v", err)
+    }
+    err = os.WriteFile(filepath.Join(reg, "pokemon", "pikachu", permissionsFileName), []byte(`{ "owners": [ "ash" ] }`), 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    catalogue, err := createCatalogue(reg)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(catalogue) != 2 {
+        t.Fatalf("unexpected projects in the catalogue; %v", catalogue)
+    }
+
+    pokemon := catalogue["pokemon"]
+    usage, err := readUsage(filepath.Join(reg, "pokemon"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if pokemon.Usage != usage.Total || pokemon.Usage == 0 || pokemon.Quota == nil || pokemon.AssetCount != 1 || len(pokemon.Permissions.Owners) != 1 {
+        t.Fatalf("unexpected project entry in the catalogue; %v", pokemon)
+    }
+    pikachu := pokemon.Assets["pikachu"]
+    if pikachu.Latest == nil || *(pikachu.Latest) != "red" || pikachu.Permissions == nil || pikachu.Permissions.Owners[0] != "ash" {
+        t.Fatalf("unexpected asset entry in the catalogue; %v", pikachu)
+    }
+
+    digimon := catalogue["digimon"]
+    if digimon.Quota == nil || digimon.Quota.Baseline != 1000 || digimon.AssetCount != 0 || len(digimon.Assets) != 0 {
+        t.Fatalf("unexpected project entry in the catalogue; %v", digimon)
+    }
+}
+
+func TestRefreshCatalogue(t *testing.T) {
+    reg, err := constructMockRegistry()
+    if err != nil {
+        t.Fatalf("failed to create the registry; %v", err)
+    }
+    globals := newGlobalConfiguration(reg, 2)
+    ctx := context.Background()
+
+    err = setupProjectForUploadTest("pokemon", &globals)
+    if err != nil {
+        t.Fatalf("failed to set up project directory; %v", err)
+    }
+
+    // Falls back to a fresh catalogue if none exists.
+    catalogue, err := getCatalogueHandler(reg, nil, nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if _, ok := catalogue["pokemon"]; !ok || len(catalogue) != 1 {
+        t.Fatalf("unexpected catalogue; %v", catalogue)
+    }
+    if _, err := os.Stat(filepath.Join(reg, catalogueFileName)); err == nil {
+        t.Fatal("expected no catalogue file to be saved by a GET request")
+    }
+
+    // Refreshing a project creates the catalogue if it doesn't exist.
+    err = refreshCatalogueProjects([]string{ "pokemon" }, &globals, ctx)
+    if err != nil {
+        t.Fatal(err)
+    }
+    catalogue, err = readCatalogue(reg)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if _, ok := catalogue["pokemon"]; !ok || len(catalogue) != 1 {
+        t.Fatalf("unexpected catalogue; %v", catalogue)
+    }
+
+    // Refreshing only affects the requested projects.
+    err = setupProjectForUploadTest("digimon", &globals)
+    if err != nil {
+        t.Fatalf("failed to set up project directory; %v", err)
+    }
+    err = os.RemoveAll(filepath.Join(reg, "pokemon"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    reqname, err := dumpRequest("delete_project", `{ "project": "pokemon" }`)
+    if err != nil {
+        t.Fatalf("failed to create request; %v", err)
+    }
+    err = refreshCatalogueForRequest("delete_project-", reqname, &globals, ctx)
+    if err != nil {
+        t.Fatal(err)
+    }
+    catalogue, err = readCatalogue(reg)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(catalogue) != 0 {
+        t.Fatalf("expected the deleted project to be removed from the catalogue; %v", catalogue)
+    }
+
+    // Full rebuilds require administrator privileges.
+    reqname, err = dumpRequest("refresh_catalogue", `{}`)
+    if err != nil {
+        t.Fatalf("failed to create request; %v", err)
+    }
+    err = refreshCatalogueHandler(reqname, &globals, ctx)
+    if err == nil || !strings.Contains(err.Error(), "not authorized") {
+        t.Fatalf("expected failure for non-administrators; %v", err)
+    }
+
+    self, err := identifyUser(reg)
+    if err != nil {
+        t.Fatal(err)
+    }
+    globals.Administrators = append(globals.Administrators, self)
+    err = refreshCatalogueHandler(reqname, &globals, ctx)
+    if err != nil {
+        t.Fatal(err)
+    }
+    catalogue, err = readCatalogue(reg)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if _, ok := catalogue["digimon"]; !ok || len(catalogue) != 1 {
+        t.Fatalf("unexpected catalogue after rebuilding; %v", catalogue)
+    }
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+    "fmt"
+    "time"
+    "path/filepath"
+    "os"
+    "encoding/json"
+    "net/http"
+    "context"
+    "log"
+    "sort"
+    "strings"
+    "sync"
+)
+
+// Selects the manifest entries to be cloned, i.e., those matching one of the 'paths' exactly or lying inside a directory in 'paths'.
+// If 'paths' is empty, all entries are selected.
+func selectClonedPaths(manifest map[string]manifestEntry, paths []string) ([]string, error) {
+    selected := []string{}
+    if len(paths) == 0 {
+        for k, _ := range manifest {
+            selected = append(selected, k)
+        }
+        sort.Strings(selected)
+        return selected, nil
+    }
+
+    found := map[string]bool{}
+    for _, p := range paths {
+        any_match := false
+        for k, _ := range manifest {
+            if k == p || strings.HasPrefix(k, p + "/") {
+                found[k] = true
+                any_match = true
+            }
+        }
+        if !any_match {
+            return nil, fmt.Errorf("path %q does not exist in the source version", p)
+        }
+    }
+
+    for k, _ := range found {
+        selected = append(selected, k)
+    }
+    sort.Strings(selected)
+    return selected, nil
+}
+
+func cloneVersionHandler(reqpath string, globals *globalConfiguration, ctx context.Context) error {
+    upload_start := time.Now()
+
+    incoming := struct {
+        SourceProject *string `json:"source_project"`
+        SourceAsset *string `json:"source_asset"`
+        SourceVersion *string `json:"source_version"`
+        Paths []string `json:"paths"`
+        Project *string `json:"project"`
+        Asset *string `json:"asset"`
+        Version *string `json:"version"`
+        OnProbation *bool `json:"on_probation"`
+        Spoof *string `json:"spoof"`
+    }{}
+    {
+        handle, err := os.ReadFile(reqpath)
+        if err != nil {
+            return fmt.Errorf("failed to read %q; %w", reqpath, err)
+        }
+
+        err = json.Unmarshal(handle, &incoming)
+        if err != nil {
+            return newHttpError(http.StatusBadRequest, fmt.Errorf("failed to parse JSON from %q; %w", reqpath, err))
+        }
+
+        err = isMissingOrBadName(incoming.SourceProject)
+        if err != nil {
+            return newHttpError(http.StatusBadRequest, fmt.Errorf("invalid 'source_project' property in %q; %w", reqpath, err))
+        }
+
+        err = isMissingOrBadName(incoming.SourceAsset)
+        if err != nil {
+            return newHttpError(http.StatusBadRequest, fmt.Errorf("invalid 'source_asset' property in %q; %w", reqpath, err))
+        }
+
+        err = isMissingOrBadName(incoming.SourceVersion)
+        if err != nil {
+            return newHttpError(http.StatusBadRequest, fmt.Errorf("invalid 'source_version' property in %q; %w", reqpath, err))
+        }
+
+        for i, p := range incoming.Paths {
+            cleaned := filepath.Clean(p)
+            if !filepath.IsLocal(cleaned) {
+                return newHttpError(http.StatusBadRequest, fmt.Errorf("invalid path %q in 'paths' of %q; should be local to the version directory", p, reqpath))
+            }
+            for _, component := range strings.Split(cleaned, "/") {
+                if strings.HasPrefix(component, "..") {
+                    return newHttpError(http.StatusBadRequest, fmt.Errorf("invalid path %q in 'paths' of %q; cannot refer to internal '..' files", p, reqpath))
+                }
+            }
+            incoming.Paths[i] = cleaned
+        }
+
+        err = isMissingOrBadName(incoming.Project)
+        if err != nil {
+            return newHttpError(http.StatusBadRequest, fmt.Errorf("invalid 'project' property in %q; %w", reqpath, err))
+        }
+
+        err = isMissingOrBadName(incoming.Asset)
+        if err != nil {
+            return newHttpError(http.StatusBadRequest, fmt.Errorf("invalid 'asset' property in %q; %w", reqpath, err))
+        }
+
+        if incoming.Version == nil {
+            return newHttpError(http.StatusBadRequest, fmt.Errorf("expected a 'version' property in %q", reqpath))
+        }
+        err = isBadNewVersionName(*(incoming.Version))
+        if err != nil {
+            return newHttpError(http.StatusBadRequest, fmt.Errorf("invalid 'version' property in %q; %w", reqpath, err))
+        }
+    }
+
+    req_user, err := identifySpoofedUser(reqpath, incoming.Spoof, globals.SpoofPermissions)
+    if err != nil {
+        return fmt.Errorf("failed to identify user; %w", err)
+    }
+    request_user := req_user
+    if incoming.Spoof != nil {
+        request_user, err = identifyUser(reqpath)
+        if err != nil {
+            return fmt.Errorf("failed to identify user; %w", err)
+        }
+    }
+    on_probation := incoming.OnProbation != nil && *(incoming.OnProbation)
+
+    // The source version is not locked, as non-probational versions are immutable;
+    // this is the same approach used for registry links in regular uploads.
+    source_project := *(incoming.SourceProject)
+    source_asset := *(incoming.SourceAsset)
+    source_version := *(incoming.SourceVersion)
+    source_dir := filepath.Join(globals.Registry, source_project, source_asset, source_version)
+    if err := checkVersionExists(source_dir, source_version, source_asset, source_project); err != nil {
+        return err
+    }
+
+    // Users should not be able to clone versions that they cannot read.
+    err = checkReadAccess(globals.Registry, source_project + "/" + source_asset, &req_user, globals.Administrators)
+    if err != nil {
+        return err
+    }
+
+    project := *(incoming.Project)
+    asset := *(incoming.Asset)
+    version := *(incoming.Version)
+    prepared, err := prepareNewVersion(project, asset, version, req_user, request_user, on_probation, true, globals, ctx)
+    if err != nil {
+        return err
+    }
+    defer prepared.Unlock(globals)
+
+    on_probation = prepared.OnProbation
+    asset_dir := prepared.AssetDir
+    version_dir := prepared.VersionDir
+
+    source_manifest, err := readManifest(source_dir)
+    if err != nil {
+        return fmt.Errorf("failed to read the manifest for %q; %w", source_dir, err)
+    }
+    selected, err := selectClonedPaths(source_manifest, incoming.Paths)
+    if err != nil {
+        return newHttpError(http.StatusBadRequest, err)
+    }
+
+    err = os.Mkdir(version_dir, 0755)
+    if err != nil {
+        return fmt.Errorf("failed to create a new version directory at %q; %w", version_dir, err)
+    }
+
+    // Remove failed clones from the registry, lest they clutter things up.
+    has_failed := true
+    defer func() {
+        if has_failed {
+            os.RemoveAll(version_dir)
+        }
+    }()
+
+    manifest := map[string]manifestEntry{}
+    manifest_cache := map[string]map[string]manifestEntry{}
+    probation_cache := map[string]bool{}
+    var manifest_cache_lock, probation_cache_lock sync.Mutex
+
+    for _, path := range selected {
+        dest := filepath.Join(version_dir, path)
+        err := os.MkdirAll(filepath.Dir(dest), 0755)
+        if err != nil {
+            return fmt.Errorf("failed to create parent directories for %q; %w", dest, err)
+        }
+
+        // Empty directories are recreated directly, as there is nothing to link to.
+        source_entry := source_manifest[path]
+        if source_entry.Link == nil && source_entry.Md5sum == "" {
+            err := os.Mkdir(dest, 0755)
+            if err != nil {
+                return fmt.Errorf("failed to create empty directory at %q; %w", dest, err)
+            }
+            manifest[path] = source_entry
+            continue
+        }
+
+        target := filepath.Join(source_project, source_asset, source_version, path)
+        entry, err := resolveRegistrySymlink(globals.Registry, project, asset, version, target, manifest_cache, &manifest_cache_lock, probation_cache, &probation_cache_lock)
+        if err != nil {
+            return newHttpError(http.StatusBadRequest, fmt.Errorf("failed to link to %q; %w", target, err))
+        }
+
+        err = createSymlink(dest, globals.Registry, entry.Link, false)
+        if err != nil {
+            return err
+        }
+        manifest[path] = *entry
+    }
+
+    manifest_path := filepath.Join(version_dir, manifestFileName)
+    err = dumpJson(manifest_path, &manifest)
+    if err != nil {
+        return fmt.Errorf("failed to save manifest for %q; %w", version_dir, err)
+    }
+
+    _, err = recreateLinkFiles(version_dir, manifest)
+    if err != nil {
+        return fmt.Errorf("failed to create linkfiles; %w", err)
+    }
+
+    // No need to update the usage as the cloned version consists entirely of links.
+    summary := summaryMetadata {
+        UploadUserId: req_user,
+        UploadStart: upload_start.Format(time.RFC3339),
+        UploadFinish: time.Now().Format(time.RFC3339),
+    }
+    if on_probation {
+        summary.OnProbation = &on_probation
+    }
+    summary.AddProvenance(reqpath, request_user, manifest, globals)
+
+    summary_path := filepath.Join(version_dir, summaryFileName)
+    err = dumpJson(summary_path, &summary)
+    if err != nil {
+        return fmt.Errorf("failed to save summary for %q; %w", asset_dir, err)
+    }
+
+    if !on_probation {
+        is_latest, err := updateLatest(asset_dir, version, &summary, true)
+        if err != nil {
+            return fmt.Errorf("failed to save latest version for %q; %w", asset_dir, err)
+        }
+
+        // Adding a log.
+        log_info := map[string]interface{} {
+            "type": "add-version",
+            "project": project,
+            "asset": asset,
+            "version": version,
+            "latest": is_latest,
+        }
+        err = dumpLog(globals.Registry, log_info)
+        if err != nil {
+            return fmt.Errorf("failed to save log file; %w", err)
+        }
+    }
+
+    has_failed = false
+
+    // Updating the index last, so that it never contains an entry for a version that is subsequently removed on failure.
+    // Errors are not fatal as the version has already been committed, and the index is rebuilt by refreshing the latest version.
+    err = updateVersionIndex(asset_dir, version)
+    if err != nil {
+        log.Printf("failed to update the version index for %q; %v", version_dir, err)
+    }
+    return nil
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+    "testing"
+    "os"
+    "path/filepath"
+    "fmt"
+    "strings"
+    "context"
+)
+
+func TestCloneVersionHandler(t *testing.T) {
+    reg, err := constructMockRegistry()
+    if err != nil {
+        t.Fatalf("failed to create the registry; %v", err)
+    }
+    globals := newGlobalConfiguration(reg, 2)
+    ctx := context.Background()
+
+    err = setupProjectForUploadTest("pokemon", &globals)
+    if err != nil {
+        t.Fatalf("failed to set up project directory; %v", err)
+    }
+    err = setupProjectForUploadTest("digimon", &globals)
+    if err != nil {
+        t.Fatalf("failed to set up project directory; %v", err)
+    }
+
+    src, err := setupSourceForWalkDirectoryTest()
+    if err != nil {
+        t.Fatalf("failed to set up test directories; %v", err)
+    }
+    reqname, err := dumpRequest("upload", fmt.Sprintf(`{ "source": "%s", "project": "pokemon", "asset": "pikachu", "version": "red" }`, filepath.Base(src)))
+    if err != nil {
+        t.Fatalf("failed to create upload request; %v", err)
+    }
+    err = uploadHandler(reqname, &globals, ctx)
+    if err != nil {
+        t.Fatalf("failed to perform the upload; %v", err)
+    }
+
+    source_manifest, err := readManifest(filepath.Join(reg, "pokemon", "pikachu", "red"))
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    clone := func(body string) error {
+        reqname, err := dumpRequest("clone_version", body)
+        if err != nil {
+            t.Fatalf("failed to create clone request; %v", err)
+        }
+        return cloneVersionHandler(reqname, &globals, ctx)
+    }
+
+    t.Run("full", func(t *testing.T) {
+        err := clone(`{ "source_project": "pokemon", "source_asset": "pikachu", "source_version": "red", "project": "digimon", "asset": "agumon", "version": "v1" }`)
+        if err != nil {
+            t.Fatalf("failed to clone the version; %v", err)
+        }
+
+        version_dir := filepath.Join(reg, "digimon", "agumon", "v1")
+        manifest, err := readManifest(version_dir)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if len(manifest) != len(source_manifest) {
+            t.Fatalf("unexpected number of entries in the cloned manifest; %v", manifest)
+        }
+        for k, v := range manifest {
+            expected := source_manifest[k]
+            if v.Size != expected.Size || v.Md5sum != expected.Md5sum {
+                t.Fatalf("mismatch in manifest entry for %q", k)
+            }
+            if v.Link == nil || v.Link.Project != "pokemon" || v.Link.Asset != "pikachu" || v.Link.Version != "red" || v.Link.Path != k {
+                t.Fatalf("expected a link to the source version for %q", k)
+            }
+        }
+
+        err = verifyFileContents(filepath.Join(version_dir, "moves", "electric", "thunderbolt"), "90")
+        if err != nil {
+            t.Fatal(err)
+        }
+
+        links, err := os.ReadFile(filepath.Join(version_dir, "moves", "electric", linksFileName))
+        if err != nil {
+            t.Fatalf("expected a links file; %v", err)
+        }
+        if !strings.Contains(string(links), "thunderbolt") {
+            t.Fatalf("unexpected contents of the links file; %s", links)
+        }
+
+        latest, err := readLatest(filepath.Join(reg, "digimon", "agumon"))
+        if err != nil {
+            t.Fatal(err)
+        }
+        if latest.Version != "v1" {
+            t.Fatalf("unexpected latest version %q", latest.Version)
+        }
+
+        // Cloning a clone preserves the ancestor.
+        err = clone(`{ "source_project": "digimon", "source_asset": "agumon", "source_version": "v1", "project": "digimon", "asset": "agumon", "version": "v2" }`)
+        if err != nil {
+            t.Fatalf("failed to clone the version; %v", err)
+        }
+        manifest, err = readManifest(filepath.Join(reg, "digimon", "agumon", "v2"))
+        if err != nil {
+            t.Fatal(err)
+        }
+        entry := manifest["type"]
+        if entry.Link == nil || entry.Link.Version != "v1" || entry.Link.Ancestor == nil || entry.Link.Ancestor.Version != "red" {
+            t.Fatalf("expected an ancestor for the cloned link; %v", entry.Link)
+        }
+        err = verifyFileContents(filepath.Join(reg, "digimon", "agumon", "v2", "type"), "electric")
+        if err != nil {
+            t.Fatal(err)
+        }
+    })
+
+    t.Run("subset", func(t *testing.T) {
+        err := clone(`{ "source_project": "pokemon", "source_asset": "pikachu", "source_version": "red", "paths": [ "type", "moves/electric/" ], "project": "digimon", "asset": "gabumon", "version": "v1" }`)
+        if err != nil {
+            t.Fatalf("failed to clone the version; %v", err)
+        }
+
+        manifest, err := readManifest(filepath.Join(reg, "digimon", "gabumon", "v1"))
+        if err != nil {
+            t.Fatal(err)
+        }
+        if len(manifest) != 4 {
+            t.Fatalf("unexpected number of entries in the cloned manifest; %v", manifest)
+        }
+        for _, expected := range []string{ "type", "moves/electric/thunder", "moves/electric/thunderbolt", "moves/electric/thunder_shock" } {
+            if _, ok := manifest[expected]; !ok {
+                t.Fatalf("expected %q in the cloned manifest", expected)
+            }
+        }
+        if _, err := os.Stat(filepath.Join(reg, "digimon", "gabumon", "v1", "evolution")); err == nil {
+            t.Fatal("unselected paths should not be cloned")
+        }
+    })
+
+    t.Run("failures", func(t *testing.T) {
+        err := clone(`{ "source_project": "pokemon", "source_asset": "pikachu", "source_version": "red", "paths": [ "moves/fire" ], "project": "digimon", "asset": "patamon", "version": "v1" }`)
+        if err == nil || !strings.Contains(err.Error(), "does not exist") {
+            t.Fatalf("expected a failure for a missing path; %v", err)
+        }
+        if _, err := os.Stat(filepath.Join(reg, "digimon", "patamon", "v1")); err == nil {
+            t.Fatal("failed clones should not leave a version directory")
+        }
+
+        err = clone(`{ "source_project": "pokemon", "source_asset": "pikachu", "source_version": "red", "paths": [ "../red" ], "project": "digimon", "asset": "patamon", "version": "v1" }`)
+        if err == nil || !strings.Contains(err.Error(), "local") {
+            t.Fatalf("expected a failure for a non-local path; %v", err)
+        }
+
+        err = clone(`{ "source_project": "pokemon", "source_asset": "pikachu", "source_version": "blue", "project": "digimon", "asset": "patamon", "version": "v1" }`)
+        if err == nil || !strings.Contains(err.Error(), "does not exist") {
+            t.Fatalf("expected a failure for a missing source version; %v", err)
+        }
+
+        err = clone(`{ "source_project": "pokemon", "source_asset": "pikachu", "source_version": "red", "project": "digimon", "asset": "agumon", "version": "v1" }`)
+        if err == nil || !strings.Contains(err.Error(), "already exists") {
+            t.Fatalf("expected a failure for an existing version; %v", err)
+        }
+
+        err = mockProbationVersion(reg, "yugioh", "kuriboh", "probational")
+        if err != nil {
+            t.Fatal(err)
+        }
+        err = clone(`{ "source_project": "yugioh", "source_asset": "kuriboh", "source_version": "probational", "project": "digimon", "asset": "patamon", "version": "v1" }`)
+        if err == nil || !strings.Contains(err.Error(), "probational") {
+            t.Fatalf("expected a failure for a probational source; %v", err)
+        }
+
+        err = clone(`{ "source_project": "pokemon", "source_asset": "pikachu", "source_version": "red", "project": "digimon", "asset": "patamon", "version": "@stable" }`)
+        if err == nil || !strings.Contains(err.Error(), "cannot start with") {
+            t.Fatalf("expected a failure for a version name that looks like an alias; %v", err)
+        }
+
+        // Unreadable source.
+        source_project_dir := filepath.Join(reg, "pokemon")
+        original_perms, err := readPermissions(source_project_dir)
+        if err != nil {
+            t.Fatal(err)
+        }
+        err = dumpJson(filepath.Join(source_project_dir, permissionsFileName), &permissionsMetadata{ Owners: []string{ "ash" }, Readers: []string{ "ash" } })
+        if err != nil {
+            t.Fatal(err)
+        }
+        err = clone(`{ "source_project": "pokemon", "source_asset": "pikachu", "source_version": "red", "project": "digimon", "asset": "patamon", "version": "v1" }`)
+        if err == nil || !strings.Contains(err.Error(), "not authorized to read") {
+            t.Fatalf("expected a read authorization failure; %v", err)
+        }
+        err = dumpJson(filepath.Join(source_project_dir, permissionsFileName), original_perms)
+        if err != nil {
+            t.Fatal(err)
+        }
+
+        // Unauthorized destination.
+        err = clone(`{ "source_project": "pokemon", "source_asset": "pikachu", "source_version": "red", "project": "yugioh", "asset": "kuriboh", "version": "v1" }`)
+        if err == nil || !strings.Contains(err.Error(), "not authorized") {
+            t.Fatalf("expected an authorization failure; %v", err)
+        }
+    })
+
+    t.Run("probation", func(t *testing.T) {
+        err := clone(`{ "source_project": "pokemon", "source_asset": "pikachu", "source_version": "red", "project": "digimon", "asset": "gabumon", "version": "v2", "on_probation": true }`)
+        if err != nil {
+            t.Fatalf("failed to clone the version; %v", err)
+        }
+
+        summ, err := readSummary(filepath.Join(reg, "digimon", "gabumon", "v2"))
+        if err != nil {
+            t.Fatal(err)
+        }
+        if !summ.IsProbational() {
+            t.Fatal("expected the cloned version to be probational")
+        }
+
+        latest, err := readLatest(filepath.Join(reg, "digimon", "gabumon"))
+        if err != nil {
+            t.Fatal(err)
+        }
+        if latest.Version != "v1" {
+            t.Fatalf("probational clone should not be the latest version; %v", latest.Version)
+        }
+    })
+}
@@ -6,7 +6,6 @@ import (
     "path/filepath"
     "os"
     "encoding/json"
-    "strconv"
     "errors"
     "net/http"
     "context"
@@ -17,10 +16,6 @@ func createProjectHandler(reqpath string, globals *globalConfiguration, ctx cont
     if err != nil {
         return fmt.Errorf("failed to find owner of %q; %w", reqpath, err)
     }
-    if !isAuthorizedToAdmin(req_user, globals.Administrators) {
-        return newHttpError(http.StatusForbidden, fmt.Errorf("user %q is not authorized to create a project", req_user))
-    }
-
     request := struct {
         Project *string `json:"project"`
         Permissions *unsafePermissionsMetadata `json:"permissions"`
@@ -44,6 +39,9 @@ func createProjectHandler(reqpath string, globals *globalConfiguration, ctx cont
     if err != nil {
         return newHttpError(http.StatusBadRequest, fmt.Errorf("invalid project name; %w", err))
     }
+    if !isAuthorizedToAdminAction(req_user, globals.Administrators, globals.ScopedAdministrators, adminActionCreateProject, &project) {
+        return newHttpError(http.StatusForbidden, fmt.Errorf("user %q is not authorized to create project %q", req_user, project))
+    }
     project_dir := filepath.Join(globals.Registry, project)
 
     rlock, err := lockDirectoryExclusive(globals.Registry, globals, ctx)
@@ -52,7 +50,16 @@ func createProjectHandler(reqpath string, globals *globalConfiguration, ctx cont
     }
     defer rlock.Unlock(globals)
 
-    return createProject(project_dir, request.Permissions, req_user)
+    err = createProject(project_dir, request.Permissions, req_user)
+    if err != nil {
+        return err
+    }
+
+    perms, err := readPermissions(project_dir)
+    if err != nil {
+        return fmt.Errorf("failed to read permissions for %q; %w", project_dir, err)
+    }
+    return recordPermissionsChange(globals.Registry, project, nil, permissionsSourceCreate, req_user, nil, nil, perms)
 }
 
 func createProject(project_dir string, inperms *unsafePermissionsMetadata, req_user string) error {
@@ -70,6 +77,10 @@ func createProject(project_dir string, inperms *unsafePermissionsMetadata, req_u
 
     perms := permissionsMetadata{}
     if inperms != nil && inperms.Owners != nil {
+        err := checkGroupEntries(inperms.Owners)
+        if err != nil {
+            return newHttpError(http.StatusBadRequest, fmt.Errorf("invalid 'permissions.owners' in the request details; %w", err))
+        }
         perms.Owners = inperms.Owners
     } else {
         perms.Owners = []string{ req_user }
@@ -86,15 +97,22 @@ func createProject(project_dir string, inperms *unsafePermissionsMetadata, req_u
     if inperms != nil && inperms.GlobalWrite != nil {
         perms.GlobalWrite = inperms.GlobalWrite
     }
+    if inperms != nil && inperms.Readers != nil {
+        err := sanitizeReaders(inperms.Readers)
+        if err != nil {
+            return newHttpError(http.StatusBadRequest, fmt.Errorf("invalid 'permissions.readers' in the request details; %w", err))
+        }
+        perms.Readers = inperms.Readers
+    }
 
     err = dumpJson(filepath.Join(project_dir, permissionsFileName), &perms)
     if err != nil {
         return fmt.Errorf("failed to write permissions for %q; %w", project_dir, err)
     }
 
-    // Dumping a mock quota and usage file for consistency with gypsum.
-    // Note that the quota isn't actually enforced yet.
-    err = os.WriteFile(filepath.Join(project_dir, "..quota"), []byte("{ \"baseline\": 1000000000, \"growth_rate\": 1000000000, \"year\": " + strconv.Itoa(time.Now().Year()) + " }"), 0644)
+    // Dumping a quota and usage file for consistency with gypsum.
+    quota := quotaMetadata{ Baseline: defaultQuotaBaseline, GrowthRate: defaultQuotaGrowthRate, Year: time.Now().Year() }
+    err = dumpJson(filepath.Join(project_dir, quotaFileName), &quota)
     if err != nil {
         return fmt.Errorf("failed to write quota for '" + project_dir + "'; %w", err)
     }
@@ -43,6 +43,14 @@ func TestCreateProjectSimple(t *testing.T) {
     if usage.Total != 0 {
         t.Fatalf("usage should be zero for a newly created project; %v", err)
     }
+
+    history, err := readPermissionsHistory(filepath.Join(reg, project))
+    if err != nil {
+        t.Fatalf("failed to read the permissions history; %v", err)
+    }
+    if len(history) != 1 || history[0].Source != "create_project" || history[0].Before != nil || history[0].After.Owners[0] != self.Username {
+        t.Fatalf("unexpected permissions history for a newly created project; %v", history)
+    }
 }
 
 func TestCreateProjectFailures(t *testing.T) {
@@ -0,0 +1,340 @@
+package main
+
+import (
+    "os"
+    "fmt"
+    "errors"
+    "encoding/json"
+    "path/filepath"
+    "sync"
+    "context"
+)
+
+// The deduplication index maps the size and MD5 checksum of each file to a non-probational, non-deprecated, non-link file in the registry.
+// Each entry is stored as a separate file in '..dedup/', to avoid contention when multiple processes are updating the index.
+// Entries may be stale if the index was not updated correctly (e.g., manual deletions or failed uploads),
+// so every entry is verified against the target's manifest before it is used for deduplication.
+// As MD5 is not collision-resistant, any additional digests (see the -digests option) must also match the target's manifest entry.
+const deduplicationDirName = "..dedup"
+
+func deduplicationIndexPath(registry string, size int64, md5sum string) string {
+    // Using the first two characters of the checksum to avoid creating too many files in a single directory.
+    return filepath.Join(registry, deduplicationDirName, md5sum[:2], deduplicateLatestKey(size, md5sum))
+}
+
+func isDeduplicatable(entry *manifestEntry) bool {
+    // Empty directories have no checksum, and links are not real files.
+    return entry.Link == nil && len(entry.Md5sum) >= 2
+}
+
+// Each index file contains an array of locations, as the same file may have multiple real copies in the registry,
+// e.g., if uploaded before the index was populated or if deduplication was skipped due to missing digests.
+// This ensures that deleting one copy does not prevent the others from being used for deduplication.
+func readDeduplicationEntries(registry string, size int64, md5sum string) ([]linkMetadata, error) {
+    path := deduplicationIndexPath(registry, size, md5sum)
+    contents, err := os.ReadFile(path)
+    if err != nil {
+        return nil, err
+    }
+
+    var output []linkMetadata
+    err = json.Unmarshal(contents, &output)
+    if err != nil {
+        return nil, fmt.Errorf("failed to parse JSON from %q; %w", path, err)
+    }
+
+    return output, nil
+}
+
+func isSameDeduplicationLocation(x *linkMetadata, y *linkMetadata) bool {
+    return x.Project == y.Project && x.Asset == y.Asset && x.Version == y.Version && x.Path == y.Path
+}
+
+// Concurrent additions of the same file may cause one of the locations to be lost, but this is tolerable as the index is only a performance optimization.
+func addDeduplicationEntry(registry string, size int64, md5sum string, link *linkMetadata) error {
+    path := deduplicationIndexPath(registry, size, md5sum)
+    err := os.MkdirAll(filepath.Dir(path), 0755)
+    if err != nil {
+        return fmt.Errorf("failed to create deduplication index directory for %q; %w", path, err)
+    }
+
+    existing, err := readDeduplicationEntries(registry, size, md5sum)
+    if err != nil && !errors.Is(err, os.ErrNotExist) {
+        return err
+    }
+
+    // Only storing the immediate location as the index should always refer to real files.
+    self := linkMetadata{ Project: link.Project, Asset: link.Asset, Version: link.Version, Path: link.Path }
+    for _, e := range existing {
+        if isSameDeduplicationLocation(&e, &self) {
+            return nil
+        }
+    }
+
+    err = dumpJson(path, append(existing, self))
+    if err != nil {
+        return fmt.Errorf("failed to save deduplication index entry; %w", err)
+    }
+    return nil
+}
+
+func addVersionToDeduplicationIndex(registry, project, asset, version string, manifest map[string]manifestEntry) error {
+    version_dir := filepath.Join(registry, project, asset, version)
+    for path, entry := range manifest {
+        if !isDeduplicatable(&entry) {
+            continue
+        }
+
+        // Skipping links to whitelisted files, which are still symbolic links on the filesystem.
+        info, err := os.Lstat(filepath.Join(version_dir, path))
+        if err != nil {
+            return fmt.Errorf("failed to stat %q in %q; %w", path, version_dir, err)
+        }
+        if !info.Mode().IsRegular() {
+            continue
+        }
+
+        err = addDeduplicationEntry(registry, entry.Size, entry.Md5sum, &linkMetadata{ Project: project, Asset: asset, Version: version, Path: path })
+        if err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+func removeVersionFromDeduplicationIndex(registry, project, asset, version string) error {
+    // If the manifest can't be read, there's not much we can do; we just let the stale entries be ignored during verification.
+    manifest, err := readManifest(filepath.Join(registry, project, asset, version))
+    if err != nil {
+        return nil
+    }
+    return removeManifestFromDeduplicationIndex(registry, project, asset, version, manifest)
+}
+
+// The manifest is supplied separately for renames, where the version directory has already been moved away from the indexed location.
+func removeManifestFromDeduplicationIndex(registry, project, asset, version string, manifest map[string]manifestEntry) error {
+    for _, entry := range manifest {
+        if !isDeduplicatable(&entry) {
+            continue
+        }
+
+        existing, err := readDeduplicationEntries(registry, entry.Size, entry.Md5sum)
+        if err != nil {
+            if errors.Is(err, os.ErrNotExist) {
+                continue
+            }
+            return err
+        }
+
+        // Only removing the locations in the to-be-deleted version, so that other copies of the same file can still be used.
+        retained := []linkMetadata{}
+        for _, e := range existing {
+            if e.Project != project || e.Asset != asset || e.Version != version {
+                retained = append(retained, e)
+            }
+        }
+        if len(retained) == len(existing) {
+            continue
+        }
+
+        path := deduplicationIndexPath(registry, entry.Size, entry.Md5sum)
+        if len(retained) == 0 {
+            err = os.Remove(path)
+            if err != nil && !errors.Is(err, os.ErrNotExist) {
+                return fmt.Errorf("failed to remove deduplication index entry at %q; %w", path, err)
+            }
+        } else {
+            err = dumpJson(path, retained)
+            if err != nil {
+                return fmt.Errorf("failed to update deduplication index entry at %q; %w", path, err)
+            }
+        }
+    }
+
+    return nil
+}
+
+func removeAssetFromDeduplicationIndex(registry, project, asset string) error {
+    asset_dir := filepath.Join(registry, project, asset)
+    versions, err := listUserDirectories(asset_dir)
+    if err != nil {
+        return fmt.Errorf("failed to list versions in %q; %w", asset_dir, err)
+    }
+    for _, version := range versions {
+        err := removeVersionFromDeduplicationIndex(registry, project, asset, version)
+        if err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+func removeProjectFromDeduplicationIndex(registry, project string) error {
+    project_dir := filepath.Join(registry, project)
+    assets, err := listUserDirectories(project_dir)
+    if err != nil {
+        return fmt.Errorf("failed to list assets in %q; %w", project_dir, err)
+    }
+    for _, asset := range assets {
+        err := removeAssetFromDeduplicationIndex(registry, project, asset)
+        if err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// This caches the manifests and probation status of the versions referenced by the deduplication index, for use within a single walkDirectory() call.
+type deduplicationIndex struct {
+    Registry string
+    Lock sync.Mutex
+    ManifestCache map[string]map[string]manifestEntry
+    ValidCache map[string]bool
+
+    // If 'Globals' is provided, shared locks are acquired on the project and asset of each target in other assets,
+    // so that the target cannot be deleted between its verification and the creation of the link to it.
+    // These should be held until the link files of the new version are written, see Unlock().
+    Globals *globalConfiguration
+    Context context.Context
+    Project string
+    Asset string
+    TargetLocks map[string]*directoryLock
+    LockedAssets map[string]bool
+}
+
+func newDeduplicationIndex(registry string) *deduplicationIndex {
+    return &deduplicationIndex{
+        Registry: registry,
+        ManifestCache: map[string]map[string]manifestEntry{},
+        ValidCache: map[string]bool{},
+    }
+}
+
+// 'project' and 'asset' refer to the asset being uploaded, which should already be locked by the caller.
+func newLockingDeduplicationIndex(registry, project, asset string, globals *globalConfiguration, ctx context.Context) *deduplicationIndex {
+    output := newDeduplicationIndex(registry)
+    output.Globals = globals
+    output.Context = ctx
+    output.Project = project
+    output.Asset = asset
+    output.TargetLocks = map[string]*directoryLock{}
+    output.LockedAssets = map[string]bool{}
+    return output
+}
+
+// This should be called while holding 'd.Lock'.
+func (d *deduplicationIndex) lockTarget(project, asset string) bool {
+    if d.Globals == nil || (project == d.Project && asset == d.Asset) {
+        return true
+    }
+
+    asset_key := filepath.Join(project, asset)
+    if locked, ok := d.LockedAssets[asset_key]; ok {
+        return locked
+    }
+
+    // Failing to acquire a lock is treated as a cache miss, so that we fall back to copying the file.
+    locked := false
+    defer func() {
+        d.LockedAssets[asset_key] = locked
+    }()
+
+    project_dir := filepath.Join(d.Registry, project)
+    if _, ok := d.TargetLocks[project]; !ok {
+        plock, err := tryLockDirectoryShared(project_dir, d.Globals, d.Context)
+        if err != nil {
+            return false
+        }
+        d.TargetLocks[project] = plock
+    }
+
+    alock, err := tryLockDirectoryShared(filepath.Join(project_dir, asset), d.Globals, d.Context)
+    if err != nil {
+        return false
+    }
+    d.TargetLocks[asset_key] = alock
+
+    locked = true
+    return true
+}
+
+func (d *deduplicationIndex) Unlock() {
+    d.Lock.Lock()
+    defer d.Lock.Unlock()
+
+    // Releasing the asset locks before the project locks.
+    for key, dlock := range d.TargetLocks {
+        if filepath.Dir(key) != "." {
+            dlock.Unlock(d.Globals)
+        }
+    }
+    for _, dlock := range d.TargetLocks {
+        dlock.Unlock(d.Globals)
+    }
+    d.TargetLocks = map[string]*directoryLock{}
+    d.LockedAssets = map[string]bool{}
+}
+
+// 'digests' should contain the additional digests of the new file, if any were computed.
+// Targets that lack any of these digests (e.g., uploaded before the digest was configured) are not used for deduplication.
+func (d *deduplicationIndex) Find(size int64, md5sum string, digests map[string]string) *linkMetadata {
+    if len(md5sum) < 2 {
+        return nil
+    }
+
+    // Any failure to read or verify the entry is treated as a cache miss, as the index is only a performance optimization.
+    candidates, err := readDeduplicationEntries(d.Registry, size, md5sum)
+    if err != nil {
+        return nil
+    }
+
+    // Preferring the most recently added locations.
+    for i := len(candidates) - 1; i >= 0; i-- {
+        found := candidates[i]
+        if d.verify(&found, size, md5sum, digests) {
+            return &found
+        }
+    }
+    return nil
+}
+
+func (d *deduplicationIndex) verify(found *linkMetadata, size int64, md5sum string, digests map[string]string) bool {
+    if isBadName(found.Project) != nil || isBadName(found.Asset) != nil || isBadName(found.Version) != nil || !filepath.IsLocal(found.Path) {
+        return false
+    }
+
+    d.Lock.Lock()
+    defer d.Lock.Unlock()
+
+    key := filepath.Join(found.Project, found.Asset, found.Version)
+    valid, ok := d.ValidCache[key]
+    if !ok {
+        valid = false
+        if d.lockTarget(found.Project, found.Asset) {
+            summ, err := readSummary(filepath.Join(d.Registry, key))
+            if err == nil && !summ.IsProbational() && !summ.IsDeprecated() {
+                manifest, err := readManifest(filepath.Join(d.Registry, key))
+                if err == nil {
+                    d.ManifestCache[key] = manifest
+                    valid = true
+                }
+            }
+        }
+        d.ValidCache[key] = valid
+    }
+    if !valid {
+        return false
+    }
+
+    entry, ok := d.ManifestCache[key][found.Path]
+    if !ok || entry.Link != nil || entry.Size != size || entry.Md5sum != md5sum {
+        return false
+    }
+    for algo, digest := range digests {
+        if entry.Digests[algo] != digest {
+            return false
+        }
+    }
+
+    return true
+}
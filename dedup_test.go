@@ -0,0 +1,388 @@
+package main
+
+import (
+    "testing"
+    "os"
+    "path/filepath"
+    "context"
+    "errors"
+)
+
+func TestDeduplicationIndex(t *testing.T) {
+    reg, err := os.MkdirTemp("", "")
+    if err != nil {
+        t.Fatalf("failed to create the registry; %v", err)
+    }
+
+    src, err := setupSourceForWalkDirectoryTest()
+    if err != nil {
+        t.Fatalf("failed to set up test directories; %v", err)
+    }
+
+    ctx := context.Background()
+    conc := newConcurrencyThrottle(2)
+
+    project := "pokemon"
+    asset := "pikachu"
+    version := "red"
+    err = transferDirectory(src, reg, project, asset, version, ctx, &conc, transferDirectoryOptions{})
+    if err != nil {
+        t.Fatalf("failed to perform the transfer; %v", err)
+    }
+
+    version_dir := filepath.Join(reg, project, asset, version)
+    err = dumpJson(filepath.Join(version_dir, summaryFileName), &summaryMetadata{ UploadUserId: "aaron", UploadStart: "2020-02-02T02:20:02Z", UploadFinish: "2020-02-02T02:20:02Z" })
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    man, err := readManifest(version_dir)
+    if err != nil {
+        t.Fatalf("failed to read the manifest; %v", err)
+    }
+
+    err = addVersionToDeduplicationIndex(reg, project, asset, version, man)
+    if err != nil {
+        t.Fatalf("failed to add to the deduplication index; %v", err)
+    }
+
+    idx := newDeduplicationIndex(reg)
+    entry := man["type"]
+    found := idx.Find(entry.Size, entry.Md5sum, nil)
+    if found == nil || found.Project != project || found.Asset != asset || found.Version != version || found.Path != "type" {
+        t.Fatalf("failed to find the expected entry in the deduplication index; %v", found)
+    }
+
+    if idx.Find(entry.Size + 1, entry.Md5sum, nil) != nil {
+        t.Fatal("expected no entry for a mismatching size")
+    }
+    if idx.Find(0, "", nil) != nil {
+        t.Fatal("expected no entry for an empty checksum")
+    }
+
+    // Additional digests must also match, if they were computed for the new file.
+    if idx.Find(entry.Size, entry.Md5sum, map[string]string{ "sha256": "abcdef" }) != nil {
+        t.Fatal("expected no entry when the target lacks the additional digest")
+    }
+
+    // Stale entries are ignored if the manifest doesn't match.
+    err = addDeduplicationEntry(reg, 999, "abcdef", &linkMetadata{ Project: project, Asset: asset, Version: version, Path: "type" })
+    if err != nil {
+        t.Fatal(err)
+    }
+    if idx.Find(999, "abcdef", nil) != nil {
+        t.Fatal("expected no entry for a stale index entry")
+    }
+
+    // Probational versions are ignored.
+    on_probation := true
+    err = dumpJson(filepath.Join(version_dir, summaryFileName), &summaryMetadata{ UploadUserId: "aaron", UploadStart: "2020-02-02T02:20:02Z", UploadFinish: "2020-02-02T02:20:02Z", OnProbation: &on_probation })
+    if err != nil {
+        t.Fatal(err)
+    }
+    if newDeduplicationIndex(reg).Find(entry.Size, entry.Md5sum, nil) != nil {
+        t.Fatal("expected no entry for a probational version")
+    }
+
+    // Removal only affects entries pointing to the version.
+    other := man["evolution/up"]
+    err = addDeduplicationEntry(reg, other.Size, other.Md5sum, &linkMetadata{ Project: project, Asset: asset, Version: "blue", Path: "evolution/up" })
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    err = removeVersionFromDeduplicationIndex(reg, project, asset, version)
+    if err != nil {
+        t.Fatalf("failed to remove from the deduplication index; %v", err)
+    }
+    if _, err := os.Stat(deduplicationIndexPath(reg, entry.Size, entry.Md5sum)); !errors.Is(err, os.ErrNotExist) {
+        t.Fatal("expected the index entry to be removed")
+    }
+    if _, err := os.Stat(deduplicationIndexPath(reg, other.Size, other.Md5sum)); err != nil {
+        t.Fatal("expected the index entry for another version to be preserved")
+    }
+}
+
+func TestDeduplicationIndexLocking(t *testing.T) {
+    reg, err := os.MkdirTemp("", "")
+    if err != nil {
+        t.Fatalf("failed to create the registry; %v", err)
+    }
+    globals := newGlobalConfiguration(reg, 2)
+
+    src, err := setupSourceForWalkDirectoryTest()
+    if err != nil {
+        t.Fatalf("failed to set up test directories; %v", err)
+    }
+
+    ctx := context.Background()
+    err = transferDirectory(src, reg, "pokemon", "pikachu", "red", ctx, globals.ConcurrencyThrottle, transferDirectoryOptions{})
+    if err != nil {
+        t.Fatalf("failed to perform the transfer; %v", err)
+    }
+
+    version_dir := filepath.Join(reg, "pokemon", "pikachu", "red")
+    err = dumpJson(filepath.Join(version_dir, summaryFileName), &summaryMetadata{ UploadUserId: "aaron", UploadStart: "2020-02-02T02:20:02Z", UploadFinish: "2020-02-02T02:20:02Z" })
+    if err != nil {
+        t.Fatal(err)
+    }
+    man, err := readManifest(version_dir)
+    if err != nil {
+        t.Fatalf("failed to read the manifest; %v", err)
+    }
+    err = addVersionToDeduplicationIndex(reg, "pokemon", "pikachu", "red", man)
+    if err != nil {
+        t.Fatalf("failed to add to the deduplication index; %v", err)
+    }
+    entry := man["type"]
+
+    // Targets in another project are locked until the index is unlocked.
+    asset_dir := filepath.Join(reg, "pokemon", "pikachu")
+    idx := newLockingDeduplicationIndex(reg, "digimon", "agumon", &globals, ctx)
+    found := idx.Find(entry.Size, entry.Md5sum, nil)
+    if found == nil || found.Project != "pokemon" {
+        t.Fatalf("failed to find the expected entry in the deduplication index; %v", found)
+    }
+    err = globals.Locks.Lock(filepath.Join(asset_dir, "..LOCK"), ctx, 0, true)
+    if err == nil {
+        t.Fatal("expected the target asset to be locked during deduplication")
+    }
+
+    idx.Unlock()
+    alock, err := lockDirectoryExclusive(asset_dir, &globals, ctx)
+    if err != nil {
+        t.Fatalf("expected the target asset to be unlocked; %v", err)
+    }
+
+    // Targets that cannot be locked are not used for deduplication.
+    idx = newLockingDeduplicationIndex(reg, "digimon", "agumon", &globals, ctx)
+    if idx.Find(entry.Size, entry.Md5sum, nil) != nil {
+        t.Fatal("expected no entry when the target asset is locked by another process")
+    }
+    idx.Unlock()
+    alock.Unlock(&globals)
+
+    // No locks are acquired for the asset being uploaded, as the caller should already hold an exclusive lock.
+    alock, err = lockDirectoryExclusive(asset_dir, &globals, ctx)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer alock.Unlock(&globals)
+    idx = newLockingDeduplicationIndex(reg, "pokemon", "pikachu", &globals, ctx)
+    if idx.Find(entry.Size, entry.Md5sum, nil) == nil {
+        t.Fatal("expected an entry for a target in the same asset")
+    }
+    idx.Unlock()
+}
+
+func TestDeduplicationIndexMultipleCopies(t *testing.T) {
+    reg, err := os.MkdirTemp("", "")
+    if err != nil {
+        t.Fatalf("failed to create the registry; %v", err)
+    }
+
+    src, err := setupSourceForWalkDirectoryTest()
+    if err != nil {
+        t.Fatalf("failed to set up test directories; %v", err)
+    }
+
+    ctx := context.Background()
+    conc := newConcurrencyThrottle(2)
+
+    // Creating two real copies of the same files, without any deduplication between them.
+    project := "pokemon"
+    asset := "pikachu"
+    for _, version := range []string{ "red", "blue" } {
+        err = transferDirectory(src, reg, project, asset, version, ctx, &conc, transferDirectoryOptions{})
+        if err != nil {
+            t.Fatalf("failed to perform the transfer; %v", err)
+        }
+    }
+
+    var man map[string]manifestEntry
+    for _, version := range []string{ "red", "blue" } {
+        version_dir := filepath.Join(reg, project, asset, version)
+        err = dumpJson(filepath.Join(version_dir, summaryFileName), &summaryMetadata{ UploadUserId: "aaron", UploadStart: "2020-02-02T02:20:02Z", UploadFinish: "2020-02-02T02:20:02Z" })
+        if err != nil {
+            t.Fatal(err)
+        }
+        man, err = readManifest(version_dir)
+        if err != nil {
+            t.Fatalf("failed to read the manifest; %v", err)
+        }
+        err = addVersionToDeduplicationIndex(reg, project, asset, version, man)
+        if err != nil {
+            t.Fatalf("failed to add to the deduplication index; %v", err)
+        }
+    }
+
+    entry := man["type"]
+    found, err := readDeduplicationEntries(reg, entry.Size, entry.Md5sum)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(found) != 2 || found[0].Version != "red" || found[1].Version != "blue" {
+        t.Fatalf("expected both copies in the deduplication index; %v", found)
+    }
+
+    // Repeated additions are no-ops.
+    err = addVersionToDeduplicationIndex(reg, project, asset, "blue", man)
+    if err != nil {
+        t.Fatalf("failed to add to the deduplication index; %v", err)
+    }
+    found, err = readDeduplicationEntries(reg, entry.Size, entry.Md5sum)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(found) != 2 {
+        t.Fatalf("expected no duplicate locations in the deduplication index; %v", found)
+    }
+
+    // The most recent copy is preferred, but the other copy is still used once the former is removed.
+    linked := newDeduplicationIndex(reg).Find(entry.Size, entry.Md5sum, nil)
+    if linked == nil || linked.Version != "blue" {
+        t.Fatalf("expected the most recent copy to be preferred; %v", linked)
+    }
+
+    err = removeVersionFromDeduplicationIndex(reg, project, asset, "blue")
+    if err != nil {
+        t.Fatalf("failed to remove from the deduplication index; %v", err)
+    }
+    linked = newDeduplicationIndex(reg).Find(entry.Size, entry.Md5sum, nil)
+    if linked == nil || linked.Version != "red" {
+        t.Fatalf("expected the surviving copy to be used after removal; %v", linked)
+    }
+}
+
+func TestTransferDirectoryDeduplicateRegistry(t *testing.T) {
+    reg, err := os.MkdirTemp("", "")
+    if err != nil {
+        t.Fatalf("failed to create the registry; %v", err)
+    }
+
+    src, err := setupSourceForWalkDirectoryTest()
+    if err != nil {
+        t.Fatalf("failed to set up test directories; %v", err)
+    }
+
+    ctx := context.Background()
+    conc := newConcurrencyThrottle(2)
+
+    err = transferDirectory(src, reg, "pokemon", "pikachu", "red", ctx, &conc, transferDirectoryOptions{})
+    if err != nil {
+        t.Fatalf("failed to perform the transfer; %v", err)
+    }
+
+    version_dir := filepath.Join(reg, "pokemon", "pikachu", "red")
+    err = dumpJson(filepath.Join(version_dir, summaryFileName), &summaryMetadata{ UploadUserId: "aaron", UploadStart: "2020-02-02T02:20:02Z", UploadFinish: "2020-02-02T02:20:02Z" })
+    if err != nil {
+        t.Fatal(err)
+    }
+    man, err := readManifest(version_dir)
+    if err != nil {
+        t.Fatalf("failed to read the manifest; %v", err)
+    }
+    err = addVersionToDeduplicationIndex(reg, "pokemon", "pikachu", "red", man)
+    if err != nil {
+        t.Fatalf("failed to add to the deduplication index; %v", err)
+    }
+
+    // Uploading the same files into a different project entirely.
+    err = transferDirectory(src, reg, "digimon", "agumon", "v1", ctx, &conc, transferDirectoryOptions{})
+    if err != nil {
+        t.Fatalf("failed to perform the transfer; %v", err)
+    }
+
+    destination := filepath.Join(reg, "digimon", "agumon", "v1")
+    man2, err := readManifest(destination)
+    if err != nil {
+        t.Fatalf("failed to read the manifest; %v", err)
+    }
+
+    for k, entry := range man2 {
+        if entry.Md5sum == "" {
+            continue
+        }
+        if entry.Link == nil || entry.Link.Project != "pokemon" || entry.Link.Asset != "pikachu" || entry.Link.Version != "red" {
+            t.Fatalf("expected %q to be linked to an existing file in the registry; %v", k, entry.Link)
+        }
+
+        expected, err := os.ReadFile(filepath.Join(src, k))
+        if err != nil {
+            t.Fatal(err)
+        }
+        err = verifyRegistrySymlink(man2, destination, k, string(expected), "pokemon", "pikachu", "red", entry.Link.Path, false)
+        if err != nil {
+            t.Fatal(err)
+        }
+    }
+}
+
+func TestTransferDirectoryDeduplicateRegistryDigests(t *testing.T) {
+    reg, err := os.MkdirTemp("", "")
+    if err != nil {
+        t.Fatalf("failed to create the registry; %v", err)
+    }
+
+    src, err := setupSourceForWalkDirectoryTest()
+    if err != nil {
+        t.Fatalf("failed to set up test directories; %v", err)
+    }
+
+    ctx := context.Background()
+    conc := newConcurrencyThrottle(2)
+
+    addToIndex := func(project, asset, version string) map[string]manifestEntry {
+        version_dir := filepath.Join(reg, project, asset, version)
+        err := dumpJson(filepath.Join(version_dir, summaryFileName), &summaryMetadata{ UploadUserId: "aaron", UploadStart: "2020-02-02T02:20:02Z", UploadFinish: "2020-02-02T02:20:02Z" })
+        if err != nil {
+            t.Fatal(err)
+        }
+        man, err := readManifest(version_dir)
+        if err != nil {
+            t.Fatalf("failed to read the manifest; %v", err)
+        }
+        err = addVersionToDeduplicationIndex(reg, project, asset, version, man)
+        if err != nil {
+            t.Fatalf("failed to add to the deduplication index; %v", err)
+        }
+        return man
+    }
+
+    // Indexed files without the configured digest are not used for deduplication.
+    err = transferDirectory(src, reg, "pokemon", "pikachu", "red", ctx, &conc, transferDirectoryOptions{})
+    if err != nil {
+        t.Fatalf("failed to perform the transfer; %v", err)
+    }
+    addToIndex("pokemon", "pikachu", "red")
+
+    options := transferDirectoryOptions{ Digests: []string{ "sha256" } }
+    err = transferDirectory(src, reg, "digimon", "agumon", "v1", ctx, &conc, options)
+    if err != nil {
+        t.Fatalf("failed to perform the transfer; %v", err)
+    }
+    man := addToIndex("digimon", "agumon", "v1")
+    for k, entry := range man {
+        if entry.Link != nil {
+            t.Fatalf("unexpected deduplication of %q against a file without the configured digest", k)
+        }
+    }
+
+    // Files with matching digests are deduplicated.
+    err = transferDirectory(src, reg, "yugioh", "kuriboh", "v1", ctx, &conc, options)
+    if err != nil {
+        t.Fatalf("failed to perform the transfer; %v", err)
+    }
+    man, err = readManifest(filepath.Join(reg, "yugioh", "kuriboh", "v1"))
+    if err != nil {
+        t.Fatalf("failed to read the manifest; %v", err)
+    }
+    for k, entry := range man {
+        if entry.Md5sum == "" {
+            continue
+        }
+        if entry.Link == nil || entry.Link.Project != "digimon" {
+            t.Fatalf("expected %q to be linked to a file with the same digests; %v", k, entry.Link)
+        }
+    }
+}
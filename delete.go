@@ -8,6 +8,7 @@ import (
     "errors"
     "net/http"
     "context"
+    "log"
 )
 
 func deleteProjectHandler(reqpath string, globals *globalConfiguration, ctx context.Context) error {
@@ -15,10 +16,6 @@ func deleteProjectHandler(reqpath string, globals *globalConfiguration, ctx cont
     if err != nil {
         return fmt.Errorf("failed to find owner of %q; %w", reqpath, err)
     }
-    if !isAuthorizedToAdmin(req_user, globals.Administrators) {
-        return newHttpError(http.StatusForbidden, fmt.Errorf("user %q is not authorized to delete a project", req_user))
-    }
-
     incoming := struct {
         Project *string `json:"project"`
     }{}
@@ -39,6 +36,10 @@ func deleteProjectHandler(reqpath string, globals *globalConfiguration, ctx cont
         }
     }
 
+    if !isAuthorizedToAdminAction(req_user, globals.Administrators, globals.ScopedAdministrators, adminActionDelete, incoming.Project) {
+        return newHttpError(http.StatusForbidden, fmt.Errorf("user %q is not authorized to delete project %q", req_user, *(incoming.Project)))
+    }
+
     rlock, err := lockDirectoryExclusive(globals.Registry, globals, ctx)
     if err != nil {
         return fmt.Errorf("failed to lock the registry; %w", err)
@@ -55,6 +56,11 @@ func deleteProjectHandler(reqpath string, globals *globalConfiguration, ctx cont
         }
     }
 
+    err = removeProjectFromDeduplicationIndex(globals.Registry, *(incoming.Project))
+    if err != nil {
+        return fmt.Errorf("failed to update the deduplication index for %s; %w", project_dir, err)
+    }
+
     err = os.RemoveAll(project_dir)
     if err != nil {
         return fmt.Errorf("failed to delete %s; %v", project_dir, err)
@@ -77,10 +83,6 @@ func deleteAssetHandler(reqpath string, globals *globalConfiguration, ctx contex
     if err != nil {
         return fmt.Errorf("failed to find owner of %q; %w", reqpath, err)
     }
-    if !isAuthorizedToAdmin(req_user, globals.Administrators) {
-        return newHttpError(http.StatusForbidden, fmt.Errorf("user %q is not authorized to delete a project", req_user))
-    }
-
     incoming := struct {
         Project *string `json:"project"`
         Asset *string `json:"asset"`
@@ -108,6 +110,10 @@ func deleteAssetHandler(reqpath string, globals *globalConfiguration, ctx contex
         }
     }
 
+    if !isAuthorizedToAdminAction(req_user, globals.Administrators, globals.ScopedAdministrators, adminActionDelete, incoming.Project) {
+        return newHttpError(http.StatusForbidden, fmt.Errorf("user %q is not authorized to delete an asset in %q", req_user, *(incoming.Project)))
+    }
+
     force_deletion := incoming.Force != nil && *(incoming.Force)
 
     rlock, err := lockDirectoryShared(globals.Registry, globals, ctx)
@@ -154,6 +160,11 @@ func deleteAssetHandler(reqpath string, globals *globalConfiguration, ctx contex
         return fmt.Errorf("failed to compute usage for %s; %v", asset_dir, asset_usage_err)
     }
 
+    err = removeAssetFromDeduplicationIndex(globals.Registry, *(incoming.Project), *(incoming.Asset))
+    if err != nil {
+        return fmt.Errorf("failed to update the deduplication index for %s; %w", asset_dir, err)
+    }
+
     err = os.RemoveAll(asset_dir)
     if err != nil {
         return fmt.Errorf("failed to delete %s; %v", asset_dir, err)
@@ -184,10 +195,6 @@ func deleteVersionHandler(reqpath string, globals *globalConfiguration, ctx cont
     if err != nil {
         return fmt.Errorf("failed to find owner of %q; %w", reqpath, err)
     }
-    if !isAuthorizedToAdmin(req_user, globals.Administrators) {
-        return newHttpError(http.StatusForbidden, fmt.Errorf("user %q is not authorized to delete a project", req_user))
-    }
-
     incoming := struct {
         Project *string `json:"project"`
         Asset *string `json:"asset"`
@@ -219,6 +226,10 @@ func deleteVersionHandler(reqpath string, globals *globalConfiguration, ctx cont
         }
     }
 
+    if !isAuthorizedToAdminAction(req_user, globals.Administrators, globals.ScopedAdministrators, adminActionDelete, incoming.Project) {
+        return newHttpError(http.StatusForbidden, fmt.Errorf("user %q is not authorized to delete a version in %q", req_user, *(incoming.Project)))
+    }
+
     force_deletion := incoming.Force != nil && *(incoming.Force)
 
     rlock, err := lockDirectoryShared(globals.Registry, globals, ctx)
@@ -273,8 +284,16 @@ func deleteVersionHandler(reqpath string, globals *globalConfiguration, ctx cont
     }
     defer alock.Unlock(globals)
 
-    version_dir := filepath.Join(asset_dir, *(incoming.Version))
-    _, err = os.Stat(version_dir)
+    return deleteVersion(project_dir, asset_dir, *(incoming.Version), force_deletion, globals, ctx)
+}
+
+// This assumes that the caller holds an exclusive lock on the asset directory.
+func deleteVersion(project_dir, asset_dir, version string, force_deletion bool, globals *globalConfiguration, ctx context.Context) error {
+    project := filepath.Base(project_dir)
+    asset := filepath.Base(asset_dir)
+
+    version_dir := filepath.Join(asset_dir, version)
+    _, err := os.Stat(version_dir)
     if err != nil {
         if errors.Is(err, os.ErrNotExist) {
             return nil
@@ -293,6 +312,11 @@ func deleteVersionHandler(reqpath string, globals *globalConfiguration, ctx cont
         return fmt.Errorf("failed to read summary for %s; %v", version_dir, summ_err)
     }
 
+    err = removeVersionFromDeduplicationIndex(globals.Registry, project, asset, version)
+    if err != nil {
+        return fmt.Errorf("failed to update the deduplication index for %s; %w", version_dir, err)
+    }
+
     err = os.RemoveAll(version_dir)
     if err != nil {
         return fmt.Errorf("failed to delete %s; %v", asset_dir, err)
@@ -305,21 +329,40 @@ func deleteVersionHandler(reqpath string, globals *globalConfiguration, ctx cont
         }
     }
 
+    // Aliases to the deleted version are no longer valid, so we remove them.
+    removed_aliases, err := removeAliasesForVersion(asset_dir, version)
+    if err != nil {
+        return fmt.Errorf("failed to remove aliases for %s; %w", version_dir, err)
+    }
+    for _, alias := range removed_aliases {
+        payload := map[string]interface{} {
+            "type": "remove-alias",
+            "project": project,
+            "asset": asset,
+            "alias": alias,
+            "version": version,
+        }
+        err = dumpLog(globals.Registry, &payload)
+        if err != nil {
+            return fmt.Errorf("failed to create log for alias removal; %w", err)
+        }
+    }
+
     if summ_err == nil && (summ.OnProbation == nil || !(*summ.OnProbation)) {
         // Only need to make a log if the version is non-probational.
         prev, err := readLatest(asset_dir)
         was_latest := false
         if err == nil {
-            was_latest = (prev.Version == *(incoming.Version))
+            was_latest = (prev.Version == version)
         } else if !errors.Is(err, os.ErrNotExist) {
             return fmt.Errorf("failed to read the latest version for %s; %v", asset_dir, err)
         }
 
         payload := map[string]interface{} { 
             "type": "delete-version", 
-            "project": *(incoming.Project),
-            "asset": *(incoming.Asset),
-            "version": *(incoming.Version),
+            "project": project,
+            "asset": asset,
+            "version": version,
             "latest": was_latest,
         }
 
@@ -335,5 +378,11 @@ func deleteVersionHandler(reqpath string, globals *globalConfiguration, ctx cont
         }
     }
 
+    // Errors are not fatal as the version has already been deleted, and the index is rebuilt by refreshing the latest version.
+    err = updateVersionIndex(asset_dir, version)
+    if err != nil {
+        log.Printf("failed to update the version index for %q; %v", asset_dir, err)
+    }
+
     return nil
 }
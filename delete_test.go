@@ -348,6 +348,54 @@ func TestDeleteVersion(t *testing.T) {
         }
     })
 
+    t.Run("corrupted index", func(t *testing.T) {
+        version := "random"
+        reg, err := mockRegistryForDeletion(project, asset, []string{ version })
+        if err != nil {
+            t.Fatalf("failed to mock up registry; %v", err) 
+        }
+
+        project_dir := filepath.Join(reg, project)
+        asset_dir := filepath.Join(project_dir, asset)
+        err = os.WriteFile(filepath.Join(asset_dir, versionsFileName), []byte("{"), 0644)
+        if err != nil {
+            t.Fatal(err)
+        }
+
+        reqpath, err := dumpRequest("delete_version", fmt.Sprintf(`{ "project": "%s", "asset": "%s", "version": "%s" }`, project, asset, version))
+        if err != nil {
+            t.Fatalf("failed to dump a request type; %v", err)
+        }
+
+        globals := newGlobalConfiguration(reg, 2)
+        self, err := identifyUser(reg)
+        if err != nil {
+            t.Fatalf("failed to identify self; %v", err)
+        }
+        globals.Administrators = append(globals.Administrators, self)
+        err = deleteVersionHandler(reqpath, &globals, ctx)
+        if err != nil {
+            t.Fatalf("failures to update the version index should not be fatal; %v", err)
+        }
+
+        // Usage and logs should still be updated.
+        usage, err := readUsage(project_dir)
+        if err != nil {
+            t.Fatalf("failed to read usage after deletion; %v", err)
+        }
+        if usage.Total != 0 {
+            t.Fatal("expected no usage after version deletion")
+        }
+
+        logs, err := readAllLogs(reg)
+        if err != nil {
+            t.Fatalf("failed to read all logs; %v", err)
+        }
+        if len(logs) != 1 || logs[0].Type != "delete-version" {
+            t.Fatalf("logs are not as expected from version deletion; %v", logs)
+        }
+    })
+
     t.Run("multiple versions", func(t *testing.T) {
         for _, delete_oldest := range []bool{ true, false } {
             reg, err := mockRegistryForDeletion(project, asset, []string{ "boring_oldies", "hot_newness" })
@@ -0,0 +1,211 @@
+package main
+
+import (
+    "os"
+    "encoding/json"
+    "fmt"
+    "errors"
+    "path/filepath"
+    "net/http"
+    "context"
+)
+
+func baseDeprecationHandler(reqpath string, deprecate bool, globals *globalConfiguration, ctx context.Context) error {
+    incoming := struct {
+        Project *string `json:"project"`
+        Asset *string `json:"asset"`
+        Version *string `json:"version"`
+        Reason *string `json:"reason"`
+        Spoof *string `json:"spoof"`
+    }{}
+    {
+        handle, err := os.ReadFile(reqpath)
+        if err != nil {
+            return fmt.Errorf("failed to read %q; %w", reqpath, err)
+        }
+
+        err = json.Unmarshal(handle, &incoming)
+        if err != nil {
+            return newHttpError(http.StatusBadRequest, fmt.Errorf("failed to parse JSON from %q; %w", reqpath, err))
+        }
+
+        err = isMissingOrBadName(incoming.Project)
+        if err != nil {
+            return newHttpError(http.StatusBadRequest, fmt.Errorf("invalid 'project' property in %q; %w", reqpath, err))
+        }
+
+        err = isMissingOrBadName(incoming.Asset)
+        if err != nil {
+            return newHttpError(http.StatusBadRequest, fmt.Errorf("invalid 'asset' property in %q; %w", reqpath, err))
+        }
+
+        err = isMissingOrBadName(incoming.Version)
+        if err != nil {
+            return newHttpError(http.StatusBadRequest, fmt.Errorf("invalid 'version' property in %q; %w", reqpath, err))
+        }
+    }
+
+    username, err := identifySpoofedUser(reqpath, incoming.Spoof, globals.SpoofPermissions)
+    if err != nil {
+        return fmt.Errorf("failed to identify user; %w", err)
+    }
+
+    rlock, err := lockDirectoryShared(globals.Registry, globals, ctx)
+    if err != nil {
+        return fmt.Errorf("failed to lock the registry %q; %w", globals.Registry, err)
+    }
+    defer rlock.Unlock(globals)
+
+    rnnlock, err := lockDirectoryNewDirShared(globals.Registry, globals, ctx)
+    if err != nil {
+        return fmt.Errorf("failed to lock the registry %q; %w", globals.Registry, err)
+    }
+    defer rnnlock.Unlock(globals)
+
+    project := *(incoming.Project)
+    project_dir := filepath.Join(globals.Registry, project)
+    if err := checkProjectExists(project_dir, project); err != nil {
+        return err
+    }
+    rnnlock.Unlock(globals) // no need for this lock once we determine that the project directory exists.
+
+    plock, err := lockDirectoryShared(project_dir, globals, ctx)
+    if err != nil {
+        return fmt.Errorf("failed to lock project directory %q; %w", project_dir, err)
+    }
+    defer plock.Unlock(globals)
+
+    pnnlock, err := lockDirectoryNewDirShared(project_dir, globals, ctx)
+    if err != nil {
+        return fmt.Errorf("failed to lock project directory %q; %w", project_dir, err)
+    }
+    defer pnnlock.Unlock(globals)
+
+    asset := *(incoming.Asset)
+    asset_dir := filepath.Join(project_dir, asset)
+    if err := checkAssetExists(asset_dir, asset, project); err != nil {
+        return err
+    }
+    pnnlock.Unlock(globals) // no need for this lock once we determine that the asset directory exists.
+
+    alock, err := lockDirectoryExclusive(asset_dir, globals, ctx)
+    if err != nil {
+        return fmt.Errorf("failed to lock asset directory %q; %w", asset_dir, err)
+    }
+    defer alock.Unlock(globals)
+
+    // Asset-level owners are allowed to deprecate versions of their own assets.
+    existing, err := readPermissions(project_dir)
+    if err != nil {
+        return fmt.Errorf("failed to read permissions for %q; %w", project_dir, err)
+    }
+    asset_perms, err := addAssetPermissionsForUpload(existing, asset_dir, asset)
+    if err != nil {
+        return fmt.Errorf("failed to read permissions for asset %q in %q; %w", asset, project, err)
+    }
+    if !isAuthorizedToMaintain(username, globals.Administrators, asset_perms.Owners) {
+        return newHttpError(http.StatusForbidden, fmt.Errorf("user %q is not authorized to deprecate versions in %q", username, asset_dir))
+    }
+
+    version := *(incoming.Version)
+    version_dir := filepath.Join(asset_dir, version)
+    if err := checkVersionExists(version_dir, version, asset, project); err != nil {
+        return err
+    }
+
+    summ, err := readSummary(version_dir)
+    if err != nil {
+        return fmt.Errorf("failed to read the version summary at %q; %w", version_dir, err)
+    }
+    if summ.IsProbational() {
+        return newHttpError(http.StatusBadRequest, fmt.Errorf("cannot change the deprecation status of probational version %q", version_dir))
+    }
+    if deprecate == summ.IsDeprecated() {
+        if deprecate {
+            return newHttpError(http.StatusBadRequest, fmt.Errorf("version %q is already deprecated", version_dir))
+        }
+        return newHttpError(http.StatusBadRequest, fmt.Errorf("version %q is not deprecated", version_dir))
+    }
+
+    prev, err := readLatest(asset_dir)
+    was_latest := false
+    if err == nil {
+        was_latest = (prev.Version == version)
+    } else if !errors.Is(err, os.ErrNotExist) {
+        return fmt.Errorf("failed to read the latest version for %s; %w", asset_dir, err)
+    }
+
+    if deprecate {
+        summ.Deprecated = &deprecate
+        if incoming.Reason != nil {
+            summ.DeprecationReason = *(incoming.Reason)
+        }
+    } else {
+        summ.Deprecated = nil
+        summ.DeprecationReason = ""
+    }
+
+    summary_path := filepath.Join(version_dir, summaryFileName)
+    err = dumpJson(summary_path, &summ)
+    if err != nil {
+        return fmt.Errorf("failed to update the version summary at %q; %w", summary_path, err)
+    }
+
+    err = updateVersionIndex(asset_dir, version)
+    if err != nil {
+        return err
+    }
+
+    // Deprecated versions should not be used as the target of new links.
+    if deprecate {
+        err = removeVersionFromDeduplicationIndex(globals.Registry, project, asset, version)
+        if err != nil {
+            return fmt.Errorf("failed to update the deduplication index for %q; %w", version_dir, err)
+        }
+    } else {
+        manifest, err := readManifest(version_dir)
+        if err != nil {
+            return fmt.Errorf("failed to read the manifest for %q; %w", version_dir, err)
+        }
+        err = addVersionToDeduplicationIndex(globals.Registry, project, asset, version, manifest)
+        if err != nil {
+            return fmt.Errorf("failed to update the deduplication index for %q; %w", version_dir, err)
+        }
+    }
+
+    latest, err := refreshLatest(asset_dir)
+    if err != nil {
+        return fmt.Errorf("failed to update the latest version for %q; %w", asset_dir, err)
+    }
+
+    // Adding a log.
+    log_info := map[string]interface{} {
+        "project": project,
+        "asset": asset,
+        "version": version,
+    }
+    if deprecate {
+        log_info["type"] = "deprecate-version"
+        log_info["latest"] = was_latest
+        if incoming.Reason != nil {
+            log_info["reason"] = *(incoming.Reason)
+        }
+    } else {
+        log_info["type"] = "undeprecate-version"
+        log_info["latest"] = latest != nil && latest.Version == version
+    }
+    err = dumpLog(globals.Registry, &log_info)
+    if err != nil {
+        return fmt.Errorf("failed to save log file; %w", err)
+    }
+
+    return nil
+}
+
+func deprecateVersionHandler(reqpath string, globals *globalConfiguration, ctx context.Context) error {
+    return baseDeprecationHandler(reqpath, true, globals, ctx)
+}
+
+func undeprecateVersionHandler(reqpath string, globals *globalConfiguration, ctx context.Context) error {
+    return baseDeprecationHandler(reqpath, false, globals, ctx)
+}
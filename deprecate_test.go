@@ -0,0 +1,264 @@
+package main
+
+import (
+    "testing"
+    "os"
+    "path/filepath"
+    "fmt"
+    "strings"
+    "context"
+    "errors"
+)
+
+func TestDeprecationHandlers(t *testing.T) {
+    reg, err := constructMockRegistry()
+    if err != nil {
+        t.Fatalf("failed to create the registry; %v", err)
+    }
+    globals := newGlobalConfiguration(reg, 2)
+    ctx := context.Background()
+
+    project := "original_series"
+    asset := "gastly"
+    err = setupProjectForUploadTest(project, &globals)
+    if err != nil {
+        t.Fatalf("failed to set up project directory; %v", err)
+    }
+    asset_dir := filepath.Join(reg, project, asset)
+
+    src, err := setupSourceForUploadTest()
+    if err != nil {
+        t.Fatalf("failed to set up test directories; %v", err)
+    }
+
+    upload := func(version string) {
+        reqname, err := dumpRequest("upload", fmt.Sprintf(`{ "source": "%s", "project": "%s", "asset": "%s", "version": "%s" }`, filepath.Base(src), project, asset, version))
+        if err != nil {
+            t.Fatalf("failed to create upload request; %v", err)
+        }
+        err = uploadHandler(reqname, &globals, ctx)
+        if err != nil {
+            t.Fatalf("failed to perform the upload; %v", err)
+        }
+    }
+    upload("v1")
+    upload("v2")
+
+    deprecate := func(version string, undo bool, extra string) error {
+        reqtype := "deprecate_version"
+        if undo {
+            reqtype = "undeprecate_version"
+        }
+        reqname, err := dumpRequest(reqtype, fmt.Sprintf(`{ "project": "%s", "asset": "%s", "version": "%s"%s }`, project, asset, version, extra))
+        if err != nil {
+            t.Fatalf("failed to create deprecation request; %v", err)
+        }
+        if undo {
+            return undeprecateVersionHandler(reqname, &globals, ctx)
+        }
+        return deprecateVersionHandler(reqname, &globals, ctx)
+    }
+
+    err = deprecate("v2", false, `, "reason": "broken"`)
+    if err != nil {
+        t.Fatalf("failed to deprecate the version; %v", err)
+    }
+
+    summ, err := readSummary(filepath.Join(asset_dir, "v2"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !summ.IsDeprecated() || summ.DeprecationReason != "broken" {
+        t.Fatalf("expected the version to be deprecated; %v", summ)
+    }
+
+    latest, err := readLatest(asset_dir)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if latest.Version != "v1" {
+        t.Fatalf("deprecated version should not be the latest; %v", latest.Version)
+    }
+
+    findLog := func(logtype string) *logEntry {
+        logs, err := readAllLogs(reg)
+        if err != nil {
+            t.Fatal(err)
+        }
+        for _, l := range logs {
+            if l.Type == logtype {
+                return &l
+            }
+        }
+        t.Fatalf("failed to find a %q log", logtype)
+        return nil
+    }
+
+    dlog := findLog("deprecate-version")
+    if *(dlog.Project) != project || *(dlog.Asset) != asset || *(dlog.Version) != "v2" || !*(dlog.Latest) {
+        t.Fatalf("unexpected log entry; %v", dlog)
+    }
+
+    err = deprecate("v2", false, "")
+    if err == nil || !strings.Contains(err.Error(), "already deprecated") {
+        t.Fatalf("expected a failure to deprecate an already-deprecated version; %v", err)
+    }
+
+    // Deprecating everything removes the latest version and the deduplication entries.
+    err = deprecate("v1", false, "")
+    if err != nil {
+        t.Fatalf("failed to deprecate the version; %v", err)
+    }
+    _, err = readLatest(asset_dir)
+    if !errors.Is(err, os.ErrNotExist) {
+        t.Fatalf("expected no latest version; %v", err)
+    }
+
+    manifest, err := readManifest(filepath.Join(asset_dir, "v1"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    for _, entry := range manifest {
+        _, err := readDeduplicationEntries(reg, entry.Size, entry.Md5sum)
+        if !errors.Is(err, os.ErrNotExist) {
+            t.Fatalf("expected no deduplication entry for deprecated versions; %v", err)
+        }
+    }
+
+    upload("v3")
+    manifest, err = readManifest(filepath.Join(asset_dir, "v3"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    for path, entry := range manifest {
+        if entry.Link != nil {
+            t.Fatalf("new version should not link to deprecated versions; %v", path)
+        }
+    }
+
+    // Undoing the deprecation. We backdate the version to avoid ties in the upload time with v3.
+    summ, err = readSummary(filepath.Join(asset_dir, "v2"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    summ.UploadFinish = "2020-01-01T00:00:00Z"
+    err = dumpJson(filepath.Join(asset_dir, "v2", summaryFileName), summ)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    err = deprecate("v2", true, "")
+    if err != nil {
+        t.Fatalf("failed to undeprecate the version; %v", err)
+    }
+    summ, err = readSummary(filepath.Join(asset_dir, "v2"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if summ.IsDeprecated() || summ.DeprecationReason != "" {
+        t.Fatalf("expected the version to no longer be deprecated; %v", summ)
+    }
+
+    latest, err = readLatest(asset_dir)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if latest.Version != "v3" {
+        t.Fatalf("unexpected latest version; %v", latest.Version)
+    }
+
+    ulog := findLog("undeprecate-version")
+    if *(ulog.Version) != "v2" || *(ulog.Latest) {
+        t.Fatalf("unexpected log entry; %v", ulog)
+    }
+
+    err = deprecate("v2", true, "")
+    if err == nil || !strings.Contains(err.Error(), "not deprecated") {
+        t.Fatalf("expected a failure to undeprecate a non-deprecated version; %v", err)
+    }
+
+    err = deprecate("v4", false, "")
+    if err == nil || !strings.Contains(err.Error(), "does not exist") {
+        t.Fatalf("expected a failure to deprecate a non-existent version; %v", err)
+    }
+}
+
+func TestDeprecationHandlersUnauthorized(t *testing.T) {
+    reg, err := constructMockRegistry()
+    if err != nil {
+        t.Fatalf("failed to create the registry; %v", err)
+    }
+    globals := newGlobalConfiguration(reg, 2)
+    ctx := context.Background()
+
+    err = mockProbationVersion(reg, "dawn", "sinnoh", "foo")
+    if err != nil {
+        t.Fatalf("failed to create a mock version; %v", err)
+    }
+
+    reqname, err := dumpRequest("deprecate_version", `{ "project": "dawn", "asset": "sinnoh", "version": "foo" }`)
+    if err != nil {
+        t.Fatalf("failed to create deprecation request; %v", err)
+    }
+    err = deprecateVersionHandler(reqname, &globals, ctx)
+    if err == nil || !strings.Contains(err.Error(), "not authorized") {
+        t.Fatalf("expected an authorization failure; %v", err)
+    }
+
+    self, err := identifyUser(reg)
+    if err != nil {
+        t.Fatal(err)
+    }
+    globals.Administrators = append(globals.Administrators, self)
+    err = deprecateVersionHandler(reqname, &globals, ctx)
+    if err == nil || !strings.Contains(err.Error(), "probational") {
+        t.Fatalf("expected a failure to deprecate a probational version; %v", err)
+    }
+}
+
+func TestDeprecationHandlersAssetOwners(t *testing.T) {
+    project := "pokemon"
+    asset := "pikachu"
+    version := "red"
+    reg, err := mockRegistryForDeletion(project, asset, []string{ version })
+    if err != nil {
+        t.Fatalf("failed to create a mock registry; %v", err)
+    }
+    globals := newGlobalConfiguration(reg, 2)
+    ctx := context.Background()
+
+    self, err := identifyUser(reg)
+    if err != nil {
+        t.Fatalf("failed to identify self; %v", err)
+    }
+    err = os.WriteFile(filepath.Join(reg, project, permissionsFileName), []byte(`{ "owners": [], "uploaders": [] }`), 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    reqname, err := dumpRequest("deprecate_version", fmt.Sprintf(`{ "project": "%s", "asset": "%s", "version": "%s" }`, project, asset, version))
+    if err != nil {
+        t.Fatalf("failed to create deprecation request; %v", err)
+    }
+    err = deprecateVersionHandler(reqname, &globals, ctx)
+    if err == nil || !strings.Contains(err.Error(), "not authorized") {
+        t.Fatalf("expected an authorization failure without any ownership; %v", err)
+    }
+
+    err = os.WriteFile(filepath.Join(reg, project, asset, permissionsFileName), []byte(fmt.Sprintf(`{ "owners": [ "%s" ], "uploaders": [] }`, self)), 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = deprecateVersionHandler(reqname, &globals, ctx)
+    if err != nil {
+        t.Fatalf("asset owners should be able to deprecate versions; %v", err)
+    }
+
+    summ, err := readSummary(filepath.Join(reg, project, asset, version))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !summ.IsDeprecated() {
+        t.Fatal("expected the version to be deprecated")
+    }
+}
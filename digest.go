@@ -0,0 +1,81 @@
+package main
+
+import (
+    "crypto/md5"
+    "crypto/sha1"
+    "crypto/sha256"
+    "crypto/sha512"
+    "encoding/hex"
+    "hash"
+    "io"
+    "os"
+    "fmt"
+    "strings"
+    "sort"
+)
+
+// Additional digests that can be recorded in the manifest alongside the MD5 checksum.
+// The name of each algorithm is used as the property name in each manifest entry.
+var supportedDigests = map[string]func() hash.Hash {
+    "sha1": sha1.New,
+    "sha256": sha256.New,
+    "sha512": sha512.New,
+}
+
+func parseDigestAlgorithms(spec string) ([]string, error) {
+    output := []string{}
+    if spec == "" {
+        return output, nil
+    }
+
+    present := map[string]bool{}
+    for _, algo := range strings.Split(spec, ",") {
+        if _, ok := supportedDigests[algo]; !ok {
+            return nil, fmt.Errorf("unsupported digest algorithm %q", algo)
+        }
+        if _, ok := present[algo]; ok {
+            continue
+        }
+        present[algo] = true
+        output = append(output, algo)
+    }
+
+    sort.Strings(output)
+    return output, nil
+}
+
+// This computes the MD5 checksum along with any additional digests in a single pass through the file.
+func computeDigests(path string, algorithms []string) (string, map[string]string, error) {
+    in, err := os.Open(path)
+    if err != nil {
+        return "", nil, fmt.Errorf("failed to open '" + path + "'; %w", err)
+    }
+    defer in.Close()
+
+    h := md5.New()
+    writers := []io.Writer{ h }
+    extra := make([]hash.Hash, len(algorithms))
+    for i, algo := range algorithms {
+        constructor, ok := supportedDigests[algo]
+        if !ok {
+            return "", nil, fmt.Errorf("unsupported digest algorithm %q", algo)
+        }
+        extra[i] = constructor()
+        writers = append(writers, extra[i])
+    }
+
+    _, err = io.Copy(io.MultiWriter(writers...), in)
+    if err != nil {
+        return "", nil, fmt.Errorf("failed to hash '" + path + "'; %w", err)
+    }
+
+    var digests map[string]string
+    if len(algorithms) > 0 {
+        digests = map[string]string{}
+        for i, algo := range algorithms {
+            digests[algo] = hex.EncodeToString(extra[i].Sum(nil))
+        }
+    }
+
+    return hex.EncodeToString(h.Sum(nil)), digests, nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+    "testing"
+    "os"
+    "path/filepath"
+    "strings"
+)
+
+func TestParseDigestAlgorithms(t *testing.T) {
+    out, err := parseDigestAlgorithms("")
+    if err != nil || len(out) != 0 {
+        t.Fatalf("expected no algorithms for an empty string; %v", err)
+    }
+
+    out, err = parseDigestAlgorithms("sha512,sha256,sha512")
+    if err != nil {
+        t.Fatalf("failed to parse algorithms; %v", err)
+    }
+    if len(out) != 2 || out[0] != "sha256" || out[1] != "sha512" {
+        t.Fatalf("unexpected algorithms %v", out)
+    }
+
+    _, err = parseDigestAlgorithms("sha256,crc32")
+    if err == nil || !strings.Contains(err.Error(), "unsupported") {
+        t.Fatal("expected failure for unsupported algorithm")
+    }
+}
+
+func TestComputeDigests(t *testing.T) {
+    f, err := os.MkdirTemp("", "test-")
+    if err != nil {
+        t.Fatalf("failed to create tempdir; %v", err)
+    }
+    path := filepath.Join(f, "foo")
+    err = os.WriteFile(path, []byte("hello"), 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    md5sum, digests, err := computeDigests(path, nil)
+    if err != nil {
+        t.Fatalf("failed to compute digests; %v", err)
+    }
+    if md5sum != "5d41402abc4b2a76b9719d911017c592" || digests != nil {
+        t.Fatalf("unexpected MD5 checksum or digests")
+    }
+
+    md5sum, digests, err = computeDigests(path, []string{ "sha1", "sha256" })
+    if err != nil {
+        t.Fatalf("failed to compute digests; %v", err)
+    }
+    if md5sum != "5d41402abc4b2a76b9719d911017c592" || 
+        digests["sha1"] != "aaf4c61ddcc5e8a2dabede0f3b482cd9aea9434d" ||
+        digests["sha256"] != "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824" ||
+        len(digests) != 2 {
+        t.Fatalf("unexpected digests %v", digests)
+    }
+
+    checksum, err := computeChecksum(path)
+    if err != nil || checksum != md5sum {
+        t.Fatalf("unexpected MD5 checksum; %v", err)
+    }
+}
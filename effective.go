@@ -0,0 +1,181 @@
+package main
+
+import (
+    "os"
+    "fmt"
+    "errors"
+    "time"
+    "path/filepath"
+    "net/http"
+    "strconv"
+)
+
+// The effective permissions merge the project- and asset-level permissions in the same manner as the upload checks,
+// so that clients do not need to reimplement the authorization logic.
+type effectiveUploaderEntry struct {
+    uploaderEntry
+    Expired bool `json:"expired,omitempty"`
+}
+
+type effectivePermissions struct {
+    Owners []string `json:"owners"`
+    Uploaders []effectiveUploaderEntry `json:"uploaders"`
+    GlobalWrite bool `json:"global_write"`
+    Readers []string `json:"readers,omitempty"`
+}
+
+type effectiveUserPermissions struct {
+    User string `json:"user"`
+    Version *string `json:"version,omitempty"`
+    Admin bool `json:"admin"`
+    AdminActions []string `json:"admin_actions"`
+    Maintain bool `json:"maintain"`
+    Approve bool `json:"approve"`
+    Upload bool `json:"upload"`
+    OnProbation bool `json:"on_probation"`
+    Read bool `json:"read"`
+}
+
+type effectivePermissionsResult struct {
+    Project string `json:"project"`
+    Asset *string `json:"asset,omitempty"`
+    Permissions effectivePermissions `json:"permissions"`
+    User *effectiveUserPermissions `json:"user,omitempty"`
+    History *[]permissionsChangeEvent `json:"history,omitempty"`
+}
+
+func getPermissionsHandler(r *http.Request, project string, asset *string, reader *string, globals *globalConfiguration) (*effectivePermissionsResult, error) {
+    err := isBadName(project)
+    if err != nil {
+        return nil, newHttpError(http.StatusBadRequest, fmt.Errorf("invalid project name; %w", err))
+    }
+    rel_path := project
+    if asset != nil {
+        err := isBadName(*asset)
+        if err != nil {
+            return nil, newHttpError(http.StatusBadRequest, fmt.Errorf("invalid asset name; %w", err))
+        }
+        rel_path += "/" + *asset
+    }
+
+    // Checking read access before inspecting the project, as in the /fetch and /list endpoints.
+    err = checkReadAccess(globals.Registry, rel_path, reader, globals.Administrators)
+    if err != nil {
+        return nil, err
+    }
+
+    project_dir := filepath.Join(globals.Registry, project)
+    if err := checkProjectExists(project_dir, project); err != nil {
+        return nil, err
+    }
+
+    project_perms, err := readPermissions(project_dir)
+    if err != nil {
+        return nil, fmt.Errorf("failed to read permissions for %q; %w", project_dir, err)
+    }
+
+    // Asset-level permissions are only considered if the asset already exists, consistent with the upload checks.
+    merged := project_perms
+    asset_exists := false
+    var asset_perms *permissionsMetadata
+    if asset != nil {
+        asset_dir := filepath.Join(project_dir, *asset)
+        _, err := os.Stat(asset_dir)
+        if err == nil {
+            asset_exists = true
+            merged, err = addAssetPermissionsForUpload(project_perms, asset_dir, *asset)
+            if err != nil {
+                return nil, fmt.Errorf("failed to read permissions for %q; %w", asset_dir, err)
+            }
+            asset_perms, err = readPermissionsIfExists(asset_dir)
+            if err != nil {
+                return nil, err
+            }
+        } else if !errors.Is(err, os.ErrNotExist) {
+            return nil, fmt.Errorf("failed to stat %q; %w", asset_dir, err)
+        }
+    }
+
+    output := &effectivePermissionsResult{
+        Project: project,
+        Asset: asset,
+        Permissions: effectivePermissions{
+            Owners: merged.Owners,
+            Uploaders: []effectiveUploaderEntry{},
+            GlobalWrite: project_perms.GlobalWrite != nil && *(project_perms.GlobalWrite),
+            Readers: project_perms.Readers,
+        },
+    }
+    if output.Permissions.Owners == nil {
+        output.Permissions.Owners = []string{}
+    }
+    if asset_perms != nil && asset_perms.Readers != nil {
+        output.Permissions.Readers = asset_perms.Readers
+    }
+
+    now := time.Now()
+    for _, u := range merged.Uploaders {
+        output.Permissions.Uploaders = append(output.Permissions.Uploaders, effectiveUploaderEntry{ uploaderEntry: u, Expired: isUploaderExpired(&u, now) })
+    }
+
+    qparams := r.URL.Query()
+    if qparams.Has("history") {
+        include_history, err := strconv.ParseBool(qparams.Get("history"))
+        if err != nil {
+            return nil, newHttpError(http.StatusBadRequest, errors.New("expected a boolean for the 'history' query parameter"))
+        }
+        if include_history {
+            history, err := readPermissionsHistory(project_dir)
+            if err != nil {
+                return nil, err
+            }
+
+            // For assets, only the changes to the project-level permissions and to this asset's permissions are relevant.
+            relevant := []permissionsChangeEvent{}
+            for _, event := range history {
+                if asset == nil || event.Asset == nil || *(event.Asset) == *asset {
+                    relevant = append(relevant, event)
+                }
+            }
+            output.History = &relevant
+        }
+    }
+
+    user := qparams.Get("user")
+    if user == "" {
+        return output, nil
+    }
+
+    uinfo := &effectiveUserPermissions{ User: user }
+    if qparams.Has("version") {
+        version := qparams.Get("version")
+        err := isBadName(version)
+        if err != nil {
+            return nil, newHttpError(http.StatusBadRequest, fmt.Errorf("invalid version name; %w", err))
+        }
+        uinfo.Version = &version
+    }
+
+    uinfo.Admin = isAuthorizedToAdmin(user, globals.Administrators)
+    uinfo.AdminActions = []string{}
+    for _, action := range allAdminActions {
+        if isAuthorizedToAdminAction(user, globals.Administrators, globals.ScopedAdministrators, action, &project) {
+            uinfo.AdminActions = append(uinfo.AdminActions, action)
+        }
+    }
+    uinfo.Maintain = isAuthorizedToMaintain(user, globals.Administrators, merged.Owners)
+    uinfo.Approve = isAuthorizedToMaintain(user, globals.Administrators, project_perms.Owners)
+    uinfo.Read = isAuthorizedToRead(&user, globals.Administrators, project_perms, asset_perms)
+
+    // Mirroring the logic in uploadHandler, where global writes only apply to the creation of a new asset.
+    if !asset_exists && output.Permissions.GlobalWrite {
+        uinfo.Upload = true
+    } else {
+        ok, trusted := isAuthorizedToUpload(user, globals.Administrators, merged, asset, uinfo.Version)
+        uinfo.Upload = ok
+        uinfo.OnProbation = ok && !trusted
+    }
+
+    output.User = uinfo
+    return output, nil
+}
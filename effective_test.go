@@ -0,0 +1,278 @@
+package main
+
+import (
+    "testing"
+    "os"
+    "path/filepath"
+    "net/http"
+    "strings"
+)
+
+func TestGetPermissionsHandler(t *testing.T) {
+    reg, err := constructMockRegistry()
+    if err != nil {
+        t.Fatalf("failed to create the registry; %v", err)
+    }
+    globals := newGlobalConfiguration(reg, 2)
+    globals.Administrators = []string{ "oak" }
+
+    project := "kanto"
+    project_dir := filepath.Join(reg, project)
+    err = os.Mkdir(project_dir, 0755)
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = os.WriteFile(
+        filepath.Join(project_dir, permissionsFileName),
+        []byte(`{ 
+            "owners": [ "brock" ],
+            "uploaders": [
+                { "id": "misty" },
+                { "id": "surge", "trusted": true, "version": "v2" },
+                { "id": "sabrina", "until": "2000-01-01T00:00:00Z", "trusted": true }
+            ]
+        }`),
+        0644,
+    )
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    asset := "pewter"
+    asset_dir := filepath.Join(project_dir, asset)
+    err = os.Mkdir(asset_dir, 0755)
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = os.WriteFile(filepath.Join(asset_dir, permissionsFileName), []byte(`{ "owners": [ "flint" ], "uploaders": [ { "id": "forrest", "trusted": true } ] }`), 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    query := func(q string, asset *string) (*effectivePermissionsResult, error) {
+        r, err := http.NewRequest("GET", "/permissions/" + project + "?" + q, nil)
+        if err != nil {
+            t.Fatal(err)
+        }
+        return getPermissionsHandler(r, project, asset, nil, &globals)
+    }
+
+    t.Run("project", func(t *testing.T) {
+        res, err := query("", nil)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if res.User != nil || res.Asset != nil || len(res.Permissions.Owners) != 1 || res.Permissions.GlobalWrite {
+            t.Fatalf("unexpected project permissions; %v", res)
+        }
+        if len(res.Permissions.Uploaders) != 3 || res.Permissions.Uploaders[0].Expired || !res.Permissions.Uploaders[2].Expired {
+            t.Fatalf("unexpected uploaders; %v", res.Permissions.Uploaders)
+        }
+
+        res, err = query("user=misty", nil)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if res.User == nil || !res.User.Upload || !res.User.OnProbation || res.User.Maintain || res.User.Approve || res.User.Admin || !res.User.Read {
+            t.Fatalf("unexpected permissions for an untrusted uploader; %v", res.User)
+        }
+
+        res, err = query("user=sabrina", nil)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if res.User.Upload {
+            t.Fatalf("unexpected upload permissions for an expired uploader; %v", res.User)
+        }
+
+        res, err = query("user=surge", nil)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if res.User.Upload {
+            t.Fatalf("unexpected upload permissions for the wrong version; %v", res.User)
+        }
+        res, err = query("user=surge&version=v2", nil)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if !res.User.Upload || res.User.OnProbation || res.User.Version == nil || *(res.User.Version) != "v2" {
+            t.Fatalf("unexpected permissions for a trusted uploader; %v", res.User)
+        }
+
+        res, err = query("user=oak", nil)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if !res.User.Admin || !res.User.Maintain || !res.User.Approve || !res.User.Upload || res.User.OnProbation {
+            t.Fatalf("unexpected permissions for an administrator; %v", res.User)
+        }
+        if len(res.User.AdminActions) != len(allAdminActions) {
+            t.Fatalf("expected all administrative actions for an administrator; %v", res.User.AdminActions)
+        }
+    })
+
+    t.Run("scoped admin", func(t *testing.T) {
+        globals.ScopedAdministrators = []scopedAdminGrant{
+            scopedAdminGrant{ User: "giovanni", Projects: []string{ "kan*" }, Actions: map[string]bool{ adminActionDelete: true, adminActionReroute: true } },
+            scopedAdminGrant{ User: "giovanni", Projects: []string{ "johto" }, Actions: nil },
+            scopedAdminGrant{ User: "lance", Projects: []string{ "johto" }, Actions: nil },
+        }
+        defer func() {
+            globals.ScopedAdministrators = []scopedAdminGrant{}
+        }()
+
+        res, err := query("user=giovanni", nil)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if res.User.Admin || res.User.Maintain {
+            t.Fatalf("scoped administrators should not be reported as global administrators; %v", res.User)
+        }
+        if len(res.User.AdminActions) != 2 || res.User.AdminActions[0] != adminActionDelete || res.User.AdminActions[1] != adminActionReroute {
+            t.Fatalf("unexpected administrative actions for a scoped administrator; %v", res.User.AdminActions)
+        }
+
+        res, err = query("user=lance", nil)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if len(res.User.AdminActions) != 0 {
+            t.Fatalf("grants for other projects should not be reported; %v", res.User.AdminActions)
+        }
+    })
+
+    t.Run("asset", func(t *testing.T) {
+        res, err := query("user=flint", &asset)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if len(res.Permissions.Owners) != 2 || len(res.Permissions.Uploaders) != 4 || *(res.Permissions.Uploaders[3].Asset) != asset {
+            t.Fatalf("unexpected merged permissions; %v", res.Permissions)
+        }
+        if !res.User.Maintain || res.User.Approve || !res.User.Upload {
+            t.Fatalf("unexpected permissions for an asset owner; %v", res.User)
+        }
+
+        res, err = query("user=forrest", &asset)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if !res.User.Upload || res.User.OnProbation || res.User.Maintain {
+            t.Fatalf("unexpected permissions for an asset-level uploader; %v", res.User)
+        }
+
+        // Asset-level permissions do not apply to other assets.
+        other := "cerulean"
+        res, err = query("user=forrest", &other)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if res.User.Upload || len(res.Permissions.Owners) != 1 {
+            t.Fatalf("unexpected permissions for a different asset; %v", res)
+        }
+    })
+
+    t.Run("global write", func(t *testing.T) {
+        err = os.WriteFile(filepath.Join(project_dir, permissionsFileName), []byte(`{ "owners": [ "brock" ], "uploaders": [], "global_write": true }`), 0644)
+        if err != nil {
+            t.Fatal(err)
+        }
+
+        other := "cerulean"
+        res, err := query("user=ash", &other)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if !res.Permissions.GlobalWrite || !res.User.Upload || res.User.OnProbation {
+            t.Fatalf("unexpected permissions for a global write to a new asset; %v", res.User)
+        }
+
+        res, err = query("user=ash", &asset)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if res.User.Upload {
+            t.Fatalf("global writes should not apply to existing assets; %v", res.User)
+        }
+    })
+
+    t.Run("history", func(t *testing.T) {
+        res, err := query("history=false", nil)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if res.History != nil {
+            t.Fatal("expected no history unless requested")
+        }
+
+        res, err = query("history=true", nil)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if res.History == nil || len(*(res.History)) != 0 {
+            t.Fatalf("expected an empty history; %v", res.History)
+        }
+
+        other := "cerulean"
+        for _, a := range []*string{ nil, &asset, &other } {
+            err := recordPermissionsChange(reg, project, a, permissionsSourceSet, "oak", nil, nil, &permissionsMetadata{ Owners: []string{ "brock" } })
+            if err != nil {
+                t.Fatal(err)
+            }
+        }
+
+        res, err = query("history=true", nil)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if len(*(res.History)) != 3 {
+            t.Fatalf("expected all changes in the project history; %v", res.History)
+        }
+
+        res, err = query("history=true", &asset)
+        if err != nil {
+            t.Fatal(err)
+        }
+        history := *(res.History)
+        if len(history) != 2 || history[0].Asset != nil || *(history[1].Asset) != asset {
+            t.Fatalf("expected only project-level and asset-level changes in the asset history; %v", history)
+        }
+
+        _, err = query("history=foo", nil)
+        if err == nil || !strings.Contains(err.Error(), "boolean") {
+            t.Fatal("expected failure for a non-boolean history")
+        }
+    })
+
+    t.Run("failures", func(t *testing.T) {
+        r, err := http.NewRequest("GET", "/permissions/foo", nil)
+        if err != nil {
+            t.Fatal(err)
+        }
+        _, err = getPermissionsHandler(r, "foo", nil, nil, &globals)
+        if err == nil || !strings.Contains(err.Error(), "does not exist") {
+            t.Fatal("expected failure for a non-existent project")
+        }
+
+        bad := "..foo"
+        _, err = query("", &bad)
+        if err == nil || !strings.Contains(err.Error(), "invalid asset") {
+            t.Fatal("expected failure for an invalid asset name")
+        }
+
+        _, err = query("user=ash&version=..foo", nil)
+        if err == nil || !strings.Contains(err.Error(), "invalid version") {
+            t.Fatal("expected failure for an invalid version name")
+        }
+
+        err = os.WriteFile(filepath.Join(project_dir, permissionsFileName), []byte(`{ "owners": [ "brock" ], "uploaders": [], "readers": [ "misty" ] }`), 0644)
+        if err != nil {
+            t.Fatal(err)
+        }
+        _, err = query("", nil)
+        if err == nil || !strings.Contains(err.Error(), "token") {
+            t.Fatal("expected failure for a restricted project")
+        }
+    })
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+    "fmt"
+    "path"
+    "path/filepath"
+    "strings"
+)
+
+// Glob-based filters for files in an upload.
+// Patterns without a slash are matched against the base name at any depth, otherwise they are matched against the entire relative path.
+// Patterns with a trailing slash only match directories.
+type pathFilter struct {
+    Include []string
+    Exclude []string
+}
+
+func checkGlobPatterns(patterns []string) error {
+    for _, pattern := range patterns {
+        trimmed := strings.TrimSuffix(pattern, "/")
+        if trimmed == "" {
+            return fmt.Errorf("pattern %q should not be empty", pattern)
+        }
+        if _, err := path.Match(trimmed, ""); err != nil {
+            return fmt.Errorf("invalid pattern %q; %w", pattern, err)
+        }
+    }
+    return nil
+}
+
+func matchGlobPattern(pattern string, rel_path string, is_dir bool) bool {
+    if strings.HasSuffix(pattern, "/") {
+        if !is_dir {
+            return false
+        }
+        pattern = strings.TrimSuffix(pattern, "/")
+    }
+
+    target := filepath.ToSlash(rel_path)
+    if !strings.Contains(pattern, "/") {
+        target = path.Base(target)
+    }
+
+    matched, _ := path.Match(pattern, target)
+    return matched
+}
+
+// Excluded directories should be skipped entirely by the caller.
+func (f *pathFilter) Excludes(rel_path string, is_dir bool) bool {
+    for _, pattern := range f.Exclude {
+        if matchGlobPattern(pattern, rel_path, is_dir) {
+            return true
+        }
+    }
+    return false
+}
+
+// A file is included if it or any of its parent directories match an inclusion pattern.
+// If there are no inclusion patterns, all files are included.
+func (f *pathFilter) Includes(rel_path string) bool {
+    if len(f.Include) == 0 {
+        return true
+    }
+
+    current := rel_path
+    is_dir := false
+    for current != "." {
+        for _, pattern := range f.Include {
+            if matchGlobPattern(pattern, current, is_dir) {
+                return true
+            }
+        }
+        current = filepath.Dir(current)
+        is_dir = true
+    }
+    return false
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+    "testing"
+    "strings"
+)
+
+func TestCheckGlobPatterns(t *testing.T) {
+    err := checkGlobPatterns([]string{ "*.tmp", "__pycache__/", "foo/*.log" })
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    err = checkGlobPatterns([]string{ "[" })
+    if err == nil || !strings.Contains(err.Error(), "invalid pattern") {
+        t.Fatal("expected an error for a malformed pattern")
+    }
+
+    err = checkGlobPatterns([]string{ "/" })
+    if err == nil || !strings.Contains(err.Error(), "empty") {
+        t.Fatal("expected an error for an empty pattern")
+    }
+}
+
+func TestPathFilterExcludes(t *testing.T) {
+    filter := &pathFilter{ Exclude: []string{ "*.tmp", "__pycache__/", "logs/*.log" } }
+
+    if !filter.Excludes("foo.tmp", false) || !filter.Excludes("a/b/foo.tmp", false) {
+        t.Fatal("expected base name patterns to match at any depth")
+    }
+    if !filter.Excludes("a/__pycache__", true) {
+        t.Fatal("expected directory patterns to match directories")
+    }
+    if filter.Excludes("a/__pycache__", false) {
+        t.Fatal("expected directory patterns to not match files")
+    }
+    if !filter.Excludes("logs/foo.log", false) {
+        t.Fatal("expected path patterns to match the relative path")
+    }
+    if filter.Excludes("a/logs/foo.log", false) || filter.Excludes("foo.log", false) {
+        t.Fatal("expected path patterns to only match the full relative path")
+    }
+    if filter.Excludes("foo.txt", false) {
+        t.Fatal("expected unmatched files to be retained")
+    }
+}
+
+func TestPathFilterIncludes(t *testing.T) {
+    empty := &pathFilter{}
+    if !empty.Includes("foo/bar") {
+        t.Fatal("expected all files to be included when there are no patterns")
+    }
+
+    filter := &pathFilter{ Include: []string{ "*.csv", "docs/" } }
+    if !filter.Includes("a/b/foo.csv") {
+        t.Fatal("expected base name patterns to match at any depth")
+    }
+    if !filter.Includes("docs/intro/index.html") {
+        t.Fatal("expected files inside included directories to be included")
+    }
+    if filter.Includes("a/foo.txt") || filter.Includes("docs") {
+        t.Fatal("expected unmatched files to be excluded")
+    }
+}
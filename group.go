@@ -0,0 +1,93 @@
+package main
+
+import (
+    "fmt"
+    "os/user"
+    "strings"
+    "sync"
+    "time"
+)
+
+// Entries in the owners, uploaders or administrators that start with '@' refer to Unix groups.
+// A user matches such an entry if they are a member of the group, including via their primary group.
+const groupEntryPrefix = "@"
+
+func isGroupEntry(id string) bool {
+    return strings.HasPrefix(id, groupEntryPrefix)
+}
+
+// Group memberships are cached to avoid repeated lookups, which may be expensive if they involve a network service like LDAP.
+type groupMembershipCacheEntry struct {
+    Groups map[string]bool
+    Expiry time.Time
+}
+
+type groupMembershipCache struct {
+    Lock sync.Mutex
+    Lifetime time.Duration
+    Users map[string]*groupMembershipCacheEntry
+}
+
+func newGroupMembershipCache(lifetime time.Duration) *groupMembershipCache {
+    return &groupMembershipCache{ Lifetime: lifetime, Users: map[string]*groupMembershipCacheEntry{} }
+}
+
+func (c *groupMembershipCache) Get(username string) map[string]bool {
+    c.Lock.Lock()
+    defer c.Lock.Unlock()
+
+    now := time.Now()
+    if entry, ok := c.Users[username]; ok && now.Before(entry.Expiry) {
+        return entry.Groups
+    }
+
+    // Failed lookups are also cached, so that unknown users do not trigger a lookup on every permission check.
+    groups := map[string]bool{}
+    uinfo, err := user.Lookup(username)
+    if err == nil {
+        gids, err := uinfo.GroupIds()
+        if err == nil {
+            for _, gid := range gids {
+                ginfo, err := user.LookupGroupId(gid)
+                if err == nil {
+                    groups[ginfo.Name] = true
+                }
+            }
+        }
+    }
+
+    c.Users[username] = &groupMembershipCacheEntry{ Groups: groups, Expiry: now.Add(c.Lifetime) }
+    return groups
+}
+
+var userGroups = newGroupMembershipCache(time.Minute * 10)
+
+// Checks whether 'username' matches an entry in the owners, uploaders or administrators.
+func matchesUserOrGroup(username string, id string) bool {
+    if !isGroupEntry(id) {
+        return id == username
+    }
+    return userGroups.Get(username)[strings.TrimPrefix(id, groupEntryPrefix)]
+}
+
+func checkGroupEntry(id string) error {
+    if !isGroupEntry(id) {
+        return nil
+    }
+    group := strings.TrimPrefix(id, groupEntryPrefix)
+    _, err := user.LookupGroup(group)
+    if err != nil {
+        return fmt.Errorf("group %q does not exist; %w", group, err)
+    }
+    return nil
+}
+
+func checkGroupEntries(ids []string) error {
+    for _, id := range ids {
+        err := checkGroupEntry(id)
+        if err != nil {
+            return err
+        }
+    }
+    return nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+    "testing"
+    "os/user"
+    "strings"
+    "time"
+)
+
+func getCurrentUserAndGroup(t *testing.T) (string, string) {
+    self, err := user.Current()
+    if err != nil {
+        t.Fatalf("failed to identify the current user; %v", err)
+    }
+    group, err := user.LookupGroupId(self.Gid)
+    if err != nil {
+        t.Skipf("failed to identify the primary group of the current user; %v", err)
+    }
+    return self.Username, group.Name
+}
+
+func TestMatchesUserOrGroup(t *testing.T) {
+    username, groupname := getCurrentUserAndGroup(t)
+
+    if !matchesUserOrGroup(username, username) {
+        t.Fatal("expected a match for the same user")
+    }
+    if matchesUserOrGroup(username, "foo-" + username) {
+        t.Fatal("unexpected match for a different user")
+    }
+
+    if !matchesUserOrGroup(username, "@" + groupname) {
+        t.Fatal("expected a match for the user's primary group")
+    }
+    if matchesUserOrGroup(username, "@gobbler-nonexistent-group") {
+        t.Fatal("unexpected match for a non-existent group")
+    }
+    if matchesUserOrGroup("gobbler-nonexistent-user", "@" + groupname) {
+        t.Fatal("unexpected match for a non-existent user")
+    }
+
+    // Group names without the prefix are treated as user names.
+    if groupname != username && matchesUserOrGroup(username, groupname) {
+        t.Fatal("unexpected match for a group name without the prefix")
+    }
+}
+
+func TestGroupMembershipCache(t *testing.T) {
+    username, groupname := getCurrentUserAndGroup(t)
+
+    cache := newGroupMembershipCache(time.Hour)
+    groups := cache.Get(username)
+    if !groups[groupname] {
+        t.Fatalf("expected the user's primary group in the cache; %v", groups)
+    }
+
+    // Modifying the cached entry to check that it is re-used.
+    cache.Users[username].Groups["foobar"] = true
+    if !cache.Get(username)["foobar"] {
+        t.Fatal("expected the cached entry to be re-used")
+    }
+
+    // Expiring the entry to check that it is refreshed.
+    cache.Users[username].Expiry = time.Now().Add(-time.Minute)
+    if cache.Get(username)["foobar"] {
+        t.Fatal("expected the expired entry to be refreshed")
+    }
+}
+
+func TestCheckGroupEntries(t *testing.T) {
+    username, groupname := getCurrentUserAndGroup(t)
+
+    err := checkGroupEntries([]string{ username, "@" + groupname, "foo" })
+    if err != nil {
+        t.Fatalf("unexpected failure for valid entries; %v", err)
+    }
+
+    err = checkGroupEntries([]string{ username, "@gobbler-nonexistent-group" })
+    if err == nil || !strings.Contains(err.Error(), "does not exist") {
+        t.Fatal("expected failure for a non-existent group")
+    }
+}
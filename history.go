@@ -0,0 +1,161 @@
+package main
+
+import (
+    "os"
+    "fmt"
+    "time"
+    "bufio"
+    "errors"
+    "path/filepath"
+    "encoding/json"
+    "slices"
+)
+
+// Every change to the permissions is recorded in the logs and in a per-project history file.
+// The latter is retained for the lifetime of the project, unlike the logs that are purged after a week.
+// Each line of the history file is a JSON object so that new events can be appended without rewriting the entire file.
+const permissionsHistoryFileName = "..permissions_history"
+
+type permissionsChangeEvent struct {
+    Type string `json:"type"`
+    Project string `json:"project"`
+    Asset *string `json:"asset,omitempty"`
+    Source string `json:"source"`
+    User string `json:"user"`
+    Spoofer *string `json:"spoofer,omitempty"`
+    Time string `json:"time"`
+    Changed []string `json:"changed"`
+    Before *permissionsMetadata `json:"before"`
+    After *permissionsMetadata `json:"after"`
+}
+
+// Sources of permission changes.
+const (
+    permissionsSourceSet = "set_permissions"
+    permissionsSourceCreate = "create_project"
+    permissionsSourceGlobalWrite = "global_write"
+    permissionsSourceRename = "rename_asset"
+)
+
+func isSameJson(x, y interface{}) bool {
+    xraw, xerr := json.Marshal(x)
+    yraw, yerr := json.Marshal(y)
+    return xerr == nil && yerr == nil && string(xraw) == string(yraw)
+}
+
+// Returns the names of the properties that differ between 'before' and 'after', where 'before' may be nil for newly created permissions.
+func diffPermissions(before, after *permissionsMetadata) []string {
+    if before == nil {
+        before = &permissionsMetadata{}
+    }
+
+    changed := []string{}
+    if !isSameJson(before.Owners, after.Owners) {
+        changed = append(changed, "owners")
+    }
+    if !isSameJson(before.Uploaders, after.Uploaders) {
+        changed = append(changed, "uploaders")
+    }
+    if !isSameJson(before.GlobalWrite, after.GlobalWrite) {
+        changed = append(changed, "global_write")
+    }
+    if !isSameJson(before.Readers, after.Readers) {
+        changed = append(changed, "readers")
+    }
+    return changed
+}
+
+// Copy of the permissions, so that the original state can be recorded before the permissions are edited in place.
+// Copying the slices is sufficient as editPermissions() only ever replaces the uploader entries, rather than modifying their pointers' targets.
+func copyPermissions(perms *permissionsMetadata) *permissionsMetadata {
+    output := *perms
+    output.Owners = slices.Clone(perms.Owners)
+    output.Uploaders = slices.Clone(perms.Uploaders)
+    output.Readers = slices.Clone(perms.Readers)
+    return &output
+}
+
+// 'user' is the user on whose behalf the change was made, while 'spoofer' is the actual user if they were spoofing 'user'.
+// No event is recorded if the permissions were not changed.
+func recordPermissionsChange(registry, project string, asset *string, source string, user string, spoofer *string, before, after *permissionsMetadata) error {
+    changed := diffPermissions(before, after)
+    if len(changed) == 0 {
+        return nil
+    }
+
+    if spoofer != nil && *spoofer == user {
+        spoofer = nil
+    }
+
+    event := permissionsChangeEvent{
+        Type: "set-permissions",
+        Project: project,
+        Asset: asset,
+        Source: source,
+        User: user,
+        Spoofer: spoofer,
+        Time: time.Now().Format(time.RFC3339),
+        Changed: changed,
+        Before: before,
+        After: after,
+    }
+
+    err := dumpLog(registry, &event)
+    if err != nil {
+        return fmt.Errorf("failed to save log file; %w", err)
+    }
+
+    raw, err := json.Marshal(&event)
+    if err != nil {
+        return fmt.Errorf("failed to serialize the permissions change; %w", err)
+    }
+
+    history_path := filepath.Join(registry, project, permissionsHistoryFileName)
+    handle, err := os.OpenFile(history_path, os.O_APPEND | os.O_CREATE | os.O_WRONLY, 0644)
+    if err != nil {
+        return fmt.Errorf("failed to open %q; %w", history_path, err)
+    }
+    defer handle.Close()
+
+    _, err = handle.Write(append(raw, '\n'))
+    if err != nil {
+        return fmt.Errorf("failed to append to %q; %w", history_path, err)
+    }
+
+    return nil
+}
+
+// Returns an empty slice if the history file does not exist.
+func readPermissionsHistory(project_dir string) ([]permissionsChangeEvent, error) {
+    history_path := filepath.Join(project_dir, permissionsHistoryFileName)
+    handle, err := os.Open(history_path)
+    if err != nil {
+        if errors.Is(err, os.ErrNotExist) {
+            return []permissionsChangeEvent{}, nil
+        }
+        return nil, fmt.Errorf("failed to open %q; %w", history_path, err)
+    }
+    defer handle.Close()
+
+    output := []permissionsChangeEvent{}
+    scanner := bufio.NewScanner(handle)
+    scanner.Buffer(make([]byte, 0, 64 * 1024), 16 * 1024 * 1024)
+    for scanner.Scan() {
+        line := scanner.Bytes()
+        if len(line) == 0 {
+            continue
+        }
+        var event permissionsChangeEvent
+        err := json.Unmarshal(line, &event)
+        if err != nil {
+            return nil, fmt.Errorf("failed to parse an event in %q; %w", history_path, err)
+        }
+        output = append(output, event)
+    }
+
+    err = scanner.Err()
+    if err != nil {
+        return nil, fmt.Errorf("failed to read %q; %w", history_path, err)
+    }
+    return output, nil
+}
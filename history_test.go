@@ -0,0 +1,94 @@
+package main
+
+import (
+    "testing"
+    "os"
+    "path/filepath"
+)
+
+func TestDiffPermissions(t *testing.T) {
+    before := &permissionsMetadata{ Owners: []string{ "erika" }, Uploaders: []uploaderEntry{} }
+    after := copyPermissions(before)
+    if len(diffPermissions(before, after)) != 0 {
+        t.Fatal("expected no differences for a copy")
+    }
+
+    after.Owners = append(after.Owners, "misty")
+    after.Readers = []string{ "may" }
+    changed := diffPermissions(before, after)
+    if len(changed) != 2 || changed[0] != "owners" || changed[1] != "readers" {
+        t.Fatalf("unexpected differences; %v", changed)
+    }
+    if len(before.Owners) != 1 {
+        t.Fatal("copy should not affect the original permissions")
+    }
+
+    changed = diffPermissions(nil, before)
+    if len(changed) != 2 || changed[0] != "owners" || changed[1] != "uploaders" {
+        t.Fatalf("unexpected differences for new permissions; %v", changed)
+    }
+}
+
+func TestRecordPermissionsChange(t *testing.T) {
+    reg, err := constructMockRegistry()
+    if err != nil {
+        t.Fatalf("failed to create the registry; %v", err)
+    }
+    project := "foo"
+    project_dir := filepath.Join(reg, project)
+    err = os.Mkdir(project_dir, 0755)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    history, err := readPermissionsHistory(project_dir)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(history) != 0 {
+        t.Fatal("expected an empty history if the file does not exist")
+    }
+
+    before := &permissionsMetadata{ Owners: []string{ "erika" }, Uploaders: []uploaderEntry{} }
+    after := &permissionsMetadata{ Owners: []string{ "misty" }, Uploaders: []uploaderEntry{} }
+    err = recordPermissionsChange(reg, project, nil, permissionsSourceSet, "erika", nil, before, after)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    // No-op changes are not recorded.
+    err = recordPermissionsChange(reg, project, nil, permissionsSourceSet, "misty", nil, after, after)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    asset := "bar"
+    spoofer := "sabrina"
+    err = recordPermissionsChange(reg, project, &asset, permissionsSourceSet, "misty", &spoofer, nil, after)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    history, err = readPermissionsHistory(project_dir)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(history) != 2 {
+        t.Fatalf("unexpected number of events in the history; %v", history)
+    }
+    if history[0].Type != "set-permissions" || history[0].User != "erika" || history[0].Spoofer != nil || history[0].Asset != nil ||
+        history[0].Before.Owners[0] != "erika" || history[0].After.Owners[0] != "misty" || len(history[0].Changed) != 1 {
+        t.Fatalf("unexpected first event in the history; %v", history[0])
+    }
+    if history[1].Asset == nil || *(history[1].Asset) != asset || history[1].Spoofer == nil || *(history[1].Spoofer) != spoofer || history[1].Before != nil {
+        t.Fatalf("unexpected second event in the history; %v", history[1])
+    }
+
+    logs, err := readAllLogs(reg)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(logs) != 2 || logs[0].Type != "set-permissions" || *(logs[0].Project) != project {
+        t.Fatalf("unexpected logs; %v", logs)
+    }
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+    "sync"
+    "time"
+    "fmt"
+    "errors"
+    "net/http"
+    "crypto/rand"
+    "encoding/hex"
+)
+
+const (
+    jobQueued = "queued"
+    jobRunning = "running"
+    jobSucceeded = "succeeded"
+    jobFailed = "failed"
+)
+
+type jobStatus struct {
+    Id string `json:"id"`
+    Request string `json:"request"`
+    State string `json:"state"`
+    Created string `json:"created"`
+    Started string `json:"started,omitempty"`
+    Finished string `json:"finished,omitempty"`
+    Payload map[string]interface{} `json:"payload,omitempty"`
+    Reason string `json:"reason,omitempty"`
+    StatusCode int `json:"status_code,omitempty"`
+    FinishedTime time.Time `json:"-"`
+}
+
+// This tracks asynchronous jobs, i.e., requests that are processed in the background after the HTTP response has been sent.
+// The state of each job is held in memory and is forgotten by Purge() once the job has been finished for longer than the expiry time.
+type jobRegistry struct {
+    Lock sync.Mutex
+    Jobs map[string]*jobStatus
+    Slots chan bool
+    Expiry time.Duration
+}
+
+func newJobRegistry(max_jobs int, expiry time.Duration) *jobRegistry {
+    return &jobRegistry{
+        Jobs: map[string]*jobStatus{},
+        Slots: make(chan bool, max_jobs),
+        Expiry: expiry,
+    }
+}
+
+func generateJobId() (string, error) {
+    buffer := make([]byte, 16)
+    _, err := rand.Read(buffer)
+    if err != nil {
+        return "", fmt.Errorf("failed to generate a job ID; %w", err)
+    }
+    return hex.EncodeToString(buffer), nil
+}
+
+func (j *jobRegistry) Submit(request string, fun func() (map[string]interface{}, error)) (string, error) {
+    id, err := generateJobId()
+    if err != nil {
+        return "", err
+    }
+
+    status := &jobStatus{
+        Id: id,
+        Request: request,
+        State: jobQueued,
+        Created: time.Now().Format(time.RFC3339),
+    }
+
+    j.Lock.Lock()
+    j.Jobs[id] = status
+    j.Lock.Unlock()
+
+    go func() {
+        j.Slots <- true
+        j.update(id, func(s *jobStatus) {
+            s.State = jobRunning
+            s.Started = time.Now().Format(time.RFC3339)
+        })
+
+        payload, err := fun()
+        <-j.Slots
+
+        j.update(id, func(s *jobStatus) {
+            s.FinishedTime = time.Now()
+            s.Finished = s.FinishedTime.Format(time.RFC3339)
+            if err == nil {
+                s.State = jobSucceeded
+                s.Payload = payload
+            } else {
+                s.State = jobFailed
+                s.Reason = err.Error()
+                s.StatusCode = http.StatusInternalServerError
+                var http_err *httpError
+                if errors.As(err, &http_err) {
+                    s.StatusCode = http_err.Status
+                }
+            }
+        })
+    }()
+
+    return id, nil
+}
+
+// Once a job is finished, we only need to hold onto its status until the user has had a chance to check it.
+// This should be called periodically to forget the statuses of jobs that finished more than 'Expiry' ago.
+func (j *jobRegistry) Purge() {
+    j.Lock.Lock()
+    defer j.Lock.Unlock()
+    now := time.Now()
+    for id, status := range j.Jobs {
+        if status.Finished != "" && now.Sub(status.FinishedTime) > j.Expiry {
+            delete(j.Jobs, id)
+        }
+    }
+}
+
+func (j *jobRegistry) update(id string, fun func(*jobStatus)) {
+    j.Lock.Lock()
+    defer j.Lock.Unlock()
+    status, ok := j.Jobs[id]
+    if ok {
+        fun(status)
+    }
+}
+
+func (j *jobRegistry) Get(id string) (*jobStatus, bool) {
+    j.Lock.Lock()
+    defer j.Lock.Unlock()
+    status, ok := j.Jobs[id]
+    if !ok {
+        return nil, false
+    }
+
+    // Returning a copy so that the caller doesn't have to worry about concurrent modifications.
+    copied := *status
+    return &copied, true
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+    "testing"
+    "time"
+    "errors"
+    "net/http"
+)
+
+func waitForJob(t *testing.T, jobs *jobRegistry, id string) *jobStatus {
+    for i := 0; i < 100; i++ {
+        status, ok := jobs.Get(id)
+        if !ok {
+            t.Fatalf("failed to find job %q", id)
+        }
+        if status.State == jobSucceeded || status.State == jobFailed {
+            return status
+        }
+        time.Sleep(time.Millisecond * 10)
+    }
+    t.Fatalf("timed out waiting for job %q", id)
+    return nil
+}
+
+func TestJobRegistry(t *testing.T) {
+    jobs := newJobRegistry(1, time.Minute)
+
+    t.Run("success", func(t *testing.T) {
+        id, err := jobs.Submit("request-foo", func() (map[string]interface{}, error) {
+            return map[string]interface{}{ "total": 10 }, nil
+        })
+        if err != nil {
+            t.Fatal(err)
+        }
+
+        status := waitForJob(t, jobs, id)
+        if status.State != jobSucceeded || status.Request != "request-foo" || status.Payload["total"] != 10 || status.Reason != "" {
+            t.Fatalf("unexpected job status; %v", status)
+        }
+        if status.Created == "" || status.Started == "" || status.Finished == "" {
+            t.Fatalf("expected timestamps to be filled; %v", status)
+        }
+    })
+
+    t.Run("failure", func(t *testing.T) {
+        id, err := jobs.Submit("request-bar", func() (map[string]interface{}, error) {
+            return nil, newHttpError(http.StatusBadRequest, errors.New("oops"))
+        })
+        if err != nil {
+            t.Fatal(err)
+        }
+
+        status := waitForJob(t, jobs, id)
+        if status.State != jobFailed || status.Reason != "oops" || status.StatusCode != http.StatusBadRequest {
+            t.Fatalf("unexpected job status; %v", status)
+        }
+    })
+
+    t.Run("queued", func(t *testing.T) {
+        block := make(chan bool)
+        first, err := jobs.Submit("request-first", func() (map[string]interface{}, error) {
+            <-block
+            return nil, nil
+        })
+        if err != nil {
+            t.Fatal(err)
+        }
+
+        for {
+            status, _ := jobs.Get(first)
+            if status.State == jobRunning {
+                break
+            }
+            time.Sleep(time.Millisecond * 10)
+        }
+
+        // Only one job can run at a time, so the second one should be queued.
+        second, err := jobs.Submit("request-second", func() (map[string]interface{}, error) {
+            return nil, nil
+        })
+        if err != nil {
+            t.Fatal(err)
+        }
+        status, _ := jobs.Get(second)
+        if status.State != jobQueued {
+            t.Fatalf("expected the second job to be queued; %v", status)
+        }
+
+        close(block)
+        waitForJob(t, jobs, first)
+        status = waitForJob(t, jobs, second)
+        if status.State != jobSucceeded {
+            t.Fatalf("unexpected job status; %v", status)
+        }
+    })
+
+    t.Run("expiry", func(t *testing.T) {
+        short := newJobRegistry(1, time.Millisecond * 20)
+        id, err := short.Submit("request-foo", func() (map[string]interface{}, error) {
+            return nil, nil
+        })
+        if err != nil {
+            t.Fatal(err)
+        }
+
+        waitForJob(t, short, id)
+        short.Purge()
+        if _, ok := short.Get(id); !ok {
+            t.Fatal("expected the job to be retained before expiry")
+        }
+
+        time.Sleep(time.Millisecond * 50)
+        short.Purge()
+        if _, ok := short.Get(id); ok {
+            t.Fatal("expected the job to be forgotten after expiry")
+        }
+        if _, ok := short.Get("missing"); ok {
+            t.Fatal("expected no status for a non-existent job")
+        }
+    })
+}
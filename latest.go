@@ -8,6 +8,7 @@ import (
     "time"
     "net/http"
     "context"
+    "errors"
 )
 
 type latestMetadata struct {
@@ -33,15 +34,126 @@ func readLatest(path string) (*latestMetadata, error) {
     return &output, nil
 }
 
+// The latest policy determines how the latest version of an asset is chosen.
+// This can be set for an entire project via a '..latest_policy' file in the project directory,
+// or for individual assets via a '..latest_policy' file in the asset directory, which takes precedence.
+const latestPolicyFileName = "..latest_policy"
+
+const (
+    latestOrderUploadTime = "upload_time"
+    latestOrderSemver = "semver"
+    latestOrderNatural = "natural"
+    latestOrderPinned = "pinned"
+)
+
+type latestPolicy struct {
+    Order string `json:"order"`
+    Version *string `json:"version,omitempty"`
+}
+
+func isBadLatestOrder(order string) error {
+    switch order {
+    case latestOrderUploadTime, latestOrderSemver, latestOrderNatural, latestOrderPinned:
+        return nil
+    }
+    return fmt.Errorf("unknown latest ordering %q", order)
+}
+
+func readLatestPolicyFile(dir string) (*latestPolicy, error) {
+    policy_path := filepath.Join(dir, latestPolicyFileName)
+
+    policy_raw, err := os.ReadFile(policy_path)
+    if err != nil {
+        if errors.Is(err, os.ErrNotExist) {
+            return nil, nil
+        }
+        return nil, fmt.Errorf("failed to read %q; %w", policy_path, err)
+    }
+
+    var output latestPolicy
+    err = json.Unmarshal(policy_raw, &output)
+    if err != nil {
+        return nil, fmt.Errorf("failed to parse JSON in %q; %w", policy_path, err)
+    }
+
+    return &output, nil
+}
+
+func readLatestPolicy(asset_dir string) (*latestPolicy, error) {
+    policy, err := readLatestPolicyFile(asset_dir)
+    if err != nil {
+        return nil, err
+    }
+    if policy != nil {
+        return policy, nil
+    }
+
+    policy, err = readLatestPolicyFile(filepath.Dir(asset_dir))
+    if err != nil {
+        return nil, err
+    }
+    if policy != nil {
+        return policy, nil
+    }
+
+    return &latestPolicy{ Order: latestOrderUploadTime }, nil
+}
+
+func compareUploadTimes(a_summ, b_summ *summaryMetadata) (int, error) {
+    a_time, err := time.Parse(time.RFC3339, a_summ.UploadFinish)
+    if err != nil {
+        return 0, fmt.Errorf("could not parse 'upload_finish' %q; %w", a_summ.UploadFinish, err)
+    }
+    b_time, err := time.Parse(time.RFC3339, b_summ.UploadFinish)
+    if err != nil {
+        return 0, fmt.Errorf("could not parse 'upload_finish' %q; %w", b_summ.UploadFinish, err)
+    }
+    return a_time.Compare(b_time), nil
+}
+
+// Returns a positive value if version 'a' should be considered more recent than version 'b' under the specified ordering.
+// Ties between version names are broken by the upload time.
+func compareVersionsForLatest(order string, a_name string, a_summ *summaryMetadata, b_name string, b_summ *summaryMetadata) (int, error) {
+    switch order {
+    case latestOrderSemver:
+        // Names that are not valid semantic versions are always older than those that are.
+        a_sem, a_ok := parseSemanticVersion(a_name)
+        b_sem, b_ok := parseSemanticVersion(b_name)
+        if a_ok && b_ok {
+            if cmp := compareSemanticVersions(a_sem, b_sem); cmp != 0 {
+                return cmp, nil
+            }
+        } else if a_ok != b_ok {
+            if a_ok {
+                return 1, nil
+            }
+            return -1, nil
+        } else if cmp := compareNatural(a_name, b_name); cmp != 0 {
+            return cmp, nil
+        }
+    case latestOrderNatural:
+        if cmp := compareNatural(a_name, b_name); cmp != 0 {
+            return cmp, nil
+        }
+    }
+
+    return compareUploadTimes(a_summ, b_summ)
+}
+
 func refreshLatest(asset_dir string) (*latestMetadata, error) {
+    policy, err := readLatestPolicy(asset_dir)
+    if err != nil {
+        return nil, fmt.Errorf("failed to read the latest policy for %q; %w", asset_dir, err)
+    }
+
     entries, err := listUserDirectories(asset_dir)
     if err != nil {
         return nil, fmt.Errorf("failed to list versions in %q; %w", asset_dir, err)
     }
 
-    found := false
-    var most_recent time.Time
+    var most_recent_summ *summaryMetadata
     var most_recent_name string
+    pinned_found := false
 
     for _, entry := range entries {
         full_path := filepath.Join(asset_dir, entry)
@@ -49,24 +161,37 @@ func refreshLatest(asset_dir string) (*latestMetadata, error) {
         if err != nil {
             return nil, fmt.Errorf("failed to read summary from %q; %w", full_path, err)
         }
-        if summ.IsProbational() {
+        if summ.IsProbational() || summ.IsDeprecated() {
             continue
         }
 
-        as_time, err := time.Parse(time.RFC3339, summ.UploadFinish)
-        if err != nil {
-            return nil, fmt.Errorf("could not parse 'upload_finish' from %q; %w", full_path, err)
+        if policy.Order == latestOrderPinned && policy.Version != nil && *(policy.Version) == entry {
+            most_recent_summ = summ
+            most_recent_name = entry
+            pinned_found = true
+            continue
+        }
+        // If the pinned version doesn't exist (e.g., it was deleted), we fall back to the upload time.
+        if pinned_found {
+            continue
         }
 
-        if !found || most_recent.Before(as_time) {
-            most_recent = as_time
-            most_recent_name = entry
-            found = true
+        if most_recent_summ != nil {
+            cmp, err := compareVersionsForLatest(policy.Order, entry, summ, most_recent_name, most_recent_summ)
+            if err != nil {
+                return nil, fmt.Errorf("failed to compare versions in %q; %w", asset_dir, err)
+            }
+            if cmp <= 0 {
+                continue
+            }
         }
+
+        most_recent_summ = summ
+        most_recent_name = entry
     }
 
     latest_path := filepath.Join(asset_dir, latestFileName)
-    if found {
+    if most_recent_summ != nil {
         output := latestMetadata { Version: most_recent_name }
         err := dumpJson(latest_path, &output)
         if err != nil {
@@ -82,16 +207,60 @@ func refreshLatest(asset_dir string) (*latestMetadata, error) {
     }
 }
 
+// This updates the latest version after a new non-probational version is added to the asset, returning whether the new version is now the latest.
+// If 'replace_on_tie' is true, the new version will replace the existing latest version if they are considered equal under the asset's policy.
+// This assumes that the caller holds an exclusive lock on the asset directory.
+func updateLatest(asset_dir string, version string, summ *summaryMetadata, replace_on_tie bool) (bool, error) {
+    policy, err := readLatestPolicy(asset_dir)
+    if err != nil {
+        return false, fmt.Errorf("failed to read the latest policy for %q; %w", asset_dir, err)
+    }
+
+    if policy.Order == latestOrderPinned {
+        latest, err := refreshLatest(asset_dir)
+        if err != nil {
+            return false, err
+        }
+        return latest != nil && latest.Version == version, nil
+    }
+
+    latest, err := readLatest(asset_dir)
+    overwrite_latest := false
+    if err == nil {
+        latest_version := filepath.Join(asset_dir, latest.Version)
+        latest_summ, err := readSummary(latest_version)
+        if err != nil {
+            return false, fmt.Errorf("failed to read the latest version summary for %q; %w", latest_version, err)
+        }
+
+        cmp, err := compareVersionsForLatest(policy.Order, version, summ, latest.Version, latest_summ)
+        if err != nil {
+            return false, fmt.Errorf("failed to compare versions in %q; %w", asset_dir, err)
+        }
+        overwrite_latest = cmp > 0 || (cmp == 0 && replace_on_tie)
+    } else if errors.Is(err, os.ErrNotExist) {
+        overwrite_latest = true
+    } else {
+        return false, fmt.Errorf("failed to read the latest version for %s; %w", asset_dir, err)
+    }
+
+    if overwrite_latest {
+        latest_path := filepath.Join(asset_dir, latestFileName)
+        err := dumpJson(latest_path, &latestMetadata{ Version: version })
+        if err != nil {
+            return false, fmt.Errorf("failed to update the latest version at %q; %w", latest_path, err)
+        }
+    }
+
+    return overwrite_latest, nil
+}
+
 func refreshLatestHandler(reqpath string, globals *globalConfiguration, ctx context.Context) (*latestMetadata, error) {
     source_user, err := identifyUser(reqpath)
     if err != nil {
         return nil, fmt.Errorf("failed to find owner of %q; %w", reqpath, err)
     }
 
-    if !isAuthorizedToAdmin(source_user, globals.Administrators) {
-        return nil, newHttpError(http.StatusForbidden, fmt.Errorf("user %q is not authorized to refresh the latest version (%q)", source_user, reqpath))
-    }
-
     incoming := struct {
         Project *string `json:"project"`
         Asset *string `json:"asset"`
@@ -118,6 +287,10 @@ func refreshLatestHandler(reqpath string, globals *globalConfiguration, ctx cont
         }
     }
 
+    if !isAuthorizedToAdminAction(source_user, globals.Administrators, globals.ScopedAdministrators, adminActionRefresh, incoming.Project) {
+        return nil, newHttpError(http.StatusForbidden, fmt.Errorf("user %q is not authorized to refresh the latest version (%q)", source_user, reqpath))
+    }
+
     rlock, err := lockDirectoryShared(globals.Registry, globals, ctx)
     if err != nil {
         return nil, fmt.Errorf("failed to lock the registry %q; %w", globals.Registry, err)
@@ -163,5 +336,193 @@ func refreshLatestHandler(reqpath string, globals *globalConfiguration, ctx cont
     defer alock.Unlock(globals)
 
     output, err := refreshLatest(asset_dir)
-    return output, err
+    if err != nil {
+        return nil, err
+    }
+
+    err = rebuildVersionIndex(asset_dir)
+    if err != nil {
+        return nil, err
+    }
+
+    return output, nil
+}
+
+func setLatestPolicyHandler(reqpath string, globals *globalConfiguration, ctx context.Context) error {
+    incoming := struct {
+        Project *string `json:"project"`
+        Asset *string `json:"asset"`
+        Order *string `json:"order"`
+        Version *string `json:"version"`
+        Spoof *string `json:"spoof"`
+    }{}
+    {
+        handle, err := os.ReadFile(reqpath)
+        if err != nil {
+            return fmt.Errorf("failed to read %q; %w", reqpath, err)
+        }
+
+        err = json.Unmarshal(handle, &incoming)
+        if err != nil {
+            return newHttpError(http.StatusBadRequest, fmt.Errorf("failed to parse JSON from %q; %w", reqpath, err))
+        }
+
+        err = isMissingOrBadName(incoming.Project)
+        if err != nil {
+            return newHttpError(http.StatusBadRequest, fmt.Errorf("invalid 'project' property in %q; %w", reqpath, err))
+        }
+
+        if incoming.Asset != nil {
+            err := isBadName(*(incoming.Asset))
+            if err != nil {
+                return newHttpError(http.StatusBadRequest, fmt.Errorf("invalid 'asset' property in %q; %w", reqpath, err))
+            }
+        }
+
+        if incoming.Order == nil {
+            return newHttpError(http.StatusBadRequest, fmt.Errorf("expected an 'order' property in %q", reqpath))
+        }
+        err = isBadLatestOrder(*(incoming.Order))
+        if err != nil {
+            return newHttpError(http.StatusBadRequest, fmt.Errorf("invalid 'order' property in %q; %w", reqpath, err))
+        }
+
+        if *(incoming.Order) == latestOrderPinned {
+            if incoming.Asset == nil {
+                return newHttpError(http.StatusBadRequest, fmt.Errorf("pinned versions can only be set for an asset in %q", reqpath))
+            }
+            err = isMissingOrBadName(incoming.Version)
+            if err != nil {
+                return newHttpError(http.StatusBadRequest, fmt.Errorf("invalid 'version' property in %q; %w", reqpath, err))
+            }
+        } else if incoming.Version != nil {
+            return newHttpError(http.StatusBadRequest, fmt.Errorf("'version' should only be supplied for pinned versions in %q", reqpath))
+        }
+    }
+
+    username, err := identifySpoofedUser(reqpath, incoming.Spoof, globals.SpoofPermissions)
+    if err != nil {
+        return fmt.Errorf("failed to identify user; %w", err)
+    }
+
+    rlock, err := lockDirectoryShared(globals.Registry, globals, ctx)
+    if err != nil {
+        return fmt.Errorf("failed to lock the registry %q; %w", globals.Registry, err)
+    }
+    defer rlock.Unlock(globals)
+
+    rnnlock, err := lockDirectoryNewDirShared(globals.Registry, globals, ctx)
+    if err != nil {
+        return fmt.Errorf("failed to lock the registry %q; %w", globals.Registry, err)
+    }
+    defer rnnlock.Unlock(globals)
+
+    project := *(incoming.Project)
+    project_dir := filepath.Join(globals.Registry, project)
+    if err := checkProjectExists(project_dir, project); err != nil {
+        return err
+    }
+    rnnlock.Unlock(globals) // no need to hold this lock once we have safely entered the subdirectory.
+
+    policy := latestPolicy{ Order: *(incoming.Order), Version: incoming.Version }
+
+    if incoming.Asset == nil {
+        // Exclusive lock on the project, as we need to refresh the latest version of every asset.
+        plock, err := lockDirectoryExclusive(project_dir, globals, ctx)
+        if err != nil {
+            return fmt.Errorf("failed to lock project directory %q; %w", project_dir, err)
+        }
+        defer plock.Unlock(globals)
+
+        project_perms, err := readPermissions(project_dir)
+        if err != nil {
+            return fmt.Errorf("failed to read permissions for %q; %w", project, err)
+        }
+        if !isAuthorizedToMaintain(username, globals.Administrators, project_perms.Owners) {
+            return newHttpError(http.StatusForbidden, fmt.Errorf("user %q is not authorized to set the latest policy for %q", username, project))
+        }
+
+        policy_path := filepath.Join(project_dir, latestPolicyFileName)
+        err = dumpJson(policy_path, &policy)
+        if err != nil {
+            return fmt.Errorf("failed to save the latest policy at %q; %w", policy_path, err)
+        }
+
+        assets, err := listUserDirectories(project_dir)
+        if err != nil {
+            return fmt.Errorf("failed to list assets in %q; %w", project_dir, err)
+        }
+        for _, asset := range assets {
+            _, err := refreshLatest(filepath.Join(project_dir, asset))
+            if err != nil {
+                return err
+            }
+        }
+
+        return nil
+    }
+
+    plock, err := lockDirectoryShared(project_dir, globals, ctx)
+    if err != nil {
+        return fmt.Errorf("failed to lock project directory %q; %w", project_dir, err)
+    }
+    defer plock.Unlock(globals)
+
+    pnnlock, err := lockDirectoryNewDirShared(project_dir, globals, ctx)
+    if err != nil {
+        return fmt.Errorf("failed to lock project directory %q; %w", project_dir, err)
+    }
+    defer pnnlock.Unlock(globals)
+
+    asset := *(incoming.Asset)
+    asset_dir := filepath.Join(project_dir, asset)
+    if err := checkAssetExists(asset_dir, asset, project); err != nil {
+        return err
+    }
+    pnnlock.Unlock(globals) // no need to hold this lock once we have safely entered the subdirectory.
+
+    alock, err := lockDirectoryExclusive(asset_dir, globals, ctx)
+    if err != nil {
+        return fmt.Errorf("failed to lock asset directory %q; %w", asset_dir, err)
+    }
+    defer alock.Unlock(globals)
+
+    existing_perms, err := readPermissions(project_dir)
+    if err != nil {
+        return fmt.Errorf("failed to read permissions for %q; %w", project_dir, err)
+    }
+    asset_perms, err := addAssetPermissionsForUpload(existing_perms, asset_dir, asset)
+    if err != nil {
+        return fmt.Errorf("failed to read permissions for asset %q in %q; %w", asset, project, err)
+    }
+    if !isAuthorizedToMaintain(username, globals.Administrators, asset_perms.Owners) {
+        return newHttpError(http.StatusForbidden, fmt.Errorf("user %q is not authorized to set the latest policy for %q", username, asset_dir))
+    }
+
+    if policy.Order == latestOrderPinned {
+        version := *(policy.Version)
+        version_dir := filepath.Join(asset_dir, version)
+        if err := checkVersionExists(version_dir, version, asset, project); err != nil {
+            return err
+        }
+        summ, err := readSummary(version_dir)
+        if err != nil {
+            return fmt.Errorf("failed to read the version summary at %q; %w", version_dir, err)
+        }
+        if summ.IsProbational() {
+            return newHttpError(http.StatusBadRequest, fmt.Errorf("cannot pin probational version %q", version_dir))
+        }
+        if summ.IsDeprecated() {
+            return newHttpError(http.StatusBadRequest, fmt.Errorf("cannot pin deprecated version %q", version_dir))
+        }
+    }
+
+    policy_path := filepath.Join(asset_dir, latestPolicyFileName)
+    err = dumpJson(policy_path, &policy)
+    if err != nil {
+        return fmt.Errorf("failed to save the latest policy at %q; %w", policy_path, err)
+    }
+
+    _, err = refreshLatest(asset_dir)
+    return err
 }
@@ -176,3 +176,271 @@ func TestRefreshLatestHandler(t *testing.T) {
         }
     }
 }
+
+func mockVersionsForLatestTest(asset_dir string, versions []string) error {
+    err := os.MkdirAll(asset_dir, 0755)
+    if err != nil {
+        return fmt.Errorf("failed to create asset directory; %w", err)
+    }
+
+    // Versions are uploaded in the specified order.
+    currently := time.Now()
+    for i, v := range versions {
+        version_dir := filepath.Join(asset_dir, v)
+        err := os.Mkdir(version_dir, 0755)
+        if err != nil {
+            return fmt.Errorf("failed to create version directory; %w", err)
+        }
+
+        summ := summaryMetadata {
+            UploadUserId: "aaron",
+            UploadStart: currently.Format(time.RFC3339),
+            UploadFinish: currently.Add(time.Duration(i) * time.Minute).Format(time.RFC3339),
+        }
+        err = dumpJson(filepath.Join(version_dir, summaryFileName), &summ)
+        if err != nil {
+            return fmt.Errorf("failed to write the version summary; %w", err)
+        }
+    }
+
+    return nil
+}
+
+func TestRefreshLatestPolicy(t *testing.T) {
+    reg, err := os.MkdirTemp("", "")
+    if err != nil {
+        t.Fatalf("failed to create the registry; %v", err)
+    }
+
+    asset_dir := filepath.Join(reg, "foobar", "stuff")
+    err = mockVersionsForLatestTest(asset_dir, []string{ "v1.10.0", "v1.9.0", "v2.0.0-rc.1", "backfill" })
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    check := func(policy *latestPolicy, expected string) {
+        if policy != nil {
+            err := dumpJson(filepath.Join(asset_dir, latestPolicyFileName), policy)
+            if err != nil {
+                t.Fatalf("failed to write the latest policy; %v", err)
+            }
+        }
+        res, err := refreshLatest(asset_dir)
+        if err != nil {
+            t.Fatalf("failed to perform the refresh; %v", err)
+        }
+        if res == nil || res.Version != expected {
+            t.Fatalf("unexpected latest version %v (expected %q)", res, expected)
+        }
+    }
+
+    check(nil, "backfill")
+    check(&latestPolicy{ Order: latestOrderUploadTime }, "backfill")
+    check(&latestPolicy{ Order: latestOrderSemver }, "v2.0.0-rc.1")
+    check(&latestPolicy{ Order: latestOrderNatural }, "v2.0.0-rc.1")
+
+    pinned := "v1.9.0"
+    check(&latestPolicy{ Order: latestOrderPinned, Version: &pinned }, "v1.9.0")
+    missing := "v3.0.0"
+    check(&latestPolicy{ Order: latestOrderPinned, Version: &missing }, "backfill")
+
+    // Project-level policy is used if there is no asset-level policy.
+    err = os.Remove(filepath.Join(asset_dir, latestPolicyFileName))
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = dumpJson(filepath.Join(reg, "foobar", latestPolicyFileName), &latestPolicy{ Order: latestOrderNatural })
+    if err != nil {
+        t.Fatal(err)
+    }
+    check(nil, "v2.0.0-rc.1")
+
+    err = os.RemoveAll(filepath.Join(asset_dir, "v2.0.0-rc.1"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    check(nil, "v1.10.0")
+}
+
+func TestUpdateLatest(t *testing.T) {
+    reg, err := os.MkdirTemp("", "")
+    if err != nil {
+        t.Fatalf("failed to create the registry; %v", err)
+    }
+
+    asset_dir := filepath.Join(reg, "foobar", "stuff")
+    err = mockVersionsForLatestTest(asset_dir, []string{ "v2", "v1" })
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    // Default policy uses the upload time.
+    summ, err := readSummary(filepath.Join(asset_dir, "v2"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    is_latest, err := updateLatest(asset_dir, "v2", summ, false)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !is_latest {
+        t.Fatal("expected first version to become the latest")
+    }
+
+    summ, err = readSummary(filepath.Join(asset_dir, "v1"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    is_latest, err = updateLatest(asset_dir, "v1", summ, false)
+    if err != nil {
+        t.Fatal(err)
+    }
+    latest, err := readLatest(asset_dir)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !is_latest || latest.Version != "v1" {
+        t.Fatal("expected more recent upload to become the latest")
+    }
+
+    // Backfilling an old version under natural ordering.
+    err = dumpJson(filepath.Join(asset_dir, latestPolicyFileName), &latestPolicy{ Order: latestOrderNatural })
+    if err != nil {
+        t.Fatal(err)
+    }
+    _, err = refreshLatest(asset_dir)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    err = mockVersionsForLatestTest(asset_dir, []string{ "v0" })
+    if err != nil {
+        t.Fatal(err)
+    }
+    summ, err = readSummary(filepath.Join(asset_dir, "v0"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    is_latest, err = updateLatest(asset_dir, "v0", summ, true)
+    if err != nil {
+        t.Fatal(err)
+    }
+    latest, err = readLatest(asset_dir)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if is_latest || latest.Version != "v2" {
+        t.Fatal("backfilled version should not become the latest")
+    }
+}
+
+func TestSetLatestPolicyHandler(t *testing.T) {
+    reg, err := constructMockRegistry()
+    if err != nil {
+        t.Fatalf("failed to create the registry; %v", err)
+    }
+
+    project := "foobar"
+    project_dir := filepath.Join(reg, project)
+    asset_dir := filepath.Join(project_dir, "stuff")
+    err = mockVersionsForLatestTest(asset_dir, []string{ "1.0.0", "0.9.0" })
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = os.WriteFile(filepath.Join(project_dir, permissionsFileName), []byte(`{ "owners": [], "uploaders": [] }`), 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    ctx := context.Background()
+    globals := newGlobalConfiguration(reg, 2)
+
+    reqpath, err := dumpRequest("set_latest_policy", `{ "project": "foobar", "order": "semver" }`)
+    if err != nil {
+        t.Fatalf("failed to write the request; %v", err)
+    }
+    err = setLatestPolicyHandler(reqpath, &globals, ctx)
+    if err == nil || !strings.Contains(err.Error(), "not authorized") {
+        t.Fatalf("expected an authorization failure; %v", err)
+    }
+
+    self, err := identifyUser(reg)
+    if err != nil {
+        t.Fatal(err)
+    }
+    globals.Administrators = append(globals.Administrators, self)
+
+    // Project-level policy refreshes all assets.
+    err = setLatestPolicyHandler(reqpath, &globals, ctx)
+    if err != nil {
+        t.Fatalf("failed to set the latest policy; %v", err)
+    }
+    latest, err := readLatest(asset_dir)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if latest.Version != "1.0.0" {
+        t.Fatalf("unexpected latest version %q", latest.Version)
+    }
+
+    // Asset-level policy takes precedence.
+    reqpath, err = dumpRequest("set_latest_policy", `{ "project": "foobar", "asset": "stuff", "order": "upload_time" }`)
+    if err != nil {
+        t.Fatalf("failed to write the request; %v", err)
+    }
+    err = setLatestPolicyHandler(reqpath, &globals, ctx)
+    if err != nil {
+        t.Fatalf("failed to set the latest policy; %v", err)
+    }
+    latest, err = readLatest(asset_dir)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if latest.Version != "0.9.0" {
+        t.Fatalf("unexpected latest version %q", latest.Version)
+    }
+
+    // Pinning a version.
+    reqpath, err = dumpRequest("set_latest_policy", `{ "project": "foobar", "asset": "stuff", "order": "pinned", "version": "1.0.0" }`)
+    if err != nil {
+        t.Fatalf("failed to write the request; %v", err)
+    }
+    err = setLatestPolicyHandler(reqpath, &globals, ctx)
+    if err != nil {
+        t.Fatalf("failed to set the latest policy; %v", err)
+    }
+    latest, err = readLatest(asset_dir)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if latest.Version != "1.0.0" {
+        t.Fatalf("unexpected latest version %q", latest.Version)
+    }
+
+    // Various failures.
+    for _, body := range []string{
+        `{ "project": "foobar", "order": "pinned", "version": "1.0.0" }`,
+        `{ "project": "foobar", "asset": "stuff", "order": "pinned" }`,
+        `{ "project": "foobar", "asset": "stuff", "order": "semver", "version": "1.0.0" }`,
+        `{ "project": "foobar", "asset": "stuff", "order": "whee" }`,
+        `{ "project": "foobar", "asset": "stuff" }`,
+    } {
+        reqpath, err := dumpRequest("set_latest_policy", body)
+        if err != nil {
+            t.Fatalf("failed to write the request; %v", err)
+        }
+        err = setLatestPolicyHandler(reqpath, &globals, ctx)
+        if err == nil {
+            t.Fatalf("expected a failure for %s", body)
+        }
+    }
+
+    reqpath, err = dumpRequest("set_latest_policy", `{ "project": "foobar", "asset": "stuff", "order": "pinned", "version": "2.0.0" }`)
+    if err != nil {
+        t.Fatalf("failed to write the request; %v", err)
+    }
+    err = setLatestPolicyHandler(reqpath, &globals, ctx)
+    if err == nil || !strings.Contains(err.Error(), "does not exist") {
+        t.Fatalf("expected a failure for a non-existent pinned version; %v", err)
+    }
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+    "fmt"
+    "os"
+    "os/user"
+)
+
+// Methods for getting files from the staging directory into the registry.
+// Each of the non-default methods falls back to a copy if it is not supported for a particular file.
+const (
+    linkModeCopy = "copy"
+    linkModeHardlink = "hardlink"
+    linkModeReflink = "reflink"
+)
+
+func isBadLinkMode(mode string) error {
+    if mode != linkModeCopy && mode != linkModeHardlink && mode != linkModeReflink {
+        return fmt.Errorf("expected one of %q, %q or %q", linkModeCopy, linkModeHardlink, linkModeReflink)
+    }
+    return nil
+}
+
+func hardlinkFile(src, dest string) error {
+    // A hard link shares the same inode, so anyone who can write to the source can modify the registry's copy.
+    // We only allow hard links for files that are owned by the Gobbler, after removing everyone else's write permissions.
+    self, err := user.Current()
+    if err != nil {
+        return fmt.Errorf("failed to identify current user; %w", err)
+    }
+    owner, err := identifyUser(src)
+    if err != nil {
+        return fmt.Errorf("failed to identify owner of %q; %w", src, err)
+    }
+    if owner != self.Username {
+        return fmt.Errorf("cannot create a hard link to %q that is not owned by the current user", src)
+    }
+
+    err = os.Chmod(src, 0644)
+    if err != nil {
+        return fmt.Errorf("failed to set permissions on %q; %w", src, err)
+    }
+    return os.Link(src, dest)
+}
+
+// The boolean indicates whether the file was transferred with the requested 'mode', i.e., it is false if a hard link or reflink fell back to a copy.
+func transferFile(src, dest, mode string) (bool, error) {
+    if mode == linkModeHardlink {
+        if err := hardlinkFile(src, dest); err == nil {
+            return true, nil
+        }
+    } else if mode == linkModeReflink {
+        if err := reflinkFile(src, dest); err == nil {
+            return true, nil
+        }
+    } else {
+        return true, copyFile(src, dest)
+    }
+    return false, copyFile(src, dest)
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+    "testing"
+    "os"
+    "path/filepath"
+    "strings"
+)
+
+func TestIsBadLinkMode(t *testing.T) {
+    for _, mode := range []string{ linkModeCopy, linkModeHardlink, linkModeReflink } {
+        if isBadLinkMode(mode) != nil {
+            t.Fatalf("expected %q to be a valid link mode", mode)
+        }
+    }
+    err := isBadLinkMode("symlink")
+    if err == nil || !strings.Contains(err.Error(), "expected one of") {
+        t.Fatal("expected an error for an invalid link mode")
+    }
+}
+
+func TestTransferFile(t *testing.T) {
+    dir, err := os.MkdirTemp("", "")
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    src := filepath.Join(dir, "src")
+    err = os.WriteFile(src, []byte("bulbasaur"), 0666)
+    if err != nil {
+        t.Fatal(err)
+    }
+    src_info, err := os.Stat(src)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    t.Run("copy", func(t *testing.T) {
+        dest := filepath.Join(dir, "copy")
+        used_mode, err := transferFile(src, dest, linkModeCopy)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if !used_mode {
+            t.Fatal("expected the copy to be reported as using the requested mode")
+        }
+        err = verifyFileContents(dest, "bulbasaur")
+        if err != nil {
+            t.Fatal(err)
+        }
+        dest_info, err := os.Stat(dest)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if os.SameFile(src_info, dest_info) {
+            t.Fatal("expected a copy to be a different file")
+        }
+    })
+
+    t.Run("hardlink", func(t *testing.T) {
+        dest := filepath.Join(dir, "hardlink")
+        used_mode, err := transferFile(src, dest, linkModeHardlink)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if !used_mode {
+            t.Fatal("expected a hard link to be created")
+        }
+        dest_info, err := os.Stat(dest)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if !os.SameFile(src_info, dest_info) {
+            t.Fatal("expected a hard link to the same file")
+        }
+        if dest_info.Mode().Perm() != 0644 {
+            t.Fatal("expected write permissions to be removed for other users")
+        }
+    })
+
+    t.Run("hardlink fallback", func(t *testing.T) {
+        other, err := os.MkdirTemp("", "")
+        if err != nil {
+            t.Fatal(err)
+        }
+
+        // Existing destination files cause the hard link to fail, so we should fall back to a copy.
+        dest := filepath.Join(other, "hardlink")
+        err = os.WriteFile(dest, []byte("ivysaur"), 0644)
+        if err != nil {
+            t.Fatal(err)
+        }
+        used_mode, err := transferFile(src, dest, linkModeHardlink)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if used_mode {
+            t.Fatal("expected the fallback to a copy to be reported")
+        }
+        err = verifyFileContents(dest, "bulbasaur")
+        if err != nil {
+            t.Fatal(err)
+        }
+    })
+
+    t.Run("hardlink not owned", func(t *testing.T) {
+        // Changing the owner of the source requires root, so this is skipped for regular users.
+        if os.Getuid() != 0 {
+            t.Skip("requires root to change file ownership")
+        }
+
+        other_src := filepath.Join(dir, "other_src")
+        err := os.WriteFile(other_src, []byte("charmander"), 0644)
+        if err != nil {
+            t.Fatal(err)
+        }
+        err = os.Chown(other_src, 65534, 65534)
+        if err != nil {
+            t.Fatal(err)
+        }
+
+        dest := filepath.Join(dir, "hardlink_not_owned")
+        used_mode, err := transferFile(other_src, dest, linkModeHardlink)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if used_mode {
+            t.Fatal("expected the fallback to a copy to be reported")
+        }
+        err = verifyFileContents(dest, "charmander")
+        if err != nil {
+            t.Fatal(err)
+        }
+    })
+
+    t.Run("reflink", func(t *testing.T) {
+        // This may or may not use an actual reflink, depending on the filesystem; either way, the contents should be the same.
+        dest := filepath.Join(dir, "reflink")
+        _, err := transferFile(src, dest, linkModeReflink)
+        if err != nil {
+            t.Fatal(err)
+        }
+        err = verifyFileContents(dest, "bulbasaur")
+        if err != nil {
+            t.Fatal(err)
+        }
+        dest_info, err := os.Stat(dest)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if os.SameFile(src_info, dest_info) {
+            t.Fatal("expected a reflink to be a different file")
+        }
+    })
+}
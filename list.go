@@ -28,6 +28,11 @@ func updateEmptyDirectories(dir string, empty_directories map[string]bool) {
 }
 
 func listFiles(dir string, recursive bool, ctx context.Context) ([]string, error) {
+    return listFilesWithFilter(dir, recursive, nil, ctx)
+}
+
+// 'skip' is called on each subdirectory (relative to 'dir') in a recursive listing, and should return true if the subdirectory is to be omitted.
+func listFilesWithFilter(dir string, recursive bool, skip func(string) bool, ctx context.Context) ([]string, error) {
     to_report := []string{}
     empty_directories := map[string]bool{}
 
@@ -52,6 +57,9 @@ func listFiles(dir string, recursive bool, ctx context.Context) ([]string, error
 
         if info.IsDir() {
             if recursive {
+                if skip != nil && skip(rel) {
+                    return fs.SkipDir
+                }
                 empty_directories[rel] = true
                 return nil
             } else {
@@ -87,11 +95,12 @@ func listFiles(dir string, recursive bool, ctx context.Context) ([]string, error
     return to_report, nil
 }
 
-func listFilesHandler(r *http.Request, registry string) ([]string, error) {
+func listFilesHandler(r *http.Request, registry string, reader *string, administrators []string) ([]string, error) {
     qparams := r.URL.Query()
     path := qparams.Get("path")
     recursive := (qparams.Get("recursive") == "true")
 
+    rel_path := ""
     if path == "" {
         path = registry
     } else {
@@ -102,10 +111,38 @@ func listFilesHandler(r *http.Request, registry string) ([]string, error) {
         } else if !filepath.IsLocal(path) {
             return nil, newHttpError(http.StatusBadRequest, errors.New("'path' is not local to the registry"))
         }
+
+        // Checking access before resolving aliases, so as to not leak the existence of aliases in restricted assets.
+        rel_path = filepath.ToSlash(filepath.Clean(path))
+        err = checkReadAccess(registry, rel_path, reader, administrators)
+        if err != nil {
+            return nil, err
+        }
+
+        path, err = resolveAliasPath(registry, rel_path)
+        if err != nil {
+            return nil, err
+        }
         path = filepath.Join(registry, path)
     }
 
-    all, err := listFiles(path, recursive, r.Context())
+    // Recursive listings may pass through other projects or assets, so we need to check access for each of them.
+    // This only needs to be done for the first two levels of the registry, i.e., the project and asset directories.
+    var skip func(string) bool
+    if recursive && strings.Count(rel_path, "/") == 0 {
+        skip = func(sub string) bool {
+            full := filepath.ToSlash(sub)
+            if rel_path != "" && rel_path != "." {
+                full = rel_path + "/" + full
+            }
+            if strings.Count(full, "/") >= 2 {
+                return false
+            }
+            return checkReadAccess(registry, full, reader, administrators) != nil
+        }
+    }
+
+    all, err := listFilesWithFilter(path, recursive, skip, r.Context())
     return all, err
 }
 
@@ -132,7 +132,7 @@ func TestListFiles(t *testing.T) {
                 t.Fatal(err)
             }
 
-            all, err := listFilesHandler(r, dir)
+            all, err := listFilesHandler(r, dir, nil, nil)
             if (err != nil) {
                 t.Fatal(err)
             }
@@ -148,7 +148,7 @@ func TestListFiles(t *testing.T) {
                 t.Fatal(err)
             }
 
-            all, err := listFilesHandler(r, dir)
+            all, err := listFilesHandler(r, dir, nil, nil)
             if (err != nil) {
                 t.Fatal(err)
             }
@@ -165,7 +165,7 @@ func TestListFiles(t *testing.T) {
                 t.Fatal(err)
             }
 
-            _, err = listFilesHandler(r, dir)
+            _, err = listFilesHandler(r, dir, nil, nil)
             if err == nil || !strings.Contains(err.Error(), "not local") {
                 t.Fatal("expected failure for non-local paths")
             }
@@ -177,7 +177,7 @@ func TestListFiles(t *testing.T) {
                 t.Fatal(err)
             }
 
-            all, err := listFilesHandler(r, dir)
+            all, err := listFilesHandler(r, dir, nil, nil)
             if err != nil {
                 t.Fatal(err)
             }
@@ -167,6 +167,18 @@ func lockDirectoryShared(dir string, globals *globalConfiguration, ctx context.C
     return &directoryLock{ LockFile: lockfile, Active: true }, nil
 }
 
+// Variant of lockDirectoryShared() that gives up immediately under contention.
+// This is intended for opportunistic locks outside of the usual lineage (e.g., on deduplication targets),
+// where waiting could deadlock with another process that holds the lock and is waiting on one of ours.
+func tryLockDirectoryShared(dir string, globals *globalConfiguration, ctx context.Context) (*directoryLock, error) {
+    lockfile := filepath.Join(dir, "..LOCK")
+    err := globals.Locks.Lock(lockfile, ctx, 0, false)
+    if err != nil {
+        return nil, err
+    }
+    return &directoryLock{ LockFile: lockfile, Active: true }, nil
+}
+
 func (dlock *directoryLock) Unlock(globals *globalConfiguration) {
     if dlock.Active {
         globals.Locks.Unlock(dlock.LockFile)
@@ -216,3 +228,17 @@ func lockDirectoryWriteUsage(dir string, globals *globalConfiguration, ctx conte
     }
     return &directoryLock{ LockFile: lockfile, Active: true }, nil
 }
+
+/* The catalogue lock allows the function to read and write the registry-wide catalogue file.
+ * Like the usage lock, no attempt should be made to acquire another lock while holding the catalogue lock, to avoid deadlocks.
+ * Functions holding the catalogue lock will read project and asset files without acquiring their directory locks;
+ * this is safe as all such files are replaced atomically, and any modification will be followed by another refresh of the catalogue.
+ */
+func lockRegistryWriteCatalogue(dir string, globals *globalConfiguration, ctx context.Context) (*directoryLock, error) {
+    lockfile := filepath.Join(dir, "..LOCK_CATALOGUE")
+    err := globals.Locks.Lock(lockfile, ctx, 60 * time.Second, true)
+    if err != nil {
+        return nil, err
+    }
+    return &directoryLock{ LockFile: lockfile, Active: true }, nil
+}
@@ -2,6 +2,7 @@ package main
 
 import (
     "log"
+    "context"
     "flag"
     "path/filepath"
     "time"
@@ -41,18 +42,146 @@ func dumpHttpErrorResponse(w http.ResponseWriter, err error, path string) {
     dumpJsonResponse(w, status_code, map[string]interface{}{ "status": "ERROR", "reason": message }, path)
 }
 
+// This dispatches each request to the appropriate handler based on its type.
+// The returned map contains additional properties to be reported to the user on success.
+func processRequest(reqtype string, reqpath string, globals *globalConfiguration, ctx context.Context) (map[string]interface{}, error) {
+    var reportable_err error
+    payload := map[string]interface{}{}
+
+    if strings.HasPrefix(reqtype, "upload-") {
+        reportable_err = uploadHandler(reqpath, globals, ctx)
+
+    } else if strings.HasPrefix(reqtype, "clone_version-") {
+        reportable_err = cloneVersionHandler(reqpath, globals, ctx)
+    } else if strings.HasPrefix(reqtype, "preflight_upload-") {
+        res, err0 := preflightUploadHandler(reqpath, globals, ctx)
+        if err0 == nil {
+            // Serializing the result directly so that its JSON tags define the response.
+            var contents []byte
+            contents, err0 = json.Marshal(res)
+            if err0 == nil {
+                err0 = json.Unmarshal(contents, &payload)
+            }
+        }
+        reportable_err = err0
+
+    } else if strings.HasPrefix(reqtype, "refresh_latest-") {
+        res, err0 := refreshLatestHandler(reqpath, globals, ctx)
+        if err0 == nil {
+            if res != nil {
+                payload["version"] = res.Version
+            }
+        } else {
+            reportable_err = err0
+        }
+
+    } else if strings.HasPrefix(reqtype, "refresh_usage-") {
+        res, err0 := refreshUsageHandler(reqpath, globals, ctx)
+        if err0 == nil {
+            payload["total"] = res.Total
+        } else {
+            reportable_err = err0
+        }
+
+    } else if strings.HasPrefix(reqtype, "set_quota-") {
+        reportable_err = setQuotaHandler(reqpath, globals, ctx)
+    } else if strings.HasPrefix(reqtype, "set_latest_policy-") {
+        reportable_err = setLatestPolicyHandler(reqpath, globals, ctx)
+    } else if strings.HasPrefix(reqtype, "set_retention-") {
+        reportable_err = setRetentionHandler(reqpath, globals, ctx)
+    } else if strings.HasPrefix(reqtype, "set_version_metadata-") {
+        reportable_err = setVersionMetadataHandler(reqpath, globals, ctx)
+    } else if strings.HasPrefix(reqtype, "set_alias-") {
+        reportable_err = setAliasHandler(reqpath, globals, ctx)
+    } else if strings.HasPrefix(reqtype, "remove_alias-") {
+        reportable_err = removeAliasHandler(reqpath, globals, ctx)
+    } else if strings.HasPrefix(reqtype, "set_permissions-") {
+        reportable_err = setPermissionsHandler(reqpath, globals, ctx)
+    } else if strings.HasPrefix(reqtype, "approve_probation-") {
+        reportable_err = approveProbationHandler(reqpath, globals, ctx)
+    } else if strings.HasPrefix(reqtype, "reject_probation-") {
+        reportable_err = rejectProbationHandler(reqpath, globals, ctx)
+    } else if strings.HasPrefix(reqtype, "amend_probation-") {
+        reportable_err = amendProbationHandler(reqpath, globals, ctx)
+    } else if strings.HasPrefix(reqtype, "deprecate_version-") {
+        reportable_err = deprecateVersionHandler(reqpath, globals, ctx)
+    } else if strings.HasPrefix(reqtype, "undeprecate_version-") {
+        reportable_err = undeprecateVersionHandler(reqpath, globals, ctx)
+    } else if strings.HasPrefix(reqtype, "create_project-") {
+        reportable_err = createProjectHandler(reqpath, globals, ctx)
+    } else if strings.HasPrefix(reqtype, "delete_project-") {
+        reportable_err = deleteProjectHandler(reqpath, globals, ctx)
+    } else if strings.HasPrefix(reqtype, "delete_asset-") {
+        reportable_err = deleteAssetHandler(reqpath, globals, ctx)
+    } else if strings.HasPrefix(reqtype, "delete_version-") {
+        reportable_err = deleteVersionHandler(reqpath, globals, ctx)
+    } else if strings.HasPrefix(reqtype, "rename_project-") {
+        reportable_err = renameProjectHandler(reqpath, globals, ctx)
+    } else if strings.HasPrefix(reqtype, "rename_asset-") {
+        reportable_err = renameAssetHandler(reqpath, globals, ctx)
+
+    } else if strings.HasPrefix(reqtype, "reroute_links-") {
+        res, err0 := rerouteLinksHandler(reqpath, globals, ctx)
+        if err0 == nil {
+            payload["changes"] = res
+        } else {
+            reportable_err = err0
+        }
+
+    } else if strings.HasPrefix(reqtype, "refresh_catalogue-") {
+        reportable_err = refreshCatalogueHandler(reqpath, globals, ctx)
+
+    } else if strings.HasPrefix(reqtype, "reindex_version-") {
+        reportable_err = reindexHandler(reqpath, globals, ctx)
+    } else if strings.HasPrefix(reqtype, "validate_version-") {
+        reportable_err = validateHandler(reqpath, globals, ctx)
+    } else if strings.HasPrefix(reqtype, "create_token-") {
+        res, err0 := createTokenHandler(reqpath, globals, ctx)
+        if err0 == nil {
+            payload["expires"] = res.Expires
+        } else {
+            reportable_err = err0
+        }
+
+    } else if strings.HasPrefix(reqtype, "health_check-") { // TO-BE-DEPRECATED, see /check below.
+        reportable_err = nil
+    } else {
+        reportable_err = newHttpError(http.StatusBadRequest, errors.New("invalid request type"))
+    }
+
+    // Keeping the catalogue in sync with any modifications to the registry.
+    // Failures are not reported to the user as the request itself was successful, and the catalogue can always be rebuilt later.
+    if reportable_err == nil &&
+        !strings.HasPrefix(reqtype, "preflight_upload-") &&
+        !strings.HasPrefix(reqtype, "validate_version-") &&
+        !strings.HasPrefix(reqtype, "health_check-") &&
+        !strings.HasPrefix(reqtype, "create_token-") &&
+        !strings.HasPrefix(reqtype, "refresh_catalogue-") {
+        err := refreshCatalogueForRequest(reqtype, reqpath, globals, ctx)
+        if err != nil {
+            log.Printf("failed to refresh the catalogue after %q; %v", reqpath, err)
+        }
+    }
+
+    return payload, reportable_err
+}
+
 /***************************************************/
 
 func main() {
     spath := flag.String("staging", "", "Path to the staging directory")
     rpath := flag.String("registry", "", "Path to the registry")
     mstr := flag.String("admin", "", "Comma-separated list of administrators (default \"\")")
+    scoped := flag.String("scoped_admin", "", "Path to a file containing administrator grants for specific projects and/or actions (default none)")
     port := flag.Int("port", 8080, "Port to listen to API requests")
     prefix := flag.String("prefix", "", "Prefix to add to each endpoint, excluding the first and last slashes (default \"\")")
     whitelist := flag.String("whitelist", "", "Whitelist of directories in which linked-to files are to be treated as real files (default none)")
     spoof := flag.String("spoof", "", "List of users who are allowed to spoof the identities of other users in certain requests (default none)")
     probation := flag.Int("probation", -1, "Lifespan of probational versions, set to -1 to keep them until rejection")
+    digests := flag.String("digests", "", "Comma-separated list of additional digests to record in the manifest, e.g., sha256 (default none)")
     concurrency := flag.Int("concurrency", 100, "Maximum number of concurrent goroutines, typically for intensive filesystem operations") 
+    max_jobs := flag.Int("jobs", 10, "Maximum number of asynchronous jobs to run at the same time")
+    instance := flag.String("instance", "", "Name of this Gobbler instance to record in version summaries (default hostname)")
     flag.Parse()
 
     if *spath == "" || *rpath == "" {
@@ -64,6 +193,17 @@ func main() {
     globals := newGlobalConfiguration(filepath.Clean(*rpath), *concurrency)
     if *mstr != "" {
         globals.Administrators = strings.Split(*mstr, ",")
+        err := checkGroupEntries(globals.Administrators)
+        if err != nil {
+            log.Fatal("invalid group in the administrators; ", err)
+        }
+    }
+    if *scoped != "" {
+        grants, err := loadScopedAdminGrants(*scoped)
+        if err != nil {
+            log.Fatal(err)
+        }
+        globals.ScopedAdministrators = grants
     }
     if *whitelist != "" {
         whitelist, err := loadLinkWhitelist(*whitelist)
@@ -72,6 +212,13 @@ func main() {
         }
         globals.LinkWhitelist = whitelist
     }
+    if *digests != "" {
+        algorithms, err := parseDigestAlgorithms(*digests)
+        if err != nil {
+            log.Fatal(err)
+        }
+        globals.Digests = algorithms
+    }
     if *spoof != "" {
         sperms, err := loadSpoofPermissions(*spoof)
         if err != nil {
@@ -79,6 +226,9 @@ func main() {
         }
         globals.SpoofPermissions = sperms
     }
+    if *instance != "" {
+        globals.Instance = *instance
+    }
 
     log_dir := filepath.Join(globals.Registry, logDirName)
     _, err := os.Stat(log_dir)
@@ -98,6 +248,10 @@ func main() {
         log.Fatalf("failed to prefill active request registry; %v", err)
     }
 
+    // Asynchronous jobs run on a context owned by the server, so they are not cancelled when the client disconnects.
+    server_ctx := context.Background()
+    jobs := newJobRegistry(*max_jobs, time.Hour * 24)
+
     endpt_prefix := *prefix
     if endpt_prefix != "" {
         endpt_prefix = "/" + endpt_prefix
@@ -114,69 +268,45 @@ func main() {
             return 
         }
 
+        async_mode := false
+        if async_str := r.URL.Query().Get("async"); async_str != "" {
+            async_mode, err = strconv.ParseBool(async_str)
+            if err != nil {
+                dumpHttpErrorResponse(w, newHttpError(http.StatusBadRequest, errors.New("expected a boolean for the 'async' query parameter")), path)
+                return
+            }
+        }
+
+        // Job statuses are available to anyone with the job ID, so we shouldn't risk leaking anything about the token.
+        if async_mode && strings.HasPrefix(path, "request-create_token-") {
+            dumpHttpErrorResponse(w, newHttpError(http.StatusBadRequest, errors.New("token requests cannot be processed asynchronously")), path)
+            return
+        }
+
         if !actreg.Add(path) {
             dumpHttpErrorResponse(w, newHttpError(http.StatusBadRequest, errors.New("path is already being processed")), path)
             return
         }
 
-        var reportable_err error
-        payload := map[string]interface{}{}
         reqtype := strings.TrimPrefix(path, "request-")
 
-        if strings.HasPrefix(reqtype, "upload-") {
-            reportable_err = uploadHandler(reqpath, &globals, r.Context())
-
-        } else if strings.HasPrefix(reqtype, "refresh_latest-") {
-            res, err0 := refreshLatestHandler(reqpath, &globals, r.Context())
-            if err0 == nil {
-                if res != nil {
-                    payload["version"] = res.Version
+        if async_mode {
+            id, err := jobs.Submit(path, func() (map[string]interface{}, error) {
+                payload, reportable_err := processRequest(reqtype, reqpath, &globals, server_ctx)
+                if reportable_err != nil {
+                    log.Printf("failed to process %q; %v\n", path, reportable_err) // same as dumpHttpErrorResponse() for synchronous requests.
                 }
+                return payload, reportable_err
+            })
+            if err != nil {
+                dumpHttpErrorResponse(w, err, path)
             } else {
-                reportable_err = err0
-            }
-
-        } else if strings.HasPrefix(reqtype, "refresh_usage-") {
-            res, err0 := refreshUsageHandler(reqpath, &globals, r.Context())
-            if err0 == nil {
-                payload["total"] = res.Total
-            } else {
-                reportable_err = err0
-            }
-
-        } else if strings.HasPrefix(reqtype, "set_permissions-") {
-            reportable_err = setPermissionsHandler(reqpath, &globals, r.Context())
-        } else if strings.HasPrefix(reqtype, "approve_probation-") {
-            reportable_err = approveProbationHandler(reqpath, &globals, r.Context())
-        } else if strings.HasPrefix(reqtype, "reject_probation-") {
-            reportable_err = rejectProbationHandler(reqpath, &globals, r.Context())
-        } else if strings.HasPrefix(reqtype, "create_project-") {
-            reportable_err = createProjectHandler(reqpath, &globals, r.Context())
-        } else if strings.HasPrefix(reqtype, "delete_project-") {
-            reportable_err = deleteProjectHandler(reqpath, &globals, r.Context())
-        } else if strings.HasPrefix(reqtype, "delete_asset-") {
-            reportable_err = deleteAssetHandler(reqpath, &globals, r.Context())
-        } else if strings.HasPrefix(reqtype, "delete_version-") {
-            reportable_err = deleteVersionHandler(reqpath, &globals, r.Context())
-
-        } else if strings.HasPrefix(reqtype, "reroute_links-") {
-            res, err0 := rerouteLinksHandler(reqpath, &globals, r.Context())
-            if err0 == nil {
-                payload["changes"] = res
-            } else {
-                reportable_err = err0
+                dumpJsonResponse(w, http.StatusAccepted, map[string]interface{}{ "status": "ACCEPTED", "job": id }, path)
             }
-
-        } else if strings.HasPrefix(reqtype, "reindex_version-") {
-            reportable_err = reindexHandler(reqpath, &globals, r.Context())
-        } else if strings.HasPrefix(reqtype, "validate_version-") {
-            reportable_err = validateHandler(reqpath, &globals, r.Context())
-        } else if strings.HasPrefix(reqtype, "health_check-") { // TO-BE-DEPRECATED, see /check below.
-            reportable_err = nil
-        } else {
-            reportable_err = newHttpError(http.StatusBadRequest, errors.New("invalid request type"))
+            return
         }
 
+        payload, reportable_err := processRequest(reqtype, reqpath, &globals, r.Context())
         if reportable_err == nil {
             payload["status"] = "SUCCESS"
             dumpJsonResponse(w, http.StatusOK, &payload, path)
@@ -185,17 +315,38 @@ func main() {
         }
     })
 
+    http.HandleFunc("GET " + endpt_prefix + "/jobs/{id}", func(w http.ResponseWriter, r *http.Request) {
+        id := r.PathValue("id")
+        status, ok := jobs.Get(id)
+        if !ok {
+            dumpHttpErrorResponse(w, newHttpError(http.StatusNotFound, errors.New("job does not exist or has expired")), "job request")
+        } else {
+            dumpJsonResponse(w, http.StatusOK, status, "job request")
+        }
+    })
+
+    http.HandleFunc("GET " + endpt_prefix + "/progress/{path}", func(w http.ResponseWriter, r *http.Request) {
+        path := r.PathValue("path")
+        report := globals.Progress.Get(path)
+        if report == nil {
+            dumpHttpErrorResponse(w, newHttpError(http.StatusNotFound, errors.New("no progress available for this request")), "progress request")
+        } else {
+            dumpJsonResponse(w, http.StatusOK, report, "progress request")
+        }
+    })
+
     // Creating an endpoint to list and serve files, for remote access to the registry.
-    fs := http.FileServer(http.Dir(globals.Registry))
     fetch_endpt := endpt_prefix + "/fetch/"
-    fs_stripped := http.StripPrefix(fetch_endpt, fs)
-    http.HandleFunc("GET " + fetch_endpt, func(w http.ResponseWriter, r *http.Request) {
-        w.Header().Set("Access-Control-Allow-Origin", "*")
-        fs_stripped.ServeHTTP(w, r)
-    })
+    catalogue_endpt := endpt_prefix + "/catalogue"
+    http.HandleFunc("GET " + fetch_endpt, newFetchHandler(&globals, fetch_endpt, catalogue_endpt))
 
     http.HandleFunc("GET " + endpt_prefix + "/list", func(w http.ResponseWriter, r *http.Request) {
-        listing, err := listFilesHandler(r, globals.Registry)
+        reader, err := identifyReader(r, globals.ReadTokens)
+        if err != nil {
+            dumpHttpErrorResponse(w, err, "list request")
+            return
+        }
+        listing, err := listFilesHandler(r, globals.Registry, reader, globals.Administrators)
         if err != nil {
             dumpHttpErrorResponse(w, err, "list request") 
         } else {
@@ -203,6 +354,50 @@ func main() {
         }
     })
 
+    http.HandleFunc("GET " + catalogue_endpt, func(w http.ResponseWriter, r *http.Request) {
+        reader, err := identifyReader(r, globals.ReadTokens)
+        if err != nil {
+            dumpHttpErrorResponse(w, err, "catalogue request")
+            return
+        }
+        catalogue, err := getCatalogueHandler(globals.Registry, reader, globals.Administrators)
+        if err != nil {
+            dumpHttpErrorResponse(w, err, "catalogue request") 
+        } else {
+            dumpJsonResponse(w, http.StatusOK, &catalogue, "catalogue request")
+        }
+    })
+
+    permissions_endpt := endpt_prefix + "/permissions/"
+    http.HandleFunc("GET " + permissions_endpt + "{project}", func(w http.ResponseWriter, r *http.Request) {
+        reader, err := identifyReader(r, globals.ReadTokens)
+        if err != nil {
+            dumpHttpErrorResponse(w, err, "permissions request")
+            return
+        }
+        res, err := getPermissionsHandler(r, r.PathValue("project"), nil, reader, &globals)
+        if err != nil {
+            dumpHttpErrorResponse(w, err, "permissions request")
+        } else {
+            dumpJsonResponse(w, http.StatusOK, res, "permissions request")
+        }
+    })
+
+    http.HandleFunc("GET " + permissions_endpt + "{project}/{asset}", func(w http.ResponseWriter, r *http.Request) {
+        reader, err := identifyReader(r, globals.ReadTokens)
+        if err != nil {
+            dumpHttpErrorResponse(w, err, "permissions request")
+            return
+        }
+        asset := r.PathValue("asset")
+        res, err := getPermissionsHandler(r, r.PathValue("project"), &asset, reader, &globals)
+        if err != nil {
+            dumpHttpErrorResponse(w, err, "permissions request")
+        } else {
+            dumpJsonResponse(w, http.StatusOK, res, "permissions request")
+        }
+    })
+
     // Creating some useful endpoints. 
     http.HandleFunc("GET " + endpt_prefix + "/info", func(w http.ResponseWriter, r *http.Request) {
         dumpJsonResponse(w, http.StatusOK, map[string]string{ "staging": staging, "registry": globals.Registry }, "info request")
@@ -215,10 +410,20 @@ func main() {
     http.HandleFunc("OPTIONS /", func(w http.ResponseWriter, r *http.Request) {
         w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
         w.Header().Set("Access-Control-Allow-Origin", "*")
-        w.Header().Set("Access-Control-Allow-Headers", "*")
+        w.Header().Set("Access-Control-Allow-Headers", "*, Authorization") // wildcards do not cover 'Authorization' in CORS.
         w.WriteHeader(http.StatusNoContent)
     })
 
+    // Adding an hourly job to forget the statuses of old asynchronous jobs.
+    go func() {
+        ticker := time.NewTicker(time.Hour)
+        defer ticker.Stop()
+        for {
+            <-ticker.C
+            jobs.Purge()
+        }
+    }()
+
     // Adding a per-day job to purge old files.
     go func() {
         ticker := time.NewTicker(time.Hour * 24)
@@ -244,6 +449,16 @@ func main() {
                     log.Println(err)
                 }
             }
+
+            for _, err := range enforceRetentionPolicies(&globals) {
+                log.Println(err)
+            }
+
+            // Usage may have changed in any project after the purges, so we rebuild the entire catalogue.
+            err = rebuildCatalogue(&globals, context.Background())
+            if err != nil {
+                log.Println(err)
+            }
         }
     }()
 
@@ -19,6 +19,69 @@ type manifestEntry struct {
     Size int64 `json:"size"`
     Md5sum string `json:"md5sum"`
     Link *linkMetadata `json:"link,omitempty"`
+    Digests map[string]string `json:"-"`
+}
+
+// Additional digests are stored as top-level properties of each manifest entry (e.g., 'sha256'),
+// so we need some custom marshalling to flatten them into the same object as the other properties.
+type rawManifestEntry struct {
+    Size int64 `json:"size"`
+    Md5sum string `json:"md5sum"`
+    Link *linkMetadata `json:"link,omitempty"`
+}
+
+func (m manifestEntry) MarshalJSON() ([]byte, error) {
+    base, err := json.Marshal(rawManifestEntry{ Size: m.Size, Md5sum: m.Md5sum, Link: m.Link })
+    if err != nil {
+        return nil, err
+    }
+    if len(m.Digests) == 0 {
+        return base, nil
+    }
+
+    extra, err := json.Marshal(m.Digests)
+    if err != nil {
+        return nil, err
+    }
+
+    output := append(base[:len(base) - 1], ',')
+    return append(output, extra[1:]...), nil
+}
+
+func (m *manifestEntry) UnmarshalJSON(contents []byte) error {
+    var raw rawManifestEntry
+    err := json.Unmarshal(contents, &raw)
+    if err != nil {
+        return err
+    }
+    m.Size = raw.Size
+    m.Md5sum = raw.Md5sum
+    m.Link = raw.Link
+    m.Digests = nil
+
+    var others map[string]json.RawMessage
+    err = json.Unmarshal(contents, &others)
+    if err != nil {
+        return err
+    }
+
+    for algo, _ := range supportedDigests {
+        val, ok := others[algo]
+        if !ok {
+            continue
+        }
+        var digest string
+        err := json.Unmarshal(val, &digest)
+        if err != nil {
+            return fmt.Errorf("expected a string for %q; %w", algo, err)
+        }
+        if m.Digests == nil {
+            m.Digests = map[string]string{}
+        }
+        m.Digests[algo] = digest
+    }
+
+    return nil
 }
 
 const manifestFileName = "..manifest"
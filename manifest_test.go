@@ -4,6 +4,7 @@ import (
     "testing"
     "os"
     "path/filepath"
+    "strings"
 )
 
 func TestReadManifest(t *testing.T) {
@@ -146,3 +147,38 @@ func TestReadManifest(t *testing.T) {
         t.Fatalf("unexpected link ancestor path for 'blah/boo/akira' in the manifest")
     }
 }
+
+func TestManifestEntryDigests(t *testing.T) {
+    f, err := os.MkdirTemp("", "test-")
+    if err != nil {
+        t.Fatalf("failed to create tempdir; %v", err)
+    }
+
+    original := map[string]manifestEntry{
+        "foobar": manifestEntry{ Size: 10, Md5sum: "abcdefgh", Digests: map[string]string{ "sha256": "12345678" } },
+        "whee": manifestEntry{ Size: 20, Md5sum: "a1b2c3d4" },
+    }
+    err = dumpJson(filepath.Join(f, manifestFileName), &original)
+    if err != nil {
+        t.Fatalf("failed to write the manifest; %v", err)
+    }
+
+    raw, err := os.ReadFile(filepath.Join(f, manifestFileName))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !strings.Contains(string(raw), `"sha256": "12345678"`) {
+        t.Fatalf("expected the digest to be a top-level property of the manifest entry")
+    }
+
+    out, err := readManifest(f)
+    if err != nil {
+        t.Fatalf("failed to read test manifest; %v", err)
+    }
+    if out["foobar"].Size != 10 || out["foobar"].Md5sum != "abcdefgh" || len(out["foobar"].Digests) != 1 || out["foobar"].Digests["sha256"] != "12345678" {
+        t.Fatalf("unexpected digests after reading the manifest")
+    }
+    if out["whee"].Size != 20 || out["whee"].Digests != nil {
+        t.Fatalf("unexpected digests after reading the manifest")
+    }
+}
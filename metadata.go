@@ -0,0 +1,168 @@
+package main
+
+import (
+    "os"
+    "encoding/json"
+    "fmt"
+    "path/filepath"
+    "net/http"
+    "context"
+)
+
+// Free-form user-supplied metadata for each version, e.g., descriptions or pipeline identifiers.
+// Unlike the rest of the version directory, this can be modified after the upload.
+const versionMetadataFileName = "..metadata"
+
+func readVersionMetadata(path string) (map[string]interface{}, error) {
+    metadata_path := filepath.Join(path, versionMetadataFileName)
+
+    metadata_raw, err := os.ReadFile(metadata_path)
+    if err != nil {
+        return nil, fmt.Errorf("failed to read %q; %w", metadata_path, err)
+    }
+
+    var output map[string]interface{}
+    err = json.Unmarshal(metadata_raw, &output)
+    if err != nil {
+        return nil, fmt.Errorf("failed to parse JSON object from %q; %w", metadata_path, err)
+    }
+    if output == nil {
+        return nil, fmt.Errorf("expected a JSON object in %q", metadata_path)
+    }
+
+    return output, nil
+}
+
+func setVersionMetadataHandler(reqpath string, globals *globalConfiguration, ctx context.Context) error {
+    incoming := struct {
+        Project *string `json:"project"`
+        Asset *string `json:"asset"`
+        Version *string `json:"version"`
+        Metadata map[string]interface{} `json:"metadata"`
+        Spoof *string `json:"spoof"`
+    }{}
+    {
+        handle, err := os.ReadFile(reqpath)
+        if err != nil {
+            return fmt.Errorf("failed to read %q; %w", reqpath, err)
+        }
+
+        err = json.Unmarshal(handle, &incoming)
+        if err != nil {
+            return newHttpError(http.StatusBadRequest, fmt.Errorf("failed to parse JSON from %q; %w", reqpath, err))
+        }
+
+        err = isMissingOrBadName(incoming.Project)
+        if err != nil {
+            return newHttpError(http.StatusBadRequest, fmt.Errorf("invalid 'project' property in %q; %w", reqpath, err))
+        }
+
+        err = isMissingOrBadName(incoming.Asset)
+        if err != nil {
+            return newHttpError(http.StatusBadRequest, fmt.Errorf("invalid 'asset' property in %q; %w", reqpath, err))
+        }
+
+        err = isMissingOrBadName(incoming.Version)
+        if err != nil {
+            return newHttpError(http.StatusBadRequest, fmt.Errorf("invalid 'version' property in %q; %w", reqpath, err))
+        }
+
+        if incoming.Metadata == nil {
+            return newHttpError(http.StatusBadRequest, fmt.Errorf("expected a 'metadata' object in %q", reqpath))
+        }
+    }
+
+    username, err := identifySpoofedUser(reqpath, incoming.Spoof, globals.SpoofPermissions)
+    if err != nil {
+        return fmt.Errorf("failed to identify user; %w", err)
+    }
+
+    rlock, err := lockDirectoryShared(globals.Registry, globals, ctx)
+    if err != nil {
+        return fmt.Errorf("failed to lock the registry %q; %w", globals.Registry, err)
+    }
+    defer rlock.Unlock(globals)
+
+    rnnlock, err := lockDirectoryNewDirShared(globals.Registry, globals, ctx)
+    if err != nil {
+        return fmt.Errorf("failed to lock the registry %q; %w", globals.Registry, err)
+    }
+    defer rnnlock.Unlock(globals)
+
+    project := *(incoming.Project)
+    project_dir := filepath.Join(globals.Registry, project)
+    if err := checkProjectExists(project_dir, project); err != nil {
+        return err
+    }
+    rnnlock.Unlock(globals) // no need for this lock once we determine that the project directory exists.
+
+    plock, err := lockDirectoryShared(project_dir, globals, ctx)
+    if err != nil {
+        return fmt.Errorf("failed to lock project directory %q; %w", project_dir, err)
+    }
+    defer plock.Unlock(globals)
+
+    pnnlock, err := lockDirectoryNewDirShared(project_dir, globals, ctx)
+    if err != nil {
+        return fmt.Errorf("failed to lock project directory %q; %w", project_dir, err)
+    }
+    defer pnnlock.Unlock(globals)
+
+    asset := *(incoming.Asset)
+    asset_dir := filepath.Join(project_dir, asset)
+    if err := checkAssetExists(asset_dir, asset, project); err != nil {
+        return err
+    }
+    pnnlock.Unlock(globals) // no need for this lock once we determine that the asset directory exists.
+
+    alock, err := lockDirectoryExclusive(asset_dir, globals, ctx)
+    if err != nil {
+        return fmt.Errorf("failed to lock asset directory %q; %w", asset_dir, err)
+    }
+    defer alock.Unlock(globals)
+
+    // Asset-level owners are also allowed to modify the metadata, same as for the retention policy.
+    existing, err := readPermissions(project_dir)
+    if err != nil {
+        return fmt.Errorf("failed to read permissions for %q; %w", project_dir, err)
+    }
+    asset_perms, err := addAssetPermissionsForUpload(existing, asset_dir, asset)
+    if err != nil {
+        return fmt.Errorf("failed to read permissions for asset %q in %q; %w", asset, project, err)
+    }
+    if !isAuthorizedToMaintain(username, globals.Administrators, asset_perms.Owners) {
+        return newHttpError(http.StatusForbidden, fmt.Errorf("user %q is not authorized to modify version metadata in %q", username, asset_dir))
+    }
+
+    version := *(incoming.Version)
+    version_dir := filepath.Join(asset_dir, version)
+    if err := checkVersionExists(version_dir, version, asset, project); err != nil {
+        return err
+    }
+
+    metadata_path := filepath.Join(version_dir, versionMetadataFileName)
+    err = dumpJson(metadata_path, incoming.Metadata)
+    if err != nil {
+        return fmt.Errorf("failed to save version metadata at %q; %w", metadata_path, err)
+    }
+
+    summ, err := readSummary(version_dir)
+    if err != nil {
+        return fmt.Errorf("failed to read the summary file at %q; %w", version_dir, err)
+    }
+
+    if !summ.IsProbational() {
+        log_info := map[string]interface{} {
+            "type": "set-version-metadata",
+            "project": project,
+            "asset": asset,
+            "version": version,
+        }
+        err = dumpLog(globals.Registry, log_info)
+        if err != nil {
+            return fmt.Errorf("failed to save log file; %w", err)
+        }
+    }
+
+    return nil
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+    "testing"
+    "os"
+    "path/filepath"
+    "fmt"
+    "strings"
+    "context"
+)
+
+func TestReadVersionMetadata(t *testing.T) {
+    dir, err := os.MkdirTemp("", "")
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    _, err = readVersionMetadata(dir)
+    if err == nil || !strings.Contains(err.Error(), "failed to read") {
+        t.Fatal("expected an error for a missing metadata file")
+    }
+
+    err = os.WriteFile(filepath.Join(dir, versionMetadataFileName), []byte(`{ "description": "foo", "run": 123 }`), 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+    metadata, err := readVersionMetadata(dir)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if metadata["description"] != "foo" || metadata["run"] != float64(123) {
+        t.Fatalf("unexpected metadata; %v", metadata)
+    }
+
+    err = os.WriteFile(filepath.Join(dir, versionMetadataFileName), []byte(`null`), 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+    _, err = readVersionMetadata(dir)
+    if err == nil || !strings.Contains(err.Error(), "expected a JSON object") {
+        t.Fatal("expected an error for non-object metadata")
+    }
+}
+
+func TestSetVersionMetadataHandler(t *testing.T) {
+    reg, err := constructMockRegistry()
+    if err != nil {
+        t.Fatalf("failed to create the registry; %v", err)
+    }
+    globals := newGlobalConfiguration(reg, 2)
+    ctx := context.Background()
+
+    project := "dawn"
+    asset := "sinnoh"
+    version := "foo"
+    err = mockProbationVersion(reg, project, asset, version)
+    if err != nil {
+        t.Fatalf("failed to create a mock version; %v", err)
+    }
+    version_dir := filepath.Join(reg, project, asset, version)
+
+    reqpath, err := dumpRequest("set_version_metadata", fmt.Sprintf(`{ "project": "%s", "asset": "%s", "version": "%s", "metadata": { "description": "a new hope" } }`, project, asset, version))
+    if err != nil {
+        t.Fatalf("failed to dump a request type; %v", err)
+    }
+
+    // Lack of authorization fails.
+    err = setVersionMetadataHandler(reqpath, &globals, ctx)
+    if err == nil || !strings.Contains(err.Error(), "not authorized") {
+        t.Fatalf("expected failure for unauthorized user; %v", err)
+    }
+
+    self, err := identifyUser(reg)
+    if err != nil {
+        t.Fatalf("failed to identify self; %v", err)
+    }
+    err = os.WriteFile(filepath.Join(reg, project, permissionsFileName), []byte(fmt.Sprintf(`{ "owners": [ "%s" ], "uploaders": [] }`, self)), 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    err = setVersionMetadataHandler(reqpath, &globals, ctx)
+    if err != nil {
+        t.Fatalf("failed to set version metadata; %v", err)
+    }
+    metadata, err := readVersionMetadata(version_dir)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(metadata) != 1 || metadata["description"] != "a new hope" {
+        t.Fatalf("unexpected metadata; %v", metadata)
+    }
+
+    // Metadata is fully replaced on subsequent requests.
+    reqpath, err = dumpRequest("set_version_metadata", fmt.Sprintf(`{ "project": "%s", "asset": "%s", "version": "%s", "metadata": { "commit": "abcdef" } }`, project, asset, version))
+    if err != nil {
+        t.Fatalf("failed to dump a request type; %v", err)
+    }
+    err = setVersionMetadataHandler(reqpath, &globals, ctx)
+    if err != nil {
+        t.Fatalf("failed to set version metadata; %v", err)
+    }
+    metadata, err = readVersionMetadata(version_dir)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(metadata) != 1 || metadata["commit"] != "abcdef" {
+        t.Fatalf("unexpected metadata; %v", metadata)
+    }
+
+    // Other files are left untouched.
+    man, err := readManifest(version_dir)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(man) != 1 {
+        t.Fatalf("unexpected change to the manifest; %v", man)
+    }
+
+    t.Run("failures", func(t *testing.T) {
+        reqpath, err := dumpRequest("set_version_metadata", fmt.Sprintf(`{ "project": "%s", "asset": "%s", "version": "%s" }`, project, asset, version))
+        if err != nil {
+            t.Fatalf("failed to dump a request type; %v", err)
+        }
+        err = setVersionMetadataHandler(reqpath, &globals, ctx)
+        if err == nil || !strings.Contains(err.Error(), "expected a 'metadata' object") {
+            t.Fatalf("expected failure for missing metadata; %v", err)
+        }
+
+        reqpath, err = dumpRequest("set_version_metadata", fmt.Sprintf(`{ "project": "%s", "asset": "%s", "version": "%s", "metadata": "foo" }`, project, asset, version))
+        if err != nil {
+            t.Fatalf("failed to dump a request type; %v", err)
+        }
+        err = setVersionMetadataHandler(reqpath, &globals, ctx)
+        if err == nil || !strings.Contains(err.Error(), "failed to parse JSON") {
+            t.Fatalf("expected failure for non-object metadata; %v", err)
+        }
+
+        reqpath, err = dumpRequest("set_version_metadata", fmt.Sprintf(`{ "project": "%s", "asset": "%s", "version": "bar", "metadata": {} }`, project, asset))
+        if err != nil {
+            t.Fatalf("failed to dump a request type; %v", err)
+        }
+        err = setVersionMetadataHandler(reqpath, &globals, ctx)
+        if err == nil || !strings.Contains(err.Error(), "does not exist") {
+            t.Fatalf("expected failure for missing version; %v", err)
+        }
+    })
+}
+
+func TestSetVersionMetadataHandlerAssetOwners(t *testing.T) {
+    project := "pokemon"
+    asset := "pikachu"
+    version := "red"
+    reg, err := mockRegistryForDeletion(project, asset, []string{ version })
+    if err != nil {
+        t.Fatalf("failed to create a mock registry; %v", err)
+    }
+    globals := newGlobalConfiguration(reg, 2)
+    ctx := context.Background()
+
+    self, err := identifyUser(reg)
+    if err != nil {
+        t.Fatalf("failed to identify self; %v", err)
+    }
+    err = os.WriteFile(filepath.Join(reg, project, permissionsFileName), []byte(`{ "owners": [], "uploaders": [] }`), 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = os.WriteFile(filepath.Join(reg, project, asset, permissionsFileName), []byte(fmt.Sprintf(`{ "owners": [ "%s" ], "uploaders": [] }`, self)), 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    reqpath, err := dumpRequest("set_version_metadata", fmt.Sprintf(`{ "project": "%s", "asset": "%s", "version": "%s", "metadata": { "description": "electric mouse" } }`, project, asset, version))
+    if err != nil {
+        t.Fatalf("failed to dump a request type; %v", err)
+    }
+    err = setVersionMetadataHandler(reqpath, &globals, ctx)
+    if err != nil {
+        t.Fatalf("asset owners should be able to set version metadata; %v", err)
+    }
+
+    metadata, err := readVersionMetadata(filepath.Join(reg, project, asset, version))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if metadata["description"] != "electric mouse" {
+        t.Fatalf("unexpected metadata; %v", metadata)
+    }
+
+    logs, err := readAllLogs(reg)
+    if err != nil {
+        t.Fatalf("failed to read the logs; %v", err)
+    }
+    if len(logs) != 1 || logs[0].Type != "set-version-metadata" || *(logs[0].Project) != project || *(logs[0].Asset) != asset || *(logs[0].Version) != version {
+        t.Fatalf("unexpected log entries; %v", logs)
+    }
+}
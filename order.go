@@ -0,0 +1,142 @@
+package main
+
+import (
+    "strconv"
+    "strings"
+)
+
+type semanticVersion struct {
+    Core [3]int64
+    Prerelease []string
+}
+
+func parseSemanticVersion(name string) (*semanticVersion, bool) {
+    name = strings.TrimPrefix(name, "v")
+
+    // Build metadata is ignored for the purposes of precedence.
+    if i := strings.Index(name, "+"); i >= 0 {
+        name = name[:i]
+    }
+
+    var prerelease []string
+    if i := strings.Index(name, "-"); i >= 0 {
+        prerelease = strings.Split(name[i+1:], ".")
+        for _, p := range prerelease {
+            if p == "" {
+                return nil, false
+            }
+        }
+        name = name[:i]
+    }
+
+    components := strings.Split(name, ".")
+    if len(components) != 3 {
+        return nil, false
+    }
+
+    output := &semanticVersion{ Prerelease: prerelease }
+    for i, c := range components {
+        if c == "" {
+            return nil, false
+        }
+        val, err := strconv.ParseInt(c, 10, 64)
+        if err != nil || val < 0 {
+            return nil, false
+        }
+        output.Core[i] = val
+    }
+
+    return output, true
+}
+
+func compareInts(a, b int64) int {
+    if a < b {
+        return -1
+    } else if a > b {
+        return 1
+    }
+    return 0
+}
+
+// Follows the precedence rules in https://semver.org/#spec-item-11.
+func compareSemanticVersions(a, b *semanticVersion) int {
+    for i := 0; i < 3; i++ {
+        if cmp := compareInts(a.Core[i], b.Core[i]); cmp != 0 {
+            return cmp
+        }
+    }
+
+    // Pre-release versions have lower precedence than the associated normal version.
+    if len(a.Prerelease) == 0 || len(b.Prerelease) == 0 {
+        return compareInts(int64(len(b.Prerelease)), int64(len(a.Prerelease)))
+    }
+
+    for i := 0; i < len(a.Prerelease) && i < len(b.Prerelease); i++ {
+        x := a.Prerelease[i]
+        y := b.Prerelease[i]
+        xnum, xerr := strconv.ParseInt(x, 10, 64)
+        ynum, yerr := strconv.ParseInt(y, 10, 64)
+        if xerr == nil && yerr == nil {
+            if cmp := compareInts(xnum, ynum); cmp != 0 {
+                return cmp
+            }
+        } else if xerr == nil {
+            return -1 // numeric identifiers have lower precedence than alphanumeric ones.
+        } else if yerr == nil {
+            return 1
+        } else if cmp := strings.Compare(x, y); cmp != 0 {
+            return cmp
+        }
+    }
+
+    return compareInts(int64(len(a.Prerelease)), int64(len(b.Prerelease)))
+}
+
+func isDigit(c byte) bool {
+    return c >= '0' && c <= '9'
+}
+
+// Natural ordering compares runs of digits by their numeric value and everything else lexicographically, e.g., 'v2' < 'v10'.
+func compareNatural(a, b string) int {
+    i := 0
+    j := 0
+    for i < len(a) && j < len(b) {
+        if isDigit(a[i]) && isDigit(b[j]) {
+            istart := i
+            for i < len(a) && isDigit(a[i]) {
+                i++
+            }
+            jstart := j
+            for j < len(b) && isDigit(b[j]) {
+                j++
+            }
+
+            // Comparing without conversion to avoid overflow for long runs of digits.
+            x := strings.TrimLeft(a[istart:i], "0")
+            y := strings.TrimLeft(b[jstart:j], "0")
+            if cmp := compareInts(int64(len(x)), int64(len(y))); cmp != 0 {
+                return cmp
+            }
+            if cmp := strings.Compare(x, y); cmp != 0 {
+                return cmp
+            }
+            continue
+        }
+
+        if a[i] != b[j] {
+            if a[i] < b[j] {
+                return -1
+            }
+            return 1
+        }
+        i++
+        j++
+    }
+
+    if cmp := compareInts(int64(len(a) - i), int64(len(b) - j)); cmp != 0 {
+        return cmp
+    }
+
+    // Breaking ties from leading zeros, e.g., 'v01' vs 'v1'.
+    return strings.Compare(a, b)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+    "testing"
+)
+
+func TestParseSemanticVersion(t *testing.T) {
+    out, ok := parseSemanticVersion("1.2.3")
+    if !ok || out.Core != [3]int64{ 1, 2, 3 } || len(out.Prerelease) != 0 {
+        t.Fatalf("unexpected parsed version; %v", out)
+    }
+
+    out, ok = parseSemanticVersion("v10.0.1-alpha.1+build.5")
+    if !ok || out.Core != [3]int64{ 10, 0, 1 } || len(out.Prerelease) != 2 || out.Prerelease[0] != "alpha" || out.Prerelease[1] != "1" {
+        t.Fatalf("unexpected parsed version; %v", out)
+    }
+
+    for _, bad := range []string{ "1.2", "1.2.3.4", "1..3", "a.b.c", "1.2.3-", "1.2.3-alpha..1", "foo" } {
+        _, ok := parseSemanticVersion(bad)
+        if ok {
+            t.Fatalf("expected %q to be an invalid semantic version", bad)
+        }
+    }
+}
+
+func TestCompareSemanticVersions(t *testing.T) {
+    // Taken from the example in the semantic versioning specification.
+    ordered := []string{ "1.0.0-alpha", "1.0.0-alpha.1", "1.0.0-alpha.beta", "1.0.0-beta", "1.0.0-beta.2", "1.0.0-beta.11", "1.0.0-rc.1", "1.0.0", "1.0.1", "1.2.0", "1.10.0", "2.0.0" }
+    for i := 1; i < len(ordered); i++ {
+        prev, _ := parseSemanticVersion(ordered[i - 1])
+        next, _ := parseSemanticVersion(ordered[i])
+        if compareSemanticVersions(prev, next) >= 0 || compareSemanticVersions(next, prev) <= 0 {
+            t.Fatalf("expected %q to be older than %q", ordered[i - 1], ordered[i])
+        }
+    }
+
+    a, _ := parseSemanticVersion("v1.0.0+foo")
+    b, _ := parseSemanticVersion("1.0.0")
+    if compareSemanticVersions(a, b) != 0 {
+        t.Fatal("expected build metadata to be ignored")
+    }
+}
+
+func TestCompareNatural(t *testing.T) {
+    ordered := []string{ "v1", "v2", "v10", "v10a", "v10b", "v11", "w1" }
+    for i := 1; i < len(ordered); i++ {
+        if compareNatural(ordered[i - 1], ordered[i]) >= 0 || compareNatural(ordered[i], ordered[i - 1]) <= 0 {
+            t.Fatalf("expected %q to be older than %q", ordered[i - 1], ordered[i])
+        }
+    }
+
+    if compareNatural("2024-01-05", "2024-01-05") != 0 {
+        t.Fatal("expected identical strings to compare equal")
+    }
+    if compareNatural("v01", "v1") == 0 {
+        t.Fatal("expected leading zeros to break ties")
+    }
+    if compareNatural("99999999999999999999999", "100000000000000000000000") >= 0 {
+        t.Fatal("expected long digit runs to be compared numerically")
+    }
+}
@@ -12,6 +12,8 @@ import (
     "time"
     "net/http"
     "context"
+    "crypto/sha256"
+    "encoding/hex"
 )
 
 type uploaderEntry struct {
@@ -26,6 +28,7 @@ type permissionsMetadata struct {
     Owners []string `json:"owners"`
     Uploaders []uploaderEntry `json:"uploaders"`
     GlobalWrite *bool `json:"global_write,omitempty"`
+    Readers []string `json:"readers,omitempty"`
 }
 
 const permissionsFileName = "..permissions"
@@ -97,7 +100,7 @@ func addAssetPermissionsForUpload(existing *permissionsMetadata, asset_dir, asse
 func isAuthorizedToAdmin(username string, administrators []string) bool {
     if administrators != nil {
         for _, s := range administrators {
-            if s == username {
+            if matchesUserOrGroup(username, s) {
                 return true
             }
         }
@@ -111,7 +114,7 @@ func isAuthorizedToMaintain(username string, administrators []string, owners []s
     }
     if owners != nil {
         for _, s := range owners {
-            if s == username {
+            if matchesUserOrGroup(username, s) {
                 return true
             }
         }
@@ -126,7 +129,7 @@ func isAuthorizedToUpload(username string, administrators []string, permissions
 
     if permissions.Uploaders != nil {
         for _, u := range permissions.Uploaders {
-            if u.Id != username {
+            if !matchesUserOrGroup(username, u.Id) {
                 continue
             }
 
@@ -139,14 +142,8 @@ func isAuthorizedToUpload(username string, administrators []string, permissions
                 continue
             }
 
-            if u.Until != nil {
-                parsed, err := time.Parse(time.RFC3339, *(u.Until))
-                if err != nil {
-                    continue
-                }
-                if parsed.Before(time.Now()) {
-                    continue
-                }
+            if isUploaderExpired(&u, time.Now()) {
+                continue
             }
 
             return true, (u.Trusted != nil && *(u.Trusted))
@@ -156,6 +153,18 @@ func isAuthorizedToUpload(username string, administrators []string, permissions
     return false, false
 }
 
+// Entries with an invalid 'until' are also treated as expired, as they can never be used to authorize an upload.
+func isUploaderExpired(u *uploaderEntry, now time.Time) bool {
+    if u.Until == nil {
+        return false
+    }
+    parsed, err := time.Parse(time.RFC3339, *(u.Until))
+    if err != nil {
+        return true
+    }
+    return parsed.Before(now)
+}
+
 func sanitizeUploaders(uploaders []unsafeUploaderEntry) ([]uploaderEntry, error) {
     output := make([]uploaderEntry, len(uploaders))
 
@@ -163,6 +172,10 @@ func sanitizeUploaders(uploaders []unsafeUploaderEntry) ([]uploaderEntry, error)
         if u.Id == nil {
             return nil, errors.New("all entries of 'uploaders' should have an 'id' property")
         }
+        err := checkGroupEntry(*(u.Id))
+        if err != nil {
+            return nil, fmt.Errorf("invalid group in 'uploaders.id'; %w", err)
+        }
 
         if u.Until != nil {
             _, err := time.Parse(time.RFC3339, *(u.Until))
@@ -193,6 +206,181 @@ type unsafePermissionsMetadata struct {
     Owners []string `json:"owners"`
     Uploaders []unsafeUploaderEntry `json:"uploaders"`
     GlobalWrite *bool `json:"global_write"`
+    Readers []string `json:"readers"`
+}
+
+// Incremental edits to the permissions, as an alternative to replacing the 'owners' or 'uploaders' wholesale.
+type permissionsEdits struct {
+    AddOwners []string `json:"add_owners"`
+    RemoveOwners []string `json:"remove_owners"`
+    AddUploaders []unsafeUploaderEntry `json:"add_uploaders"`
+    RemoveUploaders []unsafeUploaderEntry `json:"remove_uploaders"`
+}
+
+func (e *permissionsEdits) IsEmpty() bool {
+    return e.AddOwners == nil && e.RemoveOwners == nil && e.AddUploaders == nil && e.RemoveUploaders == nil
+}
+
+// Uploader entries are considered to be the same if they have the same identity and asset/version restrictions.
+func isSameUploader(entry *uploaderEntry, id string, asset, version *string) bool {
+    if entry.Id != id {
+        return false
+    }
+    if (entry.Asset == nil) != (asset == nil) || (asset != nil && *(entry.Asset) != *asset) {
+        return false
+    }
+    if (entry.Version == nil) != (version == nil) || (version != nil && *(entry.Version) != *version) {
+        return false
+    }
+    return true
+}
+
+// This modifies 'perms' in place, first applying any replacements in 'replacement' before applying the incremental 'edits'.
+// For asset-level permissions, the 'asset' of each uploader is ignored and 'global_write' is not used.
+func editPermissions(perms *permissionsMetadata, replacement *unsafePermissionsMetadata, edits *permissionsEdits, asset_level bool) error {
+    if replacement != nil {
+        if replacement.Owners != nil {
+            err := checkGroupEntries(replacement.Owners)
+            if err != nil {
+                return newHttpError(http.StatusBadRequest, fmt.Errorf("invalid 'permissions.owners' in request; %w", err))
+            }
+            perms.Owners = replacement.Owners
+        }
+        if replacement.Uploaders != nil {
+            san, err := sanitizeUploaders(replacement.Uploaders)
+            if err != nil {
+                return newHttpError(http.StatusBadRequest, fmt.Errorf("invalid 'permissions.uploaders' in request; %w", err))
+            }
+            if asset_level {
+                for i, _ := range san {
+                    san[i].Asset = nil
+                }
+            }
+            perms.Uploaders = san
+        }
+        if replacement.GlobalWrite != nil && !asset_level {
+            perms.GlobalWrite = replacement.GlobalWrite
+        }
+        if replacement.Readers != nil {
+            err := sanitizeReaders(replacement.Readers)
+            if err != nil {
+                return newHttpError(http.StatusBadRequest, fmt.Errorf("invalid 'permissions.readers' in request; %w", err))
+            }
+            perms.Readers = replacement.Readers
+        }
+    }
+
+    if edits.RemoveOwners != nil {
+        removed := map[string]bool{}
+        for _, o := range edits.RemoveOwners {
+            removed[o] = true
+        }
+        retained := []string{}
+        for _, o := range perms.Owners {
+            if !removed[o] {
+                retained = append(retained, o)
+            }
+        }
+        perms.Owners = retained
+    }
+
+    if edits.AddOwners != nil {
+        err := checkGroupEntries(edits.AddOwners)
+        if err != nil {
+            return newHttpError(http.StatusBadRequest, fmt.Errorf("invalid 'add_owners' in request; %w", err))
+        }
+        existing := map[string]bool{}
+        for _, o := range perms.Owners {
+            existing[o] = true
+        }
+        for _, o := range edits.AddOwners {
+            if !existing[o] {
+                perms.Owners = append(perms.Owners, o)
+                existing[o] = true
+            }
+        }
+    }
+
+    if edits.RemoveUploaders != nil {
+        for _, r := range edits.RemoveUploaders {
+            if r.Id == nil {
+                return newHttpError(http.StatusBadRequest, errors.New("all entries of 'remove_uploaders' should have an 'id' property"))
+            }
+        }
+        retained := []uploaderEntry{}
+        for _, u := range perms.Uploaders {
+            found := false
+            for _, r := range edits.RemoveUploaders {
+                asset := r.Asset
+                if asset_level {
+                    asset = nil
+                }
+                if isSameUploader(&u, *(r.Id), asset, r.Version) {
+                    found = true
+                    break
+                }
+            }
+            if !found {
+                retained = append(retained, u)
+            }
+        }
+        perms.Uploaders = retained
+    }
+
+    if edits.AddUploaders != nil {
+        san, err := sanitizeUploaders(edits.AddUploaders)
+        if err != nil {
+            return newHttpError(http.StatusBadRequest, fmt.Errorf("invalid 'add_uploaders' in request; %w", err))
+        }
+
+        // Existing entries for the same uploader are replaced, e.g., to update the 'until' or 'trusted' fields.
+        for _, a := range san {
+            if asset_level {
+                a.Asset = nil
+            }
+            replaced := false
+            for i, _ := range perms.Uploaders {
+                if isSameUploader(&(perms.Uploaders[i]), a.Id, a.Asset, a.Version) {
+                    perms.Uploaders[i] = a
+                    replaced = true
+                    break
+                }
+            }
+            if !replaced {
+                perms.Uploaders = append(perms.Uploaders, a)
+            }
+        }
+    }
+
+    return nil
+}
+
+// Returns the hex-encoded SHA-256 hash of the permissions file in 'dir', or an empty string if the file does not exist.
+func hashPermissionsFile(dir string) (string, error) {
+    perm_path := filepath.Join(dir, permissionsFileName)
+    contents, err := os.ReadFile(perm_path)
+    if err != nil {
+        if errors.Is(err, os.ErrNotExist) {
+            return "", nil
+        }
+        return "", fmt.Errorf("failed to read %q; %w", perm_path, err)
+    }
+    hashed := sha256.Sum256(contents)
+    return hex.EncodeToString(hashed[:]), nil
+}
+
+func checkExpectedPermissions(dir string, expected *string) error {
+    if expected == nil {
+        return nil
+    }
+    current, err := hashPermissionsFile(dir)
+    if err != nil {
+        return err
+    }
+    if current != *expected {
+        return newHttpError(http.StatusConflict, fmt.Errorf("permissions in %q have been modified since the 'expected' hash was computed", dir))
+    }
+    return nil
 }
 
 func setPermissionsHandler(reqpath string, globals *globalConfiguration, ctx context.Context) error {
@@ -200,6 +388,8 @@ func setPermissionsHandler(reqpath string, globals *globalConfiguration, ctx con
         Project *string `json:"project"`
         Asset *string `json:"asset"`
         Permissions *unsafePermissionsMetadata `json:"permissions"`
+        permissionsEdits
+        Expected *string `json:"expected"`
         Spoof *string `json:"spoof"`
     }{}
     {
@@ -225,8 +415,8 @@ func setPermissionsHandler(reqpath string, globals *globalConfiguration, ctx con
             }
         }
 
-        if incoming.Permissions == nil {
-            return newHttpError(http.StatusBadRequest, fmt.Errorf("expected a 'permissions' object in %q", reqpath))
+        if incoming.Permissions == nil && incoming.permissionsEdits.IsEmpty() {
+            return newHttpError(http.StatusBadRequest, fmt.Errorf("expected a 'permissions' object or incremental edits in %q", reqpath))
         }
     }
 
@@ -234,6 +424,13 @@ func setPermissionsHandler(reqpath string, globals *globalConfiguration, ctx con
     if err != nil {
         return fmt.Errorf("failed to identify user; %w", err)
     }
+    request_user := source_user
+    if incoming.Spoof != nil {
+        request_user, err = identifyUser(reqpath)
+        if err != nil {
+            return fmt.Errorf("failed to identify user; %w", err)
+        }
+    }
 
     rlock, err := lockDirectoryShared(globals.Registry, globals, ctx)
     if err != nil {
@@ -270,18 +467,16 @@ func setPermissionsHandler(reqpath string, globals *globalConfiguration, ctx con
             return newHttpError(http.StatusForbidden, fmt.Errorf("user %q is not authorized to modify permissions for %q", source_user, project))
         }
 
-        if incoming.Permissions.Owners != nil {
-            project_perms.Owners = incoming.Permissions.Owners
-        }
-        if incoming.Permissions.Uploaders != nil {
-            san, err := sanitizeUploaders(incoming.Permissions.Uploaders)
-            if err != nil {
-                return newHttpError(http.StatusBadRequest, fmt.Errorf("invalid 'permissions.uploaders' in request; %w", err))
-            }
-            project_perms.Uploaders = san
+        err = checkExpectedPermissions(project_dir, incoming.Expected)
+        if err != nil {
+            return err
         }
-        if incoming.Permissions.GlobalWrite != nil {
-            project_perms.GlobalWrite = incoming.Permissions.GlobalWrite
+
+        before := copyPermissions(project_perms)
+
+        err = editPermissions(project_perms, incoming.Permissions, &(incoming.permissionsEdits), false)
+        if err != nil {
+            return err
         }
 
         perm_path := filepath.Join(project_dir, permissionsFileName)
@@ -290,6 +485,11 @@ func setPermissionsHandler(reqpath string, globals *globalConfiguration, ctx con
             return fmt.Errorf("failed to write permissions for %q; %w", project, err)
         }
 
+        err = recordPermissionsChange(globals.Registry, project, nil, permissionsSourceSet, source_user, &request_user, before, project_perms)
+        if err != nil {
+            return err
+        }
+
     } else {
         plock, err := lockDirectoryShared(project_dir, globals, ctx)
         if err != nil {
@@ -313,6 +513,7 @@ func setPermissionsHandler(reqpath string, globals *globalConfiguration, ctx con
         asset_dir := filepath.Join(project_dir, asset)
         asset_perm_path := filepath.Join(asset_dir, permissionsFileName)
         asset_perms := &permissionsMetadata{ Owners: []string{}, Uploaders: []uploaderEntry{} }
+        var before *permissionsMetadata // remains nil if the asset-level permissions do not yet exist.
 
         _, err = os.Stat(asset_dir)
         if err == nil {
@@ -331,6 +532,7 @@ func setPermissionsHandler(reqpath string, globals *globalConfiguration, ctx con
                     return fmt.Errorf("failed to read permissions for asset %q in %q; %w", asset, project, err)
                 }
                 asset_perms = existing
+                before = copyPermissions(existing)
             } else if !errors.Is(err, os.ErrNotExist) {
                 return fmt.Errorf("failed to stat asset permissions in %q; %w", asset_dir, err)
             }
@@ -356,24 +558,25 @@ func setPermissionsHandler(reqpath string, globals *globalConfiguration, ctx con
             return fmt.Errorf("failed to stat asset directory %q; %w", asset_dir, err)
         }
 
-        if incoming.Permissions.Owners != nil {
-            asset_perms.Owners = incoming.Permissions.Owners
+        err = checkExpectedPermissions(asset_dir, incoming.Expected)
+        if err != nil {
+            return err
         }
-        if incoming.Permissions.Uploaders != nil {
-            san, err := sanitizeUploaders(incoming.Permissions.Uploaders)
-            if err != nil {
-                return newHttpError(http.StatusBadRequest, fmt.Errorf("invalid 'permissions.uploaders' in request; %w", err))
-            }
-            for i, _ := range san {
-                san[i].Asset = nil
-            }
-            asset_perms.Uploaders = san
+
+        err = editPermissions(asset_perms, incoming.Permissions, &(incoming.permissionsEdits), true)
+        if err != nil {
+            return err
         }
 
         err = dumpJson(asset_perm_path, asset_perms)
         if err != nil {
             return fmt.Errorf("failed to write asset-level permissions for %q; %w", asset_dir, err)
         }
+
+        err = recordPermissionsChange(globals.Registry, project, &asset, permissionsSourceSet, source_user, &request_user, before, asset_perms)
+        if err != nil {
+            return err
+        }
     }
 
     return nil
@@ -244,6 +244,26 @@ func TestSanitizeUploaders(t *testing.T) {
         t.Fatalf("validation of uploaders failed for correct uploaders with a wildcard; %v", err)
     }
 
+    self, err := user.Current()
+    if err != nil {
+        t.Fatalf("failed to identify the current user; %v", err)
+    }
+    group, err := user.LookupGroupId(self.Gid)
+    if err == nil {
+        id2 = "@" + group.Name
+        san, err = sanitizeUploaders(uploaders)
+        if err != nil || len(san) != 2 || san[1].Id != id2 {
+            t.Fatalf("validation of uploaders failed for correct uploaders with a group; %v", err)
+        }
+    }
+
+    id2 = "@gobbler-nonexistent-group"
+    _, err = sanitizeUploaders(uploaders)
+    if err == nil || !strings.Contains(err.Error(), "does not exist") {
+        t.Fatal("validation of uploaders did not fail for a non-existent group")
+    }
+
+    id2 = "*"
     mock := "YAAY"
     uploaders[1].Until = &mock
     _, err = sanitizeUploaders(uploaders)
@@ -259,7 +279,7 @@ func TestSanitizeUploaders(t *testing.T) {
 }
 
 func TestSetPermissionsHandlerHandler(t *testing.T) {
-    reg, err := os.MkdirTemp("", "")
+    reg, err := constructMockRegistry()
     if err != nil {
         t.Fatalf("failed to create the registry; %v", err)
     }
@@ -352,6 +372,189 @@ func TestSetPermissionsHandlerHandler(t *testing.T) {
         }
     })
 
+    t.Run("readers", func(t *testing.T) {
+        err = os.WriteFile(
+            filepath.Join(project_dir, permissionsFileName),
+            []byte(fmt.Sprintf(`{ "owners": [ "%s" ], "uploaders": [] }`, self)),
+            0644,
+        )
+        if err != nil {
+            t.Fatalf("failed to create some mock permissions; %v", err)
+        }
+
+        reqpath, err := dumpRequest(
+            "set_permissions",
+            fmt.Sprintf(`{ "project": "%s", "permissions": { "readers": [ "misty", "brock" ] } }`, project),
+        )
+        if err != nil {
+            t.Fatalf("failed to dump a request type; %v", err)
+        }
+
+        err = setPermissionsHandler(reqpath, &globals, ctx)
+        if err != nil {
+            t.Fatalf("failed to set permissions; %v", err)
+        }
+
+        perms, err := readPermissions(project_dir)
+        if err != nil {
+            t.Fatalf("failed to read the new permissions; %v", err)
+        }
+        if len(perms.Readers) != 2 || perms.Readers[0] != "misty" || perms.Readers[1] != "brock" || len(perms.Owners) != 1 {
+            t.Fatalf("readers were not modified as expected; %v", perms)
+        }
+
+        reqpath, err = dumpRequest(
+            "set_permissions",
+            fmt.Sprintf(`{ "project": "%s", "permissions": { "readers": [ "" ] } }`, project),
+        )
+        if err != nil {
+            t.Fatalf("failed to dump a request type; %v", err)
+        }
+
+        err = setPermissionsHandler(reqpath, &globals, ctx)
+        if err == nil || !strings.Contains(err.Error(), "invalid 'permissions.readers'") {
+            t.Fatal("expected a permissions failure for invalid readers")
+        }
+    })
+
+    t.Run("incremental edits", func(t *testing.T) {
+        err = os.WriteFile(
+            filepath.Join(project_dir, permissionsFileName),
+            []byte(fmt.Sprintf(`{ "owners": [ "brock", "ash", "%s" ], "uploaders": [ { "id": "lance" }, { "id": "lance", "asset": "dragonite" }, { "id": "karen" } ] }`, self)),
+            0644,
+        )
+        if err != nil {
+            t.Fatalf("failed to create some mock permissions; %v", err)
+        }
+
+        reqpath, err := dumpRequest(
+            "set_permissions",
+            fmt.Sprintf(`{ 
+                "project": "%s",
+                "add_owners": [ "misty", "ash" ],
+                "remove_owners": [ "brock" ],
+                "add_uploaders": [ { "id": "karen", "trusted": true }, { "id": "bruno" } ],
+                "remove_uploaders": [ { "id": "lance", "asset": "dragonite" } ]
+            }`, project),
+        )
+        if err != nil {
+            t.Fatalf("failed to dump a request type; %v", err)
+        }
+
+        err = setPermissionsHandler(reqpath, &globals, ctx)
+        if err != nil {
+            t.Fatalf("failed to set permissions; %v", err)
+        }
+
+        perms, err := readPermissions(project_dir)
+        if err != nil {
+            t.Fatalf("failed to read the new permissions; %v", err)
+        }
+        if len(perms.Owners) != 3 || perms.Owners[0] != "ash" || perms.Owners[1] != self || perms.Owners[2] != "misty" {
+            t.Fatalf("owners were not modified as expected; %v", perms.Owners)
+        }
+        if len(perms.Uploaders) != 3 || 
+            perms.Uploaders[0].Id != "lance" || perms.Uploaders[0].Asset != nil ||
+            perms.Uploaders[1].Id != "karen" || perms.Uploaders[1].Trusted == nil || !*(perms.Uploaders[1].Trusted) ||
+            perms.Uploaders[2].Id != "bruno" {
+            t.Fatalf("uploaders were not modified as expected; %v", perms.Uploaders)
+        }
+
+        reqpath, err = dumpRequest(
+            "set_permissions",
+            fmt.Sprintf(`{ "project": "%s", "remove_uploaders": [ { "asset": "foo" } ] }`, project),
+        )
+        if err != nil {
+            t.Fatalf("failed to dump a request type; %v", err)
+        }
+        err = setPermissionsHandler(reqpath, &globals, ctx)
+        if err == nil || !strings.Contains(err.Error(), "'remove_uploaders'") {
+            t.Fatal("expected a failure for invalid uploaders to remove")
+        }
+
+        reqpath, err = dumpRequest("set_permissions", fmt.Sprintf(`{ "project": "%s" }`, project))
+        if err != nil {
+            t.Fatalf("failed to dump a request type; %v", err)
+        }
+        err = setPermissionsHandler(reqpath, &globals, ctx)
+        if err == nil || !strings.Contains(err.Error(), "incremental edits") {
+            t.Fatal("expected a failure for a request without any modifications")
+        }
+    })
+
+    t.Run("expected hash", func(t *testing.T) {
+        err = os.WriteFile(
+            filepath.Join(project_dir, permissionsFileName),
+            []byte(fmt.Sprintf(`{ "owners": [ "%s" ], "uploaders": [] }`, self)),
+            0644,
+        )
+        if err != nil {
+            t.Fatalf("failed to create some mock permissions; %v", err)
+        }
+
+        current, err := hashPermissionsFile(project_dir)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if len(current) != 64 {
+            t.Fatalf("unexpected hash of the permissions file; %v", current)
+        }
+
+        reqpath, err := dumpRequest(
+            "set_permissions",
+            fmt.Sprintf(`{ "project": "%s", "add_owners": [ "misty" ], "expected": "%s" }`, project, current),
+        )
+        if err != nil {
+            t.Fatalf("failed to dump a request type; %v", err)
+        }
+        err = setPermissionsHandler(reqpath, &globals, ctx)
+        if err != nil {
+            t.Fatalf("failed to set permissions; %v", err)
+        }
+
+        // Re-using the same hash now fails as the file has been modified.
+        reqpath, err = dumpRequest(
+            "set_permissions",
+            fmt.Sprintf(`{ "project": "%s", "add_owners": [ "brock" ], "expected": "%s" }`, project, current),
+        )
+        if err != nil {
+            t.Fatalf("failed to dump a request type; %v", err)
+        }
+        err = setPermissionsHandler(reqpath, &globals, ctx)
+        if err == nil || !strings.Contains(err.Error(), "modified") {
+            t.Fatal("expected a conflict for an outdated hash")
+        }
+
+        perms, err := readPermissions(project_dir)
+        if err != nil {
+            t.Fatalf("failed to read the new permissions; %v", err)
+        }
+        if len(perms.Owners) != 2 || perms.Owners[1] != "misty" {
+            t.Fatalf("owners were not modified as expected; %v", perms.Owners)
+        }
+
+        // An empty hash is expected for a non-existent asset-level permissions file.
+        reqpath, err = dumpRequest(
+            "set_permissions",
+            fmt.Sprintf(`{ "project": "%s", "asset": "EXPECTED", "add_uploaders": [ { "id": "lance", "asset": "foo" } ], "expected": "" }`, project),
+        )
+        if err != nil {
+            t.Fatalf("failed to dump a request type; %v", err)
+        }
+        err = setPermissionsHandler(reqpath, &globals, ctx)
+        if err != nil {
+            t.Fatalf("failed to set permissions; %v", err)
+        }
+
+        aperms, err := readPermissions(filepath.Join(project_dir, "EXPECTED"))
+        if err != nil {
+            t.Fatalf("failed to read the new permissions; %v", err)
+        }
+        if len(aperms.Uploaders) != 1 || aperms.Uploaders[0].Id != "lance" || aperms.Uploaders[0].Asset != nil {
+            t.Fatalf("uploaders were not modified as expected; %v", aperms.Uploaders)
+        }
+    })
+
     t.Run("invalid uploaders", func(t *testing.T) {
         err = os.WriteFile(
             filepath.Join(project_dir, permissionsFileName),
@@ -608,5 +811,15 @@ func TestSetPermissionsHandlerHandler(t *testing.T) {
         if len(perms.Owners) != 1 || perms.Owners[0] != "misty" {
             t.Fatal("expected update to the owners")
         }
+
+        // The spoofing user is recorded in the history.
+        history, err := readPermissionsHistory(project_dir)
+        if err != nil {
+            t.Fatal(err)
+        }
+        last := history[len(history) - 1]
+        if last.User != "sabrina" || last.Spoofer == nil || *(last.Spoofer) != self || last.Source != "set_permissions" {
+            t.Fatalf("unexpected spoofing details in the history; %v", last)
+        }
     })
 }
@@ -6,10 +6,10 @@ import (
     "fmt"
     "path/filepath"
     "time"
-    "errors"
     "net/http"
     "context"
     "sync"
+    "log"
 )
 
 func rejectProbation(project_dir, version_dir string, force_deletion bool, globals *globalConfiguration, ctx context.Context) error {
@@ -31,6 +31,12 @@ func rejectProbation(project_dir, version_dir string, force_deletion bool, globa
         }
     }
 
+    // Errors are not fatal as the version has already been deleted, and the index is rebuilt by refreshing the latest version.
+    err = updateVersionIndex(filepath.Dir(version_dir), filepath.Base(version_dir))
+    if err != nil {
+        log.Printf("failed to update the version index for %q; %v", version_dir, err)
+    }
+
     return nil
 }
 
@@ -148,38 +154,14 @@ func baseProbationHandler(reqpath string, approve bool, globals *globalConfigura
             return fmt.Errorf("failed to update the version summary at %q; %w", summary_path, err)
         }
 
-        latest, err := readLatest(asset_dir)
-        overwrite_latest := false
-        if err == nil {
-            latest_version := filepath.Join(asset_dir, latest.Version)
-            latest_summ, err := readSummary(latest_version)
-            if err != nil {
-                return fmt.Errorf("failed to read the latest version summary for %q; %w", latest_version, err)
-            }
-
-            finish_time, err := time.Parse(time.RFC3339, summ.UploadFinish)
-            if err != nil {
-                return fmt.Errorf("failed to parse the upload finish time at %q; %w", summary_path, err)
-            }
-            latest_time, err := time.Parse(time.RFC3339, latest_summ.UploadFinish)
-            if err != nil {
-                return fmt.Errorf("failed to read the latest version's upload finish time from %q; %w", latest_version, err)
-            }
-            overwrite_latest = finish_time.After(latest_time) 
-        } else if errors.Is(err, os.ErrNotExist) {
-            overwrite_latest = true
-            latest = &latestMetadata{}
-        } else {
-            return fmt.Errorf("failed to read the latest version for %s; %w", asset_dir, err)
+        err = updateVersionIndex(asset_dir, version)
+        if err != nil {
+            return err
         }
 
-        if overwrite_latest {
-            latest_path := filepath.Join(asset_dir, latestFileName)
-            latest.Version = *(incoming.Version)
-            err := dumpJson(latest_path, latest)
-            if err != nil {
-                return fmt.Errorf("failed to update the latest version at %q; %w", latest_path, err)
-            }
+        overwrite_latest, err := updateLatest(asset_dir, version, summ, false)
+        if err != nil {
+            return err
         }
 
         // Adding a log.
@@ -195,6 +177,16 @@ func baseProbationHandler(reqpath string, approve bool, globals *globalConfigura
             return fmt.Errorf("failed to save log file; %w", err)
         }
 
+        // Updating the deduplication index last, once the approval is otherwise complete.
+        manifest, err := readManifest(version_dir)
+        if err != nil {
+            return fmt.Errorf("failed to read the manifest for %q; %w", version_dir, err)
+        }
+        err = addVersionToDeduplicationIndex(globals.Registry, project, asset, version, manifest)
+        if err != nil {
+            return fmt.Errorf("failed to update the deduplication index for %q; %w", version_dir, err)
+        }
+
     } else {
         force_deletion := incoming.Force != nil && *(incoming.Force)
         err = rejectProbation(project_dir, version_dir, force_deletion, globals, ctx)
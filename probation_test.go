@@ -299,6 +299,39 @@ func TestRejectProbationHandler(t *testing.T) {
         }
     })
 
+    t.Run("corrupted index", func(t *testing.T) {
+        project := "iris"
+        asset := "unova"
+        version := "foo"
+        err := mockProbationVersion(reg, project, asset, version)
+        if err != nil {
+            t.Fatalf("failed to create a mock version; %v", err)
+        }
+
+        project_dir := filepath.Join(reg, project)
+        err = os.WriteFile(filepath.Join(project_dir, asset, versionsFileName), []byte("{"), 0644)
+        if err != nil {
+            t.Fatal(err)
+        }
+
+        reqpath, err := dumpRequest("reject_probation", fmt.Sprintf(`{ "project": "%s", "asset": "%s", "version": "%s" }`, project, asset, version))
+        if err != nil {
+            t.Fatalf("failed to dump a request type; %v", err)
+        }
+        err = rejectProbationHandler(reqpath, &globals, ctx)
+        if err != nil {
+            t.Fatalf("failures to update the version index should not be fatal; %v", err)
+        }
+
+        usage, err := readUsage(project_dir)
+        if err != nil {
+            t.Fatalf("failed to read the project usage; %v", err)
+        }
+        if usage.Total != 0 {
+            t.Fatalf("expected the project usage to be zero, not %d", usage.Total)
+        }
+    })
+
     t.Run("forced", func(t *testing.T) {
         project := "serena"
         asset := "kalos"
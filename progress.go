@@ -0,0 +1,213 @@
+package main
+
+import (
+    "sync"
+    "sync/atomic"
+    "time"
+    "log"
+    "strings"
+    "path/filepath"
+)
+
+const (
+    progressPending = "pending"
+    progressExamining = "examining"
+    progressTransferring = "transferring"
+    progressLinking = "linking"
+    progressFinalizing = "finalizing"
+    progressDone = "done"
+    progressFailed = "failed"
+)
+
+type progressReport struct {
+    Request string `json:"request"`
+    Pass string `json:"pass"`
+    FilesDiscovered int64 `json:"files_discovered"`
+    FilesHashed int64 `json:"files_hashed"`
+    BytesCopied int64 `json:"bytes_copied"`
+    LinksResolved int64 `json:"links_resolved"`
+    LinkFallbacks int64 `json:"link_fallbacks"`
+    Error string `json:"error,omitempty"`
+    Updated string `json:"updated"`
+}
+
+// This tracks the progress of a single request.
+// All methods can be safely called on a nil pointer, in which case they do nothing;
+// this allows walkDirectory() and friends to be used without any progress tracking.
+type progressTracker struct {
+    Request string
+    Path string
+    FilesDiscovered atomic.Int64
+    FilesHashed atomic.Int64
+    BytesCopied atomic.Int64
+    LinksResolved atomic.Int64
+    LinkFallbacks atomic.Int64
+
+    Lock sync.Mutex
+    Pass string
+    Error string
+    Stop chan bool
+    Stopped chan bool
+}
+
+func (p *progressTracker) AddDiscovered() {
+    if p != nil {
+        p.FilesDiscovered.Add(1)
+    }
+}
+
+func (p *progressTracker) AddHashed() {
+    if p != nil {
+        p.FilesHashed.Add(1)
+    }
+}
+
+func (p *progressTracker) AddCopied(bytes int64) {
+    if p != nil {
+        p.BytesCopied.Add(bytes)
+    }
+}
+
+func (p *progressTracker) AddResolved() {
+    if p != nil {
+        p.LinksResolved.Add(1)
+    }
+}
+
+func (p *progressTracker) AddLinkFallback() {
+    if p != nil {
+        p.LinkFallbacks.Add(1)
+    }
+}
+
+func (p *progressTracker) SetPass(pass string) {
+    if p != nil {
+        p.Lock.Lock()
+        defer p.Lock.Unlock()
+        p.Pass = pass
+    }
+}
+
+func (p *progressTracker) Report() *progressReport {
+    if p == nil {
+        return nil
+    }
+    p.Lock.Lock()
+    pass := p.Pass
+    failure := p.Error
+    p.Lock.Unlock()
+    return &progressReport{
+        Request: p.Request,
+        Pass: pass,
+        Error: failure,
+        FilesDiscovered: p.FilesDiscovered.Load(),
+        FilesHashed: p.FilesHashed.Load(),
+        BytesCopied: p.BytesCopied.Load(),
+        LinksResolved: p.LinksResolved.Load(),
+        LinkFallbacks: p.LinkFallbacks.Load(),
+        Updated: time.Now().Format(time.RFC3339),
+    }
+}
+
+func (p *progressTracker) save() {
+    err := dumpJson(p.Path, p.Report())
+    if err != nil {
+        log.Printf("failed to save progress for %q; %v", p.Request, err)
+    }
+}
+
+// This tracks the progress of all requests that are currently being processed, keyed by the name of the request file.
+// Progress is also periodically written to a 'progress-<request>' file in the staging directory.
+type progressRegistry struct {
+    Lock sync.Mutex
+    Active map[string]*progressTracker
+    Interval time.Duration
+    Expiry time.Duration
+}
+
+func newProgressRegistry(interval, expiry time.Duration) *progressRegistry {
+    return &progressRegistry{
+        Active: map[string]*progressTracker{},
+        Interval: interval,
+        Expiry: expiry,
+    }
+}
+
+func progressFileName(request string) string {
+    return "progress-" + strings.TrimPrefix(request, "request-")
+}
+
+func (r *progressRegistry) Start(reqpath string) *progressTracker {
+    if r == nil {
+        return nil
+    }
+
+    request := filepath.Base(reqpath)
+    tracker := &progressTracker{
+        Request: request,
+        Path: filepath.Join(filepath.Dir(reqpath), progressFileName(request)),
+        Pass: progressPending,
+        Stop: make(chan bool),
+        Stopped: make(chan bool),
+    }
+
+    r.Lock.Lock()
+    r.Active[request] = tracker
+    r.Lock.Unlock()
+
+    tracker.save()
+    go func() {
+        defer close(tracker.Stopped)
+        ticker := time.NewTicker(r.Interval)
+        defer ticker.Stop()
+        for {
+            select {
+            case <-ticker.C:
+                tracker.save()
+            case <-tracker.Stop:
+                return
+            }
+        }
+    }()
+
+    return tracker
+}
+
+// 'failure' should contain the error that caused the request to fail, or nil if the request was successful.
+func (r *progressRegistry) Finish(tracker *progressTracker, failure error) {
+    if r == nil || tracker == nil {
+        return
+    }
+
+    close(tracker.Stop)
+    <-tracker.Stopped
+    if failure == nil {
+        tracker.SetPass(progressDone)
+    } else {
+        tracker.Lock.Lock()
+        tracker.Pass = progressFailed
+        tracker.Error = failure.Error()
+        tracker.Lock.Unlock()
+    }
+    tracker.save()
+
+    // Holding onto the final report for a while, so that clients can still check it after the request is complete.
+    go func() {
+        time.Sleep(r.Expiry)
+        r.Lock.Lock()
+        defer r.Lock.Unlock()
+        if r.Active[tracker.Request] == tracker {
+            delete(r.Active, tracker.Request)
+        }
+    }()
+}
+
+func (r *progressRegistry) Get(request string) *progressReport {
+    r.Lock.Lock()
+    tracker, ok := r.Active[request]
+    r.Lock.Unlock()
+    if !ok {
+        return nil
+    }
+    return tracker.Report()
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+    "testing"
+    "os"
+    "path/filepath"
+    "context"
+    "encoding/json"
+    "time"
+    "errors"
+    "fmt"
+)
+
+func TestProgressTrackerNil(t *testing.T) {
+    var tracker *progressTracker
+    tracker.AddDiscovered()
+    tracker.AddHashed()
+    tracker.AddCopied(100)
+    tracker.AddResolved()
+    tracker.SetPass(progressExamining)
+    if tracker.Report() != nil {
+        t.Fatal("expected no report from a nil tracker")
+    }
+
+    var preg *progressRegistry
+    if preg.Start("request-foo") != nil {
+        t.Fatal("expected no tracker from a nil registry")
+    }
+}
+
+func TestProgressRegistry(t *testing.T) {
+    staging, err := os.MkdirTemp("", "")
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    preg := newProgressRegistry(time.Millisecond * 10, time.Minute)
+    reqpath := filepath.Join(staging, "request-upload-foo")
+    tracker := preg.Start(reqpath)
+
+    tracker.AddDiscovered()
+    tracker.AddDiscovered()
+    tracker.AddHashed()
+    tracker.AddCopied(10)
+    tracker.AddCopied(20)
+    tracker.AddResolved()
+    tracker.SetPass(progressTransferring)
+
+    report := preg.Get("request-upload-foo")
+    if report == nil || report.Request != "request-upload-foo" || report.Pass != progressTransferring || report.FilesDiscovered != 2 || report.FilesHashed != 1 || report.BytesCopied != 30 || report.LinksResolved != 1 {
+        t.Fatalf("unexpected progress report; %v", report)
+    }
+    if preg.Get("request-upload-bar") != nil {
+        t.Fatal("expected no progress report for an unknown request")
+    }
+
+    // Checking that the progress file is periodically updated.
+    progress_path := filepath.Join(staging, "progress-upload-foo")
+    var saved progressReport
+    for i := 0; i < 100; i++ {
+        contents, err := os.ReadFile(progress_path)
+        if err != nil {
+            t.Fatal(err)
+        }
+        err = json.Unmarshal(contents, &saved)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if saved.Pass == progressTransferring {
+            break
+        }
+        time.Sleep(time.Millisecond * 10)
+    }
+    if saved.Pass != progressTransferring || saved.BytesCopied != 30 {
+        t.Fatalf("unexpected progress file contents; %v", saved)
+    }
+
+    // Final report is still available after finishing.
+    preg.Finish(tracker, nil)
+    report = preg.Get("request-upload-foo")
+    if report == nil || report.Pass != progressDone {
+        t.Fatalf("unexpected progress report; %v", report)
+    }
+
+    contents, err := os.ReadFile(progress_path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = json.Unmarshal(contents, &saved)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if saved.Pass != progressDone || saved.Error != "" {
+        t.Fatalf("unexpected progress file contents; %v", saved)
+    }
+}
+
+func TestProgressRegistryFailed(t *testing.T) {
+    staging, err := os.MkdirTemp("", "")
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    preg := newProgressRegistry(time.Millisecond * 10, time.Minute)
+    reqpath := filepath.Join(staging, "request-upload-foo")
+    tracker := preg.Start(reqpath)
+    tracker.SetPass(progressTransferring)
+
+    preg.Finish(tracker, errors.New("oops"))
+    report := preg.Get("request-upload-foo")
+    if report == nil || report.Pass != progressFailed || report.Error != "oops" {
+        t.Fatalf("unexpected progress report; %v", report)
+    }
+
+    contents, err := os.ReadFile(filepath.Join(staging, "progress-upload-foo"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    var saved progressReport
+    err = json.Unmarshal(contents, &saved)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if saved.Pass != progressFailed || saved.Error != "oops" {
+        t.Fatalf("unexpected progress file contents; %v", saved)
+    }
+}
+
+func TestTransferDirectoryProgress(t *testing.T) {
+    reg, err := os.MkdirTemp("", "")
+    if err != nil {
+        t.Fatalf("failed to create the registry; %v", err)
+    }
+
+    src, err := setupSourceForWalkDirectoryTest()
+    if err != nil {
+        t.Fatalf("failed to set up test directories; %v", err)
+    }
+    err = os.Symlink("../type", filepath.Join(src, "evolution", "same"))
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    ctx := context.Background()
+    conc := newConcurrencyThrottle(2)
+    tracker := &progressTracker{}
+
+    err = transferDirectory(src, reg, "pokemon", "pikachu", "red", ctx, &conc, transferDirectoryOptions{ Progress: tracker })
+    if err != nil {
+        t.Fatalf("failed to perform the transfer; %v", err)
+    }
+
+    report := tracker.Report()
+    if report.FilesDiscovered != 9 || report.FilesHashed != 8 || report.LinksResolved != 1 || report.Pass != progressLinking {
+        t.Fatalf("unexpected progress report; %v", report)
+    }
+    if report.BytesCopied != 29 {
+        t.Fatalf("unexpected number of bytes copied; %v", report.BytesCopied)
+    }
+}
+
+func TestUploadHandlerProgress(t *testing.T) {
+    reg, err := constructMockRegistry()
+    if err != nil {
+        t.Fatalf("failed to create the registry; %v", err)
+    }
+    globals := newGlobalConfiguration(reg, 2)
+
+    err = setupProjectForUploadTest("original_series", &globals)
+    if err != nil {
+        t.Fatalf("failed to set up project directory; %v", err)
+    }
+    src, err := setupSourceForUploadTest()
+    if err != nil {
+        t.Fatalf("failed to set up test directories; %v", err)
+    }
+
+    ctx := context.Background()
+    req_string := fmt.Sprintf(`{ "source": "%s", "project": "original_series", "asset": "gastly", "version": "lavender" }`, filepath.Base(src))
+    reqname, err := dumpRequest("upload", req_string)
+    if err != nil {
+        t.Fatalf("failed to create upload request; %v", err)
+    }
+    err = uploadHandler(reqname, &globals, ctx)
+    if err != nil {
+        t.Fatalf("failed to perform the upload; %v", err)
+    }
+    report := globals.Progress.Get(filepath.Base(reqname))
+    if report == nil || report.Pass != progressDone {
+        t.Fatalf("unexpected progress report for a successful upload; %v", report)
+    }
+
+    // Uploading the same version again should fail.
+    reqname, err = dumpRequest("upload", req_string)
+    if err != nil {
+        t.Fatalf("failed to create upload request; %v", err)
+    }
+    err = uploadHandler(reqname, &globals, ctx)
+    if err == nil {
+        t.Fatal("expected the upload to fail")
+    }
+    report = globals.Progress.Get(filepath.Base(reqname))
+    if report == nil || report.Pass != progressFailed || report.Error != err.Error() {
+        t.Fatalf("unexpected progress report for a failed upload; %v", report)
+    }
+}
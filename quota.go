@@ -0,0 +1,174 @@
+package main
+
+import (
+    "os"
+    "encoding/json"
+    "fmt"
+    "path/filepath"
+    "net/http"
+    "context"
+    "time"
+    "errors"
+)
+
+type quotaMetadata struct {
+    Baseline int64 `json:"baseline"`
+    GrowthRate int64 `json:"growth_rate"`
+    Year int `json:"year"`
+
+    // Quotas are enforced unless this is explicitly set to false, e.g., by an administrator via setQuotaHandler().
+    Enforced *bool `json:"enforced,omitempty"`
+}
+
+const quotaFileName = "..quota"
+
+const defaultQuotaBaseline = 1000000000
+const defaultQuotaGrowthRate = 1000000000
+
+func readQuota(path string) (*quotaMetadata, error) {
+    quota_path := filepath.Join(path, quotaFileName)
+
+    quota_raw, err := os.ReadFile(quota_path)
+    if err != nil {
+        return nil, fmt.Errorf("failed to read '" + quota_path + "'; %w", err)
+    }
+
+    var output quotaMetadata
+    err = json.Unmarshal(quota_raw, &output)
+    if err != nil {
+        return nil, fmt.Errorf("failed to parse JSON in '" + quota_path + "'; %w", err)
+    }
+
+    return &output, nil
+}
+
+// Same as gypsum, the quota grows linearly from the baseline at the start of the specified year.
+func computeQuota(quota *quotaMetadata, now time.Time) int64 {
+    return quota.Baseline + int64(now.Year() - quota.Year) * quota.GrowthRate
+}
+
+// This should be called while holding the usage lock, to ensure that the usage doesn't change between checking and editing.
+// Projects without a quota file (e.g., created manually) or with enforcement explicitly disabled are not subject to any limits.
+func checkQuota(project_dir string, usage *usageMetadata, val int64) error {
+    quota, err := readQuota(project_dir)
+    if err != nil {
+        if errors.Is(err, os.ErrNotExist) {
+            return nil
+        }
+        return fmt.Errorf("failed to read quota for %q; %w", project_dir, err)
+    }
+    if quota.Enforced != nil && !*(quota.Enforced) {
+        return nil
+    }
+
+    limit := computeQuota(quota, time.Now())
+    if usage.Total + val > limit {
+        return newHttpError(
+            http.StatusRequestEntityTooLarge,
+            fmt.Errorf("project usage would exceed the quota (%d bytes used, %d bytes requested, %d bytes allowed)", usage.Total, val, limit),
+        )
+    }
+
+    return nil
+}
+
+func setQuotaHandler(reqpath string, globals *globalConfiguration, ctx context.Context) error {
+    req_user, err := identifyUser(reqpath)
+    if err != nil {
+        return fmt.Errorf("failed to find owner of %q; %w", reqpath, err)
+    }
+
+    incoming := struct {
+        Project *string `json:"project"`
+        Baseline *int64 `json:"baseline"`
+        GrowthRate *int64 `json:"growth_rate"`
+        Year *int `json:"year"`
+        Enforced *bool `json:"enforced"`
+    }{}
+    {
+        handle, err := os.ReadFile(reqpath)
+        if err != nil {
+            return fmt.Errorf("failed to read %q; %w", reqpath, err)
+        }
+
+        err = json.Unmarshal(handle, &incoming)
+        if err != nil {
+            return newHttpError(http.StatusBadRequest, fmt.Errorf("failed to parse JSON from %q; %w", reqpath, err))
+        }
+
+        err = isMissingOrBadName(incoming.Project)
+        if err != nil {
+            return newHttpError(http.StatusBadRequest, fmt.Errorf("invalid 'project' property in %q; %w", reqpath, err))
+        }
+
+        if incoming.Baseline != nil && *(incoming.Baseline) < 0 {
+            return newHttpError(http.StatusBadRequest, fmt.Errorf("'baseline' should be non-negative in %q", reqpath))
+        }
+        if incoming.GrowthRate != nil && *(incoming.GrowthRate) < 0 {
+            return newHttpError(http.StatusBadRequest, fmt.Errorf("'growth_rate' should be non-negative in %q", reqpath))
+        }
+    }
+
+    if !isAuthorizedToAdminAction(req_user, globals.Administrators, globals.ScopedAdministrators, adminActionSetQuota, incoming.Project) {
+        return newHttpError(http.StatusForbidden, fmt.Errorf("user %q is not authorized to set the quota for %q", req_user, *(incoming.Project)))
+    }
+
+    rlock, err := lockDirectoryShared(globals.Registry, globals, ctx)
+    if err != nil {
+        return fmt.Errorf("failed to lock the registry %q; %w", globals.Registry, err)
+    }
+    defer rlock.Unlock(globals)
+
+    rnnlock, err := lockDirectoryNewDirShared(globals.Registry, globals, ctx)
+    if err != nil {
+        return fmt.Errorf("failed to lock the registry %q; %w", globals.Registry, err)
+    }
+    defer rnnlock.Unlock(globals)
+
+    project := *(incoming.Project)
+    project_dir := filepath.Join(globals.Registry, project)
+    if err := checkProjectExists(project_dir, project); err != nil {
+        return err
+    }
+    rnnlock.Unlock(globals) // no need for this lock once we know that the project directory exists.
+
+    plock, err := lockDirectoryExclusive(project_dir, globals, ctx)
+    if err != nil {
+        return fmt.Errorf("failed to lock the project directory %q; %w", project_dir, err)
+    }
+    defer plock.Unlock(globals)
+
+    quota, err := readQuota(project_dir)
+    if err != nil {
+        if !errors.Is(err, os.ErrNotExist) {
+            return fmt.Errorf("failed to read quota for %q; %w", project, err)
+        }
+        quota = &quotaMetadata{ Baseline: defaultQuotaBaseline, GrowthRate: defaultQuotaGrowthRate, Year: time.Now().Year() }
+    }
+
+    if incoming.Baseline != nil {
+        quota.Baseline = *(incoming.Baseline)
+    }
+    if incoming.GrowthRate != nil {
+        quota.GrowthRate = *(incoming.GrowthRate)
+    }
+    if incoming.Year != nil {
+        quota.Year = *(incoming.Year)
+    }
+
+    if incoming.Enforced != nil {
+        if *(incoming.Enforced) {
+            quota.Enforced = nil // enforcement is the default, so we just omit it.
+        } else {
+            quota.Enforced = incoming.Enforced
+        }
+    }
+
+    quota_path := filepath.Join(project_dir, quotaFileName)
+    err = dumpJson(quota_path, quota)
+    if err != nil {
+        return fmt.Errorf("failed to write quota for %q; %w", project, err)
+    }
+
+    return nil
+}
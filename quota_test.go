@@ -0,0 +1,204 @@
+package main
+
+import (
+    "testing"
+    "os"
+    "path/filepath"
+    "strings"
+    "fmt"
+    "os/user"
+    "context"
+    "time"
+    "errors"
+    "net/http"
+)
+
+func TestReadQuota(t *testing.T) {
+    f, err := os.MkdirTemp("", "test-")
+    if err != nil {
+        t.Fatalf("failed to create tempdir; %v", err)
+    }
+
+    err = os.WriteFile(
+        filepath.Join(f, quotaFileName),
+        []byte(`{ "baseline": 100, "growth_rate": 20, "year": 2020 }`),
+        0644,
+    )
+    if err != nil {
+        t.Fatalf("failed to create test ..quota; %v", err)
+    }
+
+    out, err := readQuota(f)
+    if err != nil {
+        t.Fatalf("failed to read test ..quota; %v", err)
+    }
+    if out.Baseline != 100 || out.GrowthRate != 20 || out.Year != 2020 {
+        t.Fatalf("unexpected values in the quota")
+    }
+
+    now, err := time.Parse(time.RFC3339, "2023-06-01T00:00:00Z")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if computeQuota(out, now) != 160 {
+        t.Fatalf("unexpected value for the computed quota")
+    }
+}
+
+func TestCheckQuota(t *testing.T) {
+    f, err := os.MkdirTemp("", "test-")
+    if err != nil {
+        t.Fatalf("failed to create tempdir; %v", err)
+    }
+
+    // No limits if the quota file is absent.
+    err = checkQuota(f, &usageMetadata{ Total: 1000 }, 1000000)
+    if err != nil {
+        t.Fatalf("expected no quota enforcement without a quota file; %v", err)
+    }
+
+    // No limits if enforcement is explicitly disabled.
+    disabled := false
+    err = dumpJson(filepath.Join(f, quotaFileName), &quotaMetadata{ Baseline: 100, GrowthRate: 0, Year: time.Now().Year(), Enforced: &disabled })
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = checkQuota(f, &usageMetadata{ Total: 1000 }, 1000000)
+    if err != nil {
+        t.Fatalf("expected no quota enforcement when disabled; %v", err)
+    }
+
+    err = dumpJson(filepath.Join(f, quotaFileName), &quotaMetadata{ Baseline: 100, GrowthRate: 0, Year: time.Now().Year() })
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    err = checkQuota(f, &usageMetadata{ Total: 50 }, 50)
+    if err != nil {
+        t.Fatalf("expected the usage to be within the quota; %v", err)
+    }
+
+    err = checkQuota(f, &usageMetadata{ Total: 50 }, 51)
+    var http_err *httpError
+    if err == nil || !errors.As(err, &http_err) || http_err.Status != http.StatusRequestEntityTooLarge {
+        t.Fatalf("expected the usage to exceed the quota; %v", err)
+    }
+}
+
+func TestSetQuotaHandler(t *testing.T) {
+    reg, err := constructMockRegistry()
+    if err != nil {
+        t.Fatalf("failed to create the registry; %v", err)
+    }
+    globals := newGlobalConfiguration(reg, 2)
+    ctx := context.Background()
+
+    project := "foobar"
+    err = setupProjectForUploadTest(project, &globals)
+    if err != nil {
+        t.Fatalf("failed to set up project directory; %v", err)
+    }
+    project_dir := filepath.Join(reg, project)
+
+    reqpath, err := dumpRequest("set_quota", fmt.Sprintf(`{ "project": "%s", "baseline": 1234 }`, project))
+    if err != nil {
+        t.Fatalf("failed to write the request; %v", err)
+    }
+
+    err = setQuotaHandler(reqpath, &globals, ctx)
+    if err == nil || !strings.Contains(err.Error(), "not authorized") {
+        t.Fatalf("unexpected authorization for quota request")
+    }
+
+    self, err := user.Current()
+    if err != nil {
+        t.Fatalf("failed to find the current user; %v", err)
+    }
+    globals.Administrators = append(globals.Administrators, self.Username)
+
+    err = setQuotaHandler(reqpath, &globals, ctx)
+    if err != nil {
+        t.Fatalf("failed to set the quota; %v", err)
+    }
+
+    quota, err := readQuota(project_dir)
+    if err != nil {
+        t.Fatalf("failed to read the quota; %v", err)
+    }
+    if quota.Baseline != 1234 || quota.GrowthRate != defaultQuotaGrowthRate || quota.Year != time.Now().Year() || quota.Enforced != nil {
+        t.Fatalf("unexpected quota after modification")
+    }
+
+    // Other fields are respected.
+    reqpath, err = dumpRequest("set_quota", fmt.Sprintf(`{ "project": "%s", "growth_rate": 10, "year": 2019 }`, project))
+    if err != nil {
+        t.Fatalf("failed to write the request; %v", err)
+    }
+    err = setQuotaHandler(reqpath, &globals, ctx)
+    if err != nil {
+        t.Fatalf("failed to set the quota; %v", err)
+    }
+
+    quota, err = readQuota(project_dir)
+    if err != nil {
+        t.Fatalf("failed to read the quota; %v", err)
+    }
+    if quota.Baseline != 1234 || quota.GrowthRate != 10 || quota.Year != 2019 {
+        t.Fatalf("unexpected quota after modification")
+    }
+
+    // Enforcement can be turned off.
+    reqpath, err = dumpRequest("set_quota", fmt.Sprintf(`{ "project": "%s", "enforced": false }`, project))
+    if err != nil {
+        t.Fatalf("failed to write the request; %v", err)
+    }
+    err = setQuotaHandler(reqpath, &globals, ctx)
+    if err != nil {
+        t.Fatalf("failed to set the quota; %v", err)
+    }
+
+    quota, err = readQuota(project_dir)
+    if err != nil {
+        t.Fatalf("failed to read the quota; %v", err)
+    }
+    if quota.Baseline != 1234 || quota.Enforced == nil || *(quota.Enforced) {
+        t.Fatalf("unexpected quota after disabling enforcement")
+    }
+
+    // Enforcement can be turned back on.
+    reqpath, err = dumpRequest("set_quota", fmt.Sprintf(`{ "project": "%s", "enforced": true }`, project))
+    if err != nil {
+        t.Fatalf("failed to write the request; %v", err)
+    }
+    err = setQuotaHandler(reqpath, &globals, ctx)
+    if err != nil {
+        t.Fatalf("failed to set the quota; %v", err)
+    }
+
+    quota, err = readQuota(project_dir)
+    if err != nil {
+        t.Fatalf("failed to read the quota; %v", err)
+    }
+    if quota.Baseline != 1234 || quota.Enforced != nil {
+        t.Fatalf("unexpected quota after enabling enforcement")
+    }
+
+    // Fails for invalid inputs.
+    reqpath, err = dumpRequest("set_quota", fmt.Sprintf(`{ "project": "%s", "baseline": -1 }`, project))
+    if err != nil {
+        t.Fatalf("failed to write the request; %v", err)
+    }
+    err = setQuotaHandler(reqpath, &globals, ctx)
+    if err == nil || !strings.Contains(err.Error(), "non-negative") {
+        t.Fatalf("expected failure for negative baseline")
+    }
+
+    reqpath, err = dumpRequest("set_quota", `{ "project": "missing", "baseline": 1 }`)
+    if err != nil {
+        t.Fatalf("failed to write the request; %v", err)
+    }
+    err = setQuotaHandler(reqpath, &globals, ctx)
+    if err == nil || !strings.Contains(err.Error(), "does not exist") {
+        t.Fatalf("expected failure for missing project")
+    }
+}
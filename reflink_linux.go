@@ -0,0 +1,37 @@
+package main
+
+import (
+    "fmt"
+    "os"
+    "syscall"
+)
+
+// From linux/fs.h, equivalent to _IOW(0x94, 9, int).
+const ficlone = 0x40049409
+
+// This creates a copy-on-write clone of the source file, which only works on supporting filesystems (e.g., Btrfs, XFS).
+func reflinkFile(src, dest string) error {
+    in, err := os.Open(src)
+    if err != nil {
+        return fmt.Errorf("failed to open input file at %q; %w", src, err)
+    }
+    defer in.Close()
+
+    out, err := os.OpenFile(dest, os.O_CREATE | os.O_TRUNC | os.O_WRONLY, 0644)
+    if err != nil {
+        return fmt.Errorf("failed to open output file at %q; %w", dest, err)
+    }
+
+    _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, out.Fd(), ficlone, in.Fd())
+    if errno != 0 {
+        out.Close()
+        os.Remove(dest)
+        return fmt.Errorf("failed to clone %q to %q; %w", src, dest, errno)
+    }
+
+    err = out.Close()
+    if err != nil {
+        return fmt.Errorf("failed to close output file at %q; %w", dest, err)
+    }
+    return nil
+}
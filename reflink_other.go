@@ -0,0 +1,11 @@
+//go:build !linux
+
+package main
+
+import (
+    "errors"
+)
+
+func reflinkFile(src, dest string) error {
+    return errors.New("reflinks are only supported on Linux")
+}
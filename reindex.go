@@ -19,6 +19,8 @@ type reindexRequest struct {
 
 type reindexDirectoryOptions struct {
     LinkWhitelist []string
+    Digests []string
+    Progress *progressTracker
 }
 
 func reindexDirectory(registry, project, asset, version string, ctx context.Context, throttle *concurrencyThrottle, options reindexDirectoryOptions) error {
@@ -40,9 +42,12 @@ func reindexDirectory(registry, project, asset, version string, ctx context.Cont
             Mode: WalkDirectoryReindex,
             Consume: false, // not used during reindexing, just set for completeness only.
             DeduplicateLatest: nil,
+            DeduplicateRegistry: nil,
             RestoreLinkParent: createRestoreLinkParentMap(old_all_links),
             IgnoreDot: false,
             LinkWhitelist: options.LinkWhitelist,
+            Digests: options.Digests,
+            Progress: options.Progress,
         },
     )
     if err != nil {
@@ -124,13 +129,18 @@ func reindexPreflight(reqpath string) (*reindexRequest, error) {
     return &request, nil
 }
 
-func reindexHandler(reqpath string, globals *globalConfiguration, ctx context.Context) error {
+func reindexHandler(reqpath string, globals *globalConfiguration, ctx context.Context) (reportable_err error) {
     request, err := reindexPreflight(reqpath)
     if err != nil {
         return err
     }
+
+    progress := globals.Progress.Start(reqpath)
+    defer func() {
+        globals.Progress.Finish(progress, reportable_err)
+    }()
     project := *(request.Project)
-    ok := isAuthorizedToAdmin(request.User, globals.Administrators)
+    ok := isAuthorizedToAdminAction(request.User, globals.Administrators, globals.ScopedAdministrators, adminActionReindex, request.Project)
     if !ok {
         return newHttpError(http.StatusForbidden, fmt.Errorf("user '" + request.User + "' is not authorized to reindex '" + project + "'"))
     }
@@ -193,11 +203,19 @@ func reindexHandler(reqpath string, globals *globalConfiguration, ctx context.Co
         globals.ConcurrencyThrottle,
         reindexDirectoryOptions{
             LinkWhitelist: globals.LinkWhitelist,
+            Digests: globals.Digests,
+            Progress: progress,
         },
     )
     if err != nil {
         return fmt.Errorf("failed to reindex project; %w", err)
     }
+    progress.SetPass(progressFinalizing)
+
+    err = updateVersionIndex(asset_dir, version)
+    if err != nil {
+        return err
+    }
 
     summ, err := readSummary(version_dir)
     if err != nil {
@@ -0,0 +1,383 @@
+package main
+
+import (
+    "os"
+    "fmt"
+    "errors"
+    "encoding/json"
+    "path/filepath"
+    "net/http"
+    "context"
+    "sync"
+    "sort"
+    "log"
+)
+
+// Describes the renaming of a project, or of an asset within a project if 'OldAsset' is not empty.
+type renameTask struct {
+    OldProject string
+    NewProject string
+    OldAsset string
+    NewAsset string
+    User string
+}
+
+func (r *renameTask) Apply(link *linkMetadata) bool {
+    if link.Project != r.OldProject {
+        return false
+    }
+    if r.OldAsset == "" {
+        link.Project = r.NewProject
+        return true
+    }
+    if link.Asset != r.OldAsset {
+        return false
+    }
+    link.Asset = r.NewAsset
+    return true
+}
+
+// This rewrites the links in a manifest that point to the renamed project or asset.
+// It returns a map containing only the modified entries.
+func proposeRenamedLinks(manifest map[string]manifestEntry, task *renameTask) map[string]manifestEntry {
+    changed := map[string]manifestEntry{}
+    for key, entry := range manifest {
+        if entry.Link == nil {
+            continue
+        }
+
+        // Copying to avoid mutating the input manifest.
+        new_link := *(entry.Link)
+        modified := task.Apply(&new_link)
+        if new_link.Ancestor != nil {
+            new_ancestor := *(new_link.Ancestor)
+            if task.Apply(&new_ancestor) {
+                modified = true
+            }
+            new_link.Ancestor = &new_ancestor
+        }
+
+        if modified {
+            entry.Link = &new_link
+            changed[key] = entry
+        }
+    }
+    return changed
+}
+
+func executeRenamedLinks(registry string, version_dir string, changed map[string]manifestEntry) error {
+    full_version_dir := filepath.Join(registry, version_dir)
+    manifest, err := readManifest(full_version_dir)
+    if err != nil {
+        return fmt.Errorf("failed to read manifest at %q; %w", full_version_dir, err)
+    }
+
+    for key, entry := range changed {
+        err := createSymlink(filepath.Join(full_version_dir, key), registry, entry.Link, /* wipe_existing = */ true)
+        if err != nil {
+            return err
+        }
+        manifest[key] = entry
+    }
+
+    err = dumpJson(filepath.Join(full_version_dir, manifestFileName), &manifest)
+    if err != nil {
+        return err
+    }
+
+    _, err = recreateLinkFiles(full_version_dir, manifest)
+    if err != nil {
+        return fmt.Errorf("failed to create linkfiles at %q; %w", full_version_dir, err)
+    }
+
+    return nil
+}
+
+// Replaces the index entries for the old names of the renamed assets with entries for their new names.
+// This should only be called after the rename, so that the index is not modified if the rename itself fails.
+func renameInDeduplicationIndex(registry string, task *renameTask, new_project string, new_assets []string) error {
+    for _, asset := range new_assets {
+        old_asset := task.OldAsset
+        if old_asset == "" {
+            old_asset = asset
+        }
+
+        asset_dir := filepath.Join(registry, new_project, asset)
+        versions, err := listUserDirectories(asset_dir)
+        if err != nil {
+            return fmt.Errorf("failed to list versions in %q; %w", asset_dir, err)
+        }
+
+        for _, version := range versions {
+            version_dir := filepath.Join(asset_dir, version)
+            manifest, err := readManifest(version_dir)
+            if err != nil {
+                return fmt.Errorf("failed to read the manifest for %q; %w", version_dir, err)
+            }
+
+            err = removeManifestFromDeduplicationIndex(registry, task.OldProject, old_asset, version, manifest)
+            if err != nil {
+                return fmt.Errorf("failed to update the deduplication index for %q; %w", version_dir, err)
+            }
+
+            summ, err := readSummary(version_dir)
+            if err != nil || summ.IsProbational() || summ.IsDeprecated() {
+                continue
+            }
+            err = addVersionToDeduplicationIndex(registry, new_project, asset, version, manifest)
+            if err != nil {
+                return fmt.Errorf("failed to update the deduplication index for %q; %w", version_dir, err)
+            }
+        }
+    }
+    return nil
+}
+
+func renameDirectory(task *renameTask, globals *globalConfiguration, ctx context.Context) error {
+    // Obtaining an all-of-registry lock as we need to modify links throughout the registry.
+    rlock, err := lockDirectoryExclusive(globals.Registry, globals, ctx)
+    if err != nil {
+        return fmt.Errorf("failed to acquire the lock on the registry; %w", err)
+    }
+    defer rlock.Unlock(globals)
+
+    old_project_dir := filepath.Join(globals.Registry, task.OldProject)
+    if err := checkProjectExists(old_project_dir, task.OldProject); err != nil {
+        return err
+    }
+
+    var old_dir, new_dir string
+    var renamed_assets []string
+    if task.OldAsset == "" {
+        old_dir = old_project_dir
+        new_dir = filepath.Join(globals.Registry, task.NewProject)
+        assets, err := listUserDirectories(old_dir)
+        if err != nil {
+            return fmt.Errorf("failed to list assets in %q; %w", old_dir, err)
+        }
+        renamed_assets = assets
+    } else {
+        old_dir = filepath.Join(old_project_dir, task.OldAsset)
+        if err := checkAssetExists(old_dir, task.OldAsset, task.OldProject); err != nil {
+            return err
+        }
+        new_dir = filepath.Join(old_project_dir, task.NewAsset)
+        renamed_assets = []string{ task.NewAsset }
+    }
+
+    _, err = os.Stat(new_dir)
+    if err == nil {
+        return newHttpError(http.StatusBadRequest, fmt.Errorf("%q already exists in the registry", new_dir))
+    } else if !errors.Is(err, os.ErrNotExist) {
+        return fmt.Errorf("failed to stat %q; %w", new_dir, err)
+    }
+
+    // First pass to identify all links that need to be changed across the registry.
+    // Version directories are keyed by their post-rename paths.
+    all_changes := map[string]map[string]manifestEntry{}
+    var all_changes_lock sync.Mutex
+    err = scanRegistryVersions(globals.Registry, ctx, globals.ConcurrencyThrottle, func(project, asset, version string) error {
+        version_dir := filepath.Join(project, asset, version)
+        manifest, err := readManifest(filepath.Join(globals.Registry, version_dir))
+        if err != nil {
+            return fmt.Errorf("failed to read manifest for %q; %w", version_dir, err)
+        }
+
+        changed := proposeRenamedLinks(manifest, task)
+        if len(changed) == 0 {
+            return nil
+        }
+
+        self := linkMetadata{ Project: project, Asset: asset }
+        task.Apply(&self)
+        all_changes_lock.Lock()
+        defer all_changes_lock.Unlock()
+        all_changes[filepath.Join(self.Project, self.Asset, version)] = changed
+        return nil
+    })
+    if err != nil {
+        return err
+    }
+
+    err = os.Rename(old_dir, new_dir)
+    if err != nil {
+        return fmt.Errorf("failed to rename %q to %q; %w", old_dir, new_dir, err)
+    }
+
+    // Second pass to actually rewrite the links.
+    // As in rerouteLinksHandler(), this is not parallelized so that any failure is limited to a single version directory.
+    // The rename itself cannot be undone at this point, so we log the version directories with stale links for manual repair, e.g., with reroute_links.
+    all_vpaths := []string{}
+    for vpath, _ := range all_changes {
+        all_vpaths = append(all_vpaths, vpath)
+    }
+    sort.Strings(all_vpaths)
+    for i, vpath := range all_vpaths {
+        err := executeRenamedLinks(globals.Registry, vpath, all_changes[vpath])
+        if err != nil {
+            log.Printf("failed to update links after renaming %q to %q; %d of %d version directories still contain links to the old name: %v", old_dir, new_dir, len(all_vpaths) - i, len(all_vpaths), all_vpaths[i:])
+            return err
+        }
+    }
+
+    // Errors are not fatal as the rename has already happened, and stale entries in the deduplication index are ignored anyway.
+    new_project := task.OldProject
+    if task.OldAsset == "" {
+        new_project = task.NewProject
+    }
+    err = renameInDeduplicationIndex(globals.Registry, task, new_project, renamed_assets)
+    if err != nil {
+        log.Printf("failed to update the deduplication index after renaming %q to %q; %v", old_dir, new_dir, err)
+    }
+
+    // Project-level uploader permissions may be restricted to the renamed asset.
+    if task.OldAsset != "" {
+        perms, err := readPermissions(old_project_dir)
+        if err != nil {
+            return fmt.Errorf("failed to read permissions for %q; %w", old_project_dir, err)
+        }
+
+        before := copyPermissions(perms)
+
+        modified := false
+        for i, up := range perms.Uploaders {
+            if up.Asset != nil && *(up.Asset) == task.OldAsset {
+                perms.Uploaders[i].Asset = &(task.NewAsset)
+                modified = true
+            }
+        }
+
+        if modified {
+            err := dumpJson(filepath.Join(old_project_dir, permissionsFileName), perms)
+            if err != nil {
+                return fmt.Errorf("failed to update permissions for %q; %w", old_project_dir, err)
+            }
+
+            err = recordPermissionsChange(globals.Registry, task.OldProject, nil, permissionsSourceRename, task.User, nil, before, perms)
+            if err != nil {
+                return err
+            }
+        }
+    }
+
+    return nil
+}
+
+func renameProjectHandler(reqpath string, globals *globalConfiguration, ctx context.Context) error {
+    req_user, err := identifyUser(reqpath)
+    if err != nil {
+        return fmt.Errorf("failed to find owner of %q; %w", reqpath, err)
+    }
+
+    incoming := struct {
+        Project *string `json:"project"`
+        NewProject *string `json:"new_project"`
+    }{}
+    {
+        handle, err := os.ReadFile(reqpath)
+        if err != nil {
+            return fmt.Errorf("failed to read %q; %w", reqpath, err)
+        }
+
+        err = json.Unmarshal(handle, &incoming)
+        if err != nil {
+            return newHttpError(http.StatusBadRequest, fmt.Errorf("failed to parse JSON from %q; %w", reqpath, err))
+        }
+
+        err = isMissingOrBadName(incoming.Project)
+        if err != nil {
+            return newHttpError(http.StatusBadRequest, fmt.Errorf("invalid 'project' property in %q; %w", reqpath, err))
+        }
+
+        err = isMissingOrBadName(incoming.NewProject)
+        if err != nil {
+            return newHttpError(http.StatusBadRequest, fmt.Errorf("invalid 'new_project' property in %q; %w", reqpath, err))
+        }
+    }
+
+    // Both the old and new project names must be covered by the grant, so that scoped administrators cannot move projects out of their scope.
+    if !isAuthorizedToAdminAction(req_user, globals.Administrators, globals.ScopedAdministrators, adminActionRename, incoming.Project) ||
+        !isAuthorizedToAdminAction(req_user, globals.Administrators, globals.ScopedAdministrators, adminActionRename, incoming.NewProject) {
+        return newHttpError(http.StatusForbidden, fmt.Errorf("user %q is not authorized to rename project %q to %q", req_user, *(incoming.Project), *(incoming.NewProject)))
+    }
+
+    task := renameTask{ OldProject: *(incoming.Project), NewProject: *(incoming.NewProject), User: req_user }
+    err = renameDirectory(&task, globals, ctx)
+    if err != nil {
+        return err
+    }
+
+    payload := map[string]interface{} {
+        "type": "rename-project",
+        "project": task.OldProject,
+        "new_project": task.NewProject,
+    }
+    err = dumpLog(globals.Registry, &payload)
+    if err != nil {
+        return fmt.Errorf("failed to create log for project renaming; %w", err)
+    }
+
+    return nil
+}
+
+func renameAssetHandler(reqpath string, globals *globalConfiguration, ctx context.Context) error {
+    req_user, err := identifyUser(reqpath)
+    if err != nil {
+        return fmt.Errorf("failed to find owner of %q; %w", reqpath, err)
+    }
+
+    incoming := struct {
+        Project *string `json:"project"`
+        Asset *string `json:"asset"`
+        NewAsset *string `json:"new_asset"`
+    }{}
+    {
+        handle, err := os.ReadFile(reqpath)
+        if err != nil {
+            return fmt.Errorf("failed to read %q; %w", reqpath, err)
+        }
+
+        err = json.Unmarshal(handle, &incoming)
+        if err != nil {
+            return newHttpError(http.StatusBadRequest, fmt.Errorf("failed to parse JSON from %q; %w", reqpath, err))
+        }
+
+        err = isMissingOrBadName(incoming.Project)
+        if err != nil {
+            return newHttpError(http.StatusBadRequest, fmt.Errorf("invalid 'project' property in %q; %w", reqpath, err))
+        }
+
+        err = isMissingOrBadName(incoming.Asset)
+        if err != nil {
+            return newHttpError(http.StatusBadRequest, fmt.Errorf("invalid 'asset' property in %q; %w", reqpath, err))
+        }
+
+        err = isMissingOrBadName(incoming.NewAsset)
+        if err != nil {
+            return newHttpError(http.StatusBadRequest, fmt.Errorf("invalid 'new_asset' property in %q; %w", reqpath, err))
+        }
+    }
+
+    if !isAuthorizedToAdminAction(req_user, globals.Administrators, globals.ScopedAdministrators, adminActionRename, incoming.Project) {
+        return newHttpError(http.StatusForbidden, fmt.Errorf("user %q is not authorized to rename an asset in %q", req_user, *(incoming.Project)))
+    }
+
+    task := renameTask{ OldProject: *(incoming.Project), NewProject: *(incoming.Project), OldAsset: *(incoming.Asset), NewAsset: *(incoming.NewAsset), User: req_user }
+    err = renameDirectory(&task, globals, ctx)
+    if err != nil {
+        return err
+    }
+
+    payload := map[string]interface{} {
+        "type": "rename-asset",
+        "project": task.OldProject,
+        "asset": task.OldAsset,
+        "new_asset": task.NewAsset,
+    }
+    err = dumpLog(globals.Registry, &payload)
+    if err != nil {
+        return fmt.Errorf("failed to create log for asset renaming; %w", err)
+    }
+
+    return nil
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+    "testing"
+    "os"
+    "path/filepath"
+    "strings"
+    "context"
+    "time"
+)
+
+func mockRegistryForRename(registry string) error {
+    err := mockRegistryForReroute(registry, "ARIA", "anime")
+    if err != nil {
+        return err
+    }
+
+    // Adding summaries so that the versions can be used as link targets.
+    currently := time.Now()
+    for i, version := range []string{ "animation", "natural", "origination", "avvenire" } {
+        err := dumpJson(filepath.Join(registry, "ARIA", "anime", version, summaryFileName), &summaryMetadata{
+            UploadUserId: "aaron",
+            UploadStart: currently.Format(time.RFC3339),
+            UploadFinish: currently.Add(time.Duration(i) * time.Minute).Format(time.RFC3339),
+        })
+        if err != nil {
+            return err
+        }
+    }
+
+    err = os.WriteFile(
+        filepath.Join(registry, "ARIA", permissionsFileName),
+        []byte(`{ "owners": [], "uploaders": [ { "id": "akari", "asset": "anime" }, { "id": "aika", "asset": "manga" } ] }`),
+        0644,
+    )
+    if err != nil {
+        return err
+    }
+
+    // Creating a version in another project that links into the to-be-renamed project.
+    src, err := os.MkdirTemp("", "")
+    if err != nil {
+        return err
+    }
+    err = os.Symlink(filepath.Join(registry, "ARIA", "anime", "avvenire", "himeya", "aika"), filepath.Join(src, "aika"))
+    if err != nil {
+        return err
+    }
+    err = os.Symlink(filepath.Join(registry, "ARIA", "anime", "natural", "orange_planet", "alice"), filepath.Join(src, "alice"))
+    if err != nil {
+        return err
+    }
+
+    conc := newConcurrencyThrottle(2)
+    return transferDirectory(src, registry, "arietta", "linker", "v1", context.Background(), &conc, transferDirectoryOptions{})
+}
+
+func checkRenamedLinks(t *testing.T, registry, version_dir, project, asset string) {
+    manifest, err := readManifest(filepath.Join(registry, version_dir))
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    for key, entry := range manifest {
+        if entry.Link == nil {
+            continue
+        }
+        for _, link := range []*linkMetadata{ entry.Link, entry.Link.Ancestor } {
+            if link == nil {
+                continue
+            }
+            if link.Project == "ARIA" && link.Asset == "anime" {
+                t.Fatalf("link for %q in %q was not rewritten; %v", key, version_dir, link)
+            }
+            if link.Project == project && link.Asset != asset {
+                t.Fatalf("unexpected link for %q in %q; %v", key, version_dir, link)
+            }
+        }
+
+        // Checking that the symlink is still valid and that the linkfile is consistent.
+        full_path := filepath.Join(registry, version_dir, key)
+        contents, err := os.ReadFile(full_path)
+        if err != nil {
+            t.Fatalf("failed to read through the rewritten symlink at %q; %v", full_path, err)
+        }
+        if int64(len(contents)) != entry.Size {
+            t.Fatalf("unexpected contents for the rewritten symlink at %q", full_path)
+        }
+
+        links, err := readLinkfile(filepath.Join(filepath.Dir(full_path), linksFileName))
+        if err != nil {
+            t.Fatal(err)
+        }
+        found, ok := links[filepath.Base(key)]
+        if !ok || found.Project != entry.Link.Project || found.Asset != entry.Link.Asset {
+            t.Fatalf("linkfile is not consistent with the manifest for %q", full_path)
+        }
+    }
+}
+
+func TestRenameAssetHandler(t *testing.T) {
+    reg, err := constructMockRegistry()
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = mockRegistryForRename(reg)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    ctx := context.Background()
+    globals := newGlobalConfiguration(reg, 2)
+
+    reqpath, err := dumpRequest("rename_asset", `{ "project": "ARIA", "asset": "anime", "new_asset": "cartoon" }`)
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = renameAssetHandler(reqpath, &globals, ctx)
+    if err == nil || !strings.Contains(err.Error(), "not authorized") {
+        t.Fatalf("expected an authorization failure; %v", err)
+    }
+
+    self, err := identifyUser(reg)
+    if err != nil {
+        t.Fatal(err)
+    }
+    globals.Administrators = append(globals.Administrators, self)
+
+    err = renameAssetHandler(reqpath, &globals, ctx)
+    if err != nil {
+        t.Fatalf("failed to rename the asset; %v", err)
+    }
+
+    if _, err := os.Stat(filepath.Join(reg, "ARIA", "anime")); err == nil {
+        t.Fatal("expected the old asset directory to be gone")
+    }
+    for _, version := range []string{ "natural", "origination", "avvenire" } {
+        checkRenamedLinks(t, reg, filepath.Join("ARIA", "cartoon", version), "ARIA", "cartoon")
+    }
+    checkRenamedLinks(t, reg, filepath.Join("arietta", "linker", "v1"), "ARIA", "cartoon")
+
+    err = verifyFileContents(filepath.Join(reg, "arietta", "linker", "v1", "aika"), "granzchesta")
+    if err != nil {
+        t.Fatal(err)
+    }
+    linker, err := readManifest(filepath.Join(reg, "arietta", "linker", "v1"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    aika := linker["aika"].Link
+    if aika == nil || aika.Asset != "cartoon" || aika.Version != "avvenire" || aika.Ancestor == nil || aika.Ancestor.Asset != "cartoon" || aika.Ancestor.Version != "origination" {
+        t.Fatalf("unexpected link after renaming; %v", aika)
+    }
+
+    // Deduplication index refers to the new asset.
+    manifest, err := readManifest(filepath.Join(reg, "ARIA", "cartoon", "animation"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    entry := manifest["akari"]
+    found, err := readDeduplicationEntries(reg, entry.Size, entry.Md5sum)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(found) != 1 || found[0].Asset != "cartoon" {
+        t.Fatalf("expected deduplication index to refer to the renamed asset; %v", found)
+    }
+
+    perms, err := readPermissions(filepath.Join(reg, "ARIA"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if *(perms.Uploaders[0].Asset) != "cartoon" || *(perms.Uploaders[1].Asset) != "manga" {
+        t.Fatalf("expected uploader permissions to be updated; %v", perms.Uploaders)
+    }
+
+    history, err := readPermissionsHistory(filepath.Join(reg, "ARIA"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(history) != 1 || history[0].Source != "rename_asset" || history[0].User != self || history[0].Asset != nil {
+        t.Fatalf("expected the uploader update to be recorded in the history; %v", history)
+    }
+    if *(history[0].Before.Uploaders[0].Asset) != "anime" || *(history[0].After.Uploaders[0].Asset) != "cartoon" {
+        t.Fatalf("unexpected uploaders in the history; %v", history[0])
+    }
+
+    logs, err := readAllLogs(reg)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(logs) != 2 {
+        t.Fatalf("unexpected logs; %v", logs)
+    }
+    found_rename := false
+    found_perms := false
+    for _, l := range logs {
+        if l.Type == "rename-asset" && *(l.Project) == "ARIA" && *(l.Asset) == "anime" && *(l.NewAsset) == "cartoon" {
+            found_rename = true
+        } else if l.Type == "set-permissions" && *(l.Project) == "ARIA" {
+            found_perms = true
+        }
+    }
+    if !found_rename || !found_perms {
+        t.Fatalf("unexpected logs; %v", logs)
+    }
+
+    // Various failures.
+    reqpath, err = dumpRequest("rename_asset", `{ "project": "ARIA", "asset": "anime", "new_asset": "foobar" }`)
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = renameAssetHandler(reqpath, &globals, ctx)
+    if err == nil || !strings.Contains(err.Error(), "does not exist") {
+        t.Fatalf("expected a failure for a missing asset; %v", err)
+    }
+
+    err = os.Mkdir(filepath.Join(reg, "ARIA", "manga"), 0755)
+    if err != nil {
+        t.Fatal(err)
+    }
+    reqpath, err = dumpRequest("rename_asset", `{ "project": "ARIA", "asset": "cartoon", "new_asset": "manga" }`)
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = renameAssetHandler(reqpath, &globals, ctx)
+    if err == nil || !strings.Contains(err.Error(), "already exists") {
+        t.Fatalf("expected a failure for an existing asset; %v", err)
+    }
+}
+
+func TestRenameProjectHandler(t *testing.T) {
+    reg, err := constructMockRegistry()
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = mockRegistryForRename(reg)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    ctx := context.Background()
+    globals := newGlobalConfiguration(reg, 2)
+    self, err := identifyUser(reg)
+    if err != nil {
+        t.Fatal(err)
+    }
+    globals.Administrators = append(globals.Administrators, self)
+
+    reqpath, err := dumpRequest("rename_project", `{ "project": "ARIA", "new_project": "AQUA" }`)
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = renameProjectHandler(reqpath, &globals, ctx)
+    if err != nil {
+        t.Fatalf("failed to rename the project; %v", err)
+    }
+
+    if _, err := os.Stat(filepath.Join(reg, "ARIA")); err == nil {
+        t.Fatal("expected the old project directory to be gone")
+    }
+    for _, version := range []string{ "natural", "origination", "avvenire" } {
+        checkRenamedLinks(t, reg, filepath.Join("AQUA", "anime", version), "AQUA", "anime")
+    }
+    checkRenamedLinks(t, reg, filepath.Join("arietta", "linker", "v1"), "AQUA", "anime")
+
+    err = verifyFileContents(filepath.Join(reg, "arietta", "linker", "v1", "alice"), "carroll")
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    logs, err := readAllLogs(reg)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(logs) != 1 || logs[0].Type != "rename-project" || *(logs[0].Project) != "ARIA" || *(logs[0].NewProject) != "AQUA" {
+        t.Fatalf("unexpected logs; %v", logs)
+    }
+
+    reqpath, err = dumpRequest("rename_project", `{ "project": "AQUA", "new_project": "arietta" }`)
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = renameProjectHandler(reqpath, &globals, ctx)
+    if err == nil || !strings.Contains(err.Error(), "already exists") {
+        t.Fatalf("expected a failure for an existing project; %v", err)
+    }
+}
@@ -250,6 +250,29 @@ func executeLinkReroutes(registry string, version_dir string, proposal *rerouteP
         return err
     }
 
+    // Copies of the to-be-deleted files are now the real files, so we point the deduplication index at them instead.
+    if len(delinked) > 0 {
+        summ, err := readSummary(full_version_dir)
+        if err == nil && !summ.IsProbational() && !summ.IsDeprecated() {
+            asset_dir := filepath.Dir(version_dir)
+            self := linkMetadata{ Project: filepath.Dir(asset_dir), Asset: filepath.Base(asset_dir), Version: filepath.Base(version_dir) }
+            for _, action := range proposal.Actions {
+                if !action.Copy {
+                    continue
+                }
+                entry := manifest[action.Key]
+                if !isDeduplicatable(&entry) {
+                    continue
+                }
+                self.Path = action.Key
+                err := addDeduplicationEntry(registry, entry.Size, entry.Md5sum, &self)
+                if err != nil {
+                    return err
+                }
+            }
+        }
+    }
+
     // Recreating all link files just to be safe, and removing all linkfiles that are no longer necessary (because links were replaced by copies).
     all_links, err := recreateLinkFiles(full_version_dir, manifest)
     if err != nil {
@@ -266,17 +289,123 @@ func executeLinkReroutes(registry string, version_dir string, proposal *rerouteP
         }
     }
 
+    // Copies increase the usage of this version, so the version index needs to be updated.
+    // We only do so if the index already exists, otherwise it will be created on the next modification of the asset.
+    if len(delinked) > 0 {
+        asset_dir := filepath.Dir(full_version_dir)
+        if _, err := os.Stat(filepath.Join(asset_dir, versionsFileName)); err == nil {
+            err := updateVersionIndex(asset_dir, filepath.Base(full_version_dir))
+            if err != nil {
+                return err
+            }
+        }
+    }
+
     return nil
 }
 
+// This calls 'process' on every version directory in the registry, in parallel.
+// The first error from any call is returned, after which no further versions are processed.
+// The caller is responsible for holding an appropriate lock on the registry.
+func scanRegistryVersions(registry string, ctx context.Context, throttle *concurrencyThrottle, process func(project, asset, version string) error) error {
+    projects, err := listUserDirectories(registry)
+    if err != nil {
+        return err
+    }
+
+    // We run this in parallel for greater throughput. 
+    var error_lock sync.RWMutex
+    all_errors := []error{}
+    safeCheckError := func() error {
+        error_lock.RLock()
+        defer error_lock.RUnlock()
+        if len(all_errors) > 0 {
+            return all_errors[0]
+        } else {
+            return nil
+        }
+    }
+    safeAddError := func(err error) {
+        error_lock.Lock()
+        defer error_lock.Unlock()
+        all_errors = append(all_errors, err)
+    }
+
+    var wg sync.WaitGroup
+    defer wg.Wait() // don't release the directory lock while goroutines are still operating inside!
+
+    for _, project := range projects {
+        project_dir := filepath.Join(registry, project)
+        assets, err := listUserDirectories(project_dir)
+        if err != nil {
+            return fmt.Errorf("failed to list assets for project %q; %w", project, err)
+        }
+
+        for _, asset := range assets {
+            asset_dir := filepath.Join(project_dir, asset)
+            versions, err := listUserDirectories(asset_dir)
+            if err != nil {
+                return fmt.Errorf("failed to list versions for asset %q in project %q; %w", asset, project, err)
+            }
+
+            for _, version := range versions {
+                err := ctx.Err()
+                if err != nil {
+                    return fmt.Errorf("registry scan cancelled; %w", err)
+                }
+
+                err = safeCheckError()
+                if err != nil {
+                    return err
+                }
+
+                handle := throttle.Wait()
+                wg.Add(1);
+                go func(project, asset, version string) {
+                    defer throttle.Release(handle)
+                    defer wg.Done();
+                    err := func() error {
+                        // Re-check for early cancellation once we get into the goroutine, as throttling might have blocked an arbitrarily long time. 
+                        err := ctx.Err()
+                        if err != nil {
+                            return fmt.Errorf("directory processing cancelled; %w", err)
+                        }
+
+                        err = safeCheckError()
+                        if err != nil {
+                            return err
+                        }
+
+                        return process(project, asset, version)
+                    }()
+
+                    if err != nil {
+                        safeAddError(err)
+                    }
+                }(project, asset, version)
+            }
+
+            err = safeCheckError()
+            if err != nil {
+                return err
+            }
+        }
+
+        err = safeCheckError()
+        if err != nil {
+            return err
+        }
+    }
+
+    wg.Wait()
+    return safeCheckError()
+}
+
 func rerouteLinksHandler(reqpath string, globals *globalConfiguration, ctx context.Context) ([]rerouteAction, error) {
     req_user, err := identifyUser(reqpath)
     if err != nil {
         return nil, fmt.Errorf("failed to find owner of %q; %w", reqpath, err)
     }
-    if !isAuthorizedToAdmin(req_user, globals.Administrators) {
-        return nil, newHttpError(http.StatusForbidden, fmt.Errorf("user %q is not authorized to delete a project", req_user))
-    }
 
     // First we validate the request.
     all_incoming := struct {
@@ -321,6 +450,16 @@ func rerouteLinksHandler(reqpath string, globals *globalConfiguration, ctx conte
         }
     }
 
+    // Rerouting modifies links in any project that refers to the to-be-deleted content,
+    // but this only preserves the linked files, so project-scoped grants are sufficient if they cover all of the to-be-deleted projects.
+    if !isAuthorizedToAdminAction(req_user, globals.Administrators, globals.ScopedAdministrators, adminActionReroute, nil) {
+        for _, incoming := range all_incoming.ToDelete {
+            if !isAuthorizedToAdminAction(req_user, globals.Administrators, globals.ScopedAdministrators, adminActionReroute, &(incoming.Project)) {
+                return nil, newHttpError(http.StatusForbidden, fmt.Errorf("user %q is not authorized to reroute links to project %q", req_user, incoming.Project))
+            }
+        }
+    }
+
     // Obtaining an all-of-registry lock before we identify the rerouting actions.
     rlock, err := lockDirectoryExclusive(globals.Registry, globals, ctx)
     if err != nil {
@@ -328,7 +467,12 @@ func rerouteLinksHandler(reqpath string, globals *globalConfiguration, ctx conte
     }
     defer rlock.Unlock(globals)
 
-    to_delete_versions, err := listToBeDeletedVersions(globals.Registry, all_incoming.ToDelete)
+    return rerouteLinksForDeletion(all_incoming.ToDelete, all_incoming.DryRun, globals, ctx)
+}
+
+// This assumes that the caller holds an exclusive lock on the registry.
+func rerouteLinksForDeletion(to_delete []deleteTask, dry_run bool, globals *globalConfiguration, ctx context.Context) ([]rerouteAction, error) {
+    to_delete_versions, err := listToBeDeletedVersions(globals.Registry, to_delete)
     if err != nil {
         return nil, err
     }
@@ -343,142 +487,72 @@ func rerouteLinksHandler(reqpath string, globals *globalConfiguration, ctx conte
         return actions, nil
     }
 
-    projects, err := listUserDirectories(globals.Registry)
-    if err != nil {
-        return nil, err
-    }
-
     // First pass to identify all the rerouting actions across the registry.
-    // We run this in parallel for greater throughput. 
     all_changes := map[string]*rerouteProposal{}
     all_usage := map[string]*usageMetadata{}
-    dry_run := all_incoming.DryRun
-
     var all_changes_lock, all_usage_lock sync.Mutex 
-    var error_lock sync.RWMutex
-    all_errors := []error{}
-    safeCheckError := func() error {
-        error_lock.RLock()
-        defer error_lock.RUnlock()
-        if len(all_errors) > 0 {
-            return all_errors[0]
-        } else {
+
+    err = scanRegistryVersions(globals.Registry, ctx, globals.ConcurrencyThrottle, func(project, asset, version string) error {
+        version_dir := filepath.Join(project, asset, version)
+        if _, found := to_delete_versions[version_dir]; found { // no need to process version directories that are about to be deleted.
             return nil
         }
-    }
-    safeAddError := func(err error) {
-        error_lock.Lock()
-        defer error_lock.Unlock()
-        all_errors = append(all_errors, err)
-    }
-
-    var wg sync.WaitGroup
-    defer wg.Wait() // don't release the directory lock while goroutines are still operating inside!
 
-    for _, project := range projects {
-        project_dir := filepath.Join(globals.Registry, project)
-        assets, err := listUserDirectories(project_dir)
+        cur_changes, err := proposeLinkReroutes(globals.Registry, to_delete_files, version_dir)
         if err != nil {
-            return nil, fmt.Errorf("failed to list assets for project %q; %w", project, err)
+            return fmt.Errorf("failed to reroute links for version %q of asset %q in project %q; %w", version, asset, project, err)
         }
-
-        for _, asset := range assets {
-            asset_dir := filepath.Join(project_dir, asset)
-            versions, err := listUserDirectories(asset_dir)
-            if err != nil {
-                return nil, fmt.Errorf("failed to list versions for asset %q in project %q; %w", asset, project, err)
-            }
-
-            for _, version := range versions {
-                err := ctx.Err()
-                if err != nil {
-                    return nil, fmt.Errorf("reroute request cancelled; %w", err)
-                }
-
-                err = safeCheckError()
+        if len(cur_changes.Actions) == 0 {
+            return nil
+        }
+        all_changes_lock.Lock()
+        defer all_changes_lock.Unlock()
+        all_changes[version_dir] = cur_changes
+
+        if !dry_run {
+            all_usage_lock.Lock()
+            defer all_usage_lock.Unlock()
+            cur_usage, ok := all_usage[project]
+
+            if !ok {
+                project_dir := filepath.Join(globals.Registry, project)
+                usage0, err := readUsage(project_dir)
                 if err != nil {
-                    return nil, err
+                    return fmt.Errorf("failed to read usage for %q; %w", project_dir, err)
                 }
-
-                version_dir := filepath.Join(project, asset, version)
-                if _, found := to_delete_versions[version_dir]; found { // no need to process version directories that are about to be deleted.
-                    continue
-                }
-
-                handle := globals.ConcurrencyThrottle.Wait()
-                wg.Add(1);
-                go func() {
-                    defer globals.ConcurrencyThrottle.Release(handle)
-                    defer wg.Done();
-                    err := func() error {
-                        // Re-check for early cancellation once we get into the goroutine, as throttling might have blocked an arbitrarily long time. 
-                        err := ctx.Err()
-                        if err != nil {
-                            return fmt.Errorf("directory processing cancelled; %w", err)
-                        }
-
-                        err = safeCheckError()
-                        if err != nil {
-                            return err
-                        }
-
-                        cur_changes, err := proposeLinkReroutes(globals.Registry, to_delete_files, version_dir)
-                        if err != nil {
-                            return fmt.Errorf("failed to reroute links for version %q of asset %q in project %q; %w", version, asset, project, err)
-                        }
-                        if len(cur_changes.Actions) == 0 {
-                            return nil
-                        }
-                        all_changes_lock.Lock()
-                        defer all_changes_lock.Unlock()
-                        all_changes[version_dir] = cur_changes
-
-                        if !dry_run {
-                            all_usage_lock.Lock()
-                            defer all_usage_lock.Unlock()
-                            cur_usage, ok := all_usage[project]
-
-                            if !ok {
-                                usage0, err := readUsage(project_dir)
-                                if err != nil {
-                                    return fmt.Errorf("failed to read usage for %q; %w", project_dir, err)
-                                }
-                                cur_usage = usage0
-                            }
-
-                            for _, action := range cur_changes.Actions {
-                                cur_usage.Total += action.Usage
-                            }
-                            all_usage[project] = cur_usage
-                        }
-
-                        return nil
-                    }()
-
-                    if err != nil {
-                        safeAddError(err)
-                    }
-                }()
+                cur_usage = usage0
             }
 
-            err = safeCheckError()
-            if err != nil {
-                return nil, err
+            for _, action := range cur_changes.Actions {
+                cur_usage.Total += action.Usage
             }
+            all_usage[project] = cur_usage
         }
 
-        err = safeCheckError()
-        if err != nil {
-            return nil, err
-        }
-    }
-
-    wg.Wait()
-    err = safeCheckError()
+        return nil
+    })
     if err != nil {
         return nil, err
     }
 
+    // Checking that the extra copies don't push any project over its quota, before we make any changes to the registry.
+    if !dry_run {
+        for project, usage := range all_usage {
+            project_dir := filepath.Join(globals.Registry, project)
+            original, err := readUsage(project_dir)
+            if err != nil {
+                return nil, fmt.Errorf("failed to read usage for %q; %w", project_dir, err)
+            }
+            delta := usage.Total - original.Total
+            if delta > 0 {
+                err := checkQuota(project_dir, original, delta)
+                if err != nil {
+                    return nil, fmt.Errorf("failed to reroute links in project %q; %w", project, err)
+                }
+            }
+        }
+    }
+
     // Second pass to actually implement the changes.
     // This two-pass approach improves the atomicity of the rerouting operation as any failures in the first pass won't leave the registry in a half-mutated state.
     // We won't parallelize this part as we want to only fail once (and report the error accordingly) so that the scope of any repairs is limited to a single file.
@@ -1249,6 +1249,26 @@ func TestRerouteLinksHandler(t *testing.T) {
         if err == nil || !strings.Contains(err.Error(), "not authorized") {
             t.Error("unexpected authorization for non-admin")
         }
+
+        // Project-scoped grants must cover every project in 'to_delete'.
+        globals.ScopedAdministrators = []scopedAdminGrant{ scopedAdminGrant{ User: self, Projects: []string{ "ARIA" }, Actions: map[string]bool{ adminActionReroute: true } } }
+        reqpath, err = dumpRequest("reroute_links", `{ "to_delete": [ { "project": "ARIA" }, { "project": "arietta" } ], "dry_run": true }`)
+        if err != nil {
+            t.Fatalf("failed to dump a request type; %v", err)
+        }
+        _, err = rerouteLinksHandler(reqpath, &globals, ctx)
+        if err == nil || !strings.Contains(err.Error(), "not authorized") {
+            t.Error("unexpected authorization for a grant that does not cover all projects")
+        }
+
+        reqpath, err = dumpRequest("reroute_links", `{ "to_delete": [ { "project": "ARIA", "asset": "anime" } ], "dry_run": true }`)
+        if err != nil {
+            t.Fatalf("failed to dump a request type; %v", err)
+        }
+        _, err = rerouteLinksHandler(reqpath, &globals, ctx)
+        if err != nil {
+            t.Errorf("expected a project-scoped grant to reroute links; %v", err)
+        }
     })
 
     t.Run("bad request", func(t *testing.T) {
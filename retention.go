@@ -0,0 +1,338 @@
+package main
+
+import (
+    "os"
+    "encoding/json"
+    "fmt"
+    "errors"
+    "path/filepath"
+    "net/http"
+    "context"
+    "sort"
+    "time"
+)
+
+// The retention policy determines which old versions of an asset are automatically deleted.
+// This can be set for an entire project via a '..retention' file in the project directory,
+// or for individual assets via a '..retention' file in the asset directory, which takes precedence.
+const retentionFileName = "..retention"
+
+type retentionPolicy struct {
+    KeepLast *int `json:"keep_last,omitempty"`
+    KeepDays *int `json:"keep_days,omitempty"`
+}
+
+func readRetentionPolicyFile(dir string) (*retentionPolicy, error) {
+    policy_path := filepath.Join(dir, retentionFileName)
+
+    policy_raw, err := os.ReadFile(policy_path)
+    if err != nil {
+        if errors.Is(err, os.ErrNotExist) {
+            return nil, nil
+        }
+        return nil, fmt.Errorf("failed to read %q; %w", policy_path, err)
+    }
+
+    var output retentionPolicy
+    err = json.Unmarshal(policy_raw, &output)
+    if err != nil {
+        return nil, fmt.Errorf("failed to parse JSON in %q; %w", policy_path, err)
+    }
+
+    return &output, nil
+}
+
+// Returns nil if no retention policy applies to this asset.
+func readRetentionPolicy(asset_dir string) (*retentionPolicy, error) {
+    policy, err := readRetentionPolicyFile(asset_dir)
+    if err != nil || policy != nil {
+        return policy, err
+    }
+    return readRetentionPolicyFile(filepath.Dir(asset_dir))
+}
+
+func isBadRetentionPolicy(policy *retentionPolicy) error {
+    if policy.KeepLast != nil && *(policy.KeepLast) < 0 {
+        return errors.New("'keep_last' should be non-negative")
+    }
+    if policy.KeepDays != nil && *(policy.KeepDays) < 0 {
+        return errors.New("'keep_days' should be non-negative")
+    }
+    return nil
+}
+
+// A non-probational version is retained if it is one of the 'keep_last' most recently uploaded versions, or if it was uploaded within the last 'keep_days' days.
+// The latest version and any aliased versions are always retained, as are probational versions (which are handled by the probation expiry instead).
+func selectExpiredVersions(asset_dir string, policy *retentionPolicy, now time.Time) ([]string, error) {
+    if policy.KeepLast == nil && policy.KeepDays == nil {
+        return []string{}, nil
+    }
+
+    protected := map[string]bool{}
+    latest, err := readLatest(asset_dir)
+    if err == nil {
+        protected[latest.Version] = true
+    } else if !errors.Is(err, os.ErrNotExist) {
+        return nil, fmt.Errorf("failed to read the latest version for %q; %w", asset_dir, err)
+    }
+
+    aliases, err := readAliases(asset_dir)
+    if err != nil {
+        return nil, err
+    }
+    for _, version := range aliases {
+        protected[version] = true
+    }
+
+    versions, err := listUserDirectories(asset_dir)
+    if err != nil {
+        return nil, fmt.Errorf("failed to list versions in %q; %w", asset_dir, err)
+    }
+
+    type candidate struct {
+        Name string
+        Finish time.Time
+    }
+    candidates := []candidate{}
+    for _, version := range versions {
+        version_dir := filepath.Join(asset_dir, version)
+        summ, err := readSummary(version_dir)
+        if err != nil {
+            return nil, fmt.Errorf("failed to read summary from %q; %w", version_dir, err)
+        }
+        if summ.IsProbational() {
+            continue
+        }
+        as_time, err := time.Parse(time.RFC3339, summ.UploadFinish)
+        if err != nil {
+            return nil, fmt.Errorf("could not parse 'upload_finish' from %q; %w", version_dir, err)
+        }
+        candidates = append(candidates, candidate{ Name: version, Finish: as_time })
+    }
+
+    sort.SliceStable(candidates, func(i, j int) bool {
+        return candidates[i].Finish.After(candidates[j].Finish)
+    })
+
+    expired := []string{}
+    for i, c := range candidates {
+        if protected[c.Name] {
+            continue
+        }
+        if policy.KeepLast != nil && i < *(policy.KeepLast) {
+            continue
+        }
+        if policy.KeepDays != nil && now.Sub(c.Finish) <= time.Duration(*(policy.KeepDays)) * time.Hour * 24 {
+            continue
+        }
+        expired = append(expired, c.Name)
+    }
+
+    return expired, nil
+}
+
+func enforceRetentionPolicies(globals *globalConfiguration) []error {
+    ctx := context.Background()
+
+    // Obtaining an all-of-registry lock as we may need to reroute links throughout the registry.
+    rlock, err := lockDirectoryExclusive(globals.Registry, globals, ctx)
+    if err != nil {
+        return []error{ fmt.Errorf("failed to lock the registry %q; %w", globals.Registry, err) }
+    }
+    defer rlock.Unlock(globals)
+
+    projects, err := listUserDirectories(globals.Registry)
+    if err != nil {
+        return []error{ fmt.Errorf("failed to list projects in registry; %w", err) }
+    }
+
+    all_errors := []error{}
+    to_delete := []deleteTask{}
+    for _, project := range projects {
+        project_dir := filepath.Join(globals.Registry, project)
+        assets, err := listUserDirectories(project_dir)
+        if err != nil {
+            all_errors = append(all_errors, fmt.Errorf("failed to list assets in project directory %q; %w", project_dir, err))
+            continue
+        }
+
+        for _, asset := range assets {
+            asset_dir := filepath.Join(project_dir, asset)
+            policy, err := readRetentionPolicy(asset_dir)
+            if err != nil {
+                all_errors = append(all_errors, err)
+                continue
+            }
+            if policy == nil {
+                continue
+            }
+
+            expired, err := selectExpiredVersions(asset_dir, policy, time.Now())
+            if err != nil {
+                all_errors = append(all_errors, err)
+                continue
+            }
+            for _, version := range expired {
+                to_delete = append(to_delete, deleteTask{ Project: project, Asset: &asset, Version: &version })
+            }
+        }
+    }
+
+    if len(to_delete) == 0 {
+        return all_errors
+    }
+
+    // If rerouting fails, we don't delete anything as this would break links in the remaining versions.
+    _, err = rerouteLinksForDeletion(to_delete, false, globals, ctx)
+    if err != nil {
+        return append(all_errors, fmt.Errorf("failed to reroute links to expired versions; %w", err))
+    }
+
+    for _, task := range to_delete {
+        project_dir := filepath.Join(globals.Registry, task.Project)
+        asset_dir := filepath.Join(project_dir, *(task.Asset))
+        err := deleteVersion(project_dir, asset_dir, *(task.Version), false, globals, ctx)
+        if err != nil {
+            all_errors = append(all_errors, fmt.Errorf("failed to delete expired version %q of asset %q in project %q; %w", *(task.Version), *(task.Asset), task.Project, err))
+        }
+    }
+
+    return all_errors
+}
+
+func setRetentionHandler(reqpath string, globals *globalConfiguration, ctx context.Context) error {
+    incoming := struct {
+        Project *string `json:"project"`
+        Asset *string `json:"asset"`
+        KeepLast *int `json:"keep_last"`
+        KeepDays *int `json:"keep_days"`
+        Spoof *string `json:"spoof"`
+    }{}
+    {
+        handle, err := os.ReadFile(reqpath)
+        if err != nil {
+            return fmt.Errorf("failed to read %q; %w", reqpath, err)
+        }
+
+        err = json.Unmarshal(handle, &incoming)
+        if err != nil {
+            return newHttpError(http.StatusBadRequest, fmt.Errorf("failed to parse JSON from %q; %w", reqpath, err))
+        }
+
+        err = isMissingOrBadName(incoming.Project)
+        if err != nil {
+            return newHttpError(http.StatusBadRequest, fmt.Errorf("invalid 'project' property in %q; %w", reqpath, err))
+        }
+
+        if incoming.Asset != nil {
+            err := isBadName(*(incoming.Asset))
+            if err != nil {
+                return newHttpError(http.StatusBadRequest, fmt.Errorf("invalid 'asset' property in %q; %w", reqpath, err))
+            }
+        }
+    }
+
+    policy := retentionPolicy{ KeepLast: incoming.KeepLast, KeepDays: incoming.KeepDays }
+    err := isBadRetentionPolicy(&policy)
+    if err != nil {
+        return newHttpError(http.StatusBadRequest, fmt.Errorf("invalid retention policy in %q; %w", reqpath, err))
+    }
+
+    username, err := identifySpoofedUser(reqpath, incoming.Spoof, globals.SpoofPermissions)
+    if err != nil {
+        return fmt.Errorf("failed to identify user; %w", err)
+    }
+
+    rlock, err := lockDirectoryShared(globals.Registry, globals, ctx)
+    if err != nil {
+        return fmt.Errorf("failed to lock the registry %q; %w", globals.Registry, err)
+    }
+    defer rlock.Unlock(globals)
+
+    rnnlock, err := lockDirectoryNewDirShared(globals.Registry, globals, ctx)
+    if err != nil {
+        return fmt.Errorf("failed to lock the registry %q; %w", globals.Registry, err)
+    }
+    defer rnnlock.Unlock(globals)
+
+    project := *(incoming.Project)
+    project_dir := filepath.Join(globals.Registry, project)
+    if err := checkProjectExists(project_dir, project); err != nil {
+        return err
+    }
+    rnnlock.Unlock(globals) // no need to hold this lock once we have safely entered the subdirectory.
+
+    var target_dir string
+    if incoming.Asset == nil {
+        plock, err := lockDirectoryExclusive(project_dir, globals, ctx)
+        if err != nil {
+            return fmt.Errorf("failed to lock project directory %q; %w", project_dir, err)
+        }
+        defer plock.Unlock(globals)
+
+        project_perms, err := readPermissions(project_dir)
+        if err != nil {
+            return fmt.Errorf("failed to read permissions for %q; %w", project, err)
+        }
+        if !isAuthorizedToMaintain(username, globals.Administrators, project_perms.Owners) {
+            return newHttpError(http.StatusForbidden, fmt.Errorf("user %q is not authorized to set the retention policy for %q", username, project))
+        }
+        target_dir = project_dir
+
+    } else {
+        plock, err := lockDirectoryShared(project_dir, globals, ctx)
+        if err != nil {
+            return fmt.Errorf("failed to lock project directory %q; %w", project_dir, err)
+        }
+        defer plock.Unlock(globals)
+
+        pnnlock, err := lockDirectoryNewDirShared(project_dir, globals, ctx)
+        if err != nil {
+            return fmt.Errorf("failed to lock project directory %q; %w", project_dir, err)
+        }
+        defer pnnlock.Unlock(globals)
+
+        asset := *(incoming.Asset)
+        asset_dir := filepath.Join(project_dir, asset)
+        if err := checkAssetExists(asset_dir, asset, project); err != nil {
+            return err
+        }
+        pnnlock.Unlock(globals) // no need to hold this lock once we have safely entered the subdirectory.
+
+        alock, err := lockDirectoryExclusive(asset_dir, globals, ctx)
+        if err != nil {
+            return fmt.Errorf("failed to lock asset directory %q; %w", asset_dir, err)
+        }
+        defer alock.Unlock(globals)
+
+        existing_perms, err := readPermissions(project_dir)
+        if err != nil {
+            return fmt.Errorf("failed to read permissions for %q; %w", project_dir, err)
+        }
+        asset_perms, err := addAssetPermissionsForUpload(existing_perms, asset_dir, asset)
+        if err != nil {
+            return fmt.Errorf("failed to read permissions for asset %q in %q; %w", asset, project, err)
+        }
+        if !isAuthorizedToMaintain(username, globals.Administrators, asset_perms.Owners) {
+            return newHttpError(http.StatusForbidden, fmt.Errorf("user %q is not authorized to set the retention policy for %q", username, asset_dir))
+        }
+        target_dir = asset_dir
+    }
+
+    // Supplying neither property removes the existing policy.
+    policy_path := filepath.Join(target_dir, retentionFileName)
+    if policy.KeepLast == nil && policy.KeepDays == nil {
+        err := os.RemoveAll(policy_path)
+        if err != nil {
+            return fmt.Errorf("failed to remove the retention policy at %q; %w", policy_path, err)
+        }
+        return nil
+    }
+
+    err = dumpJson(policy_path, &policy)
+    if err != nil {
+        return fmt.Errorf("failed to save the retention policy at %q; %w", policy_path, err)
+    }
+
+    return nil
+}
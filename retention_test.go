@@ -0,0 +1,353 @@
+package main
+
+import (
+    "testing"
+    "os"
+    "path/filepath"
+    "fmt"
+    "strings"
+    "context"
+    "time"
+    "sort"
+)
+
+func mockVersionsForRetentionTest(asset_dir string, now time.Time) error {
+    for i, version := range []string{ "v1", "v2", "v3", "v4", "v5" } {
+        version_dir := filepath.Join(asset_dir, version)
+        err := os.MkdirAll(version_dir, 0755)
+        if err != nil {
+            return err
+        }
+
+        // v1 is the oldest, uploaded 50 days ago; v5 is the newest, uploaded 10 days ago.
+        finish := now.Add(-time.Duration(50 - i * 10) * time.Hour * 24).Format(time.RFC3339)
+        summ := summaryMetadata{ UploadUserId: "ash", UploadStart: finish, UploadFinish: finish }
+        if version == "v4" {
+            on_probation := true
+            summ.OnProbation = &on_probation
+        }
+        err = dumpJson(filepath.Join(version_dir, summaryFileName), &summ)
+        if err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+func TestSelectExpiredVersions(t *testing.T) {
+    reg, err := constructMockRegistry()
+    if err != nil {
+        t.Fatalf("failed to create the registry; %v", err)
+    }
+
+    now := time.Now()
+    asset_dir := filepath.Join(reg, "pokemon", "pikachu")
+    err = mockVersionsForRetentionTest(asset_dir, now)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    check := func(policy retentionPolicy, expected []string) {
+        expired, err := selectExpiredVersions(asset_dir, &policy, now)
+        if err != nil {
+            t.Fatal(err)
+        }
+        sort.Strings(expired)
+        if fmt.Sprint(expired) != fmt.Sprint(expected) {
+            t.Fatalf("unexpected expired versions %v, expected %v", expired, expected)
+        }
+    }
+
+    keep_last := 2
+    keep_days := 25
+    check(retentionPolicy{}, []string{})
+    check(retentionPolicy{ KeepLast: &keep_last }, []string{ "v1", "v2" }) // v4 is probational and not counted.
+    check(retentionPolicy{ KeepDays: &keep_days }, []string{ "v1", "v2", "v3" })
+    check(retentionPolicy{ KeepLast: &keep_last, KeepDays: &keep_days }, []string{ "v1", "v2" })
+
+    // Protecting the latest and aliased versions.
+    err = dumpJson(filepath.Join(asset_dir, latestFileName), &latestMetadata{ Version: "v1" })
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = dumpJson(filepath.Join(asset_dir, aliasesFileName), map[string]string{ "stable": "v2" })
+    if err != nil {
+        t.Fatal(err)
+    }
+    zero := 0
+    check(retentionPolicy{ KeepLast: &zero }, []string{ "v3", "v5" })
+}
+
+func TestReadRetentionPolicy(t *testing.T) {
+    reg, err := constructMockRegistry()
+    if err != nil {
+        t.Fatalf("failed to create the registry; %v", err)
+    }
+
+    asset_dir := filepath.Join(reg, "pokemon", "pikachu")
+    err = os.MkdirAll(asset_dir, 0755)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    policy, err := readRetentionPolicy(asset_dir)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if policy != nil {
+        t.Fatal("expected no policy when no files are present")
+    }
+
+    err = os.WriteFile(filepath.Join(reg, "pokemon", retentionFileName), []byte(`{ "keep_last": 5 }`), 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+    policy, err = readRetentionPolicy(asset_dir)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if policy == nil || policy.KeepLast == nil || *(policy.KeepLast) != 5 || policy.KeepDays != nil {
+        t.Fatal("expected the project-level policy to be used")
+    }
+
+    err = os.WriteFile(filepath.Join(asset_dir, retentionFileName), []byte(`{ "keep_days": 10 }`), 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+    policy, err = readRetentionPolicy(asset_dir)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if policy == nil || policy.KeepLast != nil || policy.KeepDays == nil || *(policy.KeepDays) != 10 {
+        t.Fatal("expected the asset-level policy to take precedence")
+    }
+}
+
+func TestSetRetentionHandler(t *testing.T) {
+    reg, err := constructMockRegistry()
+    if err != nil {
+        t.Fatalf("failed to create the registry; %v", err)
+    }
+    globals := newGlobalConfiguration(reg, 2)
+    ctx := context.Background()
+
+    project := "pokemon"
+    asset := "pikachu"
+    err = mockProbationVersion(reg, project, asset, "red")
+    if err != nil {
+        t.Fatalf("failed to create a mock version; %v", err)
+    }
+
+    self, err := identifyUser(reg)
+    if err != nil {
+        t.Fatalf("failed to identify self; %v", err)
+    }
+
+    set := func(body string) error {
+        reqpath, err := dumpRequest("set_retention", body)
+        if err != nil {
+            t.Fatalf("failed to dump a request type; %v", err)
+        }
+        return setRetentionHandler(reqpath, &globals, ctx)
+    }
+
+    t.Run("unauthorized", func(t *testing.T) {
+        err := set(fmt.Sprintf(`{ "project": "%s", "keep_last": 2 }`, project))
+        if err == nil || !strings.Contains(err.Error(), "not authorized") {
+            t.Fatalf("expected failure for unauthorized user; %v", err)
+        }
+        err = set(fmt.Sprintf(`{ "project": "%s", "asset": "%s", "keep_last": 2 }`, project, asset))
+        if err == nil || !strings.Contains(err.Error(), "not authorized") {
+            t.Fatalf("expected failure for unauthorized user; %v", err)
+        }
+    })
+
+    err = os.WriteFile(filepath.Join(reg, project, permissionsFileName), []byte(fmt.Sprintf(`{ "owners": [ "%s" ], "uploaders": [] }`, self)), 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    t.Run("project", func(t *testing.T) {
+        err := set(fmt.Sprintf(`{ "project": "%s", "keep_last": 2, "keep_days": 30 }`, project))
+        if err != nil {
+            t.Fatal(err)
+        }
+        policy, err := readRetentionPolicyFile(filepath.Join(reg, project))
+        if err != nil {
+            t.Fatal(err)
+        }
+        if policy == nil || *(policy.KeepLast) != 2 || *(policy.KeepDays) != 30 {
+            t.Fatalf("unexpected project-level policy; %v", policy)
+        }
+    })
+
+    t.Run("asset", func(t *testing.T) {
+        err := set(fmt.Sprintf(`{ "project": "%s", "asset": "%s", "keep_days": 5 }`, project, asset))
+        if err != nil {
+            t.Fatal(err)
+        }
+        policy, err := readRetentionPolicy(filepath.Join(reg, project, asset))
+        if err != nil {
+            t.Fatal(err)
+        }
+        if policy == nil || policy.KeepLast != nil || *(policy.KeepDays) != 5 {
+            t.Fatalf("unexpected asset-level policy; %v", policy)
+        }
+
+        // Removing the asset-level policy falls back to the project.
+        err = set(fmt.Sprintf(`{ "project": "%s", "asset": "%s" }`, project, asset))
+        if err != nil {
+            t.Fatal(err)
+        }
+        policy, err = readRetentionPolicy(filepath.Join(reg, project, asset))
+        if err != nil {
+            t.Fatal(err)
+        }
+        if policy == nil || *(policy.KeepLast) != 2 {
+            t.Fatalf("expected the project-level policy after removal; %v", policy)
+        }
+    })
+
+    t.Run("failures", func(t *testing.T) {
+        err := set(fmt.Sprintf(`{ "project": "%s", "keep_last": -1 }`, project))
+        if err == nil || !strings.Contains(err.Error(), "non-negative") {
+            t.Fatalf("expected failure for a negative value; %v", err)
+        }
+        err = set(fmt.Sprintf(`{ "project": "%s", "asset": "missing", "keep_last": 1 }`, project))
+        if err == nil || !strings.Contains(err.Error(), "does not exist") {
+            t.Fatalf("expected failure for a missing asset; %v", err)
+        }
+        err = set(`{ "keep_last": 1 }`)
+        if err == nil || !strings.Contains(err.Error(), "invalid 'project'") {
+            t.Fatalf("expected failure for a missing project; %v", err)
+        }
+    })
+}
+
+func TestEnforceRetentionPolicies(t *testing.T) {
+    reg, err := constructMockRegistry()
+    if err != nil {
+        t.Fatalf("failed to create the registry; %v", err)
+    }
+    globals := newGlobalConfiguration(reg, 2)
+    ctx := context.Background()
+
+    err = setupProjectForUploadTest("pokemon", &globals)
+    if err != nil {
+        t.Fatalf("failed to set up project directory; %v", err)
+    }
+    err = setupProjectForUploadTest("digimon", &globals)
+    if err != nil {
+        t.Fatalf("failed to set up project directory; %v", err)
+    }
+
+    src, err := setupSourceForWalkDirectoryTest()
+    if err != nil {
+        t.Fatalf("failed to set up test directories; %v", err)
+    }
+    asset_dir := filepath.Join(reg, "pokemon", "pikachu")
+    for i, version := range []string{ "red", "blue", "green" } {
+        reqname, err := dumpRequest("upload", fmt.Sprintf(`{ "source": "%s", "project": "pokemon", "asset": "pikachu", "version": "%s" }`, filepath.Base(src), version))
+        if err != nil {
+            t.Fatalf("failed to create upload request; %v", err)
+        }
+        err = uploadHandler(reqname, &globals, ctx)
+        if err != nil {
+            t.Fatalf("failed to perform the upload; %v", err)
+        }
+
+        // Backdating the uploads so that their order is unambiguous.
+        summ_path := filepath.Join(asset_dir, version, summaryFileName)
+        summ, err := readSummary(filepath.Join(asset_dir, version))
+        if err != nil {
+            t.Fatal(err)
+        }
+        summ.UploadFinish = time.Now().Add(-time.Duration(3 - i) * time.Hour).Format(time.RFC3339)
+        err = dumpJson(summ_path, summ)
+        if err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    // Creating a version in another project that links to the oldest version.
+    reqname, err := dumpRequest("clone_version", `{ "source_project": "pokemon", "source_asset": "pikachu", "source_version": "red", "project": "digimon", "asset": "agumon", "version": "v1" }`)
+    if err != nil {
+        t.Fatalf("failed to create clone request; %v", err)
+    }
+    err = cloneVersionHandler(reqname, &globals, ctx)
+    if err != nil {
+        t.Fatalf("failed to clone the version; %v", err)
+    }
+
+    // No policies, so nothing happens.
+    errs := enforceRetentionPolicies(&globals)
+    if len(errs) > 0 {
+        t.Fatal(errs)
+    }
+    for _, version := range []string{ "red", "blue", "green" } {
+        if _, err := os.Stat(filepath.Join(asset_dir, version)); err != nil {
+            t.Fatalf("expected version %q to be retained; %v", version, err)
+        }
+    }
+
+    err = os.WriteFile(filepath.Join(reg, "pokemon", retentionFileName), []byte(`{ "keep_last": 2 }`), 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+    errs = enforceRetentionPolicies(&globals)
+    if len(errs) > 0 {
+        t.Fatal(errs)
+    }
+
+    if _, err := os.Stat(filepath.Join(asset_dir, "red")); err == nil || !os.IsNotExist(err) {
+        t.Fatal("expected the oldest version to be deleted")
+    }
+    for _, version := range []string{ "blue", "green" } {
+        if _, err := os.Stat(filepath.Join(asset_dir, version)); err != nil {
+            t.Fatalf("expected version %q to be retained; %v", version, err)
+        }
+    }
+
+    // Links in the clone should have been rerouted to real files.
+    clone_dir := filepath.Join(reg, "digimon", "agumon", "v1")
+    manifest, err := readManifest(clone_dir)
+    if err != nil {
+        t.Fatal(err)
+    }
+    for k, entry := range manifest {
+        if entry.Link != nil && entry.Link.Version == "red" {
+            t.Fatalf("expected link for %q to no longer refer to the deleted version", k)
+        }
+    }
+    err = verifyFileContents(filepath.Join(clone_dir, "moves", "electric", "thunderbolt"), "90")
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    // Later versions were deduplicated against the deleted version, so the recorded usage should remain consistent after rerouting.
+    usage, err := readUsage(filepath.Join(reg, "pokemon"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    expected_usage, err := computeProjectUsage(filepath.Join(reg, "pokemon"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if usage.Total != expected_usage {
+        t.Fatalf("recorded usage %v is not consistent with the actual usage %v", usage.Total, expected_usage)
+    }
+
+    logs, err := readAllLogs(reg)
+    if err != nil {
+        t.Fatalf("failed to read the logs; %v", err)
+    }
+    found := false
+    for _, l := range logs {
+        if l.Type == "delete-version" && *(l.Project) == "pokemon" && *(l.Asset) == "pikachu" && *(l.Version) == "red" {
+            found = true
+        }
+    }
+    if !found {
+        t.Fatalf("expected a delete-version log for the expired version; %v", logs)
+    }
+}
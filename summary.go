@@ -14,12 +14,56 @@ type summaryMetadata struct {
     UploadStart string `json:"upload_start"`
     UploadFinish string `json:"upload_finish"`
     OnProbation *bool `json:"on_probation,omitempty"`
+    Include []string `json:"include,omitempty"`
+    Exclude []string `json:"exclude,omitempty"`
+    Deprecated *bool `json:"deprecated,omitempty"`
+    DeprecationReason string `json:"deprecation_reason,omitempty"`
+
+    // Provenance of the upload. These are absent in summaries created by older versions of the Gobbler.
+    RequestUserId string `json:"request_user_id,omitempty"`
+    Instance string `json:"instance,omitempty"`
+    RequestFile string `json:"request_file,omitempty"`
+    FileCount *int64 `json:"file_count,omitempty"`
+    TotalBytes *int64 `json:"total_bytes,omitempty"`
+    DeduplicatedBytes *int64 `json:"deduplicated_bytes,omitempty"`
+    Consume *bool `json:"consume,omitempty"`
+    IgnoreDot *bool `json:"ignore_dot,omitempty"`
 }
 
 func (s summaryMetadata) IsProbational() bool {
     return s.OnProbation != nil && *(s.OnProbation)
 }
 
+func (s summaryMetadata) IsDeprecated() bool {
+    return s.Deprecated != nil && *(s.Deprecated)
+}
+
+// Records the provenance of a new or amended version in its summary.
+// 'request_user' is the user who actually created the request file, which is only different from the uploading user if spoofing was involved.
+func (s *summaryMetadata) AddProvenance(reqpath string, request_user string, manifest map[string]manifestEntry, globals *globalConfiguration) {
+    s.RequestUserId = ""
+    if request_user != s.UploadUserId {
+        s.RequestUserId = request_user
+    }
+    s.Instance = globals.Instance
+    s.RequestFile = filepath.Base(reqpath)
+
+    var count, total, deduplicated int64
+    for _, entry := range manifest {
+        if entry.Link == nil && entry.Md5sum == "" { // skipping empty directories.
+            continue
+        }
+        count++
+        total += entry.Size
+        if entry.Link != nil {
+            deduplicated += entry.Size
+        }
+    }
+    s.FileCount = &count
+    s.TotalBytes = &total
+    s.DeduplicatedBytes = &deduplicated
+}
+
 func readSummary(path string) (*summaryMetadata, error) {
     summary_path := filepath.Join(path, summaryFileName)
 
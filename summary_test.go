@@ -60,3 +60,44 @@ func TestReadSummary(t *testing.T) {
         t.Fatalf("unexpected values in the test summary; %v", err)
     }
 }
+
+func TestReadSummaryProvenance(t *testing.T) {
+    f, err := os.MkdirTemp("", "test-")
+    if err != nil {
+        t.Fatalf("failed to create tempdir; %v", err)
+    }
+
+    err = os.WriteFile(
+        filepath.Join(f, summaryFileName),
+        []byte(`
+{ 
+    "upload_user_id": "aaron",
+    "upload_start": "2020-02-02T02:20:02Z",
+    "upload_finish": "2021-12-20T21:20:11Z",
+    "request_user_id": "serena",
+    "instance": "gobbler-1",
+    "request_file": "request-upload-1234",
+    "file_count": 5,
+    "total_bytes": 100,
+    "deduplicated_bytes": 20,
+    "consume": true,
+    "ignore_dot": false
+}`),
+        0644,
+    )
+    if err != nil {
+        t.Fatalf("failed to create test summary; %v", err)
+    }
+
+    out, err := readSummary(f)
+    if err != nil {
+        t.Fatalf("failed to read test summary; %v", err)
+    }
+
+    if out.RequestUserId != "serena" || out.Instance != "gobbler-1" || out.RequestFile != "request-upload-1234" {
+        t.Fatalf("unexpected provenance in the test summary; %v", out)
+    }
+    if *(out.FileCount) != 5 || *(out.TotalBytes) != 100 || *(out.DeduplicatedBytes) != 20 || !*(out.Consume) || *(out.IgnoreDot) {
+        t.Fatalf("unexpected statistics in the test summary; %v", out)
+    }
+}
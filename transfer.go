@@ -7,12 +7,26 @@ import (
     "errors"
     "context"
     "strconv"
+    "strings"
+    "io/fs"
 )
 
 type transferDirectoryOptions struct {
     Consume bool
+    LinkMode string
     IgnoreDot bool
+    Filter *pathFilter
     LinkWhitelist []string
+    Digests []string
+    Progress *progressTracker
+
+    // If provided, targets in other assets are locked while they are used for deduplication, see newLockingDeduplicationIndex().
+    // This should be set whenever the transfer is not a dry run.
+    Globals *globalConfiguration
+
+    // If true, no files are written to the registry, and the outcome for each file is recorded in 'Report'.
+    DryRun bool
+    Report *walkDirectoryReport
 }
 
 func deduplicateLatestKey(size int64, md5sum string) string {
@@ -21,7 +35,7 @@ func deduplicateLatestKey(size int64, md5sum string) string {
 
 func transferDirectory(source, registry, project, asset, version string, ctx context.Context, throttle *concurrencyThrottle, options transferDirectoryOptions) error {
     // Loading the latest version's metadata into a deduplication index.
-    // There's no need to check for probational versions here as only non-probational versions ever make it into '..latest'.
+    // There's no need to check for probational or deprecated versions here as they never make it into '..latest'.
     var last_dedup map[string]*linkMetadata
     asset_dir := filepath.Join(registry, project, asset)
     latest_path := filepath.Join(asset_dir, latestFileName)
@@ -60,6 +74,15 @@ func transferDirectory(source, registry, project, asset, version string, ctx con
         return fmt.Errorf("failed to stat '" + latest_path + "; %w", err)
     }
 
+    dedup := newDeduplicationIndex(registry)
+    if options.Globals != nil && !options.DryRun {
+        dedup = newLockingDeduplicationIndex(registry, project, asset, options.Globals, ctx)
+    }
+
+    // Locks on the deduplication targets are held until the link files are written,
+    // so that the new version's links are visible to rerouting before the targets can be deleted.
+    defer dedup.Unlock()
+
     manifest, err := walkDirectory(
         source,
         registry,
@@ -71,16 +94,27 @@ func transferDirectory(source, registry, project, asset, version string, ctx con
         walkDirectoryOptions{
             Mode: WalkDirectoryTransfer,
             Consume: options.Consume,
+            LinkMode: options.LinkMode,
             DeduplicateLatest: last_dedup,
+            DeduplicateRegistry: dedup,
             RestoreLinkParent: nil,
             IgnoreDot: options.IgnoreDot,
+            Filter: options.Filter,
             LinkWhitelist: options.LinkWhitelist,
+            Digests: options.Digests,
+            Progress: options.Progress,
+            DryRun: options.DryRun,
+            Report: options.Report,
         },
     )
     if err != nil {
         return err
     }
 
+    if options.DryRun {
+        return nil
+    }
+
     destination := filepath.Join(asset_dir, version)
     manifest_path := filepath.Join(destination, manifestFileName)
     err = dumpJson(manifest_path, &manifest)
@@ -95,3 +129,56 @@ func transferDirectory(source, registry, project, asset, version string, ctx con
 
     return nil
 }
+
+// Upper bound on the storage consumed by transferring 'source', i.e., the total size of all regular files that would be transferred.
+// This is cheap as it does not compute any checksums, but it ignores deduplication so the actual usage may be lower.
+// Symbolic links are not counted as they will usually become links in the registry.
+func estimateTransferUsage(source string, ignore_dot bool, filter *pathFilter) (int64, error) {
+    var total int64
+    err := filepath.WalkDir(source, func(src_path string, info fs.DirEntry, err error) error {
+        if err != nil {
+            return fmt.Errorf("failed to walk into %q; %w", src_path, err)
+        }
+
+        // Same skipping logic as in walkDirectory().
+        base := filepath.Base(src_path)
+        if strings.HasPrefix(base, ".") {
+            if ignore_dot || strings.HasPrefix(base, "..") {
+                if info.IsDir() {
+                    return filepath.SkipDir
+                } else {
+                    return nil
+                }
+            }
+        }
+
+        rel_path, err := filepath.Rel(source, src_path)
+        if err != nil {
+            return fmt.Errorf("failed to convert %q into a relative path; %w", src_path, err);
+        }
+
+        if filter != nil && rel_path != "." {
+            if filter.Excludes(rel_path, info.IsDir()) {
+                if info.IsDir() {
+                    return filepath.SkipDir
+                } else {
+                    return nil
+                }
+            }
+            if !info.IsDir() && !filter.Includes(rel_path) {
+                return nil
+            }
+        }
+
+        if !info.Type().IsRegular() {
+            return nil
+        }
+        restat, err := info.Info()
+        if err != nil {
+            return fmt.Errorf("failed to stat %q; %w", src_path, err)
+        }
+        total += restat.Size()
+        return nil
+    })
+    return total, err
+}
@@ -1293,3 +1293,66 @@ func TestTransferDirectoryExternalLinks(t *testing.T) {
     })
 }
 
+func TestEstimateTransferUsage(t *testing.T) {
+    src, err := os.MkdirTemp("", "")
+    if err != nil {
+        t.Fatalf("failed to create the source directory; %v", err)
+    }
+
+    err = os.WriteFile(filepath.Join(src, "foo"), []byte("abcde"), 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = os.Mkdir(filepath.Join(src, "bar"), 0755)
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = os.WriteFile(filepath.Join(src, "bar", "whee.txt"), []byte("1234567890"), 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = os.WriteFile(filepath.Join(src, ".hidden"), []byte("xyz"), 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = os.WriteFile(filepath.Join(src, "..internal"), []byte("xyz"), 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = os.Symlink("foo", filepath.Join(src, "link"))
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    total, err := estimateTransferUsage(src, false, nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if total != 18 {
+        t.Errorf("unexpected estimate %d for the entire directory", total)
+    }
+
+    total, err = estimateTransferUsage(src, true, nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if total != 15 {
+        t.Errorf("unexpected estimate %d when ignoring dotfiles", total)
+    }
+
+    total, err = estimateTransferUsage(src, true, &pathFilter{ Include: []string{ "*.txt" } })
+    if err != nil {
+        t.Fatal(err)
+    }
+    if total != 10 {
+        t.Errorf("unexpected estimate %d with inclusion filters", total)
+    }
+
+    total, err = estimateTransferUsage(src, true, &pathFilter{ Exclude: []string{ "bar/" } })
+    if err != nil {
+        t.Fatal(err)
+    }
+    if total != 5 {
+        t.Errorf("unexpected estimate %d with exclusion filters", total)
+    }
+}
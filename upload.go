@@ -9,6 +9,7 @@ import (
     "errors"
     "net/http"
     "context"
+    "log"
 )
 
 type uploadRequest struct {
@@ -18,8 +19,13 @@ type uploadRequest struct {
     Version *string `json:"version"`
     OnProbation *bool `json:"on_probation"`
     Consume *bool `json:"consume"`
+    LinkMode *string `json:"link_mode"`
     IgnoreDot *bool `json:"ignore_dot"`
+    Include []string `json:"include"`
+    Exclude []string `json:"exclude"`
+    Metadata map[string]interface{} `json:"metadata"`
     User string `json:"-"`
+    RequestUser string `json:"-"`
     Spoof *string `json:"spoof"`
 }
 
@@ -90,137 +96,246 @@ func uploadPreflight(reqpath string, globals *globalConfiguration) (*uploadReque
         return nil, newHttpError(http.StatusBadRequest, fmt.Errorf("expected a 'version' property in %q", reqpath))
     }
     version := *(request.Version)
-    err = isBadName(version)
+    err = isBadNewVersionName(version)
     if err != nil {
         return nil, newHttpError(http.StatusBadRequest, fmt.Errorf("invalid version name %q; %w", version, err))
     }
 
+    if request.LinkMode != nil {
+        err := isBadLinkMode(*(request.LinkMode))
+        if err != nil {
+            return nil, newHttpError(http.StatusBadRequest, fmt.Errorf("invalid 'link_mode' in %q; %w", reqpath, err))
+        }
+    }
+
+    err = checkGlobPatterns(request.Include)
+    if err != nil {
+        return nil, newHttpError(http.StatusBadRequest, fmt.Errorf("invalid 'include' in %q; %w", reqpath, err))
+    }
+    err = checkGlobPatterns(request.Exclude)
+    if err != nil {
+        return nil, newHttpError(http.StatusBadRequest, fmt.Errorf("invalid 'exclude' in %q; %w", reqpath, err))
+    }
+
     request.User = req_user
+    request.RequestUser = req_user
+    if request.Spoof != nil {
+        real_user, err := identifyUser(reqpath)
+        if err != nil {
+            return nil, fmt.Errorf("failed to find owner of %q; %w", reqpath, err)
+        }
+        request.RequestUser = real_user
+    }
+
     return &request, nil
 }
 
-func uploadHandler(reqpath string, globals *globalConfiguration, ctx context.Context) error {
-    upload_start := time.Now()
+// Locks and destinations for the addition of a new version to the registry, see prepareNewVersion().
+type newVersionTarget struct {
+    ProjectDir string
+    AssetDir string
+    VersionDir string
+    OnProbation bool
+    Locks []*directoryLock
+}
 
-    request, err := uploadPreflight(reqpath, globals)
-    if err != nil {
-        return err
+func (n *newVersionTarget) Unlock(globals *globalConfiguration) {
+    for i := len(n.Locks) - 1; i >= 0; i-- {
+        n.Locks[i].Unlock(globals)
     }
+}
 
-    req_user := request.User
-    on_probation := request.OnProbation != nil && *(request.OnProbation)
+// Acquires the locks for adding 'version' to 'asset' in 'project', and checks that 'user' is authorized to upload it.
+// If 'create = true', an exclusive lock is held on the asset directory, which is created if it does not already exist.
+// Otherwise, only shared locks are acquired and nothing is modified in the registry, e.g., for dry runs.
+// On success, the caller is responsible for calling Unlock() on the output; on failure, all locks are released before returning.
+func prepareNewVersion(
+    project string,
+    asset string,
+    version string,
+    user string,
+    request_user string,
+    on_probation bool,
+    create bool,
+    globals *globalConfiguration,
+    ctx context.Context,
+) (*newVersionTarget, error) {
+    output := &newVersionTarget{ OnProbation: on_probation }
+    success := false
+    defer func() {
+        if !success {
+            output.Unlock(globals)
+        }
+    }()
 
     rlock, err := lockDirectoryShared(globals.Registry, globals, ctx)
     if err != nil {
-        return fmt.Errorf("failed to lock the registry %q; %w", globals.Registry, err)
+        return nil, fmt.Errorf("failed to lock the registry %q; %w", globals.Registry, err)
     }
-    defer rlock.Unlock(globals)
+    output.Locks = append(output.Locks, rlock)
 
     rnnlock, err := lockDirectoryNewDirShared(globals.Registry, globals, ctx)
     if err != nil {
-        return fmt.Errorf("failed to lock the registry %q; %w", globals.Registry, err)
+        return nil, fmt.Errorf("failed to lock the registry %q; %w", globals.Registry, err)
     }
-    defer rnnlock.Unlock(globals)
+    output.Locks = append(output.Locks, rnnlock)
 
-    project := *(request.Project)
     project_dir := filepath.Join(globals.Registry, project)
     if err := checkProjectExists(project_dir, project); err != nil {
-        return err
+        return nil, err
     }
     rnnlock.Unlock(globals) // no need for this thing once we know the project directory exists.
+    output.ProjectDir = project_dir
 
     plock, err := lockDirectoryShared(project_dir, globals, ctx)
     if err != nil {
-        return fmt.Errorf("failed to lock project directory %q; %w", project_dir, err)
+        return nil, fmt.Errorf("failed to lock project directory %q; %w", project_dir, err)
     }
-    defer plock.Unlock(globals)
+    output.Locks = append(output.Locks, plock)
 
     // Acquiring an exclusive lock just in case we need to create the asset directory.
-    pnnlock, err := lockDirectoryNewDirExclusive(project_dir, globals, ctx)
+    var pnnlock *directoryLock
+    if create {
+        pnnlock, err = lockDirectoryNewDirExclusive(project_dir, globals, ctx)
+    } else {
+        pnnlock, err = lockDirectoryNewDirShared(project_dir, globals, ctx)
+    }
     if err != nil {
-        return fmt.Errorf("failed to lock project directory %q; %w", project_dir, err)
+        return nil, fmt.Errorf("failed to lock project directory %q; %w", project_dir, err)
     }
-    defer pnnlock.Unlock(globals)
+    output.Locks = append(output.Locks, pnnlock)
 
     perms, err := readPermissions(project_dir)
     if err != nil {
-        return fmt.Errorf("failed to read permissions for %q; %w", project, err)
+        return nil, fmt.Errorf("failed to read permissions for %q; %w", project, err)
     }
 
-    asset := *(request.Asset)
     asset_dir := filepath.Join(project_dir, asset)
+    output.AssetDir = asset_dir
     asset_exists := false
     _, err = os.Stat(asset_dir)
     if err == nil {
         asset_exists = true
     } else if !errors.Is(err, os.ErrNotExist) {
-        return fmt.Errorf("failed to stat asset directory %q; %w", asset_dir, err)
+        return nil, fmt.Errorf("failed to stat asset directory %q; %w", asset_dir, err)
     }
 
     if asset_exists {
-        alock, err := lockDirectoryExclusive(asset_dir, globals, ctx)
+        var alock *directoryLock
+        if create {
+            alock, err = lockDirectoryExclusive(asset_dir, globals, ctx)
+        } else {
+            alock, err = lockDirectoryShared(asset_dir, globals, ctx)
+        }
         if err != nil {
-            return fmt.Errorf("failed to lock asset directory %q; %w", asset_dir, err)
+            return nil, fmt.Errorf("failed to lock asset directory %q; %w", asset_dir, err)
         }
-        defer alock.Unlock(globals)
+        output.Locks = append(output.Locks, alock)
 
         asset_perms, err := addAssetPermissionsForUpload(perms, asset_dir, asset)
         if err != nil {
-            return fmt.Errorf("failed to read permissions for asset %q in %q; %w", asset, project, err)
+            return nil, fmt.Errorf("failed to read permissions for asset %q in %q; %w", asset, project, err)
         }
 
-        ok, trusted := isAuthorizedToUpload(req_user, globals.Administrators, asset_perms, request.Asset, request.Version)
+        ok, trusted := isAuthorizedToUpload(user, globals.Administrators, asset_perms, &asset, &version)
         if !ok {
-            return newHttpError(http.StatusForbidden, fmt.Errorf("user '" + req_user + "' is not authorized to upload to '" + project + "'"))
+            return nil, newHttpError(http.StatusForbidden, fmt.Errorf("user '" + user + "' is not authorized to upload to '" + project + "'"))
         }
         if !trusted {
-            on_probation = true
+            output.OnProbation = true
         }
 
     } else {
         use_global_write := perms.GlobalWrite != nil && *(perms.GlobalWrite)
         if !use_global_write {
-            ok, trusted := isAuthorizedToUpload(req_user, globals.Administrators, perms, request.Asset, request.Version)
+            ok, trusted := isAuthorizedToUpload(user, globals.Administrators, perms, &asset, &version)
             if !ok {
-                return newHttpError(http.StatusForbidden, fmt.Errorf("user '" + req_user + "' is not authorized to upload to '" + project + "'"))
+                return nil, newHttpError(http.StatusForbidden, fmt.Errorf("user '" + user + "' is not authorized to upload to '" + project + "'"))
             }
             if !trusted {
-                on_probation = true
+                output.OnProbation = true
             }
         }
 
-        // Note that we have an implicit exclusive lock on the asset directory at this point.
-        // We hold the newdir lock on the project directory, so no other process can even enter the asset directory if they're following correct procedure.
-        err = os.Mkdir(asset_dir, 0755)
-        if err != nil {
-            return fmt.Errorf("failed to create a new asset directory inside %q; %w", asset_dir, err)
-        }
-
-        if use_global_write {
-            asset_permissions := &permissionsMetadata{ Owners: []string{ req_user } }
-            perm_path := filepath.Join(asset_dir, permissionsFileName)
-            err := dumpJson(perm_path, asset_permissions)
+        if create {
+            // Note that we have an implicit exclusive lock on the asset directory at this point.
+            // We hold the newdir lock on the project directory, so no other process can even enter the asset directory if they're following correct procedure.
+            err = os.Mkdir(asset_dir, 0755)
             if err != nil {
-                return fmt.Errorf("failed to create new permissions for asset %q in %q; %w", asset, project, err)
+                return nil, fmt.Errorf("failed to create a new asset directory inside %q; %w", asset_dir, err)
             }
-        }
 
-        alock, err := lockDirectoryExclusive(asset_dir, globals, ctx)
-        if err != nil {
-            return fmt.Errorf("failed to lock asset directory %q; %w", asset_dir, err)
+            if use_global_write {
+                asset_permissions := &permissionsMetadata{ Owners: []string{ user } }
+                perm_path := filepath.Join(asset_dir, permissionsFileName)
+                err := dumpJson(perm_path, asset_permissions)
+                if err != nil {
+                    return nil, fmt.Errorf("failed to create new permissions for asset %q in %q; %w", asset, project, err)
+                }
+                err = recordPermissionsChange(globals.Registry, project, &asset, permissionsSourceGlobalWrite, user, &request_user, nil, asset_permissions)
+                if err != nil {
+                    return nil, err
+                }
+            }
+
+            alock, err := lockDirectoryExclusive(asset_dir, globals, ctx)
+            if err != nil {
+                return nil, fmt.Errorf("failed to lock asset directory %q; %w", asset_dir, err)
+            }
+            output.Locks = append(output.Locks, alock)
         }
-        defer alock.Unlock(globals)
     }
 
-    pnnlock.Unlock(globals) // at this point, we have safely secured the asset directory, so we can release this lock.
+    if create {
+        pnnlock.Unlock(globals) // at this point, we have safely secured the asset directory, so we can release this lock.
+    }
 
-    version := *(request.Version)
     version_dir := filepath.Join(asset_dir, version)
+    output.VersionDir = version_dir
     _, err = os.Stat(version_dir)
     if err == nil {
-        return newHttpError(http.StatusBadRequest, fmt.Errorf("version %q already exists in %q", version, asset_dir))
+        return nil, newHttpError(http.StatusBadRequest, fmt.Errorf("version %q already exists in %q", version, asset_dir))
     } else if !errors.Is(err, os.ErrNotExist) {
-        return fmt.Errorf("failed to stat version directory %q; %w", version_dir, err)
+        return nil, fmt.Errorf("failed to stat version directory %q; %w", version_dir, err)
+    }
+
+    success = true
+    return output, nil
+}
+
+func uploadHandler(reqpath string, globals *globalConfiguration, ctx context.Context) (reportable_err error) {
+    upload_start := time.Now()
+
+    request, err := uploadPreflight(reqpath, globals)
+    if err != nil {
+        return err
+    }
+
+    progress := globals.Progress.Start(reqpath)
+    defer func() {
+        globals.Progress.Finish(progress, reportable_err)
+    }()
+
+    req_user := request.User
+    on_probation := request.OnProbation != nil && *(request.OnProbation)
+
+    project := *(request.Project)
+    asset := *(request.Asset)
+    version := *(request.Version)
+    prepared, err := prepareNewVersion(project, asset, version, req_user, request.RequestUser, on_probation, true, globals, ctx)
+    if err != nil {
+        return err
+    }
+    defer prepared.Unlock(globals)
+
+    on_probation = prepared.OnProbation
+    project_dir := prepared.ProjectDir
+    asset_dir := prepared.AssetDir
+    version_dir := prepared.VersionDir
+
+    err = checkUploadQuota(request, project_dir, globals, ctx)
+    if err != nil {
+        return err
     }
 
     err = os.Mkdir(version_dir, 0755)
@@ -236,6 +351,11 @@ func uploadHandler(reqpath string, globals *globalConfiguration, ctx context.Con
         }
     }()
 
+    link_mode := linkModeCopy
+    if request.LinkMode != nil {
+        link_mode = *(request.LinkMode)
+    }
+
     source := *(request.Source)
     err = transferDirectory(
         source,
@@ -247,32 +367,57 @@ func uploadHandler(reqpath string, globals *globalConfiguration, ctx context.Con
         globals.ConcurrencyThrottle,
         transferDirectoryOptions{
             Consume: (request.Consume != nil && *(request.Consume)),
+            LinkMode: link_mode,
             IgnoreDot: (request.IgnoreDot != nil && *(request.IgnoreDot)),
+            Filter: &pathFilter{ Include: request.Include, Exclude: request.Exclude },
             LinkWhitelist: globals.LinkWhitelist,
+            Digests: globals.Digests,
+            Progress: progress,
+            Globals: globals,
         },
     )
     if err != nil {
         return fmt.Errorf("failed to transfer files from %q; %w", source, err)
     }
+    progress.SetPass(progressFinalizing)
 
-    upload_finish := time.Now()
-    {
-        summary := summaryMetadata {
-            UploadUserId: req_user,
-            UploadStart: upload_start.Format(time.RFC3339),
-            UploadFinish: upload_finish.Format(time.RFC3339),
-        }
-        if on_probation {
-            summary.OnProbation = &on_probation
-        }
+    manifest, err := readManifest(version_dir)
+    if err != nil {
+        return fmt.Errorf("failed to read the manifest for %q; %w", version_dir, err)
+    }
 
-        summary_path := filepath.Join(version_dir, summaryFileName)
-        err := dumpJson(summary_path, &summary)
+    if request.Metadata != nil {
+        metadata_path := filepath.Join(version_dir, versionMetadataFileName)
+        err := dumpJson(metadata_path, request.Metadata)
         if err != nil {
-            return fmt.Errorf("failed to save summary for %q; %w", asset_dir, err)
+            return fmt.Errorf("failed to save version metadata for %q; %w", version_dir, err)
         }
     }
 
+    upload_finish := time.Now()
+    summary := summaryMetadata {
+        UploadUserId: req_user,
+        UploadStart: upload_start.Format(time.RFC3339),
+        UploadFinish: upload_finish.Format(time.RFC3339),
+    }
+    if on_probation {
+        summary.OnProbation = &on_probation
+    }
+    summary.Include = request.Include
+    summary.Exclude = request.Exclude
+
+    consume := request.Consume != nil && *(request.Consume)
+    ignore_dot := request.IgnoreDot != nil && *(request.IgnoreDot)
+    summary.Consume = &consume
+    summary.IgnoreDot = &ignore_dot
+    summary.AddProvenance(reqpath, request.RequestUser, manifest, globals)
+
+    summary_path := filepath.Join(version_dir, summaryFileName)
+    err = dumpJson(summary_path, &summary)
+    if err != nil {
+        return fmt.Errorf("failed to save summary for %q; %w", asset_dir, err)
+    }
+
     extra_usage, err := computeVersionUsage(version_dir)
     if err != nil {
         return fmt.Errorf("failed to compute usage for the new version at %q; %w", version_dir, err)
@@ -284,9 +429,8 @@ func uploadHandler(reqpath string, globals *globalConfiguration, ctx context.Con
     }
 
     if !on_probation {
-        latest := latestMetadata { Version: version }
-        latest_path := filepath.Join(asset_dir, latestFileName)
-        err := dumpJson(latest_path, &latest)
+        // Any new upload is the most recent, so it replaces an existing latest version with the same upload time.
+        is_latest, err := updateLatest(asset_dir, version, &summary, true)
         if err != nil {
             return fmt.Errorf("failed to save latest version for %q; %w", asset_dir, err)
         }
@@ -297,7 +441,7 @@ func uploadHandler(reqpath string, globals *globalConfiguration, ctx context.Con
             "project": project,
             "asset": asset,
             "version": version,
-            "latest": true,
+            "latest": is_latest,
         }
         err = dumpLog(globals.Registry, log_info)
         if err != nil {
@@ -306,5 +450,130 @@ func uploadHandler(reqpath string, globals *globalConfiguration, ctx context.Con
     }
 
     has_failed = false
+
+    // Updating the index last, so that it never contains an entry for a version that is subsequently removed on failure.
+    // Errors are not fatal as the version has already been committed, and the index is rebuilt by refreshing the latest version.
+    err = updateVersionIndex(asset_dir, version)
+    if err != nil {
+        log.Printf("failed to update the version index for %q; %v", version_dir, err)
+    }
+
+    // The deduplication index is only updated once nothing else can fail, so that it never refers to a version that is subsequently removed.
+    // Errors are not fatal as the index is only a performance optimization, i.e., missing entries just mean that some future uploads are not deduplicated.
+    if !on_probation {
+        err = addVersionToDeduplicationIndex(globals.Registry, project, asset, version, manifest)
+        if err != nil {
+            log.Printf("failed to update the deduplication index for %q; %v", version_dir, err)
+        }
+    }
+
     return nil
 }
+
+// Reports what would happen to each file in the upload, without writing anything to the registry.
+func dryRunUpload(request *uploadRequest, globals *globalConfiguration, ctx context.Context) (*walkDirectoryReport, error) {
+    report := newWalkDirectoryReport()
+    source := *(request.Source)
+    err := transferDirectory(
+        source,
+        globals.Registry,
+        *(request.Project),
+        *(request.Asset),
+        *(request.Version),
+        ctx,
+        globals.ConcurrencyThrottle,
+        transferDirectoryOptions{
+            IgnoreDot: (request.IgnoreDot != nil && *(request.IgnoreDot)),
+            Filter: &pathFilter{ Include: request.Include, Exclude: request.Exclude },
+            LinkWhitelist: globals.LinkWhitelist,
+            Digests: globals.Digests,
+            DryRun: true,
+            Report: report,
+        },
+    )
+    if err != nil {
+        return nil, fmt.Errorf("failed to inspect files in %q; %w", source, err)
+    }
+    return report, nil
+}
+
+// Rejects uploads that would exceed the quota before any files are transferred, rather than copying everything and then rolling it back.
+// A cheap upper bound on the usage is checked first, and the exact usage (accounting for deduplication) is only computed with a dry run if the bound exceeds the quota.
+// The quota is checked again in editUsage() after the transfer, as the project usage may have changed in the meantime.
+func checkUploadQuota(request *uploadRequest, project_dir string, globals *globalConfiguration, ctx context.Context) error {
+    usage, err := readUsage(project_dir)
+    if err != nil {
+        return fmt.Errorf("failed to read existing usage for %q; %w", project_dir, err)
+    }
+
+    source := *(request.Source)
+    bound, err := estimateTransferUsage(source, request.IgnoreDot != nil && *(request.IgnoreDot), &pathFilter{ Include: request.Include, Exclude: request.Exclude })
+    if err != nil {
+        return fmt.Errorf("failed to inspect files in %q; %w", source, err)
+    }
+
+    err = checkQuota(project_dir, usage, bound)
+    var http_err *httpError
+    if err == nil || !errors.As(err, &http_err) {
+        return err
+    }
+
+    report, err := dryRunUpload(request, globals, ctx)
+    if err != nil {
+        return err
+    }
+    return checkQuota(project_dir, usage, report.Usage)
+}
+
+type uploadDryRunResult struct {
+    OnProbation bool `json:"on_probation"`
+    UsageDelta int64 `json:"usage_delta"`
+    ExceedsQuota bool `json:"exceeds_quota"`
+    Files *walkDirectoryReport `json:"files"`
+}
+
+func preflightUploadHandler(reqpath string, globals *globalConfiguration, ctx context.Context) (*uploadDryRunResult, error) {
+    request, err := uploadPreflight(reqpath, globals)
+    if err != nil {
+        return nil, err
+    }
+
+    req_user := request.User
+    on_probation := request.OnProbation != nil && *(request.OnProbation)
+
+    // Only acquiring shared locks throughout, as we don't modify anything in the registry.
+    prepared, err := prepareNewVersion(*(request.Project), *(request.Asset), *(request.Version), req_user, request.RequestUser, on_probation, false, globals, ctx)
+    if err != nil {
+        return nil, err
+    }
+    defer prepared.Unlock(globals)
+
+    on_probation = prepared.OnProbation
+    project_dir := prepared.ProjectDir
+
+    report, err := dryRunUpload(request, globals, ctx)
+    if err != nil {
+        return nil, err
+    }
+
+    usage, err := readUsage(project_dir)
+    if err != nil {
+        return nil, fmt.Errorf("failed to read existing usage for %q; %w", project_dir, err)
+    }
+    exceeds_quota := false
+    err = checkQuota(project_dir, usage, report.Usage)
+    if err != nil {
+        var http_err *httpError
+        if !errors.As(err, &http_err) {
+            return nil, err
+        }
+        exceeds_quota = true
+    }
+
+    return &uploadDryRunResult{
+        OnProbation: on_probation,
+        UsageDelta: report.Usage,
+        ExceedsQuota: exceeds_quota,
+        Files: report,
+    }, nil
+}
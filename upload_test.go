@@ -126,6 +126,23 @@ func TestUploadHandlerSimple(t *testing.T) {
         t.Fatal("no probation property should be present")
     }
 
+    // Checking the provenance.
+    if summ.RequestUserId != "" {
+        t.Fatal("no requesting user should be present without spoofing")
+    }
+    if summ.Instance != globals.Instance || summ.RequestFile != filepath.Base(reqname) {
+        t.Fatalf("unexpected provenance in the summary; %v", summ)
+    }
+    if summ.FileCount == nil || *(summ.FileCount) != 2 || summ.TotalBytes == nil || *(summ.TotalBytes) != int64(len("haunter") + len("lick,confuse_ray,shadow_ball,dream_eater")) {
+        t.Fatalf("unexpected file statistics in the summary; %v", summ)
+    }
+    if summ.DeduplicatedBytes == nil || *(summ.DeduplicatedBytes) != 0 {
+        t.Fatalf("unexpected deduplicated bytes in the summary; %v", summ)
+    }
+    if summ.Consume == nil || *(summ.Consume) || summ.IgnoreDot == nil || *(summ.IgnoreDot) {
+        t.Fatalf("unexpected options in the summary; %v", summ)
+    }
+
     // Checking out the usage.
     project_dir := filepath.Join(reg, project)
     used, err := readUsage(project_dir)
@@ -346,6 +363,101 @@ func TestUploadHandlerSimpleFailures(t *testing.T) {
         if err == nil || !strings.Contains(err.Error(), "invalid version name") {
             t.Fatal("configuration should fail for invalid version name")
         }
+
+        req_string = fmt.Sprintf(`{ "source": "%s", "project": "foo", "asset": "bar", "version": "@stable" }`, filepath.Base(src))
+        reqname, err = dumpRequest("upload", req_string)
+        if err != nil {
+            t.Fatalf("failed to create upload request; %v", err)
+        }
+        err = uploadHandler(reqname, &globals, ctx)
+        if err == nil || !strings.Contains(err.Error(), "cannot start with") {
+            t.Fatal("configuration should fail for a version name that looks like an alias")
+        }
+    })
+
+    t.Run("failed latest", func(t *testing.T) {
+        project := "test_latest"
+        asset := "gastly"
+        err := setupProjectForUploadTest(project, &globals)
+        if err != nil {
+            t.Fatalf("failed to set up project directory; %v", err)
+        }
+
+        req_string := fmt.Sprintf(`{ "source": "%s", "project": "%s", "asset": "%s", "version": "lavender" }`, filepath.Base(src), project, asset)
+        reqname, err := dumpRequest("upload", req_string)
+        if err != nil {
+            t.Fatalf("failed to create upload request; %v", err)
+        }
+        err = uploadHandler(reqname, &globals, ctx)
+        if err != nil {
+            t.Fatalf("failed to perform the upload; %v", err)
+        }
+
+        // Corrupting the latest policy so that the next upload fails when updating the latest version.
+        asset_dir := filepath.Join(reg, project, asset)
+        err = os.WriteFile(filepath.Join(asset_dir, latestPolicyFileName), []byte("{"), 0644)
+        if err != nil {
+            t.Fatal(err)
+        }
+
+        req_string = fmt.Sprintf(`{ "source": "%s", "project": "%s", "asset": "%s", "version": "cerulean" }`, filepath.Base(src), project, asset)
+        reqname, err = dumpRequest("upload", req_string)
+        if err != nil {
+            t.Fatalf("failed to create upload request; %v", err)
+        }
+        err = uploadHandler(reqname, &globals, ctx)
+        if err == nil || !strings.Contains(err.Error(), "latest") {
+            t.Fatalf("expected the upload to fail when updating the latest version; %v", err)
+        }
+
+        if _, err := os.Stat(filepath.Join(asset_dir, "cerulean")); err == nil {
+            t.Fatal("failed uploads should not leave a version directory")
+        }
+        index, err := readVersionIndex(asset_dir)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if _, ok := index["cerulean"]; ok || len(index) != 1 {
+            t.Fatalf("failed uploads should not be present in the version index; %v", index)
+        }
+    })
+
+    t.Run("failed index", func(t *testing.T) {
+        project := "test_index"
+        asset := "haunter"
+        err := setupProjectForUploadTest(project, &globals)
+        if err != nil {
+            t.Fatalf("failed to set up project directory; %v", err)
+        }
+
+        asset_dir := filepath.Join(reg, project, asset)
+        err = os.Mkdir(asset_dir, 0755)
+        if err != nil {
+            t.Fatal(err)
+        }
+        err = os.WriteFile(filepath.Join(asset_dir, versionsFileName), []byte("{"), 0644)
+        if err != nil {
+            t.Fatal(err)
+        }
+
+        req_string := fmt.Sprintf(`{ "source": "%s", "project": "%s", "asset": "%s", "version": "saffron" }`, filepath.Base(src), project, asset)
+        reqname, err := dumpRequest("upload", req_string)
+        if err != nil {
+            t.Fatalf("failed to create upload request; %v", err)
+        }
+        err = uploadHandler(reqname, &globals, ctx)
+        if err != nil {
+            t.Fatalf("failures to update the version index should not be fatal; %v", err)
+        }
+
+        // The version should still be committed.
+        latest, err := readLatest(asset_dir)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if latest.Version != "saffron" {
+            t.Fatalf("expected the upload to be the latest version; %v", latest)
+        }
     })
 }
 
@@ -596,6 +708,14 @@ func TestUploadHandlerSpoof(t *testing.T) {
     if err != nil {
         t.Fatalf("failed to read the manifest; %v", err)
     }
+
+    summ, err := readSummary(filepath.Join(reg, project, asset, version))
+    if err != nil {
+        t.Fatalf("failed to read the summary; %v", err)
+    }
+    if summ.UploadUserId != "serena" || summ.RequestUserId != self.Username {
+        t.Fatalf("unexpected users in the summary; %v", summ)
+    }
 }
 
 func TestUploadHandlerGlobalWrite(t *testing.T) {
@@ -649,6 +769,14 @@ func TestUploadHandlerGlobalWrite(t *testing.T) {
             t.Fatalf("global write upload did not update the uploaders; %v", err)
         }
 
+        history, err := readPermissionsHistory(project_dir)
+        if err != nil {
+            t.Fatalf("failed to read the permissions history; %v", err)
+        }
+        if len(history) != 1 || history[0].Source != "global_write" || history[0].Asset == nil || *(history[0].Asset) != asset || history[0].User != self.Username {
+            t.Fatalf("unexpected permissions history after a global write; %v", history)
+        }
+
         // Check that the upload completed.
         destination := filepath.Join(reg, project, asset, version)
         man, err := readManifest(destination)
@@ -1000,6 +1128,79 @@ func TestUploadHandlerConsume(t *testing.T) {
     })
 }
 
+func TestUploadHandlerLinkMode(t *testing.T) {
+    project := "pokemon"
+    asset := "gastly"
+    version := "lavender"
+
+    reg, err := constructMockRegistry()
+    if err != nil {
+        t.Fatalf("failed to create the registry; %v", err)
+    }
+    globals := newGlobalConfiguration(reg, 2)
+
+    err = setupProjectForUploadTest(project, &globals)
+    if err != nil {
+        t.Fatalf("failed to set up project directory; %v", err)
+    }
+
+    src, err := setupSourceForUploadTest()
+    if err != nil {
+        t.Fatalf("failed to set up test directories; %v", err)
+    }
+
+    ctx := context.Background()
+
+    t.Run("invalid", func(t *testing.T) {
+        req_string := fmt.Sprintf(`{ "source": "%s", "project": "%s", "asset": "%s", "version": %q, "link_mode": "symlink" }`, filepath.Base(src), project, asset, version)
+        reqname, err := dumpRequest("upload", req_string)
+        if err != nil {
+            t.Fatalf("failed to create upload request; %v", err)
+        }
+
+        err = uploadHandler(reqname, &globals, ctx)
+        if err == nil || !strings.Contains(err.Error(), "link_mode") {
+            t.Fatalf("expected upload to fail with an invalid link mode; %v", err)
+        }
+    })
+
+    t.Run("hardlink", func(t *testing.T) {
+        req_string := fmt.Sprintf(`{ "source": "%s", "project": "%s", "asset": "%s", "version": %q, "link_mode": "hardlink" }`, filepath.Base(src), project, asset, version)
+        reqname, err := dumpRequest("upload", req_string)
+        if err != nil {
+            t.Fatalf("failed to create upload request; %v", err)
+        }
+
+        err = uploadHandler(reqname, &globals, ctx)
+        if err != nil {
+            t.Fatalf("failed to perform the upload; %v", err)
+        }
+
+        destination := filepath.Join(reg, project, asset, version)
+        err = verifyFileContents(filepath.Join(destination, "moves"), "lick,confuse_ray,shadow_ball,dream_eater")
+        if err != nil {
+            t.Errorf("could not verify 'moves' in the registry; %v", err)
+        }
+
+        src_info, err := os.Stat(filepath.Join(src, "moves"))
+        if err != nil {
+            t.Fatal(err)
+        }
+        dest_info, err := os.Stat(filepath.Join(destination, "moves"))
+        if err != nil {
+            t.Fatal(err)
+        }
+        if !os.SameFile(src_info, dest_info) {
+            t.Errorf("expected 'moves' to be hard linked into the registry")
+        }
+
+        report := globals.Progress.Get(filepath.Base(reqname))
+        if report == nil || report.LinkFallbacks != 0 {
+            t.Errorf("expected no fallbacks to copies; %v", report)
+        }
+    })
+}
+
 func TestUploadHandlerIgnoreDot(t *testing.T) {
     project := "pokemon"
     asset := "gastly"
@@ -1065,3 +1266,506 @@ func TestUploadHandlerIgnoreDot(t *testing.T) {
         }
     })
 }
+
+func TestUploadHandlerFilter(t *testing.T) {
+    project := "original_series"
+    asset := "gastly"
+    version := "lavender"
+
+    reg, err := constructMockRegistry()
+    if err != nil {
+        t.Fatalf("failed to create the registry; %v", err)
+    }
+    globals := newGlobalConfiguration(reg, 2)
+
+    err = setupProjectForUploadTest(project, &globals)
+    if err != nil {
+        t.Fatalf("failed to set up project directory; %v", err)
+    }
+
+    src, err := setupSourceForUploadTest()
+    if err != nil {
+        t.Fatalf("failed to set up test directories; %v", err)
+    }
+    err = os.WriteFile(filepath.Join(src, "scratch.tmp"), []byte("foo"), 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = os.Mkdir(filepath.Join(src, "__pycache__"), 0755)
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = os.WriteFile(filepath.Join(src, "__pycache__", "evolution"), []byte("bar"), 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    ctx := context.Background()
+
+    t.Run("invalid", func(t *testing.T) {
+        req_string := fmt.Sprintf(`{ "source": "%s", "project": "%s", "asset": "%s", "version": "%s", "exclude": [ "[" ] }`, filepath.Base(src), project, asset, version)
+        reqname, err := dumpRequest("upload", req_string)
+        if err != nil {
+            t.Fatalf("failed to create upload request; %v", err)
+        }
+        err = uploadHandler(reqname, &globals, ctx)
+        if err == nil || !strings.Contains(err.Error(), "invalid 'exclude'") {
+            t.Fatalf("expected upload to fail with an invalid pattern; %v", err)
+        }
+    })
+
+    t.Run("success", func(t *testing.T) {
+        req_string := fmt.Sprintf(`{ "source": "%s", "project": "%s", "asset": "%s", "version": "%s", "include": [ "evolution", "*.tmp" ], "exclude": [ "__pycache__/", "scratch.*" ] }`, filepath.Base(src), project, asset, version)
+        reqname, err := dumpRequest("upload", req_string)
+        if err != nil {
+            t.Fatalf("failed to create upload request; %v", err)
+        }
+        err = uploadHandler(reqname, &globals, ctx)
+        if err != nil {
+            t.Fatalf("failed to perform the upload; %v", err)
+        }
+
+        destination := filepath.Join(reg, project, asset, version)
+        man, err := readManifest(destination)
+        if err != nil {
+            t.Fatalf("failed to read the manifest; %v", err)
+        }
+        if len(man) != 1 {
+            t.Fatalf("expected only one file in the manifest; %v", man)
+        }
+        if _, ok := man["evolution"]; !ok {
+            t.Fatal("expected 'evolution' to be included in the manifest")
+        }
+        if _, err := os.Stat(filepath.Join(destination, "__pycache__")); !errors.Is(err, os.ErrNotExist) {
+            t.Fatal("expected excluded directory to be skipped")
+        }
+
+        summ, err := readSummary(destination)
+        if err != nil {
+            t.Fatalf("failed to read the summary; %v", err)
+        }
+        if len(summ.Include) != 2 || summ.Include[1] != "*.tmp" || len(summ.Exclude) != 2 || summ.Exclude[0] != "__pycache__/" {
+            t.Fatalf("expected filters to be recorded in the summary; %v", summ)
+        }
+    })
+}
+
+func TestUploadHandlerMetadata(t *testing.T) {
+    project := "original_series"
+    asset := "gastly"
+
+    reg, err := constructMockRegistry()
+    if err != nil {
+        t.Fatalf("failed to create the registry; %v", err)
+    }
+    globals := newGlobalConfiguration(reg, 2)
+
+    err = setupProjectForUploadTest(project, &globals)
+    if err != nil {
+        t.Fatalf("failed to set up project directory; %v", err)
+    }
+
+    src, err := setupSourceForUploadTest()
+    if err != nil {
+        t.Fatalf("failed to set up test directories; %v", err)
+    }
+
+    ctx := context.Background()
+
+    t.Run("present", func(t *testing.T) {
+        version := "lavender"
+        req_string := fmt.Sprintf(`{ "source": "%s", "project": "%s", "asset": "%s", "version": "%s", "metadata": { "description": "spooky", "run": 42 } }`, filepath.Base(src), project, asset, version)
+        reqname, err := dumpRequest("upload", req_string)
+        if err != nil {
+            t.Fatalf("failed to create upload request; %v", err)
+        }
+        err = uploadHandler(reqname, &globals, ctx)
+        if err != nil {
+            t.Fatalf("failed to perform the upload; %v", err)
+        }
+
+        metadata, err := readVersionMetadata(filepath.Join(reg, project, asset, version))
+        if err != nil {
+            t.Fatal(err)
+        }
+        if metadata["description"] != "spooky" || metadata["run"] != float64(42) {
+            t.Fatalf("unexpected version metadata; %v", metadata)
+        }
+    })
+
+    t.Run("absent", func(t *testing.T) {
+        version := "pallet"
+        req_string := fmt.Sprintf(`{ "source": "%s", "project": "%s", "asset": "%s", "version": "%s" }`, filepath.Base(src), project, asset, version)
+        reqname, err := dumpRequest("upload", req_string)
+        if err != nil {
+            t.Fatalf("failed to create upload request; %v", err)
+        }
+        err = uploadHandler(reqname, &globals, ctx)
+        if err != nil {
+            t.Fatalf("failed to perform the upload; %v", err)
+        }
+
+        if _, err := os.Stat(filepath.Join(reg, project, asset, version, versionMetadataFileName)); !errors.Is(err, os.ErrNotExist) {
+            t.Fatal("expected no metadata file when no metadata is supplied")
+        }
+    })
+
+    t.Run("invalid", func(t *testing.T) {
+        req_string := fmt.Sprintf(`{ "source": "%s", "project": "%s", "asset": "%s", "version": "cerulean", "metadata": [ 1, 2, 3 ] }`, filepath.Base(src), project, asset)
+        reqname, err := dumpRequest("upload", req_string)
+        if err != nil {
+            t.Fatalf("failed to create upload request; %v", err)
+        }
+        err = uploadHandler(reqname, &globals, ctx)
+        if err == nil || !strings.Contains(err.Error(), "failed to parse JSON") {
+            t.Fatalf("expected upload to fail with non-object metadata; %v", err)
+        }
+    })
+}
+
+func TestUploadHandlerQuota(t *testing.T) {
+    reg, err := constructMockRegistry()
+    if err != nil {
+        t.Fatalf("failed to create the registry; %v", err)
+    }
+    globals := newGlobalConfiguration(reg, 2)
+
+    src, err := setupSourceForUploadTest()
+    if err != nil {
+        t.Fatalf("failed to set up test directories; %v", err)
+    }
+
+    project := "original_series"
+    err = setupProjectForUploadTest(project, &globals)
+    if err != nil {
+        t.Fatalf("failed to set up project directory; %v", err)
+    }
+
+    project_dir := filepath.Join(reg, project)
+    err = dumpJson(filepath.Join(project_dir, quotaFileName), &quotaMetadata{ Baseline: 10, GrowthRate: 0, Year: time.Now().Year() })
+    if err != nil {
+        t.Fatalf("failed to write a new quota; %v", err)
+    }
+
+    ctx := context.Background()
+    asset := "gastly"
+    version := "lavender"
+    req_string := fmt.Sprintf(`{ "source": "%s", "project": "%s", "asset": "%s", "version": "%s" }`, filepath.Base(src), project, asset, version)
+    reqname, err := dumpRequest("upload", req_string)
+    if err != nil {
+        t.Fatalf("failed to create upload request; %v", err)
+    }
+
+    err = uploadHandler(reqname, &globals, ctx)
+    if err == nil || !strings.Contains(err.Error(), "exceed the quota") {
+        t.Fatalf("expected upload to fail when the quota is exceeded; %v", err)
+    }
+
+    // Checking that the failed upload was cleaned out and the usage was unchanged.
+    if _, err := os.Stat(filepath.Join(project_dir, asset, version)); err == nil || !errors.Is(err, os.ErrNotExist) {
+        t.Fatal("expected the failed upload to be removed")
+    }
+    used, err := readUsage(project_dir)
+    if err != nil {
+        t.Fatalf("failed to read the usage; %v", err)
+    }
+    if used.Total != 0 {
+        t.Fatalf("usage should not be changed by a failed upload")
+    }
+
+    // Works after raising the quota.
+    err = dumpJson(filepath.Join(project_dir, quotaFileName), &quotaMetadata{ Baseline: 1000, GrowthRate: 0, Year: time.Now().Year() })
+    if err != nil {
+        t.Fatalf("failed to write a new quota; %v", err)
+    }
+    reqname, err = dumpRequest("upload", req_string)
+    if err != nil {
+        t.Fatalf("failed to create upload request; %v", err)
+    }
+    err = uploadHandler(reqname, &globals, ctx)
+    if err != nil {
+        t.Fatalf("failed to upload within the quota; %v", err)
+    }
+
+    // Re-uploads are still allowed at the quota, as the pre-transfer check falls back to the exact usage after deduplication.
+    used, err = readUsage(project_dir)
+    if err != nil {
+        t.Fatalf("failed to read the usage; %v", err)
+    }
+    err = dumpJson(filepath.Join(project_dir, quotaFileName), &quotaMetadata{ Baseline: used.Total, GrowthRate: 0, Year: time.Now().Year() })
+    if err != nil {
+        t.Fatalf("failed to write a new quota; %v", err)
+    }
+    reqname, err = dumpRequest("upload", fmt.Sprintf(`{ "source": "%s", "project": "%s", "asset": "%s", "version": "%s" }`, filepath.Base(src), project, asset, "violet"))
+    if err != nil {
+        t.Fatalf("failed to create upload request; %v", err)
+    }
+    err = uploadHandler(reqname, &globals, ctx)
+    if err != nil {
+        t.Fatalf("failed to upload a deduplicated version at the quota; %v", err)
+    }
+
+    // Quotas are not enforced if explicitly disabled.
+    disabled := false
+    err = dumpJson(filepath.Join(project_dir, quotaFileName), &quotaMetadata{ Baseline: 0, GrowthRate: 0, Year: time.Now().Year(), Enforced: &disabled })
+    if err != nil {
+        t.Fatalf("failed to write a new quota; %v", err)
+    }
+    other_src, err := setupSourceForUploadTest()
+    if err != nil {
+        t.Fatalf("failed to set up test directories; %v", err)
+    }
+    err = os.WriteFile(filepath.Join(other_src, "extra"), []byte("something new"), 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+    reqname, err = dumpRequest("upload", fmt.Sprintf(`{ "source": "%s", "project": "%s", "asset": "%s", "version": "%s" }`, filepath.Base(other_src), project, asset, "indigo"))
+    if err != nil {
+        t.Fatalf("failed to create upload request; %v", err)
+    }
+    err = uploadHandler(reqname, &globals, ctx)
+    if err != nil {
+        t.Fatalf("failed to upload with an unenforced quota; %v", err)
+    }
+}
+
+func TestUploadHandlerDeduplicateRegistry(t *testing.T) {
+    reg, err := constructMockRegistry()
+    if err != nil {
+        t.Fatalf("failed to create the registry; %v", err)
+    }
+    globals := newGlobalConfiguration(reg, 2)
+
+    src, err := setupSourceForUploadTest()
+    if err != nil {
+        t.Fatalf("failed to set up test directories; %v", err)
+    }
+
+    ctx := context.Background()
+    for _, project := range []string{ "original_series", "gold_silver" } {
+        err = setupProjectForUploadTest(project, &globals)
+        if err != nil {
+            t.Fatalf("failed to set up project directory; %v", err)
+        }
+    }
+
+    req_string := fmt.Sprintf(`{ "source": "%s", "project": "original_series", "asset": "gastly", "version": "lavender" }`, filepath.Base(src))
+    reqname, err := dumpRequest("upload", req_string)
+    if err != nil {
+        t.Fatalf("failed to create upload request; %v", err)
+    }
+    err = uploadHandler(reqname, &globals, ctx)
+    if err != nil {
+        t.Fatalf("failed to perform the upload; %v", err)
+    }
+
+    // Same files in a different project are linked to the first upload.
+    req_string = fmt.Sprintf(`{ "source": "%s", "project": "gold_silver", "asset": "haunter", "version": "ecruteak" }`, filepath.Base(src))
+    reqname, err = dumpRequest("upload", req_string)
+    if err != nil {
+        t.Fatalf("failed to create upload request; %v", err)
+    }
+    err = uploadHandler(reqname, &globals, ctx)
+    if err != nil {
+        t.Fatalf("failed to perform the upload; %v", err)
+    }
+
+    destination := filepath.Join(reg, "gold_silver", "haunter", "ecruteak")
+    man, err := readManifest(destination)
+    if err != nil {
+        t.Fatalf("failed to read the manifest; %v", err)
+    }
+    err = verifyRegistrySymlink(man, destination, "evolution", "haunter", "original_series", "gastly", "lavender", "evolution", false)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    used, err := readUsage(filepath.Join(reg, "gold_silver"))
+    if err != nil {
+        t.Fatalf("failed to read the usage; %v", err)
+    }
+    if used.Total != 0 {
+        t.Fatalf("expected no usage for a fully deduplicated upload")
+    }
+
+    summ, err := readSummary(destination)
+    if err != nil {
+        t.Fatalf("failed to read the summary; %v", err)
+    }
+    if summ.TotalBytes == nil || summ.DeduplicatedBytes == nil || *(summ.TotalBytes) == 0 || *(summ.DeduplicatedBytes) != *(summ.TotalBytes) {
+        t.Fatalf("expected all bytes to be deduplicated; %v", summ)
+    }
+
+    // Deleting the first project removes its entries from the index.
+    self, err := user.Current()
+    if err != nil {
+        t.Fatalf("failed to determine the current user; %v", err)
+    }
+    globals.Administrators = append(globals.Administrators, self.Username)
+
+    reqname, err = dumpRequest("delete_project", `{ "project": "original_series" }`)
+    if err != nil {
+        t.Fatalf("failed to create delete request; %v", err)
+    }
+    err = deleteProjectHandler(reqname, &globals, ctx)
+    if err != nil {
+        t.Fatalf("failed to delete the project; %v", err)
+    }
+
+    entry := man["evolution"]
+    if _, err := os.Stat(deduplicationIndexPath(reg, entry.Size, entry.Md5sum)); !errors.Is(err, os.ErrNotExist) {
+        t.Fatal("expected the index entry to be removed after deletion")
+    }
+}
+
+func TestPreflightUploadHandler(t *testing.T) {
+    project := "original_series"
+    asset := "gastly"
+
+    reg, err := constructMockRegistry()
+    if err != nil {
+        t.Fatalf("failed to create the registry; %v", err)
+    }
+    globals := newGlobalConfiguration(reg, 2)
+
+    err = setupProjectForUploadTest(project, &globals)
+    if err != nil {
+        t.Fatalf("failed to set up project directory; %v", err)
+    }
+
+    ctx := context.Background()
+
+    src, err := setupSourceForUploadTest()
+    if err != nil {
+        t.Fatalf("failed to set up test directories; %v", err)
+    }
+    req_string := fmt.Sprintf(`{ "source": "%s", "project": "%s", "asset": "%s", "version": "lavender" }`, filepath.Base(src), project, asset)
+    reqname, err := dumpRequest("upload", req_string)
+    if err != nil {
+        t.Fatalf("failed to create upload request; %v", err)
+    }
+    err = uploadHandler(reqname, &globals, ctx)
+    if err != nil {
+        t.Fatalf("failed to perform the upload; %v", err)
+    }
+
+    original_usage, err := readUsage(filepath.Join(reg, project))
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    // Adding some new files and links to the source.
+    err = os.WriteFile(filepath.Join(src, "type"), []byte("ghost,poison"), 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = os.Symlink("type", filepath.Join(src, "type2"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = os.Symlink(filepath.Join(reg, project, asset, "lavender", "moves"), filepath.Join(src, "moves2"))
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    outside, err := os.CreateTemp("", "")
+    if err != nil {
+        t.Fatal(err)
+    }
+    outside.Close()
+    err = os.Symlink(outside.Name(), filepath.Join(src, "bad"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = os.Symlink("bad", filepath.Join(src, "bad2"))
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    t.Run("success", func(t *testing.T) {
+        req_string := fmt.Sprintf(`{ "source": "%s", "project": "%s", "asset": "%s", "version": "pallet" }`, filepath.Base(src), project, asset)
+        reqname, err := dumpRequest("preflight_upload", req_string)
+        if err != nil {
+            t.Fatalf("failed to create preflight request; %v", err)
+        }
+
+        res, err := preflightUploadHandler(reqname, &globals, ctx)
+        if err != nil {
+            t.Fatalf("failed to perform the preflight; %v", err)
+        }
+
+        if res.OnProbation || res.ExceedsQuota {
+            t.Fatalf("unexpected probation or quota status; %v", res)
+        }
+        if res.UsageDelta != int64(len("ghost,poison")) {
+            t.Fatalf("unexpected usage delta; %v", res.UsageDelta)
+        }
+
+        files := res.Files
+        if len(files.Transferred) != 1 || files.Transferred[0] != "type" {
+            t.Fatalf("unexpected transferred files; %v", files.Transferred)
+        }
+        if len(files.DeduplicatedLatest) != 2 || files.DeduplicatedLatest[0] != "evolution" || files.DeduplicatedLatest[1] != "moves" {
+            t.Fatalf("unexpected deduplicated files; %v", files.DeduplicatedLatest)
+        }
+        if len(files.RegistryLinks) != 1 || files.RegistryLinks[0] != "moves2" {
+            t.Fatalf("unexpected registry links; %v", files.RegistryLinks)
+        }
+        if len(files.LocalLinks) != 1 || files.LocalLinks[0] != "type2" {
+            t.Fatalf("unexpected local links; %v", files.LocalLinks)
+        }
+        if len(files.RejectedLinks) != 2 || !strings.Contains(files.RejectedLinks["bad"], "outside the registry") {
+            t.Fatalf("unexpected rejected links; %v", files.RejectedLinks)
+        }
+        if _, ok := files.RejectedLinks["bad2"]; !ok {
+            t.Fatalf("expected local links to rejected links to also be rejected; %v", files.RejectedLinks)
+        }
+
+        // Nothing should have been written to the registry.
+        if _, err := os.Stat(filepath.Join(reg, project, asset, "pallet")); !errors.Is(err, os.ErrNotExist) {
+            t.Fatal("expected no version directory to be created")
+        }
+        usage, err := readUsage(filepath.Join(reg, project))
+        if err != nil {
+            t.Fatal(err)
+        }
+        if usage.Total != original_usage.Total {
+            t.Fatal("expected no change in the usage")
+        }
+    })
+
+    t.Run("quota", func(t *testing.T) {
+        err := dumpJson(filepath.Join(reg, project, quotaFileName), &quotaMetadata{ Baseline: original_usage.Total, Year: time.Now().Year() })
+        if err != nil {
+            t.Fatal(err)
+        }
+        defer os.Remove(filepath.Join(reg, project, quotaFileName))
+
+        req_string := fmt.Sprintf(`{ "source": "%s", "project": "%s", "asset": "%s", "version": "pallet", "on_probation": true }`, filepath.Base(src), project, asset)
+        reqname, err := dumpRequest("preflight_upload", req_string)
+        if err != nil {
+            t.Fatalf("failed to create preflight request; %v", err)
+        }
+
+        res, err := preflightUploadHandler(reqname, &globals, ctx)
+        if err != nil {
+            t.Fatalf("failed to perform the preflight; %v", err)
+        }
+        if !res.OnProbation || !res.ExceedsQuota {
+            t.Fatalf("expected the upload to be on probation and exceed the quota; %v", res)
+        }
+    })
+
+    t.Run("existing version", func(t *testing.T) {
+        req_string := fmt.Sprintf(`{ "source": "%s", "project": "%s", "asset": "%s", "version": "lavender" }`, filepath.Base(src), project, asset)
+        reqname, err := dumpRequest("preflight_upload", req_string)
+        if err != nil {
+            t.Fatalf("failed to create preflight request; %v", err)
+        }
+
+        _, err = preflightUploadHandler(reqname, &globals, ctx)
+        if err == nil || !strings.Contains(err.Error(), "already exists") {
+            t.Fatalf("expected preflight failure for an existing version; %v", err)
+        }
+    })
+}
@@ -113,6 +113,14 @@ func editUsage(project_dir string, val int64, globals *globalConfiguration, ctx
     if err != nil {
         return fmt.Errorf("failed to read existing usage for %q; %w", project_dir, err)
     }
+
+    // Only increases in usage are subject to the quota, otherwise we might block deletions from an over-quota project.
+    if val > 0 {
+        err := checkQuota(project_dir, usage, val)
+        if err != nil {
+            return err
+        }
+    }
     usage.Total += val
 
     usage_path := filepath.Join(project_dir, usageFileName)
@@ -130,10 +138,6 @@ func refreshUsageHandler(reqpath string, globals *globalConfiguration, ctx conte
         return nil, fmt.Errorf("failed to find owner of %q; %w", reqpath, err)
     }
 
-    if !isAuthorizedToAdmin(source_user, globals.Administrators) {
-        return nil, newHttpError(http.StatusForbidden, fmt.Errorf("user %q is not authorized to refresh the latest version (%q)", source_user, reqpath))
-    }
-
     incoming := struct {
         Project *string `json:"project"`
     }{}
@@ -154,6 +158,10 @@ func refreshUsageHandler(reqpath string, globals *globalConfiguration, ctx conte
         }
     }
 
+    if !isAuthorizedToAdminAction(source_user, globals.Administrators, globals.ScopedAdministrators, adminActionRefresh, incoming.Project) {
+        return nil, newHttpError(http.StatusForbidden, fmt.Errorf("user %q is not authorized to refresh the usage (%q)", source_user, reqpath))
+    }
+
     rlock, err := lockDirectoryShared(globals.Registry, globals, ctx)
     if err != nil {
         return nil, fmt.Errorf("failed to lock the registry %q; %w", globals.Registry, err)
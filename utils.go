@@ -16,21 +16,32 @@ import (
 type globalConfiguration struct {
     Registry string
     Administrators []string
+    ScopedAdministrators []scopedAdminGrant
     Locks pathLocks
     LinkWhitelist []string
     SpoofPermissions map[string]spoofPermissions
     ConcurrencyThrottle *concurrencyThrottle
+    Digests []string
+    Progress *progressRegistry
+    Instance string
+    ReadTokens *readTokenRegistry
 }
 
 func newGlobalConfiguration(registry string, max_concurrency int) globalConfiguration {
     conc := newConcurrencyThrottle(max_concurrency)
+    host, _ := os.Hostname() // an empty name is fine if the hostname is not available.
     return globalConfiguration{ 
         Registry: registry, 
         Administrators: []string{},
+        ScopedAdministrators: []scopedAdminGrant{},
         Locks: newPathLocks(),
         LinkWhitelist: []string{},
         SpoofPermissions: map[string]spoofPermissions{},
         ConcurrencyThrottle: &conc,
+        Digests: []string{},
+        Progress: newProgressRegistry(time.Second * 10, time.Hour),
+        Instance: host,
+        ReadTokens: newReadTokenRegistry(time.Hour * 24, time.Hour * 24 * 7),
     }
 }
 
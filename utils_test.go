@@ -62,6 +62,9 @@ type logEntry struct {
     Asset *string `json:"asset"`
     Version *string `json:"version"`
     Latest *bool `json:"latest"`
+    Alias *string `json:"alias"`
+    NewProject *string `json:"new_project"`
+    NewAsset *string `json:"new_asset"`
 }
 
 func readAllLogs(registry string) ([]logEntry, error) {
@@ -8,10 +8,12 @@ import (
     "encoding/json"
     "net/http"
     "time"
+    "errors"
 )
 
 type validateDirectoryOptions struct {
     LinkWhitelist []string
+    Progress *progressTracker
 }
 
 func compareLinksSimple(observed, expected *linkMetadata, what string) error {
@@ -68,6 +70,23 @@ func validateDirectory(
         return fmt.Errorf("failed to parse existing linkfiles in %q; %w", source, err)
     }
 
+    previous_manifest, err := readManifest(source)
+    if err != nil {
+        return fmt.Errorf("failed to read the manifest at %q; %w", source, err)
+    }
+
+    // Recomputing every digest that is present in the existing manifest.
+    digest_set := map[string]bool{}
+    for _, entry := range previous_manifest {
+        for algo, _ := range entry.Digests {
+            digest_set[algo] = true
+        }
+    }
+    digests := []string{}
+    for algo, _ := range digest_set {
+        digests = append(digests, algo)
+    }
+
     new_manifest, err := walkDirectory(
         source,
         registry,
@@ -80,20 +99,18 @@ func validateDirectory(
             Mode: WalkDirectoryValidate,
             Consume: false, // not used during validation, just set for completeness only.
             DeduplicateLatest: nil,
+            DeduplicateRegistry: nil,
             RestoreLinkParent: createRestoreLinkParentMap(old_all_links),
             IgnoreDot: false,
             LinkWhitelist: options.LinkWhitelist,
+            Digests: digests,
+            Progress: options.Progress,
         },
     )
     if err != nil {
         return err
     }
 
-    previous_manifest, err := readManifest(source)
-    if err != nil {
-        return fmt.Errorf("failed to read the manifest at %q; %w", source, err)
-    }
-
     // Checking that everything in the current manifest is also present in the new manifest.
     for path, prev_entry := range previous_manifest {
         new_entry, ok := new_manifest[path]
@@ -106,6 +123,19 @@ func validateDirectory(
         if new_entry.Md5sum != prev_entry.Md5sum {
             return fmt.Errorf("incorrect MD5 checksum in manifest for %q in directory %q", path, source)
         }
+        for algo, prev_digest := range prev_entry.Digests {
+            new_digest, ok := new_entry.Digests[algo]
+            if !ok {
+                // Links take their digests from the target's manifest, which may not have been computed with the same algorithms.
+                if new_entry.Link != nil {
+                    continue
+                }
+                return fmt.Errorf("failed to compute %s digest for %q in directory %q", algo, path, source)
+            }
+            if new_digest != prev_digest {
+                return fmt.Errorf("incorrect %s digest in manifest for %q in directory %q", algo, path, source)
+            }
+        }
         err := compareLinks(prev_entry.Link, new_entry.Link)
         if err != nil {
             return fmt.Errorf("mismatching link information for %q in directory %q; %w", path, source, err)
@@ -211,13 +241,18 @@ func validatePreflight(reqpath string) (*validateRequest, error) {
     return &request, nil
 }
 
-func validateHandler(reqpath string, globals *globalConfiguration, ctx context.Context) error {
+func validateHandler(reqpath string, globals *globalConfiguration, ctx context.Context) (reportable_err error) {
     request, err := validatePreflight(reqpath)
     if err != nil {
         return err
     }
+
+    progress := globals.Progress.Start(reqpath)
+    defer func() {
+        globals.Progress.Finish(progress, reportable_err)
+    }()
     project := *(request.Project)
-    ok := isAuthorizedToAdmin(request.User, globals.Administrators)
+    ok := isAuthorizedToAdminAction(request.User, globals.Administrators, globals.ScopedAdministrators, adminActionValidate, request.Project)
     if !ok {
         return newHttpError(http.StatusForbidden, fmt.Errorf("user '" + request.User + "' is not authorized to validate '" + project + "'"))
     }
@@ -280,6 +315,7 @@ func validateHandler(reqpath string, globals *globalConfiguration, ctx context.C
         globals.ConcurrencyThrottle,
         validateDirectoryOptions{
             LinkWhitelist: globals.LinkWhitelist,
+            Progress: progress,
         },
     )
     if err != nil {
@@ -301,5 +337,25 @@ func validateHandler(reqpath string, globals *globalConfiguration, ctx context.C
         return fmt.Errorf("could not parse 'upload_finish' from the summary file at %q; %w", version_dir, err)
     }
 
+    // Provenance fields are optional, as they are not present in older summaries.
+    for name, val := range map[string]*int64{ "file_count": summ.FileCount, "total_bytes": summ.TotalBytes, "deduplicated_bytes": summ.DeduplicatedBytes } {
+        if val != nil && *val < 0 {
+            return fmt.Errorf("invalid '%s' in the summary file at %q; should be non-negative", name, version_dir)
+        }
+    }
+    if summ.TotalBytes != nil && summ.DeduplicatedBytes != nil && *(summ.DeduplicatedBytes) > *(summ.TotalBytes) {
+        return fmt.Errorf("invalid 'deduplicated_bytes' in the summary file at %q; should not be greater than 'total_bytes'", version_dir)
+    }
+
+    // Version metadata is optional, but if it exists, it should be a JSON object.
+    if _, err := os.Stat(filepath.Join(version_dir, versionMetadataFileName)); err == nil {
+        _, err := readVersionMetadata(version_dir)
+        if err != nil {
+            return fmt.Errorf("invalid version metadata at %q; %w", version_dir, err)
+        }
+    } else if !errors.Is(err, os.ErrNotExist) {
+        return fmt.Errorf("failed to stat the version metadata at %q; %w", version_dir, err)
+    }
+
     return nil
 }
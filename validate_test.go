@@ -131,6 +131,58 @@ func TestValidateDirectorySimple(t *testing.T) {
     })
 }
 
+func TestValidateDirectoryDigests(t *testing.T) {
+    src, err := setupSourceForWalkDirectoryTest()
+    if err != nil {
+        t.Fatalf("failed to set up test directories; %v", err)
+    }
+
+    ctx := context.Background()
+    conc := newConcurrencyThrottle(2)
+
+    project := "pokemon"
+    asset := "pikachu"
+    version := "red"
+
+    reg, err := os.MkdirTemp("", "")
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    err = transferDirectory(src, reg, project, asset, version, ctx, &conc, transferDirectoryOptions{ Digests: []string{ "sha256" } })
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    version_dir := filepath.Join(reg, project, asset, version)
+    man, err := readManifest(version_dir)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(man["type"].Digests["sha256"]) != 64 {
+        t.Fatalf("expected a SHA-256 digest in the manifest")
+    }
+
+    err = validateDirectory(reg, project, asset, version, ctx, &conc, validateDirectoryOptions{})
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    // Mangling the digest in the manifest.
+    entry := man["type"]
+    entry.Digests = map[string]string{ "sha256": "abcdefgh" }
+    man["type"] = entry
+    err = dumpJson(filepath.Join(version_dir, manifestFileName), &man)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    err = validateDirectory(reg, project, asset, version, ctx, &conc, validateDirectoryOptions{})
+    if err == nil || !strings.Contains(err.Error(), "incorrect sha256 digest") {
+        t.Errorf("expected an error from incorrect digest, got %v", err)
+    }
+}
+
 func TestValidateDirectoryEmptyDir(t *testing.T) {
     src, err := setupSourceForWalkDirectoryTest()
     if err != nil {
@@ -832,6 +884,81 @@ func TestValidateHandlerSimple(t *testing.T) {
         if err == nil || !strings.Contains(err.Error(), "could not parse 'upload_finish'") {
             t.Errorf("expected a validation error from invalid summary, got %v", err)
         }
+
+        err = os.WriteFile(filepath.Join(reg, project, asset, version, summaryFileName), []byte(`{ "upload_user_id": "aaron", "upload_start": "2022-12-22T22:22:22Z", "upload_finish": "2022-12-22T22:22:23Z", "total_bytes": 10, "deduplicated_bytes": 20 }`), 0644)
+        if err != nil {
+            t.Fatal(err)
+        }
+        err = validateHandler(reqname, &globals, ctx)
+        if err == nil || !strings.Contains(err.Error(), "invalid 'deduplicated_bytes'") {
+            t.Errorf("expected a validation error from invalid summary, got %v", err)
+        }
+
+        err = os.WriteFile(filepath.Join(reg, project, asset, version, summaryFileName), []byte(`{ "upload_user_id": "aaron", "upload_start": "2022-12-22T22:22:22Z", "upload_finish": "2022-12-22T22:22:23Z", "file_count": -1 }`), 0644)
+        if err != nil {
+            t.Fatal(err)
+        }
+        err = validateHandler(reqname, &globals, ctx)
+        if err == nil || !strings.Contains(err.Error(), "invalid 'file_count'") {
+            t.Errorf("expected a validation error from invalid summary, got %v", err)
+        }
+
+        // Extended summaries are accepted.
+        err = os.WriteFile(filepath.Join(reg, project, asset, version, summaryFileName), []byte(`{ 
+    "upload_user_id": "aaron",
+    "upload_start": "2022-12-22T22:22:22Z",
+    "upload_finish": "2022-12-22T22:22:23Z",
+    "request_user_id": "serena",
+    "instance": "foo",
+    "request_file": "request-upload-12345",
+    "file_count": 2,
+    "total_bytes": 10,
+    "deduplicated_bytes": 5,
+    "consume": false,
+    "ignore_dot": true
+}`), 0644)
+        if err != nil {
+            t.Fatal(err)
+        }
+        err = validateHandler(reqname, &globals, ctx)
+        if err != nil {
+            t.Errorf("expected no validation error from an extended summary, got %v", err)
+        }
+    })
+
+    t.Run("invalid metadata", func(t *testing.T) {
+        asset := "chimchar"
+        version := "platinum"
+
+        err := setupDirectoryForValidateHandlerTest(reg, project, asset, version)
+        if err != nil {
+            t.Fatalf("failed to set up project directory; %v", err)
+        }
+
+        req_string := fmt.Sprintf(`{ "project": "%s", "asset": "%s", "version": "%s" }`, project, asset, version)
+        reqname, err := dumpRequest("validate", req_string)
+        if err != nil {
+            t.Fatalf("failed to create reindex request; %v", err)
+        }
+
+        metadata_path := filepath.Join(reg, project, asset, version, versionMetadataFileName)
+        err = os.WriteFile(metadata_path, []byte(`{ "description": "foo" }`), 0644)
+        if err != nil {
+            t.Fatal(err)
+        }
+        err = validateHandler(reqname, &globals, ctx)
+        if err != nil {
+            t.Fatal(err)
+        }
+
+        err = os.WriteFile(metadata_path, []byte(`[ "foo" ]`), 0644)
+        if err != nil {
+            t.Fatal(err)
+        }
+        err = validateHandler(reqname, &globals, ctx)
+        if err == nil || !strings.Contains(err.Error(), "invalid version metadata") {
+            t.Errorf("expected a validation error from invalid metadata, got %v", err)
+        }
     })
 }
 
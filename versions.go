@@ -0,0 +1,126 @@
+package main
+
+import (
+    "os"
+    "encoding/json"
+    "fmt"
+    "errors"
+    "path/filepath"
+)
+
+// The version index lists all versions of an asset along with some details from their summaries,
+// so that clients do not have to fetch each '..summary' file individually.
+// Versions with missing or invalid summaries or manifests are omitted, as these are either incomplete or corrupted.
+const versionsFileName = "..versions"
+
+type versionIndexEntry struct {
+    UploadUserId string `json:"upload_user_id"`
+    UploadStart string `json:"upload_start"`
+    UploadFinish string `json:"upload_finish"`
+    OnProbation *bool `json:"on_probation,omitempty"`
+    Deprecated *bool `json:"deprecated,omitempty"`
+    Usage int64 `json:"usage"`
+}
+
+// Returns nil if the index does not exist, e.g., for assets created by older versions of the Gobbler.
+func readVersionIndex(asset_dir string) (map[string]versionIndexEntry, error) {
+    index_path := filepath.Join(asset_dir, versionsFileName)
+
+    index_raw, err := os.ReadFile(index_path)
+    if err != nil {
+        if errors.Is(err, os.ErrNotExist) {
+            return nil, nil
+        }
+        return nil, fmt.Errorf("failed to read %q; %w", index_path, err)
+    }
+
+    var output map[string]versionIndexEntry
+    err = json.Unmarshal(index_raw, &output)
+    if err != nil {
+        return nil, fmt.Errorf("failed to parse JSON in %q; %w", index_path, err)
+    }
+
+    return output, nil
+}
+
+func createVersionIndexEntry(version_dir string) (*versionIndexEntry, error) {
+    summ, err := readSummary(version_dir)
+    if err != nil {
+        return nil, fmt.Errorf("failed to read summary from %q; %w", version_dir, err)
+    }
+
+    usage, err := computeVersionUsage(version_dir)
+    if err != nil {
+        return nil, fmt.Errorf("failed to compute usage for %q; %w", version_dir, err)
+    }
+
+    return &versionIndexEntry{
+        UploadUserId: summ.UploadUserId,
+        UploadStart: summ.UploadStart,
+        UploadFinish: summ.UploadFinish,
+        OnProbation: summ.OnProbation,
+        Deprecated: summ.Deprecated,
+        Usage: usage,
+    }, nil
+}
+
+// This assumes that the caller holds an exclusive lock on the asset directory.
+func rebuildVersionIndex(asset_dir string) error {
+    versions, err := listUserDirectories(asset_dir)
+    if err != nil {
+        return fmt.Errorf("failed to list versions in %q; %w", asset_dir, err)
+    }
+
+    index := map[string]versionIndexEntry{}
+    for _, version := range versions {
+        entry, err := createVersionIndexEntry(filepath.Join(asset_dir, version))
+        if err == nil {
+            index[version] = *entry
+        }
+    }
+
+    index_path := filepath.Join(asset_dir, versionsFileName)
+    err = dumpJson(index_path, &index)
+    if err != nil {
+        return fmt.Errorf("failed to save the version index at %q; %w", index_path, err)
+    }
+    return nil
+}
+
+// Updates the index entries for the specified versions, removing the entries for versions that no longer exist.
+// If the index does not exist yet, it is rebuilt from scratch.
+// This assumes that the caller holds an exclusive lock on the asset directory.
+func updateVersionIndex(asset_dir string, versions ...string) error {
+    index, err := readVersionIndex(asset_dir)
+    if err != nil {
+        return err
+    }
+    if index == nil {
+        return rebuildVersionIndex(asset_dir)
+    }
+
+    for _, version := range versions {
+        version_dir := filepath.Join(asset_dir, version)
+        if _, err := os.Stat(version_dir); err != nil {
+            if errors.Is(err, os.ErrNotExist) {
+                delete(index, version)
+                continue
+            }
+            return fmt.Errorf("failed to stat %q; %w", version_dir, err)
+        }
+
+        entry, err := createVersionIndexEntry(version_dir)
+        if err == nil {
+            index[version] = *entry
+        } else {
+            delete(index, version)
+        }
+    }
+
+    index_path := filepath.Join(asset_dir, versionsFileName)
+    err = dumpJson(index_path, &index)
+    if err != nil {
+        return fmt.Errorf("failed to save the version index at %q; %w", index_path, err)
+    }
+    return nil
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+    "testing"
+    "os"
+    "path/filepath"
+    "fmt"
+    "context"
+)
+
+func TestVersionIndex(t *testing.T) {
+    reg, err := constructMockRegistry()
+    if err != nil {
+        t.Fatalf("failed to create the registry; %v", err)
+    }
+
+    asset_dir := filepath.Join(reg, "pokemon", "pikachu")
+    for _, version := range []string{ "red", "blue", "corrupted" } {
+        version_dir := filepath.Join(asset_dir, version)
+        err := os.MkdirAll(version_dir, 0755)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if version == "corrupted" {
+            continue
+        }
+
+        err = os.WriteFile(filepath.Join(version_dir, "type"), []byte("electric"), 0644)
+        if err != nil {
+            t.Fatal(err)
+        }
+        err = dumpJson(filepath.Join(version_dir, manifestFileName), map[string]manifestEntry{ "type": manifestEntry{ Size: 8, Md5sum: "abcdef" } })
+        if err != nil {
+            t.Fatal(err)
+        }
+        summ := summaryMetadata{ UploadUserId: "ash", UploadStart: "2020-02-02T02:02:02Z", UploadFinish: "2020-02-02T02:02:20Z" }
+        if version == "blue" {
+            on_probation := true
+            summ.OnProbation = &on_probation
+        }
+        err = dumpJson(filepath.Join(version_dir, summaryFileName), &summ)
+        if err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    index, err := readVersionIndex(asset_dir)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if index != nil {
+        t.Fatal("expected no index before creation")
+    }
+
+    // Updating without an existing index triggers a rebuild.
+    err = updateVersionIndex(asset_dir, "red")
+    if err != nil {
+        t.Fatal(err)
+    }
+    index, err = readVersionIndex(asset_dir)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(index) != 2 {
+        t.Fatalf("expected corrupted versions to be omitted; %v", index)
+    }
+    red := index["red"]
+    if red.UploadUserId != "ash" || red.UploadStart != "2020-02-02T02:02:02Z" || red.UploadFinish != "2020-02-02T02:02:20Z" || red.OnProbation != nil || red.Usage != 8 {
+        t.Fatalf("unexpected index entry; %v", red)
+    }
+    blue := index["blue"]
+    if blue.OnProbation == nil || !*(blue.OnProbation) {
+        t.Fatalf("unexpected index entry; %v", blue)
+    }
+
+    // Updating a deleted version removes it from the index.
+    err = os.RemoveAll(filepath.Join(asset_dir, "blue"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = updateVersionIndex(asset_dir, "blue")
+    if err != nil {
+        t.Fatal(err)
+    }
+    index, err = readVersionIndex(asset_dir)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if _, ok := index["blue"]; ok || len(index) != 1 {
+        t.Fatalf("expected deleted versions to be removed; %v", index)
+    }
+}
+
+func TestVersionIndexHandlers(t *testing.T) {
+    reg, err := constructMockRegistry()
+    if err != nil {
+        t.Fatalf("failed to create the registry; %v", err)
+    }
+    globals := newGlobalConfiguration(reg, 2)
+    ctx := context.Background()
+
+    project := "pokemon"
+    asset := "pikachu"
+    err = setupProjectForUploadTest(project, &globals)
+    if err != nil {
+        t.Fatalf("failed to set up project directory; %v", err)
+    }
+
+    src, err := setupSourceForUploadTest()
+    if err != nil {
+        t.Fatalf("failed to set up test directories; %v", err)
+    }
+    asset_dir := filepath.Join(reg, project, asset)
+
+    for _, version := range []string{ "red", "blue" } {
+        reqname, err := dumpRequest("upload", fmt.Sprintf(`{ "source": "%s", "project": "%s", "asset": "%s", "version": "%s", "on_probation": %v }`, filepath.Base(src), project, asset, version, version == "blue"))
+        if err != nil {
+            t.Fatalf("failed to create upload request; %v", err)
+        }
+        err = uploadHandler(reqname, &globals, ctx)
+        if err != nil {
+            t.Fatalf("failed to perform the upload; %v", err)
+        }
+    }
+
+    index, err := readVersionIndex(asset_dir)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(index) != 2 || index["red"].OnProbation != nil || index["blue"].OnProbation == nil {
+        t.Fatalf("unexpected index after uploads; %v", index)
+    }
+    expected_usage, err := computeVersionUsage(filepath.Join(asset_dir, "red"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if index["red"].Usage != expected_usage {
+        t.Fatalf("unexpected usage in the index; %v", index["red"])
+    }
+
+    reqname, err := dumpRequest("approve_probation", fmt.Sprintf(`{ "project": "%s", "asset": "%s", "version": "blue" }`, project, asset))
+    if err != nil {
+        t.Fatalf("failed to create approval request; %v", err)
+    }
+    err = approveProbationHandler(reqname, &globals, ctx)
+    if err != nil {
+        t.Fatalf("failed to approve probation; %v", err)
+    }
+    index, err = readVersionIndex(asset_dir)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if index["blue"].OnProbation != nil {
+        t.Fatalf("expected probation to be removed from the index; %v", index)
+    }
+
+    reqname, err = dumpRequest("deprecate_version", fmt.Sprintf(`{ "project": "%s", "asset": "%s", "version": "red" }`, project, asset))
+    if err != nil {
+        t.Fatalf("failed to create deprecation request; %v", err)
+    }
+    err = deprecateVersionHandler(reqname, &globals, ctx)
+    if err != nil {
+        t.Fatalf("failed to deprecate the version; %v", err)
+    }
+    index, err = readVersionIndex(asset_dir)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if index["red"].Deprecated == nil || !*(index["red"].Deprecated) {
+        t.Fatalf("expected deprecation to be recorded in the index; %v", index)
+    }
+
+    self, err := identifyUser(reg)
+    if err != nil {
+        t.Fatal(err)
+    }
+    globals.Administrators = append(globals.Administrators, self)
+    reqname, err = dumpRequest("delete_version", fmt.Sprintf(`{ "project": "%s", "asset": "%s", "version": "red" }`, project, asset))
+    if err != nil {
+        t.Fatalf("failed to create deletion request; %v", err)
+    }
+    err = deleteVersionHandler(reqname, &globals, ctx)
+    if err != nil {
+        t.Fatalf("failed to delete the version; %v", err)
+    }
+    index, err = readVersionIndex(asset_dir)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if _, ok := index["red"]; ok || len(index) != 1 {
+        t.Fatalf("expected the deleted version to be removed from the index; %v", index)
+    }
+
+    // Refreshing the latest version rebuilds the index.
+    err = os.Remove(filepath.Join(asset_dir, versionsFileName))
+    if err != nil {
+        t.Fatal(err)
+    }
+    reqname, err = dumpRequest("refresh_latest", fmt.Sprintf(`{ "project": "%s", "asset": "%s" }`, project, asset))
+    if err != nil {
+        t.Fatalf("failed to create refresh request; %v", err)
+    }
+    _, err = refreshLatestHandler(reqname, &globals, ctx)
+    if err != nil {
+        t.Fatalf("failed to refresh the latest version; %v", err)
+    }
+    index, err = readVersionIndex(asset_dir)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if _, ok := index["blue"]; !ok || len(index) != 1 {
+        t.Fatalf("expected the index to be rebuilt; %v", index)
+    }
+}
@@ -1,19 +1,18 @@
 package main
 
 import (
-    "crypto/md5"
     "path/filepath"
     "fmt"
     "os"
     "os/user"
     "io"
     "io/fs"
-    "encoding/hex"
     "encoding/json"
     "errors"
     "strings"
     "context"
     "sync"
+    "sort"
 )
 
 func copyFile(src, dest string) error {
@@ -51,19 +50,8 @@ func copyFile(src, dest string) error {
 }
 
 func computeChecksum(path string) (string, error) {
-    in, err_ := os.Open(path)
-    if err_ != nil {
-        return "", fmt.Errorf("failed to open '" + path + "'; %w", err_)
-    }
-    defer in.Close()
-
-    h := md5.New()
-    _, err := io.Copy(h, in)
-    if err != nil {
-        return "", fmt.Errorf("failed to hash '" + path + "'; %w", err_)
-    }
-
-    return hex.EncodeToString(h.Sum(nil)), nil
+    md5sum, _, err := computeDigests(path, nil)
+    return md5sum, err
 }
 
 func fullLinkPath(registry string, link *linkMetadata) string {
@@ -213,6 +201,7 @@ func resolveRegistrySymlink(
     }
     output.Size = found.Size
     output.Md5sum = found.Md5sum
+    output.Digests = found.Digests
 
     // Check if our target is itself a link to something else.
     if found.Link != nil {
@@ -272,6 +261,7 @@ func resolveLocalSymlink(
     output := manifestEntry{
         Size: target_deets.Size,
         Md5sum: target_deets.Md5sum,
+        Digests: target_deets.Digests,
         Link: &linkMetadata{
             Project: project,
             Asset: asset,
@@ -307,13 +297,68 @@ const (
     WalkDirectoryValidate
 )
 
+// This records what happened to each file during the walk, primarily for reporting in dry runs.
+type walkDirectoryReport struct {
+    Lock sync.Mutex `json:"-"`
+    Transferred []string `json:"transferred"`
+    DeduplicatedLatest []string `json:"deduplicated_latest"`
+    DeduplicatedRegistry []string `json:"deduplicated_registry"`
+    RegistryLinks []string `json:"registry_links"`
+    LocalLinks []string `json:"local_links"`
+    WhitelistedLinks []string `json:"whitelisted_links"`
+    RejectedLinks map[string]string `json:"rejected_links"`
+
+    // Total size of all non-link files, i.e., the storage consumed by this version.
+    Usage int64 `json:"-"`
+}
+
+func newWalkDirectoryReport() *walkDirectoryReport {
+    return &walkDirectoryReport{
+        Transferred: []string{},
+        DeduplicatedLatest: []string{},
+        DeduplicatedRegistry: []string{},
+        RegistryLinks: []string{},
+        LocalLinks: []string{},
+        WhitelistedLinks: []string{},
+        RejectedLinks: map[string]string{},
+    }
+}
+
+func (r *walkDirectoryReport) add(list *[]string, path string) {
+    r.Lock.Lock()
+    defer r.Lock.Unlock()
+    *list = append(*list, path)
+}
+
+func (r *walkDirectoryReport) reject(path string, err error) {
+    r.Lock.Lock()
+    defer r.Lock.Unlock()
+    r.RejectedLinks[path] = err.Error()
+}
+
+func (r *walkDirectoryReport) sort() {
+    for _, list := range [][]string{ r.Transferred, r.DeduplicatedLatest, r.DeduplicatedRegistry, r.RegistryLinks, r.LocalLinks, r.WhitelistedLinks } {
+        sort.Strings(list)
+    }
+}
+
 type walkDirectoryOptions struct {
     Mode WalkDirectoryMode
     Consume bool
+    LinkMode string
     DeduplicateLatest map[string]*linkMetadata
+    DeduplicateRegistry *deduplicationIndex
     RestoreLinkParent map[string]*linkMetadata
     IgnoreDot bool
+    Filter *pathFilter
     LinkWhitelist []string
+    Digests []string
+    Progress *progressTracker
+
+    // Only used in transfer mode, where nothing is written to the registry.
+    // Invalid symbolic links are also recorded in the report instead of causing an error.
+    DryRun bool
+    Report *walkDirectoryReport
 }
 
 func walkDirectory(
@@ -355,7 +400,8 @@ func walkDirectory(
     defer wg.Wait()
 
     do_transfer := options.Mode == WalkDirectoryTransfer
-    do_create_symlink := do_transfer || options.Mode == WalkDirectoryReindex
+    do_write := do_transfer && !options.DryRun
+    do_create_symlink := do_write || options.Mode == WalkDirectoryReindex
     do_replace_symlink := options.Mode == WalkDirectoryReindex
     do_check_relative_symlink := options.Mode == WalkDirectoryValidate
 
@@ -363,7 +409,15 @@ func walkDirectory(
     probation_cache := map[string]bool{}
     var manifest_cache_lock, probation_cache_lock sync.Mutex
 
+    progress := options.Progress
+    report := options.Report
+    dry_run := do_transfer && options.DryRun
+    if dry_run && report == nil {
+        report = newWalkDirectoryReport()
+    }
+
     /*** First pass examines all files and decides what to do with them. ***/
+    progress.SetPass(progressExamining)
     err := filepath.WalkDir(source, func(src_path string, info fs.DirEntry, err error) error {
         if err != nil {
             return fmt.Errorf("failed to walk into '" + src_path + "'; %w", err)
@@ -395,8 +449,21 @@ func walkDirectory(
             return fmt.Errorf("failed to convert %q into a relative path; %w", src_path, err);
         }
 
+        if options.Filter != nil && rel_path != "." {
+            if options.Filter.Excludes(rel_path, info.IsDir()) {
+                if info.IsDir() {
+                    return filepath.SkipDir
+                } else {
+                    return nil
+                }
+            }
+            if !info.IsDir() && !options.Filter.Includes(rel_path) {
+                return nil
+            }
+        }
+
         if info.IsDir() {
-            if do_transfer {
+            if do_write {
                 err := os.MkdirAll(filepath.Join(destination, rel_path), 0755)
                 if err != nil {
                     return fmt.Errorf("failed to create a directory at %q; %w", src_path, err)
@@ -410,11 +477,13 @@ func walkDirectory(
             return nil
         }
 
+        progress.AddDiscovered()
         handle := throttle.Wait()
         wg.Add(1);
         go func() {
             defer throttle.Release(handle)
             defer wg.Done();
+            is_symlink := false
             err := func() error {
                 // Re-check for early cancellation once we get into the goroutine, as throttling might have blocked an arbitrarily long time.
                 err := ctx.Err()
@@ -434,6 +503,7 @@ func walkDirectory(
 
                 // Preserving links to targets within the registry, within the 'src' directory, or inside whitelisted directories.
                 if restat.Mode() & os.ModeSymlink == os.ModeSymlink {
+                    is_symlink = true
                     target, err := os.Readlink(src_path)
                     if err != nil {
                         return fmt.Errorf("failed to read the symlink at %q; %w", src_path, err)
@@ -500,6 +570,10 @@ func walkDirectory(
                         if err != nil {
                             return fmt.Errorf("failed to resolve symlink for %q to registry path %q; %w", src_path, target, err)
                         }
+                        progress.AddResolved()
+                        if report != nil {
+                            report.add(&report.RegistryLinks, rel_path)
+                        }
 
                         if do_create_symlink {
                             err = createSymlink(filepath.Join(destination, rel_path), registry, obj.Link, do_replace_symlink)
@@ -516,16 +590,20 @@ func walkDirectory(
 
                     // Symlinks to files in whitelisted directories are preserved, but manifest pretends as if they were the files themselves.
                     if isLinkWhitelisted(target, options.LinkWhitelist) {
-                        target_sum, err := computeChecksum(target)
+                        target_sum, target_digests, err := computeDigests(target, options.Digests)
                         if err != nil {
                             return fmt.Errorf("failed to hash the link target %q; %w", target, err)
                         }
+                        progress.AddHashed()
+                        if report != nil {
+                            report.add(&report.WhitelistedLinks, rel_path)
+                        }
 
                         manifest_lock.Lock()
                         defer manifest_lock.Unlock()
-                        manifest[rel_path] = manifestEntry{ Size: target_stat.Size(), Md5sum: target_sum }
+                        manifest[rel_path] = manifestEntry{ Size: target_stat.Size(), Md5sum: target_sum, Digests: target_digests }
 
-                        if do_transfer {
+                        if do_write {
                             final := filepath.Join(destination, rel_path)
                             err := os.Symlink(target, final)
                             if err != nil {
@@ -538,11 +616,12 @@ func walkDirectory(
                     }
                 }
 
-                insum, err := computeChecksum(src_path)
+                insum, indigests, err := computeDigests(src_path, options.Digests)
                 if err != nil {
                     return fmt.Errorf("failed to hash the source file; %w", err)
                 }
-                man_entry := manifestEntry{ Size: restat.Size(), Md5sum: insum }
+                progress.AddHashed()
+                man_entry := manifestEntry{ Size: restat.Size(), Md5sum: insum, Digests: indigests }
 
                 if do_transfer {
                     var link_target *linkMetadata
@@ -551,6 +630,16 @@ func walkDirectory(
                         last_entry, ok := options.DeduplicateLatest[deduplicateLatestKey(man_entry.Size, man_entry.Md5sum)]
                         if ok {
                             link_target = last_entry
+                            if report != nil {
+                                report.add(&report.DeduplicatedLatest, rel_path)
+                            }
+                        }
+                    }
+                    if link_target == nil && options.DeduplicateRegistry != nil {
+                        // Otherwise, seeing if there's a file with the same md5sum anywhere else in the registry.
+                        link_target = options.DeduplicateRegistry.Find(man_entry.Size, man_entry.Md5sum, man_entry.Digests)
+                        if link_target != nil && report != nil {
+                            report.add(&report.DeduplicatedRegistry, rel_path)
                         }
                     }
 
@@ -558,11 +647,16 @@ func walkDirectory(
                         transferrable_lock.Lock()
                         defer transferrable_lock.Unlock()
                         transferrable = append(transferrable, rel_path)
+                        if report != nil {
+                            report.add(&report.Transferred, rel_path)
+                        }
                     } else {
                         man_entry.Link = link_target 
-                        err := createSymlink(filepath.Join(destination, rel_path), registry, link_target, false)
-                        if err != nil {
-                            return err
+                        if do_write {
+                            err := createSymlink(filepath.Join(destination, rel_path), registry, link_target, false)
+                            if err != nil {
+                                return err
+                            }
                         }
                     }
                 }
@@ -574,7 +668,11 @@ func walkDirectory(
             }()
 
             if err != nil {
-                safeAddError(err)
+                if dry_run && is_symlink {
+                    report.reject(rel_path, err)
+                } else {
+                    safeAddError(err)
+                }
             }
         }()
 
@@ -593,7 +691,8 @@ func walkDirectory(
      *** Second pass performs a copy/move of files.
      *** We use a second pass so any move doesn't break local links within the staging directory during the first pass.
      ***/
-    if do_transfer {
+    if do_write {
+        progress.SetPass(progressTransferring)
         for _, path := range transferrable {
             err := ctx.Err()
             if err != nil {
@@ -640,26 +739,39 @@ func walkDirectory(
                         if owner == self.Username { // check for correct ownership, otherwise a move is not directly equivalent to copy-and-delete.
                             if err := os.Chmod(src_path, 0644); err == nil {
                                 if err := os.Rename(src_path, final); err == nil {
+                                    progress.AddCopied(manifest[path].Size)
                                     return nil 
                                 }
                             }
                         }
                     }
 
-                    err = copyFile(src_path, final)
+                    // Hard links and reflinks are attempted if requested, otherwise we fall back to a copy.
+                    // We still verify the checksums afterwards in case the source was modified after the first pass.
+                    used_mode, err := transferFile(src_path, final, options.LinkMode)
                     if err != nil {
                         return fmt.Errorf("failed to copy file at %q to %q; %w", path, destination, err)
                     }
+                    if !used_mode {
+                        progress.AddLinkFallback()
+                    }
 
-                    finalsum, err := computeChecksum(final)
+                    finalsum, finaldigests, err := computeDigests(final, options.Digests)
                     if err != nil {
                         return fmt.Errorf("failed to hash the file at %q; %w", final, err)
                     }
 
-                    insum := manifest[path].Md5sum
-                    if finalsum != insum {
+                    inentry := manifest[path]
+                    if finalsum != inentry.Md5sum {
                         return fmt.Errorf("mismatch in checksums between source and destination files for %q", path)
                     }
+                    for algo, indigest := range inentry.Digests {
+                        if finaldigests[algo] != indigest {
+                            return fmt.Errorf("mismatch in %s digests between source and destination files for %q", algo, path)
+                        }
+                    }
+
+                    progress.AddCopied(inentry.Size)
 
                     // We use a copy-and-delete to mimic a move to ensure that our permissions of the new file are configured correctly.
                     // Otherwise we might end up preserving the wrong permissions (especially ownership) of the moved file.
@@ -688,6 +800,7 @@ func walkDirectory(
      *** Don't try to parallelize this, as different local_links might have the same ancestors.
      *** This would result in repeated attempts to create the same symbolic links from multiple goroutines.
      ***/
+    progress.SetPass(progressLinking)
     for path, target := range local_links {
         err := ctx.Err()
         if err != nil {
@@ -696,8 +809,16 @@ func walkDirectory(
 
         man, err := resolveLocalSymlink(project, asset, version, path, target, local_links, manifest, nil)
         if err != nil {
+            if dry_run {
+                report.reject(path, err)
+                continue
+            }
             return nil, err
         }
+        progress.AddResolved()
+        if report != nil {
+            report.add(&report.LocalLinks, path)
+        }
 
         if do_create_symlink {
             err = createSymlink(filepath.Join(destination, path), registry, man.Link, do_replace_symlink)
@@ -727,6 +848,15 @@ func walkDirectory(
         }
     }
 
+    if report != nil {
+        report.sort()
+        for _, entry := range manifest {
+            if entry.Link == nil {
+                report.Usage += entry.Size
+            }
+        }
+    }
+
     return manifest, nil
 }
 